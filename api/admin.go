@@ -0,0 +1,314 @@
+package api
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/macrat/lauth/errors"
+	"github.com/macrat/lauth/lockout"
+	"github.com/macrat/lauth/metrics"
+	"github.com/macrat/lauth/session"
+	"github.com/macrat/lauth/webauthn"
+)
+
+type AdminSessionsRequest struct {
+	Subject string `form:"subject" json:"subject" xml:"subject"`
+}
+
+type AdminCredentialsRequest struct {
+	Subject string `form:"subject" json:"subject" xml:"subject"`
+}
+
+type AdminLockoutsRequest struct {
+	Username  string `form:"username"  json:"username"  xml:"username"`
+	Directory string `form:"directory" json:"directory" xml:"directory"`
+	IP        string `form:"ip"        json:"ip"        xml:"ip"`
+}
+
+func (api *LauthAPI) SetAdminRoutes(r gin.IRoutes) {
+	if !api.Config.Admin.Enabled() {
+		return
+	}
+
+	auth := gin.BasicAuthForRealm(gin.Accounts{
+		api.Config.Admin.Username: api.Config.Admin.Password,
+	}, "Lauth Admin")
+
+	sessions := path.Join(api.Config.Issuer.Path, api.Config.Admin.Path, "sessions")
+
+	r.GET(sessions, auth, api.GetAdminSessions)
+	r.DELETE(sessions, auth, api.DeleteAdminSessions)
+	r.DELETE(sessions+"/:id", auth, api.DeleteAdminSession)
+
+	credentials := path.Join(api.Config.Issuer.Path, api.Config.Admin.Path, "credentials")
+
+	r.GET(credentials, auth, api.GetAdminCredentials)
+	r.DELETE(credentials, auth, api.DeleteAdminCredentials)
+
+	lockouts := path.Join(api.Config.Issuer.Path, api.Config.Admin.Path, "lockouts")
+
+	r.GET(lockouts, auth, api.GetAdminLockouts)
+	r.DELETE(lockouts, auth, api.DeleteAdminLockouts)
+}
+
+func (api *LauthAPI) GetAdminSessions(c *gin.Context) {
+	report := metrics.StartLogging(c)
+	defer report.Close()
+
+	c.Header("Cache-Control", "no-store")
+
+	var req AdminSessionsRequest
+	if err := c.ShouldBind(&req); err != nil {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.InvalidRequest,
+			Description: "failed to parse request",
+		}
+		report.SetError(e)
+		errors.SendJSON(c, e)
+		return
+	}
+
+	var ss []session.Session
+	var err error
+	if req.Subject != "" {
+		ss, err = session.ListBySubject(api.Sessions, req.Subject)
+	} else {
+		ss, err = api.Sessions.List()
+	}
+	if err != nil {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.ServerError,
+			Description: "failed to get sessions",
+		}
+		report.SetError(e)
+		errors.SendJSON(c, e)
+		return
+	}
+
+	if ss == nil {
+		ss = []session.Session{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": ss,
+	})
+}
+
+func (api *LauthAPI) DeleteAdminSessions(c *gin.Context) {
+	report := metrics.StartLogging(c)
+	defer report.Close()
+
+	var req AdminSessionsRequest
+	if err := c.ShouldBind(&req); err != nil || req.Subject == "" {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.InvalidRequest,
+			Description: "subject is required",
+		}
+		report.SetError(e)
+		errors.SendJSON(c, e)
+		return
+	}
+
+	revoked, err := session.RevokeSubject(api.Sessions, req.Subject)
+	if err != nil {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.ServerError,
+			Description: "failed to revoke sessions",
+		}
+		report.SetError(e)
+		errors.SendJSON(c, e)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": revoked,
+	})
+}
+
+func (api *LauthAPI) DeleteAdminSession(c *gin.Context) {
+	report := metrics.StartLogging(c)
+	defer report.Close()
+
+	err := api.Sessions.Revoke(c.Param("id"))
+	if err == session.SessionNotFoundError {
+		e := &errors.Error{
+			Reason:      errors.PageNotFound,
+			Description: "session was not found",
+		}
+		report.SetError(e)
+		errors.SendJSON(c, e)
+		return
+	} else if err != nil {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.ServerError,
+			Description: "failed to revoke session",
+		}
+		report.SetError(e)
+		errors.SendJSON(c, e)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revoked": 1,
+	})
+}
+
+func (api *LauthAPI) GetAdminCredentials(c *gin.Context) {
+	report := metrics.StartLogging(c)
+	defer report.Close()
+
+	c.Header("Cache-Control", "no-store")
+
+	var req AdminCredentialsRequest
+	if err := c.ShouldBind(&req); err != nil || req.Subject == "" {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.InvalidRequest,
+			Description: "subject is required",
+		}
+		report.SetError(e)
+		errors.SendJSON(c, e)
+		return
+	}
+
+	creds, err := api.Credentials.Credentials(req.Subject)
+	if err != nil {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.ServerError,
+			Description: "failed to get credentials",
+		}
+		report.SetError(e)
+		errors.SendJSON(c, e)
+		return
+	}
+
+	if creds == nil {
+		creds = []webauthn.Credential{}
+	}
+
+	// public keys are not secret, but not useful for administrators.
+	for i := range creds {
+		creds[i].PublicKey = nil
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"credentials": creds,
+	})
+}
+
+func (api *LauthAPI) DeleteAdminCredentials(c *gin.Context) {
+	report := metrics.StartLogging(c)
+	defer report.Close()
+
+	var req AdminCredentialsRequest
+	if err := c.ShouldBind(&req); err != nil || req.Subject == "" {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.InvalidRequest,
+			Description: "subject is required",
+		}
+		report.SetError(e)
+		errors.SendJSON(c, e)
+		return
+	}
+
+	deleted, err := api.Credentials.DeleteAll(req.Subject)
+	if err != nil {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.ServerError,
+			Description: "failed to delete credentials",
+		}
+		report.SetError(e)
+		errors.SendJSON(c, e)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deleted": deleted,
+	})
+}
+
+func (api *LauthAPI) GetAdminLockouts(c *gin.Context) {
+	report := metrics.StartLogging(c)
+	defer report.Close()
+
+	c.Header("Cache-Control", "no-store")
+
+	store := api.lockoutStore()
+
+	rs := []lockout.Record{}
+	for _, kind := range lockout.Kinds {
+		xs, err := store.List(kind)
+		if err != nil {
+			e := &errors.Error{
+				Err:         err,
+				Reason:      errors.ServerError,
+				Description: "failed to get lockouts",
+			}
+			report.SetError(e)
+			errors.SendJSON(c, e)
+			return
+		}
+		rs = append(rs, xs...)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"lockouts": rs,
+	})
+}
+
+func (api *LauthAPI) DeleteAdminLockouts(c *gin.Context) {
+	report := metrics.StartLogging(c)
+	defer report.Close()
+
+	var req AdminLockoutsRequest
+	if err := c.ShouldBind(&req); err != nil || (req.Username == "" && req.IP == "") {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.InvalidRequest,
+			Description: "username or ip is required",
+		}
+		report.SetError(e)
+		errors.SendJSON(c, e)
+		return
+	}
+
+	keys := map[lockout.Kind]string{
+		lockout.Username: api.lockoutUsername(req.Directory, req.Username),
+		lockout.IP:       req.IP,
+	}
+
+	cleared := 0
+	for _, kind := range lockout.Kinds {
+		if keys[kind] == "" {
+			continue
+		}
+
+		ok, err := api.lockoutStore().Reset(kind, keys[kind])
+		if err != nil {
+			e := &errors.Error{
+				Err:         err,
+				Reason:      errors.ServerError,
+				Description: "failed to clear lockout",
+			}
+			report.SetError(e)
+			errors.SendJSON(c, e)
+			return
+		}
+		if ok {
+			cleared++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"cleared": cleared,
+	})
+}
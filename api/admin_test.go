@@ -0,0 +1,99 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/testutil"
+)
+
+func TestAdminSessions(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+
+	first := env.MakeSSOSession(t, "macrat", []string{"some_client_id"}, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	second := env.MakeSSOSession(t, "macrat", []string{"implicit_client_id"}, time.Now(), time.Now().Add(time.Hour))
+	env.MakeSSOSession(t, "j.smith", []string{"some_client_id"}, time.Now(), time.Now().Add(time.Hour))
+
+	request := func(method, path string) *http.Response {
+		req, _ := http.NewRequest(method, path, nil)
+		req.SetBasicAuth("admin", "admin password")
+		return env.DoRequest(req).Result()
+	}
+
+	listSessions := func(t *testing.T, path string) []string {
+		t.Helper()
+
+		resp := request("GET", path)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", resp.StatusCode)
+		}
+
+		var body struct {
+			Sessions []struct {
+				ID      string `json:"id"`
+				Subject string `json:"sub"`
+			} `json:"sessions"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to parse response: %s", err)
+		}
+
+		var ids []string
+		for _, s := range body.Sessions {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+
+	t.Run("without credentials", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/admin/sessions", nil)
+		if resp := env.DoRequest(req); resp.Code != http.StatusUnauthorized {
+			t.Errorf("unexpected status code: %d", resp.Code)
+		}
+
+		req, _ = http.NewRequest("DELETE", "/admin/sessions/"+first, nil)
+		req.SetBasicAuth("admin", "wrong password")
+		if resp := env.DoRequest(req); resp.Code != http.StatusUnauthorized {
+			t.Errorf("unexpected status code: %d", resp.Code)
+		}
+	})
+
+	t.Run("list all", func(t *testing.T) {
+		if ids := listSessions(t, "/admin/sessions"); len(ids) != 3 {
+			t.Errorf("unexpected sessions: %#v", ids)
+		}
+	})
+
+	t.Run("list by subject", func(t *testing.T) {
+		ids := listSessions(t, "/admin/sessions?subject=macrat")
+		if len(ids) != 2 || ids[0] != first || ids[1] != second {
+			t.Errorf("unexpected sessions: %#v", ids)
+		}
+	})
+
+	t.Run("revoke one", func(t *testing.T) {
+		if resp := request("DELETE", "/admin/sessions/"+first); resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		if resp := request("DELETE", "/admin/sessions/"+first); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		if ids := listSessions(t, "/admin/sessions?subject=macrat"); len(ids) != 1 || ids[0] != second {
+			t.Errorf("unexpected sessions: %#v", ids)
+		}
+	})
+
+	t.Run("revoke by subject", func(t *testing.T) {
+		if resp := request("DELETE", "/admin/sessions"); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		if resp := request("DELETE", "/admin/sessions?subject=j.smith"); resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		if ids := listSessions(t, "/admin/sessions"); len(ids) != 1 || ids[0] != second {
+			t.Errorf("unexpected sessions: %#v", ids)
+		}
+	})
+}
@@ -6,16 +6,29 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/consent"
 	"github.com/macrat/lauth/errors"
 	"github.com/macrat/lauth/ldap"
 	"github.com/macrat/lauth/metrics"
+	"github.com/macrat/lauth/session"
+	"github.com/macrat/lauth/store"
 	"github.com/macrat/lauth/token"
+	"github.com/macrat/lauth/upstream"
+	"github.com/macrat/lauth/webauthn"
 )
 
 type LauthAPI struct {
 	Connector    ldap.Connector
 	Config       *config.Config
 	TokenManager token.Manager
+	Store        store.Store
+	Sessions     session.Store
+	Credentials  webauthn.CredentialStore
+	Upstreams    upstream.Providers
+}
+
+func (api *LauthAPI) Consents() consent.Store {
+	return consent.Store{Store: api.Store}
 }
 
 func (api *LauthAPI) SetRoutes(r gin.IRoutes) {
@@ -32,6 +45,17 @@ func (api *LauthAPI) SetRoutes(r gin.IRoutes) {
 	r.GET(endpoints.Jwks, api.GetCerts)
 	r.GET(endpoints.Logout, api.Logout)
 	r.POST(endpoints.Logout, api.Logout)
+
+	if len(api.Config.Upstreams) > 0 {
+		r.GET(endpoints.UpstreamCallback, api.GetUpstreamCallback)
+		r.POST(endpoints.UpstreamCallback, api.PostAuthz) // the page of the second factor is posted to here.
+	}
+
+	if api.Config.HasSAMLClients() {
+		r.GET(endpoints.SAMLMetadata, api.GetSAMLMetadata)
+		r.GET(endpoints.SAMLSSO, api.SAMLSSO)
+		r.POST(endpoints.SAMLSSO, api.SAMLSSO)
+	}
 }
 
 func (api *LauthAPI) SetErrorRoutes(r *gin.Engine) {
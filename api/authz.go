@@ -2,6 +2,7 @@ package api
 
 import (
 	"fmt"
+	"html/template"
 	"io"
 	"net/http"
 	"net/url"
@@ -10,8 +11,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/macrat/lauth/errors"
+	"github.com/macrat/lauth/lockout"
 	"github.com/macrat/lauth/metrics"
+	"github.com/macrat/lauth/mfa"
+	"github.com/macrat/lauth/session"
 	"github.com/macrat/lauth/token"
+	"github.com/macrat/lauth/webauthn"
+	"github.com/rs/zerolog/log"
 )
 
 type AuthzRequest struct {
@@ -30,8 +36,30 @@ type AuthzRequest struct {
 	RequestURI string `form:"request_uri" json:"request_uri" xml:"request_uri"`
 
 	// use only POST method
-	User     string `form:"username" json:"username" xml:"username"`
-	Password string `form:"password" json:"password" xml:"password"`
+	User      string `form:"username"  json:"username"  xml:"username"`
+	Password  string `form:"password"  json:"password"  xml:"password"`
+	Directory string `form:"directory" json:"directory" xml:"directory"`
+	Upstream  string `form:"upstream"  json:"upstream"  xml:"upstream"`
+	Consent   string `form:"consent"   json:"consent"   xml:"consent"`
+	OTP       string `form:"otp"       json:"otp"       xml:"otp"`
+	WebAuthn  string `form:"webauthn"  json:"webauthn"  xml:"webauthn"`
+
+	RegisterPasskey bool `form:"register_passkey" json:"register_passkey" xml:"register_passkey"`
+
+	NewPassword        string `form:"new_password"         json:"new_password"         xml:"new_password"`
+	NewPasswordConfirm string `form:"new_password_confirm" json:"new_password_confirm" xml:"new_password_confirm"`
+
+	// carried in the request object while the user is changing the expired password
+	PasswordChangeUser      string `form:"-" json:"-" xml:"-"`
+	PasswordChangeDirectory string `form:"-" json:"-" xml:"-"`
+
+	// carried in the request object while the user is passing the second factor
+	MFASubject        string `form:"-" json:"-" xml:"-"`
+	MFAUsername       string `form:"-" json:"-" xml:"-"`
+	MFASecret         string `form:"-" json:"-" xml:"-"`
+	MFAMethod         string `form:"-" json:"-" xml:"-"`
+	MFAUpstream       bool   `form:"-" json:"-" xml:"-"`
+	WebAuthnChallenge string `form:"-" json:"-" xml:"-"`
 
 	RequestExpiresAt int64  `form:"-" json:"-" xml:"-"`
 	RequestSubject   string `form:"-" json:"-" xml:"-"`
@@ -69,6 +97,17 @@ func (req *AuthzRequest) RequestObjectClaims() token.RequestObjectClaims {
 		State:        req.State,
 		Nonce:        req.Nonce,
 		MaxAge:       req.MaxAge,
+		MFASubject:   req.MFASubject,
+		MFAUsername:  req.MFAUsername,
+		MFASecret:    req.MFASecret,
+
+		MFAMethod:         req.MFAMethod,
+		MFAUpstream:       req.MFAUpstream && req.MFASubject != "",
+		WebAuthnChallenge: req.WebAuthnChallenge,
+		RegisterPasskey:   req.RegisterPasskey && req.MFASubject != "",
+
+		PasswordChangeUser:      req.PasswordChangeUser,
+		PasswordChangeDirectory: req.PasswordChangeDirectory,
 	}
 }
 
@@ -281,6 +320,16 @@ func (req *GetAuthzRequestUnmarshaller) validate(api *LauthAPI) *errors.Error {
 		)
 	}
 
+	scope, err := api.allowedScope(req.ClientID, ParseStringSet(req.Scope))
+	if err != nil {
+		return req.GetRequest().makeRedirectError(
+			err,
+			errors.InvalidScope,
+			err.Error(),
+		)
+	}
+	req.Scope = scope.String()
+
 	prompt := ParseStringSet(req.Prompt)
 	if prompt.Has("none") && (prompt.Has("login") || prompt.Has("select_account") || prompt.Has("consent")) {
 		return req.GetRequest().makeRedirectError(
@@ -309,9 +358,19 @@ func (req *GetAuthzRequestUnmarshaller) PreProcess(api *LauthAPI) *errors.Error
 }
 
 type PostAuthzRequestUnmarshaller struct {
-	Request  string `form:"request"  json:"request"  xml:"request"`
-	User     string `form:"username" json:"username" xml:"username"`
-	Password string `form:"password" json:"password" xml:"password"`
+	Request   string `form:"request"   json:"request"   xml:"request"`
+	User      string `form:"username"  json:"username"  xml:"username"`
+	Password  string `form:"password"  json:"password"  xml:"password"`
+	Directory string `form:"directory" json:"directory" xml:"directory"`
+	Upstream  string `form:"upstream"  json:"upstream"  xml:"upstream"`
+	Consent   string `form:"consent"   json:"consent"   xml:"consent"`
+	OTP       string `form:"otp"       json:"otp"       xml:"otp"`
+	WebAuthn  string `form:"webauthn"  json:"webauthn"  xml:"webauthn"`
+
+	RegisterPasskey bool `form:"register_passkey" json:"register_passkey" xml:"register_passkey"`
+
+	NewPassword        string `form:"new_password"         json:"new_password"         xml:"new_password"`
+	NewPasswordConfirm string `form:"new_password_confirm" json:"new_password_confirm" xml:"new_password_confirm"`
 
 	claims token.RequestObjectClaims
 }
@@ -326,8 +385,28 @@ func (req *PostAuthzRequestUnmarshaller) GetRequest() *AuthzRequest {
 		Nonce:        req.claims.Nonce,
 		MaxAge:       req.claims.MaxAge,
 
-		User:     req.User,
-		Password: req.Password,
+		User:      req.User,
+		Password:  req.Password,
+		Directory: req.Directory,
+		Upstream:  req.Upstream,
+		Consent:   req.Consent,
+		OTP:       req.OTP,
+		WebAuthn:  req.WebAuthn,
+
+		RegisterPasskey: req.RegisterPasskey || req.claims.RegisterPasskey,
+
+		NewPassword:        req.NewPassword,
+		NewPasswordConfirm: req.NewPasswordConfirm,
+
+		PasswordChangeUser:      req.claims.PasswordChangeUser,
+		PasswordChangeDirectory: req.claims.PasswordChangeDirectory,
+
+		MFASubject:        req.claims.MFASubject,
+		MFAUsername:       req.claims.MFAUsername,
+		MFASecret:         req.claims.MFASecret,
+		MFAMethod:         req.claims.MFAMethod,
+		MFAUpstream:       req.claims.MFAUpstream,
+		WebAuthnChallenge: req.claims.WebAuthnChallenge,
 
 		RequestExpiresAt: req.claims.ExpiresAt,
 		RequestSubject:   req.claims.Subject,
@@ -375,6 +454,9 @@ type AuthzContext struct {
 	Gin     *gin.Context
 	Request *AuthzRequest
 	Report  *metrics.Context
+
+	// reserved is the login attempt that counted by ReserveLoginAttempt in advance.
+	reserved map[lockout.Kind]lockout.Record
 }
 
 func NewAuthzContext(api *LauthAPI, c *gin.Context) (*AuthzContext, *errors.Error) {
@@ -400,6 +482,11 @@ func NewAuthzContext(api *LauthAPI, c *gin.Context) (*AuthzContext, *errors.Erro
 		return nil, e
 	}
 
+	return newAuthzContext(api, c, m, unmarshaller)
+}
+
+// newAuthzContext makes AuthzContext from the request that already unmarshalled.
+func newAuthzContext(api *LauthAPI, c *gin.Context, m *metrics.Context, unmarshaller AuthzRequestUnmarshaller) (*AuthzContext, *errors.Error) {
 	if err := unmarshaller.PreProcess(api); err != nil {
 		m.SetError(err)
 		m.Close()
@@ -442,6 +529,10 @@ func (ctx *AuthzContext) MakeRequestObject() (string, error) {
 
 func (ctx *AuthzContext) ErrorRedirect(err *errors.Error) {
 	ctx.Report.SetError(err)
+	if ctx.Request.ResponseType == SAML_RESPONSE_TYPE && err.RedirectURI != nil {
+		ctx.API.sendSAMLError(ctx.Gin, ctx.Request, err)
+		return
+	}
 	errors.SendRedirect(ctx.Gin, err)
 }
 
@@ -450,32 +541,52 @@ func (ctx *AuthzContext) TrySSO(authorized bool) (proceed bool) {
 
 	prompt := ParseStringSet(ctx.Request.Prompt)
 
-	if prompt.Has("login") || prompt.Has("select_account") || ctx.API.Config.Expire.SSO <= 0 {
+	expire := ctx.API.Config.ExpireFor(ctx.Request.ClientID)
+
+	if prompt.Has("login") || prompt.Has("select_account") || expire.SSO <= 0 {
 		return false
 	}
 
-	token, err := ctx.API.GetSSOToken(ctx.Gin)
-	if err == nil {
-		if ctx.Request.MaxAge <= 0 || ctx.Request.MaxAge > time.Now().Unix()-token.AuthTime {
+	// the client can require shorter SSO lifetime than the session has.
+	clientSSO := ctx.API.Config.Clients[ctx.Request.ClientID].Expire.SSO
+
+	sess, err := ctx.API.GetSSOSession(ctx.Gin)
+	if err == nil && (clientSSO <= 0 || time.Since(sess.AuthTime) < clientSSO.Duration()) {
+		if ctx.Request.MaxAge <= 0 || ctx.Request.MaxAge > time.Now().Unix()-sess.AuthTime.Unix() {
 			ctx.Report.Set("authn_by", "sso_token")
-			ctx.Report.Set("username", token.Subject)
+			ctx.Report.Set("username", sess.DisplayName())
+
+			if !ctx.CheckAccess(sess.Subject) {
+				return true
+			}
+
+			if !ctx.SessionSatisfiesMFA(sess) {
+				if prompt.Has("none") {
+					ctx.ErrorRedirect(ctx.Request.makeRedirectError(nil, errors.LoginRequired, ""))
+					return true
+				}
+				return false
+			}
 
-			if !authorized && (prompt.Has("consent") || !token.Authorized.Includes(ctx.Request.ClientID)) {
+			if !authorized && ctx.NeedConsent(sess) {
 				if prompt.Has("none") {
 					ctx.ErrorRedirect(ctx.Request.makeRedirectError(nil, errors.InteractionRequired, ""))
 				} else {
-					ctx.ShowConfirmPage(http.StatusOK, token.Subject)
+					ctx.ShowConsentPage(http.StatusOK, sess.DisplayName())
 				}
 
 				return true
 			}
 
-			ctx.API.SetSSOToken(ctx.Gin, token.Subject, ctx.Request.ClientID, false)
-			ctx.SendTokens(token.Subject, time.Unix(token.AuthTime, 0))
+			ctx.API.SetSSOSession(ctx.Gin, sess.Subject, sess.Username, ctx.Request.ClientID, sess.AuthMethods, false)
+			if authorized {
+				ctx.RememberConsent(sess.Subject)
+			}
+			ctx.SendTokens(sess.Subject, sess.AuthTime, sess.AuthMethods)
 			return true
 		}
-	} else if err != http.ErrNoCookie {
-		ctx.API.DeleteSSOToken(ctx.Gin)
+	} else if err != nil && err != http.ErrNoCookie {
+		ctx.API.DeleteSSOSession(ctx.Gin)
 	}
 
 	if prompt.Has("none") {
@@ -487,7 +598,100 @@ func (ctx *AuthzContext) TrySSO(authorized bool) (proceed bool) {
 	return false
 }
 
-func (ctx *AuthzContext) showPage(code int, authzOnly bool, initialUser, errorDescription string) {
+// CheckAccess reports the user is allowed to use the client.
+//
+// If the user is not allowed, it responds access_denied error and returns false.
+func (ctx *AuthzContext) CheckAccess(subject string) bool {
+	filter := ctx.API.Config.Clients[ctx.Request.ClientID].AccessFilter()
+	if filter == "" {
+		return true
+	}
+
+	conn, reason, err := ctx.API.connectLDAP()
+	if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, reason, "failed to connecting LDAP server"))
+		return false
+	}
+	defer conn.Close()
+
+	allowed, err := conn.MatchFilter(subject, filter)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to check user permission")
+
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, unavailableOr(err, errors.ServerError), "failed to check user permission"))
+		return false
+	}
+
+	if !allowed {
+		ctx.Report.Denied()
+
+		if ParseStringSet(ctx.Request.Prompt).Has("none") {
+			ctx.ErrorRedirect(ctx.Request.makeRedirectError(nil, errors.AccessDenied, "user is not allowed to use this client"))
+		} else {
+			ctx.ErrorRedirect(ctx.Request.makeNonRedirectError(nil, errors.AccessDenied, "user is not allowed to use this client"))
+		}
+		return false
+	}
+
+	return true
+}
+
+// NeedConsent reports the end-user has to consent to the request before issue tokens.
+func (ctx *AuthzContext) NeedConsent(sess session.Session) bool {
+	if ParseStringSet(ctx.Request.Prompt).Has("consent") {
+		return true
+	}
+
+	if ctx.API.Config.Clients[ctx.Request.ClientID].SkipConsent {
+		return false
+	}
+
+	if ctx.API.Config.Expire.Consent <= 0 {
+		return !sess.IsAuthorized(ctx.Request.ClientID)
+	}
+
+	granted, err := ctx.API.Consents().IsGranted(sess.Subject, ctx.Request.ClientID, ParseStringSet(ctx.Request.Scope).List())
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to get consent")
+		return true
+	}
+	return !granted
+}
+
+// RememberConsent records that the end-user consented to the request.
+func (ctx *AuthzContext) RememberConsent(subject string) {
+	if ctx.API.Config.Expire.Consent <= 0 {
+		return
+	}
+
+	err := ctx.API.Consents().Grant(
+		subject,
+		ctx.Request.ClientID,
+		ParseStringSet(ctx.Request.Scope).List(),
+		ctx.API.Config.Expire.Consent.Duration(),
+	)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to save consent")
+	}
+}
+
+type upstreamButton struct {
+	Name        string
+	DisplayName string
+}
+
+type scopeDescription struct {
+	Name   string
+	Claims []string
+}
+
+func (ctx *AuthzContext) showPage(code int, templateName, initialUser, errorDescription string) {
 	requestObject, err := ctx.MakeRequestObject()
 	if err != nil {
 		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, "server_error", "failed to create login session"))
@@ -496,6 +700,14 @@ func (ctx *AuthzContext) showPage(code int, authzOnly bool, initialUser, errorDe
 
 	client := ctx.API.Config.Clients[ctx.Request.ClientID]
 
+	var scopes []scopeDescription
+	for _, s := range ParseStringSet(ctx.Request.Scope).List() {
+		scopes = append(scopes, scopeDescription{
+			Name:   s,
+			Claims: ctx.API.Config.Scopes.ClaimsOf(s),
+		})
+	}
+
 	data := map[string]interface{}{
 		"client": map[string]interface{}{
 			"ID":      ctx.Request.ClientID,
@@ -504,24 +716,66 @@ func (ctx *AuthzContext) showPage(code int, authzOnly bool, initialUser, errorDe
 		},
 		"response_type":    ctx.Request.ResponseType,
 		"request":          requestObject,
+		"scopes":           scopes,
+		"username":         initialUser,
 		"initial_username": initialUser,
 		"error":            errorDescription,
-		"authz_only":       authzOnly,
 	}
-	ctx.Gin.HTML(code, "login.tmpl", data)
+	if message, ok := errorMessages[errorDescription]; ok {
+		data["error_message"] = message
+	}
+	if ctx.Request.MFASecret != "" {
+		data["totp_secret"] = ctx.Request.MFASecret
+		// otpauth:// is not a safe scheme for html/template, but it is made by us.
+		data["totp_uri"] = template.URL(mfa.URI(ctx.API.Config.Issuer.Hostname(), ctx.Request.MFAUsername, ctx.Request.MFASecret))
+	}
+	if ctx.API.Config.WebAuthn.Enabled {
+		options, err := ctx.webauthnOptions()
+		if err != nil {
+			ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to create login session"))
+			return
+		}
+		if options != nil {
+			data["webauthn_options"] = options
+			data["webauthn_register"] = ctx.Request.MFAMethod == MFA_METHOD_WEBAUTHN_REGISTER
+		}
+		data["webauthn_enroll"] = ctx.API.Config.WebAuthn.Enroll
+		data["webauthn_failed"] = ctx.Request.WebAuthn != "" && errorDescription != ""
+	}
+	if ctx.API.Config.DirectoryPicker {
+		data["directories"] = ctx.API.Config.DirectoryNames()
+		data["initial_directory"] = ctx.Request.Directory
+	}
+	if len(ctx.API.Config.Upstreams) > 0 {
+		var upstreams []upstreamButton
+		for _, u := range ctx.API.Config.Upstreams {
+			upstreams = append(upstreams, upstreamButton{Name: u.Name, DisplayName: u.DisplayName})
+		}
+		data["upstreams"] = upstreams
+	}
+	ctx.Gin.HTML(code, templateName, data)
 }
 
 func (ctx *AuthzContext) ShowLoginPage(code int, initialUser string, errorDescription string) {
+	if ctx.API.Config.WebAuthn.Passwordless {
+		challenge, err := webauthn.NewChallenge()
+		if err != nil {
+			ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to create login session"))
+			return
+		}
+		ctx.Request.WebAuthnChallenge = challenge
+	}
+
 	ctx.Report.Continue()
-	ctx.showPage(code, false, initialUser, errorDescription)
+	ctx.showPage(code, "login.tmpl", initialUser, errorDescription)
 }
 
-func (ctx *AuthzContext) ShowConfirmPage(code int, initialUser string) {
+func (ctx *AuthzContext) ShowConsentPage(code int, username string) {
 	ctx.Report.Continue()
-	ctx.showPage(code, true, initialUser, "")
+	ctx.showPage(code, "consent.tmpl", username, "")
 }
 
-func (ctx *AuthzContext) makeCodeToken(subject string, authTime time.Time) (string, *errors.Error) {
+func (ctx *AuthzContext) makeCodeToken(subject string, authTime time.Time, authMethods []string) (string, *errors.Error) {
 	code, err := ctx.API.TokenManager.CreateCode(
 		ctx.API.Config.Issuer,
 		subject,
@@ -530,7 +784,8 @@ func (ctx *AuthzContext) makeCodeToken(subject string, authTime time.Time) (stri
 		ctx.Request.Scope,
 		ctx.Request.Nonce,
 		authTime,
-		ctx.API.Config.Expire.Code.Duration(),
+		authMethods,
+		ctx.API.Config.ExpireFor(ctx.Request.ClientID).Code.Duration(),
 	)
 	if err != nil {
 		return "", ctx.Request.makeRedirectError(err, errors.ServerError, "failed to generate code")
@@ -545,7 +800,7 @@ func (ctx *AuthzContext) makeAccessToken(subject string, authTime time.Time) (st
 		ctx.Request.ClientID,
 		ctx.Request.Scope,
 		authTime,
-		ctx.API.Config.Expire.Token.Duration(),
+		ctx.API.Config.ExpireFor(ctx.Request.ClientID).Token.Duration(),
 	)
 	if err != nil {
 		return "", ctx.Request.makeRedirectError(err, errors.ServerError, "failed to generate access_token")
@@ -553,7 +808,7 @@ func (ctx *AuthzContext) makeAccessToken(subject string, authTime time.Time) (st
 	return token, nil
 }
 
-func (ctx *AuthzContext) makeIDToken(subject string, authTime time.Time, code, accessToken string) (string, *errors.Error) {
+func (ctx *AuthzContext) makeIDToken(subject string, authTime time.Time, authMethods []string, code, accessToken string) (string, *errors.Error) {
 	scope := ParseStringSet(ctx.Request.Scope)
 	userinfo, errMsg := ctx.API.userinfo(subject, scope)
 	if errMsg != nil {
@@ -570,7 +825,8 @@ func (ctx *AuthzContext) makeIDToken(subject string, authTime time.Time, code, a
 		accessToken,
 		userinfo,
 		authTime,
-		ctx.API.Config.Expire.Token.Duration(),
+		authMethods,
+		ctx.API.Config.ExpireFor(ctx.Request.ClientID).Token.Duration(),
 	)
 	if err != nil {
 		return "", ctx.Request.makeRedirectError(err, errors.ServerError, "failed to generate id_token")
@@ -579,7 +835,7 @@ func (ctx *AuthzContext) makeIDToken(subject string, authTime time.Time, code, a
 	return token, nil
 }
 
-func (ctx *AuthzContext) makeAuthzTokens(subject string, authTime time.Time) (*url.URL, *errors.Error) {
+func (ctx *AuthzContext) makeAuthzTokens(subject string, authTime time.Time, authMethods []string) (*url.URL, *errors.Error) {
 	resp := make(url.Values)
 
 	if ctx.Request.State != "" {
@@ -589,7 +845,7 @@ func (ctx *AuthzContext) makeAuthzTokens(subject string, authTime time.Time) (*u
 	rt := ParseStringSet(ctx.Request.ResponseType)
 
 	if rt.Has("code") {
-		code, err := ctx.makeCodeToken(subject, authTime)
+		code, err := ctx.makeCodeToken(subject, authTime, authMethods)
 		if err != nil {
 			return nil, err
 		}
@@ -603,15 +859,15 @@ func (ctx *AuthzContext) makeAuthzTokens(subject string, authTime time.Time) (*u
 		resp.Set("token_type", "Bearer")
 		resp.Set("access_token", token)
 		resp.Set("scope", ctx.Request.Scope)
-		resp.Set("expires_in", ctx.API.Config.Expire.Token.StrSeconds())
+		resp.Set("expires_in", ctx.API.Config.ExpireFor(ctx.Request.ClientID).Token.StrSeconds())
 	}
 	if rt.Has("id_token") {
-		token, err := ctx.makeIDToken(subject, authTime, resp.Get("code"), resp.Get("access_token"))
+		token, err := ctx.makeIDToken(subject, authTime, authMethods, resp.Get("code"), resp.Get("access_token"))
 		if err != nil {
 			return nil, err
 		}
 		resp.Set("id_token", token)
-		resp.Set("expires_in", ctx.API.Config.Expire.Token.StrSeconds())
+		resp.Set("expires_in", ctx.API.Config.ExpireFor(ctx.Request.ClientID).Token.StrSeconds())
 	}
 
 	redirectURI, _ := url.Parse(ctx.Request.RedirectURI)
@@ -623,8 +879,16 @@ func (ctx *AuthzContext) makeAuthzTokens(subject string, authTime time.Time) (*u
 	return redirectURI, nil
 }
 
-func (ctx *AuthzContext) SendTokens(subject string, authTime time.Time) {
-	redirect, errMsg := ctx.makeAuthzTokens(subject, authTime)
+// SendTokens issues tokens for the user, and sends them to the client.
+//
+// authMethods is the amr claim, the list of methods used for authenticate the user.
+func (ctx *AuthzContext) SendTokens(subject string, authTime time.Time, authMethods []string) {
+	if ctx.Request.ResponseType == SAML_RESPONSE_TYPE {
+		ctx.SendSAMLResponse(subject, authTime, authMethods)
+		return
+	}
+
+	redirect, errMsg := ctx.makeAuthzTokens(subject, authTime, authMethods)
 
 	if errMsg != nil {
 		ctx.ErrorRedirect(errMsg)
@@ -1,14 +1,18 @@
 package api_test
 
 import (
+	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/macrat/lauth/api"
 	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/ldap"
 	"github.com/macrat/lauth/testutil"
+	"github.com/macrat/lauth/token"
 )
 
 func authzEndpointCommonTests(t *testing.T, c *config.Config) []testutil.RedirectTest {
@@ -109,13 +113,15 @@ func TestSSOLogin(t *testing.T) {
 		t.Fatalf("cookies for SSO was not found")
 	}
 
-	cookie, _ := (&http.Request{Header: http.Header{"Cookie": rawCookie}}).Cookie(api.SSO_TOKEN_COOKIE)
+	cookie, _ := (&http.Request{Header: http.Header{"Cookie": rawCookie}}).Cookie(api.SSO_SESSION_COOKIE)
 
-	ssoToken, err := env.API.TokenManager.ParseSSOToken(cookie.Value)
+	sess, err := env.API.Sessions.Get(cookie.Value)
 	if err != nil {
-		t.Errorf("failed to parse token in cookie: %s", err)
-	} else if err := ssoToken.Validate(env.API.Config.Issuer); err != nil {
-		t.Errorf("token in cookie is invalid: %s", err)
+		t.Fatalf("failed to get session in cookie: %s", err)
+	} else if sess.Subject != "macrat" {
+		t.Errorf("unexpected subject in session: %s", sess.Subject)
+	} else if !sess.IsAuthorized("some_client_id") {
+		t.Errorf("session is not authorized some_client_id: %#v", sess.Authorized)
 	}
 
 	t.Log("---------- login with SSO token ----------")
@@ -144,10 +150,10 @@ func TestSSOLogin(t *testing.T) {
 		t.Errorf("failed to parse code: %s", err)
 	} else if err = code.Validate(env.API.Config.Issuer); err != nil {
 		t.Errorf("respond code is invalid: %s", err)
-	} else if code.AuthTime != ssoToken.AuthTime {
-		t.Errorf("auth_time is not match: sso_token=%d != code=%d", ssoToken.AuthTime, code.AuthTime)
-	} else if code.Subject != ssoToken.Subject {
-		t.Errorf("auth_time is not match: sso_token=%s != code=%s", ssoToken.Subject, code.Subject)
+	} else if code.AuthTime != sess.AuthTime.Unix() {
+		t.Errorf("auth_time is not match: session=%d != code=%d", sess.AuthTime.Unix(), code.AuthTime)
+	} else if code.Subject != sess.Subject {
+		t.Errorf("subject is not match: session=%s != code=%s", sess.Subject, code.Subject)
 	}
 
 	t.Log("---------- show consent prompt with SSO token ----------")
@@ -207,10 +213,10 @@ func TestSSOLogin(t *testing.T) {
 		t.Errorf("failed to parse code: %s", err)
 	} else if err = code.Validate(env.API.Config.Issuer); err != nil {
 		t.Errorf("respond code is invalid: %s", err)
-	} else if code.AuthTime != ssoToken.AuthTime {
-		t.Errorf("auth_time is not match: sso_token=%d != code=%d", ssoToken.AuthTime, code.AuthTime)
-	} else if code.Subject != ssoToken.Subject {
-		t.Errorf("auth_time is not match: sso_token=%s != code=%s", ssoToken.Subject, code.Subject)
+	} else if code.AuthTime != sess.AuthTime.Unix() {
+		t.Errorf("auth_time is not match: session=%d != code=%d", sess.AuthTime.Unix(), code.AuthTime)
+	} else if code.Subject != sess.Subject {
+		t.Errorf("subject is not match: session=%s != code=%s", sess.Subject, code.Subject)
 	}
 
 	t.Log("---------- try login without prompt by another client with SSO token ----------")
@@ -268,10 +274,41 @@ func TestSSOLogin(t *testing.T) {
 		t.Errorf("expected consent page but got password input")
 	}
 
+	t.Log("---------- deny consent by another client ----------")
+
 	params = url.Values{}
 	for k, v := range inputs {
 		params.Add(k, v)
 	}
+	params.Set("consent", "deny")
+
+	req, _ = http.NewRequest("POST", "/authz", strings.NewReader(params.Encode()))
+	for _, c := range rawCookie {
+		req.Header.Add("Cookie", c)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp = env.DoRequest(req)
+	if resp.Code != http.StatusFound {
+		t.Fatalf("unexpected status code on deny consent: %d", resp.Code)
+	}
+
+	location, err = url.Parse(resp.Header().Get("Location"))
+	if err != nil {
+		t.Errorf("failed to parse location: %s", err)
+	} else if errMsg := location.Query().Get("error"); errMsg != "access_denied" {
+		t.Errorf("unexpected error message: %#v", errMsg)
+	}
+
+	if granted, err := env.API.Consents().IsGranted("macrat", "implicit_client_id", nil); err != nil {
+		t.Errorf("failed to check consent: %s", err)
+	} else if granted {
+		t.Errorf("consent was granted even though denied")
+	}
+
+	t.Log("---------- approve consent by another client ----------")
+
+	params.Set("consent", "approve")
 
 	req, _ = http.NewRequest("POST", "/authz", strings.NewReader(params.Encode()))
 	for _, c := range rawCookie {
@@ -284,6 +321,12 @@ func TestSSOLogin(t *testing.T) {
 		t.Fatalf("unexpected status code on login via consent prompt: %d", resp.Code)
 	}
 
+	if granted, err := env.API.Consents().IsGranted("macrat", "implicit_client_id", nil); err != nil {
+		t.Errorf("failed to check consent: %s", err)
+	} else if !granted {
+		t.Errorf("consent was not remembered")
+	}
+
 	location, err = url.Parse(resp.Header().Get("Location"))
 	if err != nil {
 		t.Errorf("failed to parse location: %s", err)
@@ -293,10 +336,10 @@ func TestSSOLogin(t *testing.T) {
 		t.Errorf("failed to parse code: %s", err)
 	} else if err = code.Validate(env.API.Config.Issuer); err != nil {
 		t.Errorf("respond code is invalid: %s", err)
-	} else if code.AuthTime != ssoToken.AuthTime {
-		t.Errorf("auth_time is not match: sso_token=%d != code=%d", ssoToken.AuthTime, code.AuthTime)
-	} else if code.Subject != ssoToken.Subject {
-		t.Errorf("auth_time is not match: sso_token=%s != code=%s", ssoToken.Subject, code.Subject)
+	} else if code.AuthTime != sess.AuthTime.Unix() {
+		t.Errorf("auth_time is not match: session=%d != code=%d", sess.AuthTime.Unix(), code.AuthTime)
+	} else if code.Subject != sess.Subject {
+		t.Errorf("subject is not match: session=%s != code=%s", sess.Subject, code.Subject)
 	}
 
 	t.Log("---------- first client still can login with SSO token ----------")
@@ -325,9 +368,142 @@ func TestSSOLogin(t *testing.T) {
 		t.Errorf("failed to parse code: %s", err)
 	} else if err = code.Validate(env.API.Config.Issuer); err != nil {
 		t.Errorf("respond code is invalid: %s", err)
-	} else if code.AuthTime != ssoToken.AuthTime {
-		t.Errorf("auth_time is not match: sso_token=%d != code=%d", ssoToken.AuthTime, code.AuthTime)
-	} else if code.Subject != ssoToken.Subject {
-		t.Errorf("auth_time is not match: sso_token=%s != code=%s", ssoToken.Subject, code.Subject)
+	} else if code.AuthTime != sess.AuthTime.Unix() {
+		t.Errorf("auth_time is not match: session=%d != code=%d", sess.AuthTime.Unix(), code.AuthTime)
+	} else if code.Subject != sess.Subject {
+		t.Errorf("subject is not match: session=%s != code=%s", sess.Subject, code.Subject)
+	}
+}
+
+func TestAuthz_RequiredGroups(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+
+	request, err := env.API.TokenManager.CreateRequestObject(
+		env.API.Config.Issuer,
+		"::1",
+		token.RequestObjectClaims{
+			ClientID:     "admin_client_id",
+			RedirectURI:  "http://admin-client.example.com/callback",
+			ResponseType: "code",
+		},
+		time.Now().Add(10*time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err)
+	}
+
+	env.RedirectTest(t, "POST", "/authz", []testutil.RedirectTest{
+		{
+			Name: "member of required group",
+			Request: url.Values{
+				"request":  {request},
+				"username": {"macrat"},
+				"password": {"foobar"},
+			},
+			Code:        http.StatusFound,
+			HasLocation: true,
+			CheckParams: func(t *testing.T, query, fragment url.Values) {
+				if query.Get("code") == "" {
+					t.Errorf("expected code but not set")
+				}
+			},
+		},
+		{
+			Name: "not member of required group",
+			Request: url.Values{
+				"request":  {request},
+				"username": {"j.smith"},
+				"password": {"hello"},
+			},
+			Code:         http.StatusBadRequest,
+			HasLocation:  false,
+			BodyIncludes: []string{"access_denied", "user is not allowed to use this client"},
+		},
+	})
+
+	sessionID := env.MakeSSOSession(t, "j.smith", nil, time.Now(), time.Now().Add(10*time.Minute))
+
+	tests := []struct {
+		Name   string
+		Prompt string
+		Code   int
+	}{
+		{"SSO login", "", http.StatusBadRequest},
+		{"SSO login / prompt=none", "none", http.StatusFound},
 	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			params := url.Values{
+				"redirect_uri":  {"http://admin-client.example.com/callback"},
+				"client_id":     {"admin_client_id"},
+				"response_type": {"code"},
+				"prompt":        {tt.Prompt},
+			}
+			req, _ := http.NewRequest("GET", "/authz?"+params.Encode(), nil)
+			req.Header.Set("Cookie", fmt.Sprintf("%s=%s", api.SSO_SESSION_COOKIE, sessionID))
+
+			resp := env.DoRequest(req)
+			if resp.Code != tt.Code {
+				t.Fatalf("unexpected status code: %d", resp.Code)
+			}
+
+			if tt.Code == http.StatusFound {
+				location, err := url.Parse(resp.Header().Get("Location"))
+				if err != nil {
+					t.Fatalf("failed to parse location: %s", err)
+				}
+				if errMsg := location.Query().Get("error"); errMsg != "access_denied" {
+					t.Errorf("unexpected error: %#v", errMsg)
+				}
+			} else if !strings.Contains(resp.Body.String(), "access_denied") {
+				t.Errorf("expected access_denied error page but got: %s", resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthz_LDAPUnavailable(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+	env.API.Connector = &ldap.MultiConnector{
+		Dial: func(server *url.URL) (ldap.Session, error) {
+			return nil, fmt.Errorf("connection refused")
+		},
+		Servers: []*url.URL{
+			{Scheme: "ldap", Host: "dc01.example.local"},
+			{Scheme: "ldap", Host: "dc02.example.local"},
+		},
+	}
+
+	request, err := env.API.TokenManager.CreateRequestObject(
+		env.API.Config.Issuer,
+		"::1",
+		token.RequestObjectClaims{
+			ClientID:     "some_client_id",
+			RedirectURI:  "http://some-client.example.com/callback",
+			ResponseType: "code",
+		},
+		time.Now().Add(10*time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err)
+	}
+
+	env.RedirectTest(t, "POST", "/authz", []testutil.RedirectTest{
+		{
+			Name: "no server reachable",
+			Request: url.Values{
+				"request":  {request},
+				"username": {"macrat"},
+				"password": {"foobar"},
+			},
+			Code:        http.StatusFound,
+			HasLocation: true,
+			CheckParams: func(t *testing.T, query, fragment url.Values) {
+				if query.Get("error") != "temporarily_unavailable" {
+					t.Errorf("unexpected error: %#v", query.Get("error"))
+				}
+			},
+		},
+	})
 }
@@ -10,7 +10,6 @@ import (
 
 	"github.com/macrat/lauth/api"
 	"github.com/macrat/lauth/testutil"
-	"github.com/macrat/lauth/token"
 )
 
 func TestGetAuthz(t *testing.T) {
@@ -367,6 +366,22 @@ func TestGetAuthz(t *testing.T) {
 				"error_description": {"nonce is required in the implicit/hybrid flow of OpenID Connect"},
 			},
 		},
+		{
+			Name: "not allowed scope",
+			Request: url.Values{
+				"redirect_uri":  {"http://implicit-client.example.com/callback"},
+				"client_id":     {"implicit_client_id"},
+				"response_type": {"code"},
+				"scope":         {"email groups"},
+			},
+			Code:        http.StatusFound,
+			HasLocation: true,
+			Query: url.Values{
+				"error":             {"invalid_scope"},
+				"error_description": {"requested scope is not allowed for this client"},
+			},
+			Fragment: url.Values{},
+		},
 		{
 			Name: "can't use both prompt of none and login",
 			Request: url.Values{
@@ -511,19 +526,16 @@ func TestGetAuthz_LoginExpires(t *testing.T) {
 func TestGetAuthz_SSO(t *testing.T) {
 	env := testutil.NewAPITestEnvironment(t)
 
-	invalidToken, err := env.API.TokenManager.CreateIDToken(
-		env.API.Config.Issuer,
+	expiredSession := env.MakeSSOSession(
+		t,
 		"macrat",
-		"another_cilent_id",
-		"",
-		"",
-		"",
-		nil,
+		[]string{"some_client_id"},
+		time.Now().Add(-15*time.Minute),
 		time.Now().Add(-5*time.Minute),
-		10*time.Minute,
 	)
-	if err != nil {
-		t.Fatalf("failed to create SSO token: %s", err)
+
+	if err := env.API.Consents().Grant("macrat", "some_client_id", []string{"openid"}, time.Hour); err != nil {
+		t.Fatalf("failed to grant consent: %s", err)
 	}
 
 	tests := []struct {
@@ -564,6 +576,28 @@ func TestGetAuthz_SSO(t *testing.T) {
 			AuthTime: time.Now().Add(-5 * time.Minute),
 			CanSSO:   true,
 		},
+		{
+			Name: "logged in at 5m ago / consented scope",
+			Request: url.Values{
+				"redirect_uri":  {"http://some-client.example.com/callback"},
+				"client_id":     {"some_client_id"},
+				"response_type": {"code"},
+				"scope":         {"openid"},
+			},
+			AuthTime: time.Now().Add(-5 * time.Minute),
+			CanSSO:   true,
+		},
+		{
+			Name: "logged in at 5m ago / not consented scope",
+			Request: url.Values{
+				"redirect_uri":  {"http://some-client.example.com/callback"},
+				"client_id":     {"some_client_id"},
+				"response_type": {"code"},
+				"scope":         {"openid profile"},
+			},
+			AuthTime: time.Now().Add(-5 * time.Minute),
+			CanSSO:   false,
+		},
 		{
 			Name: "logged in at 5m ago / prompt=login",
 			Request: url.Values{
@@ -620,7 +654,7 @@ func TestGetAuthz_SSO(t *testing.T) {
 			CanSSO:   false,
 		},
 		{
-			Name: "invalid token (can't parse)",
+			Name: "unknown session",
 			Request: url.Values{
 				"redirect_uri":  {"http://some-client.example.com/callback"},
 				"client_id":     {"some_client_id"},
@@ -630,37 +664,33 @@ func TestGetAuthz_SSO(t *testing.T) {
 			CanSSO: false,
 		},
 		{
-			Name: "invalid token (invalid value)",
+			Name: "expired session",
 			Request: url.Values{
 				"redirect_uri":  {"http://some-client.example.com/callback"},
 				"client_id":     {"some_client_id"},
 				"response_type": {"code"},
 				"max_age":       {"240"},
 			},
-			Token:  invalidToken,
+			Token:  expiredSession,
 			CanSSO: false,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.Name, func(t *testing.T) {
-			ssoToken := tt.Token
-			if ssoToken == "" {
-				var err error
-				ssoToken, err = env.API.TokenManager.CreateSSOToken(
-					env.API.Config.Issuer,
+			sessionID := tt.Token
+			if sessionID == "" {
+				sessionID = env.MakeSSOSession(
+					t,
 					"macrat",
-					token.AuthorizedParties{"some_client_id"},
+					[]string{"some_client_id"},
 					tt.AuthTime,
 					time.Now().Add(10*time.Minute),
 				)
-				if err != nil {
-					t.Fatalf("failed to create SSO token: %s", err)
-				}
 			}
 
 			req, _ := http.NewRequest("GET", "/authz?"+tt.Request.Encode(), nil)
-			req.Header.Set("Cookie", fmt.Sprintf("%s=%s", api.SSO_TOKEN_COOKIE, ssoToken))
+			req.Header.Set("Cookie", fmt.Sprintf("%s=%s", api.SSO_SESSION_COOKIE, sessionID))
 			resp := env.DoRequest(req)
 
 			if !tt.CanSSO {
@@ -726,3 +756,149 @@ func TestGetAuthz_SSO(t *testing.T) {
 		}
 	})
 }
+
+func TestGetAuthz_AllowedScopes(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+
+	tests := []struct {
+		ClientID    string
+		RedirectURI string
+		Request     string
+		Expect      string
+	}{
+		{"implicit_client_id", "http://implicit-client.example.com/callback", "openid profile email groups", "openid profile"},
+		{"implicit_client_id", "http://implicit-client.example.com/callback", "phone", "phone"},
+		{"implicit_client_id", "http://implicit-client.example.com/callback", "", ""},
+		{"some_client_id", "http://some-client.example.com/callback", "openid email unknown", "email openid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ClientID+"/"+tt.Request, func(t *testing.T) {
+			resp := env.Get("/authz", "", url.Values{
+				"redirect_uri":  {tt.RedirectURI},
+				"client_id":     {tt.ClientID},
+				"response_type": {"code"},
+				"scope":         {tt.Request},
+			})
+			if resp.Code != http.StatusOK {
+				t.Fatalf("unexpected status code: %d", resp.Code)
+			}
+
+			request, err := testutil.FindRequestObjectByHTML(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to get request object: %s", err)
+			}
+
+			claims, err := env.API.TokenManager.ParseRequestObject(request, "")
+			if err != nil {
+				t.Fatalf("failed to parse request object: %s", err)
+			}
+
+			if claims.Scope != tt.Expect {
+				t.Errorf("expected scope %#v but got %#v", tt.Expect, claims.Scope)
+			}
+		})
+	}
+}
+
+func TestGetAuthz_AllowedScopes_rejected(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+
+	client := env.API.Config.Clients["some_client_id"]
+	client.AllowedScopes = []string{"profile"}
+	env.API.Config.Clients["some_client_id"] = client
+
+	tests := []struct {
+		Request string
+		Expect  string
+		Error   string
+	}{
+		{"unknown", "", "invalid_scope"},
+		{"email", "", "invalid_scope"},
+		{"openid", "openid", ""},
+		{"openid profile email", "openid profile", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Request, func(t *testing.T) {
+			resp := env.Get("/authz", "", url.Values{
+				"redirect_uri":  {"http://some-client.example.com/callback"},
+				"client_id":     {"some_client_id"},
+				"response_type": {"code"},
+				"scope":         {tt.Request},
+			})
+
+			if tt.Error == "" {
+				if resp.Code != http.StatusOK {
+					t.Fatalf("unexpected status code: %d", resp.Code)
+				}
+
+				request, err := testutil.FindRequestObjectByHTML(resp.Body)
+				if err != nil {
+					t.Fatalf("failed to get request object: %s", err)
+				}
+				claims, err := env.API.TokenManager.ParseRequestObject(request, "")
+				if err != nil {
+					t.Fatalf("failed to parse request object: %s", err)
+				}
+				if claims.Scope != tt.Expect {
+					t.Errorf("expected scope %#v but got %#v", tt.Expect, claims.Scope)
+				}
+				return
+			}
+
+			if resp.Code != http.StatusFound {
+				t.Fatalf("unexpected status code: %d", resp.Code)
+			}
+			location, err := url.Parse(resp.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("failed to parse location: %s", err)
+			}
+			if e := location.Query().Get("error"); e != tt.Error {
+				t.Errorf("expected error %#v but got %#v", tt.Error, e)
+			}
+		})
+	}
+}
+
+func TestGetAuthz_ClientSSOExpire(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+
+	if err := env.API.Consents().Grant("macrat", "admin_client_id", nil, time.Hour); err != nil {
+		t.Fatalf("failed to grant consent: %s", err)
+	}
+
+	tests := []struct {
+		Name     string
+		AuthTime time.Time
+		CanSSO   bool
+	}{
+		{"logged in at 1m ago", time.Now().Add(-1 * time.Minute), true},
+		{"logged in at 7m ago", time.Now().Add(-7 * time.Minute), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			sessionID := env.MakeSSOSession(t, "macrat", []string{"admin_client_id"}, tt.AuthTime, time.Now().Add(10*time.Minute))
+
+			params := url.Values{
+				"redirect_uri":  {"http://admin-client.example.com/callback"},
+				"client_id":     {"admin_client_id"},
+				"response_type": {"code"},
+			}
+			req, _ := http.NewRequest("GET", "/authz?"+params.Encode(), nil)
+			req.Header.Set("Cookie", fmt.Sprintf("%s=%s", api.SSO_SESSION_COOKIE, sessionID))
+			resp := env.DoRequest(req)
+
+			if tt.CanSSO && resp.Code != http.StatusFound {
+				t.Errorf("expect SSO login but failed (status code = %d)", resp.Code)
+			} else if !tt.CanSSO && resp.Code != http.StatusOK {
+				t.Errorf("expect non SSO login but failed (status code = %d)", resp.Code)
+			}
+
+			if _, err := env.API.Sessions.Get(sessionID); err != nil {
+				t.Errorf("session should not be revoked: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	stderrors "errors"
+
+	"github.com/macrat/lauth/errors"
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/metrics"
+	"github.com/macrat/lauth/upstream"
+	"github.com/rs/zerolog/log"
+)
+
+// connectLDAP connects to the LDAP server, and records which server served the request.
+// The session also knows users of upstream providers if configured.
+//
+// The returned reason is temporarily_unavailable if no LDAP server is reachable, otherwise server_error.
+func (api *LauthAPI) connectLDAP() (ldap.Session, errors.Reason, error) {
+	conn, err := api.Connector.Connect()
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to connecting LDAP server")
+
+		return nil, unavailableOr(err, errors.ServerError), err
+	}
+
+	if server := ldap.ServerOf(conn); server != "" {
+		metrics.LDAPServerUsed(server)
+	}
+
+	if len(api.Config.Upstreams) > 0 {
+		conn = upstream.Session{Session: conn, Config: api.Config, Store: api.upstreamStore()}
+	}
+
+	return conn, "", nil
+}
+
+// unavailableOr returns temporarily_unavailable if err means no LDAP server is reachable, otherwise returns reason.
+//
+// Sessions for multiple directories connect to each directory lazily, so the error could be returned from any method of the session.
+func unavailableOr(err error, reason errors.Reason) errors.Reason {
+	if stderrors.Is(err, ldap.UnavailableError) {
+		return errors.TemporarilyUnavailable
+	}
+	return reason
+}
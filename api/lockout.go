@@ -0,0 +1,166 @@
+package api
+
+import (
+	stderrors "errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/macrat/lauth/errors"
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/lockout"
+	"github.com/macrat/lauth/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+func (api *LauthAPI) lockoutStore() lockout.Store {
+	return lockout.Store{Store: api.Store}
+}
+
+// lockoutPolicy returns the policy for the kind.
+// IP addresses have no backoff, because many users may share an address behind NAT.
+func (api *LauthAPI) lockoutPolicy(kind lockout.Kind) lockout.Policy {
+	conf := api.Config.Lockout
+
+	if kind == lockout.IP {
+		return lockout.Policy{
+			MaxAttempts: conf.IPMaxAttempts,
+			Duration:    conf.Duration.Duration(),
+		}
+	}
+
+	return lockout.Policy{
+		MaxAttempts: conf.MaxAttempts,
+		Duration:    conf.Duration.Duration(),
+		Backoff:     conf.Backoff.Duration(),
+		MaxBackoff:  conf.MaxBackoff.Duration(),
+	}
+}
+
+// lockoutUsername normalizes the username in the same way as the directory that the login is routed to,
+// so DOMAIN\username, username@domain and username share the failures.
+//
+// The key doesn't include the directory name, because a login without picked directory or domain may reach any directory.
+func (api *LauthAPI) lockoutUsername(directory, username string) string {
+	return strings.ToLower(api.Config.NormalizeUsername(directory, username))
+}
+
+// lockoutKeys returns the username and the IP address of the login attempt, for each kind.
+func (ctx *AuthzContext) lockoutKeys(directory, username string) map[lockout.Kind]string {
+	return map[lockout.Kind]string{
+		lockout.Username: ctx.API.lockoutUsername(directory, username),
+		lockout.IP:       ctx.Gin.ClientIP(),
+	}
+}
+
+// isLoginFailure reports the error of LoginTest or ChangePassword counts as a failed login.
+// Errors of the LDAP server don't count, so users are not locked out while the server is down.
+func isLoginFailure(err error) bool {
+	if stderrors.Is(err, ldap.InvalidCredentialsError) || stderrors.Is(err, ldap.UserNotFoundError) || stderrors.Is(err, ldap.MultipleUsersFoundError) {
+		return true
+	}
+	for _, reason := range loginFailures {
+		if stderrors.Is(err, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReserveLoginAttempt counts the login attempt of the user in the directory as a failure in advance, and reports the user can try the password now.
+// If not, it shows the page by show with 429 Too Many Requests.
+//
+// The attempt is counted before trying the password, so parallel attempts can't exceed the limit.
+// Call RecordLoginFailure, ReleaseLoginAttempt, or ResetLockout after tried.
+func (ctx *AuthzContext) ReserveLoginAttempt(directory, username string, show func(code int, description string)) bool {
+	store := ctx.API.lockoutStore()
+	keys := ctx.lockoutKeys(directory, username)
+
+	ctx.reserved = make(map[lockout.Kind]lockout.Record)
+	for _, kind := range lockout.Kinds {
+		policy := ctx.API.lockoutPolicy(kind)
+		if !policy.Enabled() {
+			continue
+		}
+
+		r, wait, err := store.Reserve(kind, keys[kind], policy)
+		if err != nil {
+			ctx.ReleaseLoginAttempt()
+			ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to check lockout"))
+			return false
+		}
+		if wait > 0 {
+			ctx.ReleaseLoginAttempt()
+			metrics.LoginRejected(string(kind))
+
+			ctx.Gin.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			ctx.Report.Denied()
+			ctx.Report.SetError(ctx.Request.makeRedirectError(nil, errors.AccessDenied, "too many failed attempts"))
+			show(http.StatusTooManyRequests, "too many failed attempts")
+			return false
+		}
+		ctx.reserved[kind] = r
+	}
+
+	return true
+}
+
+// RecordLoginFailure keeps the reserved attempt as a failed login.
+func (ctx *AuthzContext) RecordLoginFailure() {
+	for _, kind := range lockout.Kinds {
+		r, ok := ctx.reserved[kind]
+		if !ok {
+			continue
+		}
+
+		if r.Locked && r.Failures == ctx.API.lockoutPolicy(kind).MaxAttempts {
+			metrics.LockedOut(string(kind))
+
+			log.Warn().
+				Str("kind", string(kind)).
+				Str("key", r.Key).
+				Int("failures", r.Failures).
+				Time("until", r.RetryAt).
+				Msg("locked out by too many failed logins")
+		}
+	}
+	ctx.reserved = nil
+}
+
+// ReleaseLoginAttempt takes back the reserved attempt, because it was not a failed login, like the LDAP server is down.
+func (ctx *AuthzContext) ReleaseLoginAttempt() {
+	store := ctx.API.lockoutStore()
+
+	for kind, r := range ctx.reserved {
+		if err := store.Release(kind, r.Key, ctx.API.lockoutPolicy(kind)); err != nil {
+			log.Error().
+				Err(err).
+				Str("kind", string(kind)).
+				Msg("failed to release login attempt")
+		}
+	}
+	ctx.reserved = nil
+}
+
+// ResetLockout forgets failed logins of the user, because the password was correct.
+// Failures of the IP address are kept except the reserved attempt, because other users may be attacked from the same address.
+func (ctx *AuthzContext) ResetLockout() {
+	store := ctx.API.lockoutStore()
+
+	for kind, r := range ctx.reserved {
+		var err error
+		if kind == lockout.Username {
+			_, err = store.Reset(kind, r.Key)
+		} else {
+			err = store.Release(kind, r.Key, ctx.API.lockoutPolicy(kind))
+		}
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("kind", string(kind)).
+				Msg("failed to reset failed logins")
+		}
+	}
+	ctx.reserved = nil
+}
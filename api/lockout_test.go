@@ -0,0 +1,251 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/testutil"
+)
+
+func setupLockout(t *testing.T, conf config.LockoutConfig) *testutil.APITestEnvironment {
+	t.Helper()
+
+	env := testutil.NewAPITestEnvironment(t)
+	env.API.Config.Lockout = conf
+	return env
+}
+
+func assertLockedOut(t *testing.T, resp *http.Response, body string) {
+	t.Helper()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Retry-After header is not set")
+	}
+	if !strings.Contains(body, "Too many failed attempts.") {
+		t.Errorf("expected message but not found: %s", body)
+	}
+}
+
+func TestPostAuthz_lockoutUsername(t *testing.T) {
+	env := setupLockout(t, config.LockoutConfig{
+		MaxAttempts: 2,
+		Duration:    config.Duration(time.Hour),
+	})
+
+	for i := 0; i < 2; i++ {
+		if resp := postPassword(t, env, "macrat", "wrong"); resp.Code != http.StatusForbidden {
+			t.Fatalf("%d: unexpected status code: %d", i, resp.Code)
+		}
+	}
+
+	resp := postPassword(t, env, " MacRat", "foobar")
+	assertLockedOut(t, resp.Result(), resp.Body.String())
+
+	if resp := postPassword(t, env, "j.smith", "hello"); resp.Code != http.StatusFound {
+		t.Errorf("other user should not be locked out: %d", resp.Code)
+	}
+
+	admin := func(method, path string) map[string]interface{} {
+		t.Helper()
+
+		req, _ := http.NewRequest(method, path, nil)
+		req.SetBasicAuth("admin", "admin password")
+		resp := env.DoRequest(req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("unexpected status code: %d: %s", resp.Code, resp.Body.String())
+		}
+
+		var body map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to parse response: %s", err)
+		}
+		return body
+	}
+
+	if lockouts, ok := admin("GET", "/admin/lockouts")["lockouts"].([]interface{}); !ok || len(lockouts) != 1 {
+		t.Errorf("unexpected lockouts: %#v", lockouts)
+	} else if r := lockouts[0].(map[string]interface{}); r["key"] != "macrat" || r["locked"] != true {
+		t.Errorf("unexpected lockout: %#v", r)
+	}
+
+	if cleared := admin("DELETE", "/admin/lockouts?username=MacRat")["cleared"]; cleared != float64(1) {
+		t.Errorf("unexpected cleared count: %#v", cleared)
+	}
+
+	parseIDTokenInRedirect(t, env, postPassword(t, env, "macrat", "foobar"))
+}
+
+// countingLDAP is a DummyLDAP that counts binds to check passwords.
+type countingLDAP struct {
+	testutil.DummyLDAP
+	binds *int32
+}
+
+func (c countingLDAP) Connect() (ldap.Session, error) {
+	return c, nil
+}
+
+func (c countingLDAP) LoginTest(username, password string) (ldap.Identity, error) {
+	atomic.AddInt32(c.binds, 1)
+	time.Sleep(10 * time.Millisecond)
+	return c.DummyLDAP.LoginTest(username, password)
+}
+
+func TestPostAuthz_lockoutParallel(t *testing.T) {
+	env := setupLockout(t, config.LockoutConfig{
+		MaxAttempts: 3,
+		Duration:    config.Duration(time.Hour),
+	})
+
+	var binds int32
+	env.API.Connector = countingLDAP{DummyLDAP: testutil.LDAP, binds: &binds}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 20)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = postPassword(t, env, "macrat", "wrong").Code
+		}(i)
+	}
+	wg.Wait()
+
+	if binds > 3 {
+		t.Errorf("expected at most 3 binds but got %d", binds)
+	}
+
+	rejected := 0
+	for _, code := range codes {
+		if code == http.StatusTooManyRequests {
+			rejected++
+		}
+	}
+	if rejected != len(codes)-int(binds) {
+		t.Errorf("expected %d rejected attempts but got %d: %v", len(codes)-int(binds), rejected, codes)
+	}
+
+	resp := postPassword(t, env, "macrat", "foobar")
+	assertLockedOut(t, resp.Result(), resp.Body.String())
+}
+
+// unavailableLDAP is a DummyLDAP that no server is available.
+type unavailableLDAP struct {
+	testutil.DummyLDAP
+}
+
+func (c unavailableLDAP) Connect() (ldap.Session, error) {
+	return c, nil
+}
+
+func (c unavailableLDAP) LoginTest(username, password string) (ldap.Identity, error) {
+	return ldap.Identity{}, ldap.UnavailableError
+}
+
+func TestPostAuthz_lockoutUnavailable(t *testing.T) {
+	env := setupLockout(t, config.LockoutConfig{
+		MaxAttempts: 2,
+		Duration:    config.Duration(time.Hour),
+		Backoff:     config.Duration(time.Hour),
+	})
+
+	env.API.Connector = unavailableLDAP{testutil.LDAP}
+	for i := 0; i < 3; i++ {
+		if resp := postPassword(t, env, "macrat", "foobar"); resp.Code == http.StatusTooManyRequests {
+			t.Fatalf("%d: unavailable server should not be counted as a failure", i)
+		}
+	}
+
+	env.API.Connector = testutil.LDAP
+	parseIDTokenInRedirect(t, env, postPassword(t, env, "macrat", "foobar"))
+}
+
+func TestPostAuthz_lockoutLoginDomains(t *testing.T) {
+	env := setupLockout(t, config.LockoutConfig{
+		MaxAttempts: 2,
+		Duration:    config.Duration(time.Hour),
+	})
+	env.API.Config.LDAP.LoginDomains = []string{"CORP", "corp.example"}
+
+	for _, username := range []string{"CORP\\macrat", "macrat@corp.example"} {
+		if resp := postPassword(t, env, username, "wrong"); resp.Code != http.StatusForbidden {
+			t.Fatalf("%s: unexpected status code: %d", username, resp.Code)
+		}
+	}
+
+	resp := postPassword(t, env, "macrat", "foobar")
+	assertLockedOut(t, resp.Result(), resp.Body.String())
+
+	req, _ := http.NewRequest("DELETE", "/admin/lockouts?username=corp%5Cmacrat", nil)
+	req.SetBasicAuth("admin", "admin password")
+	if resp := env.DoRequest(req); resp.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", resp.Code)
+	} else if !strings.Contains(resp.Body.String(), `"cleared":1`) {
+		t.Errorf("unexpected response: %s", resp.Body.String())
+	}
+
+	parseIDTokenInRedirect(t, env, postPassword(t, env, "macrat", "foobar"))
+}
+
+func TestPostAuthz_lockoutBackoff(t *testing.T) {
+	env := setupLockout(t, config.LockoutConfig{
+		MaxAttempts: 10,
+		Duration:    config.Duration(time.Hour),
+		Backoff:     config.Duration(time.Hour),
+	})
+
+	if resp := postPassword(t, env, "macrat", "wrong"); resp.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status code: %d", resp.Code)
+	}
+
+	resp := postPassword(t, env, "macrat", "foobar")
+	assertLockedOut(t, resp.Result(), resp.Body.String())
+}
+
+func TestPostAuthz_lockoutBackoffOnly(t *testing.T) {
+	env := setupLockout(t, config.LockoutConfig{
+		Duration: config.Duration(time.Hour),
+		Backoff:  config.Duration(time.Hour),
+	})
+
+	if resp := postPassword(t, env, "macrat", "wrong"); resp.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status code: %d", resp.Code)
+	}
+
+	resp := postPassword(t, env, "macrat", "foobar")
+	assertLockedOut(t, resp.Result(), resp.Body.String())
+}
+
+func TestPostAuthz_lockoutIP(t *testing.T) {
+	env := setupLockout(t, config.LockoutConfig{
+		IPMaxAttempts: 2,
+		Duration:      config.Duration(time.Hour),
+	})
+
+	for _, username := range []string{"macrat", "j.smith"} {
+		if resp := postPassword(t, env, username, "wrong"); resp.Code != http.StatusForbidden {
+			t.Fatalf("%s: unexpected status code: %d", username, resp.Code)
+		}
+	}
+
+	resp := postPassword(t, env, "another", "foobar")
+	assertLockedOut(t, resp.Result(), resp.Body.String())
+
+	req, _ := http.NewRequest("DELETE", "/admin/lockouts?ip=::1", nil)
+	req.SetBasicAuth("admin", "admin password")
+	if resp := env.DoRequest(req); resp.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", resp.Code)
+	}
+
+	parseIDTokenInRedirect(t, env, postPassword(t, env, "macrat", "foobar"))
+}
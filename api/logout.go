@@ -113,7 +113,7 @@ func (api *LauthAPI) Logout(c *gin.Context) {
 		return
 	}
 
-	ssoToken, err := api.GetSSOToken(c)
+	sess, err := api.GetSSOSession(c)
 	if err != nil {
 		e := &errors.Error{
 			Err:         err,
@@ -124,7 +124,7 @@ func (api *LauthAPI) Logout(c *gin.Context) {
 		errors.SendHTML(c, e)
 		return
 	}
-	if !ssoToken.Authorized.Includes(idToken.Audience) || idToken.Subject != ssoToken.Subject {
+	if !sess.IsAuthorized(idToken.Audience) || idToken.Subject != sess.Subject {
 		e := &errors.Error{
 			Reason:      errors.InvalidRequest,
 			Description: "user not logged in",
@@ -134,7 +134,7 @@ func (api *LauthAPI) Logout(c *gin.Context) {
 		return
 	}
 
-	api.DeleteSSOToken(c)
+	api.DeleteSSOSession(c)
 
 	if req.RedirectURI == "" {
 		c.HTML(http.StatusOK, "logout.tmpl", nil)
@@ -11,23 +11,11 @@ import (
 	"github.com/macrat/lauth/api"
 	"github.com/macrat/lauth/config"
 	"github.com/macrat/lauth/testutil"
-	"github.com/macrat/lauth/token"
 )
 
 func TestLogout(t *testing.T) {
 	env := testutil.NewAPITestEnvironment(t)
 
-	ssoToken, err := env.API.TokenManager.CreateSSOToken(
-		env.API.Config.Issuer,
-		"macrat",
-		token.AuthorizedParties{"some_client_id"},
-		time.Now(),
-		time.Now().Add(10*time.Minute),
-	)
-	if err != nil {
-		t.Fatalf("failed to create test id_token: %s", err)
-	}
-
 	idToken, err := env.API.TokenManager.CreateIDToken(
 		env.API.Config.Issuer,
 		"macrat",
@@ -37,6 +25,7 @@ func TestLogout(t *testing.T) {
 		"",
 		nil,
 		time.Now(),
+		nil,
 		10*time.Minute,
 	)
 	if err != nil {
@@ -52,6 +41,7 @@ func TestLogout(t *testing.T) {
 		"",
 		nil,
 		time.Now(),
+		nil,
 		10*time.Minute,
 	)
 	if err != nil {
@@ -67,6 +57,7 @@ func TestLogout(t *testing.T) {
 		"",
 		nil,
 		time.Now(),
+		nil,
 		10*time.Minute,
 	)
 	if err != nil {
@@ -82,6 +73,7 @@ func TestLogout(t *testing.T) {
 		"",
 		nil,
 		time.Now(),
+		nil,
 		10*time.Minute,
 	)
 	if err != nil {
@@ -97,6 +89,7 @@ func TestLogout(t *testing.T) {
 		"",
 		nil,
 		time.Now(),
+		nil,
 		10*time.Minute,
 	)
 	if err != nil {
@@ -259,8 +252,16 @@ func TestLogout(t *testing.T) {
 						t.Fatalf("failed to prepare test request: %s", err)
 					}
 				}
+				sessionID := ""
 				if !tt.NotLoggedIn {
-					req.Header.Set("Cookie", fmt.Sprintf("%s=%s", api.SSO_TOKEN_COOKIE, ssoToken))
+					sessionID = env.MakeSSOSession(
+						t,
+						"macrat",
+						[]string{"some_client_id"},
+						time.Now(),
+						time.Now().Add(10*time.Minute),
+					)
+					req.Header.Set("Cookie", fmt.Sprintf("%s=%s", api.SSO_SESSION_COOKIE, sessionID))
 				}
 				resp := env.DoRequest(req)
 
@@ -277,15 +278,18 @@ func TestLogout(t *testing.T) {
 				h.Add("Cookie", resp.Header().Get("Set-Cookie"))
 				r := http.Request{Header: h}
 				if tt.Logout {
-					if c, err := r.Cookie(api.SSO_TOKEN_COOKIE); err != nil {
+					if c, err := r.Cookie(api.SSO_SESSION_COOKIE); err != nil {
 						t.Errorf("failed to get token cookie: %s", err)
 					} else if c.Value != "" {
 						t.Errorf("expected logout but token cookie has value %#v", c.Value)
 					} else if c.MaxAge > 0 {
 						t.Errorf("expected logout but token cookie has positive max-age (had %d)", c.MaxAge)
 					}
+					if _, err := env.API.Sessions.Get(sessionID); err == nil {
+						t.Errorf("expected session is revoked but still alive")
+					}
 				} else {
-					if c, err := r.Cookie(api.SSO_TOKEN_COOKIE); err == nil {
+					if c, err := r.Cookie(api.SSO_SESSION_COOKIE); err == nil {
 						t.Errorf("%s %s: expected failed to logout but token cookie is set: %s", method, tt.Name, c)
 					}
 				}
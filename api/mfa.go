@@ -0,0 +1,283 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/macrat/lauth/errors"
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/mfa"
+	"github.com/macrat/lauth/session"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	MFA_METHOD_TOTP              = "totp"
+	MFA_METHOD_WEBAUTHN          = "webauthn"
+	MFA_METHOD_WEBAUTHN_REGISTER = "webauthn_register"
+)
+
+var (
+	// PasswordAuthMethods is the amr claim for users who logged in with only the password.
+	PasswordAuthMethods = []string{"pwd"}
+
+	// TOTPAuthMethods is the amr claim for users who logged in with the password and TOTP.
+	TOTPAuthMethods = []string{"pwd", "otp"}
+)
+
+func (api *LauthAPI) mfaStore() mfa.Store {
+	return mfa.Store{Store: api.Store}
+}
+
+// mfaSecret returns TOTP secret of the user from the LDAP attribute or the storage, or empty string if the user has no secret.
+//
+// Users of upstream providers have no LDAP attribute, because their attributes are claims that the upstream made.
+func (api *LauthAPI) mfaSecret(conn ldap.Session, subject string) (string, error) {
+	_, _, isUpstream := api.Config.UpstreamFor(subject)
+
+	if attr := api.Config.MFA.TOTPAttribute; attr != "" && !isUpstream {
+		attrs, err := conn.GetUserAttributes(subject, []string{attr})
+		if err != nil {
+			return "", err
+		}
+		if vs := attrs[attr]; len(vs) > 0 && vs[0] != "" {
+			return vs[0], nil
+		}
+	}
+
+	if api.Config.MFA.Enroll {
+		return api.mfaStore().Secret(subject)
+	}
+	return "", nil
+}
+
+// mfaRequired reports the user has to use the second factor for the client.
+func (ctx *AuthzContext) mfaRequired(conn ldap.Session, subject string) (bool, error) {
+	if ctx.API.Config.Clients[ctx.Request.ClientID].RequireMFA {
+		return true, nil
+	}
+	if filter := ctx.API.Config.MFA.RequiredFilter(); filter != "" {
+		return conn.MatchFilter(subject, filter)
+	}
+	return false, nil
+}
+
+// needMFA reports the user has to pass the second factor, and returns the secret of the user.
+// The secret is empty if the user has to enroll.
+func (ctx *AuthzContext) needMFA(conn ldap.Session, subject string) (need bool, secret string, err error) {
+	if !ctx.API.Config.MFA.Enabled() {
+		return false, "", nil
+	}
+
+	secret, err = ctx.API.mfaSecret(conn, subject)
+	if err != nil || secret != "" {
+		return err == nil, secret, err
+	}
+
+	need, err = ctx.mfaRequired(conn, subject)
+	return need, "", err
+}
+
+// SessionSatisfiesMFA reports the SSO session can be used without the second factor.
+//
+// Sessions that made without the second factor, by the password or an upstream provider, can't be used if the user has to pass it now.
+// Sessions with TOTP can't be used either if the user has to use a security key.
+func (ctx *AuthzContext) SessionSatisfiesMFA(sess session.Session) bool {
+	if !ctx.API.Config.MFA.Enabled() && !ctx.API.Config.WebAuthn.Enabled {
+		return true
+	}
+
+	otp := false
+	for _, m := range sess.AuthMethods {
+		switch m {
+		case "hwk":
+			return true
+		case "otp":
+			otp = true
+		}
+	}
+	if otp && !ctx.API.Config.WebAuthn.Enabled {
+		return true
+	}
+
+	conn, _, err := ctx.API.connectLDAP()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	need, _, err := ctx.needWebAuthn(conn, sess.Subject)
+	if err == nil && !need && !otp {
+		need, _, err = ctx.needMFA(conn, sess.Subject)
+	}
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to check MFA requirement")
+		return false
+	}
+	return !need
+}
+
+// StartMFA shows the page of the second factor to the user who passed the password, if needed.
+//
+// It returns true if the user doesn't need the second factor.
+// Otherwise, it responds the page or an error, and returns false.
+func (ctx *AuthzContext) StartMFA(conn ldap.Session, id ldap.Identity) bool {
+	need, secret, err := ctx.needMFA(conn, id.Subject)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to check MFA requirement")
+
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, unavailableOr(err, errors.ServerError), "failed to check two-factor authentication"))
+		return false
+	}
+	if !need {
+		return true
+	}
+
+	if secret == "" {
+		if !ctx.API.Config.MFA.Enroll {
+			ctx.Report.Denied()
+			ctx.ErrorRedirect(ctx.Request.makeNonRedirectError(nil, errors.AccessDenied, "two-factor authentication is required but not configured for the user"))
+			return false
+		}
+
+		ctx.Request.MFASecret, err = mfa.GenerateSecret()
+		if err != nil {
+			ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to create login session"))
+			return false
+		}
+	}
+
+	ctx.Request.MFASubject = id.Subject
+	ctx.Request.MFAUsername = id.Username
+	ctx.Request.MFAMethod = MFA_METHOD_TOTP
+	ctx.ShowMFAPage(http.StatusOK, "")
+	return false
+}
+
+// VerifyMFA checks TOTP code of the user who passed the password, and sends tokens if correct.
+//
+// Wrong codes are counted for each user, and the user can't try more than MaxAttempts until the login expiration passes.
+// Each code is counted before verifying, so parallel attempts can't exceed MaxAttempts.
+func (ctx *AuthzContext) VerifyMFA() {
+	ctx.Report.Set("authn_by", "password")
+	ctx.Report.Set("username", ctx.Request.MFAUsername)
+
+	subject := ctx.Request.MFASubject
+	store := ctx.API.mfaStore()
+
+	failures, err := store.Failures(subject)
+	if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to check two-factor authentication"))
+		return
+	}
+	if failures >= ctx.API.Config.MFA.MaxAttempts {
+		ctx.Report.Denied()
+		ctx.ErrorRedirect(ctx.Request.makeNonRedirectError(nil, errors.AccessDenied, "too many failed attempts of two-factor authentication"))
+		return
+	}
+
+	if ctx.Request.OTP == "" {
+		ctx.Report.UserError()
+		ctx.ShowMFAPage(http.StatusForbidden, "missing code")
+		return
+	}
+
+	secret := ctx.Request.MFASecret
+	if secret == "" {
+		conn, reason, err := ctx.API.connectLDAP()
+		if err != nil {
+			ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, reason, "failed to connecting LDAP server"))
+			return
+		}
+		defer conn.Close()
+
+		if secret, err = ctx.API.mfaSecret(conn, subject); err != nil {
+			ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, unavailableOr(err, errors.ServerError), "failed to check two-factor authentication"))
+			return
+		}
+	}
+
+	key, err := mfa.ParseSecret(secret)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("username", ctx.Request.MFAUsername).
+			Msg("TOTP secret of the user is broken")
+
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to check two-factor authentication"))
+		return
+	}
+
+	if ok, err := store.AddAttempt(subject, ctx.API.Config.MFA.MaxAttempts, ctx.API.Config.Expire.Login.Duration()); err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to check two-factor authentication"))
+		return
+	} else if !ok {
+		ctx.Report.Denied()
+		ctx.ErrorRedirect(ctx.Request.makeNonRedirectError(nil, errors.AccessDenied, "too many failed attempts of two-factor authentication"))
+		return
+	}
+
+	step, ok := mfa.Verify(key, ctx.Request.OTP, time.Now())
+	if ok {
+		ok, err = store.UseStep(subject, step)
+		if err != nil {
+			ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to check two-factor authentication"))
+			return
+		}
+	}
+	if !ok {
+		ctx.Report.UserError()
+		RandomDelay()
+		ctx.Report.SetError(ctx.Request.makeRedirectError(nil, errors.InvalidRequest, "invalid code"))
+		ctx.ShowMFAPage(http.StatusForbidden, "invalid code")
+		return
+	}
+
+	store.ResetFailures(subject)
+
+	if ctx.Request.MFASecret != "" {
+		if err := store.SaveSecret(subject, ctx.Request.MFASecret); err != nil {
+			ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to enroll TOTP"))
+			return
+		}
+	}
+
+	if ctx.Request.RegisterPasskey && ctx.API.Config.WebAuthn.Enroll {
+		ctx.Request.MFAMethod = MFA_METHOD_WEBAUTHN_REGISTER
+		ctx.Request.MFASecret = ""
+		ctx.ShowWebAuthnPage(http.StatusOK, "")
+		return
+	}
+
+	if ctx.API.Config.ExpireFor(ctx.Request.ClientID).SSO > 0 {
+		ctx.API.SetSSOSession(ctx.Gin, subject, ctx.Request.MFAUsername, ctx.Request.ClientID, ctx.secondFactorAuthMethods(TOTPAuthMethods), true)
+	}
+	ctx.RememberConsent(subject)
+
+	ctx.SendTokens(subject, time.Now(), ctx.secondFactorAuthMethods(TOTPAuthMethods))
+}
+
+// secondFactorAuthMethods returns the amr claim for the user who passed the second factor.
+// Users who logged in with an upstream provider didn't use the password.
+func (ctx *AuthzContext) secondFactorAuthMethods(authMethods []string) []string {
+	if !ctx.Request.MFAUpstream {
+		return authMethods
+	}
+
+	var ms []string
+	for _, m := range authMethods {
+		if m != "pwd" {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+func (ctx *AuthzContext) ShowMFAPage(code int, errorDescription string) {
+	ctx.Report.Continue()
+	ctx.showPage(code, "mfa.tmpl", ctx.Request.MFAUsername, errorDescription)
+}
@@ -0,0 +1,268 @@
+package api_test
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/mfa"
+	"github.com/macrat/lauth/testutil"
+	"github.com/macrat/lauth/token"
+)
+
+const mfaTestSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+var totpSecretPattern = regexp.MustCompile(`aria-label="TOTP secret">([^<]*)<`)
+
+// wrongCode returns a code that is not valid for the secret now.
+func wrongCode(secret []byte) string {
+	for _, c := range []string{"000000", "111111"} {
+		if _, ok := mfa.Verify(secret, c, time.Now()); !ok {
+			return c
+		}
+	}
+	return "222222"
+}
+
+func setupMFA(t *testing.T, conf config.MFAConfig) *testutil.APITestEnvironment {
+	t.Helper()
+
+	env := testutil.NewAPITestEnvironment(t)
+	env.API.Config.MFA = conf
+
+	users := testutil.DummyLDAP{}
+	for name, user := range testutil.LDAP {
+		users[name] = user
+	}
+	users["totp-user"] = testutil.DummyUserInfo{
+		Password: "totp-password",
+		Attributes: map[string][]string{
+			"totpSecret": {mfaTestSecret},
+		},
+	}
+	env.API.Connector = users
+
+	return env
+}
+
+// postPassword posts username and password from the login page of the implicit client.
+func postPassword(t *testing.T, env *testutil.APITestEnvironment, username, password string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request, err := env.API.TokenManager.CreateRequestObject(
+		env.API.Config.Issuer,
+		"::1",
+		token.RequestObjectClaims{
+			ClientID:     "implicit_client_id",
+			RedirectURI:  "http://implicit-client.example.com/callback",
+			ResponseType: "id_token",
+			Scope:        "openid",
+			Nonce:        "client-nonce",
+		},
+		time.Now().Add(10*time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err)
+	}
+
+	return env.Post("/authz", "", url.Values{
+		"request":  {request},
+		"username": {username},
+		"password": {password},
+	})
+}
+
+// postOTP posts the code from the page of the second factor.
+func postOTP(t *testing.T, env *testutil.APITestEnvironment, page *httptest.ResponseRecorder, code string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	if page.Code != http.StatusOK && page.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status code of the page: %d: %s", page.Code, page.Body.String())
+	}
+	if !strings.Contains(page.Body.String(), `name="otp"`) {
+		t.Fatalf("the page has no input for code: %s", page.Body.String())
+	}
+
+	request, err := testutil.FindRequestObjectByHTML(strings.NewReader(page.Body.String()))
+	if err != nil {
+		t.Fatalf("failed to get request object: %s", err)
+	}
+
+	return env.Post("/authz", "", url.Values{
+		"request": {request},
+		"otp":     {code},
+	})
+}
+
+func parseIDTokenInRedirect(t *testing.T, env *testutil.APITestEnvironment, resp *httptest.ResponseRecorder) token.IDTokenClaims {
+	t.Helper()
+
+	if resp.Code != http.StatusFound {
+		t.Fatalf("unexpected status code: %d: %s", resp.Code, resp.Body.String())
+	}
+
+	location, _ := url.Parse(resp.Header().Get("Location"))
+	fragment, _ := url.ParseQuery(location.Fragment)
+	idToken, err := env.API.TokenManager.ParseIDToken(fragment.Get("id_token"))
+	if err != nil {
+		t.Fatalf("failed to parse id_token: %s: %s", err, location)
+	}
+	return idToken
+}
+
+func TestMFA_attribute(t *testing.T) {
+	env := setupMFA(t, config.MFAConfig{TOTPAttribute: "totpSecret", MaxAttempts: 5})
+	secret, _ := mfa.ParseSecret(mfaTestSecret)
+
+	resp := postPassword(t, env, "macrat", "foobar")
+	idToken := parseIDTokenInRedirect(t, env, resp)
+	if !reflect.DeepEqual(idToken.AuthMethods, []string{"pwd"}) {
+		t.Errorf("user without secret should login with only password: %#v", idToken.AuthMethods)
+	}
+
+	page := postPassword(t, env, "totp-user", "totp-password")
+	if page.Code != http.StatusOK || strings.Contains(page.Body.String(), "TOTP secret") {
+		t.Fatalf("unexpected page: %d: %s", page.Code, page.Body.String())
+	}
+
+	resp = postOTP(t, env, page, wrongCode(secret))
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("wrong code should be rejected: %d", resp.Code)
+	}
+
+	code := mfa.Code(secret, time.Now())
+	idToken = parseIDTokenInRedirect(t, env, postOTP(t, env, resp, code))
+	if idToken.Subject != "totp-user" {
+		t.Errorf("unexpected subject: %#v", idToken.Subject)
+	}
+	if !reflect.DeepEqual(idToken.AuthMethods, []string{"pwd", "otp"}) {
+		t.Errorf("unexpected amr: %#v", idToken.AuthMethods)
+	}
+
+	page = postPassword(t, env, "totp-user", "totp-password")
+	if resp := postOTP(t, env, page, code); resp.Code != http.StatusForbidden {
+		t.Errorf("same code should not be used twice: %d", resp.Code)
+	}
+}
+
+func TestMFA_enroll(t *testing.T) {
+	env := setupMFA(t, config.MFAConfig{Enroll: true, MaxAttempts: 5})
+
+	client := env.API.Config.Clients["implicit_client_id"]
+	client.RequireMFA = true
+	env.API.Config.Clients["implicit_client_id"] = client
+
+	page := postPassword(t, env, "macrat", "foobar")
+	m := totpSecretPattern.FindStringSubmatch(page.Body.String())
+	if m == nil {
+		t.Fatalf("enrollment page has no secret: %d: %s", page.Code, page.Body.String())
+	}
+	if !strings.Contains(page.Body.String(), `href="otpauth://totp/`) {
+		t.Errorf("enrollment page has no link for authenticator app: %s", page.Body.String())
+	}
+
+	secret, err := mfa.ParseSecret(html.UnescapeString(m[1]))
+	if err != nil {
+		t.Fatalf("failed to parse secret: %s", err)
+	}
+
+	idToken := parseIDTokenInRedirect(t, env, postOTP(t, env, page, mfa.Code(secret, time.Now())))
+	if !reflect.DeepEqual(idToken.AuthMethods, []string{"pwd", "otp"}) {
+		t.Errorf("unexpected amr: %#v", idToken.AuthMethods)
+	}
+
+	if saved, err := (mfa.Store{Store: env.API.Store}).Secret("macrat"); err != nil || saved != html.UnescapeString(m[1]) {
+		t.Errorf("secret should be saved after verified: %#v %s", saved, err)
+	}
+
+	page = postPassword(t, env, "macrat", "foobar")
+	if page.Code != http.StatusOK || strings.Contains(page.Body.String(), "TOTP secret") {
+		t.Errorf("enrolled user should be asked only code: %d: %s", page.Code, page.Body.String())
+	}
+}
+
+func TestMFA_requiredGroups(t *testing.T) {
+	env := setupMFA(t, config.MFAConfig{
+		TOTPAttribute:  "totpSecret",
+		RequiredGroups: []string{"CN=admin,OU=group,DC=example,DC=local"},
+		MaxAttempts:    5,
+	})
+
+	resp := postPassword(t, env, "macrat", "foobar")
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "two-factor authentication is required") {
+		t.Errorf("member of required group without secret should be denied: %d %s", resp.Code, resp.Body.String())
+	}
+
+	idToken := parseIDTokenInRedirect(t, env, postPassword(t, env, "j.smith", "hello"))
+	if idToken.Subject != "j.smith" {
+		t.Errorf("user out of required groups should login with only password: %#v", idToken.Subject)
+	}
+}
+
+func TestMFA_maxAttempts(t *testing.T) {
+	env := setupMFA(t, config.MFAConfig{TOTPAttribute: "totpSecret", MaxAttempts: 2})
+	secret, _ := mfa.ParseSecret(mfaTestSecret)
+
+	page := postPassword(t, env, "totp-user", "totp-password")
+	for i := 0; i < 2; i++ {
+		page = postOTP(t, env, page, wrongCode(secret))
+		if page.Code != http.StatusForbidden {
+			t.Fatalf("wrong code should be rejected: %d", page.Code)
+		}
+	}
+
+	resp := postOTP(t, env, page, mfa.Code(secret, time.Now()))
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "too many failed attempts") {
+		t.Errorf("user should be locked out after max attempts: %d %s", resp.Code, resp.Body.String())
+	}
+
+	page = postPassword(t, env, "totp-user", "totp-password")
+	if resp := postOTP(t, env, page, mfa.Code(secret, time.Now())); resp.Code != http.StatusBadRequest {
+		t.Errorf("lockout should be kept over login sessions: %d", resp.Code)
+	}
+}
+
+func TestMFA_sso(t *testing.T) {
+	env := setupMFA(t, config.MFAConfig{})
+
+	resp := postPassword(t, env, "macrat", "foobar")
+	if resp.Code != http.StatusFound {
+		t.Fatalf("unexpected status code: %d: %s", resp.Code, resp.Body.String())
+	}
+	cookies := resp.Result().Cookies()
+
+	authz := func() *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/authz?"+url.Values{
+			"response_type": {"id_token"},
+			"client_id":     {"implicit_client_id"},
+			"redirect_uri":  {"http://implicit-client.example.com/callback"},
+			"scope":         {"openid"},
+			"nonce":         {"client-nonce"},
+		}.Encode(), nil)
+		r.RemoteAddr = "[::1]:54321"
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		return env.DoRequest(r)
+	}
+
+	if resp := authz(); resp.Code != http.StatusFound {
+		t.Fatalf("failed to login with SSO session: %d: %s", resp.Code, resp.Body.String())
+	}
+
+	env.API.Config.MFA = config.MFAConfig{Enroll: true, MaxAttempts: 5}
+	client := env.API.Config.Clients["implicit_client_id"]
+	client.RequireMFA = true
+	env.API.Config.Clients["implicit_client_id"] = client
+
+	if resp := authz(); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `name="password"`) {
+		t.Errorf("session without second factor should not be used for client that requires MFA: %d", resp.Code)
+	}
+}
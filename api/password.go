@@ -0,0 +1,156 @@
+package api
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"github.com/macrat/lauth/errors"
+	"github.com/macrat/lauth/ldap"
+	"github.com/rs/zerolog/log"
+)
+
+// loginFailures are the reasons of login failure that told to the user as is.
+// Other errors are told as "invalid username or password".
+var loginFailures = []error{
+	ldap.LogonTimeRestrictedError,
+	ldap.WorkstationRestrictedError,
+	ldap.PasswordExpiredError,
+	ldap.AccountDisabledError,
+	ldap.AccountExpiredError,
+	ldap.PasswordMustChangeError,
+	ldap.AccountLockedError,
+}
+
+// errorMessages are the messages to show the user in the pages, for each error description.
+var errorMessages = map[string]string{
+	ldap.LogonTimeRestrictedError.Error():   "You are not permitted to login at this time.",
+	ldap.WorkstationRestrictedError.Error(): "You are not permitted to login from this computer.",
+	ldap.PasswordExpiredError.Error():       "Your password has expired. Please contact your administrator.",
+	ldap.AccountDisabledError.Error():       "Your account is disabled. Please contact your administrator.",
+	ldap.AccountExpiredError.Error():        "Your account has expired. Please contact your administrator.",
+	ldap.PasswordMustChangeError.Error():    "You have to change your password. Please contact your administrator.",
+	ldap.AccountLockedError.Error():         "Your account is locked out. Please try again later, or contact your administrator.",
+
+	"missing password":                               "Please enter the current password and the new password.",
+	"invalid current password":                       "The current password is incorrect.",
+	"new passwords are mismatch":                     "The new passwords do not match.",
+	"new password is same as current one":            "The new password must be different from the current password.",
+	"new password does not meet the password policy": "The new password does not meet the password policy. Please try a longer or more complex one.",
+
+	"too many failed attempts": "Too many failed attempts. Please wait a while and try again.",
+}
+
+// loginFailureDescription returns the error description for the error of LoginTest.
+func loginFailureDescription(err error) string {
+	for _, reason := range loginFailures {
+		if stderrors.Is(err, reason) {
+			return reason.Error()
+		}
+	}
+	return "invalid username or password"
+}
+
+// needPasswordChange reports the error of LoginTest means the user can login after changing the password.
+func needPasswordChange(err error) bool {
+	return stderrors.Is(err, ldap.PasswordExpiredError) || stderrors.Is(err, ldap.PasswordMustChangeError)
+}
+
+// StartPasswordChange shows the page to change the password, to the user whose password has expired or must be changed.
+func (ctx *AuthzContext) StartPasswordChange() {
+	ctx.Request.PasswordChangeUser = ctx.Request.User
+	ctx.Request.PasswordChangeDirectory = ctx.Request.Directory
+	ctx.ShowPasswordPage(http.StatusOK, "")
+}
+
+// ChangePassword changes the password of the user who is on the password change page, and continues the login with the new password.
+func (ctx *AuthzContext) ChangePassword() {
+	ctx.Report.Set("authn_by", "password")
+	ctx.Report.Set("username", ctx.Request.PasswordChangeUser)
+
+	username := ctx.Request.PasswordChangeUser
+	directory := ctx.Request.PasswordChangeDirectory
+
+	showPasswordForm := func(err error, description string) {
+		ctx.Report.UserError()
+		ctx.Report.SetError(ctx.Request.makeRedirectError(err, errors.InvalidRequest, description))
+		ctx.ShowPasswordPage(http.StatusForbidden, description)
+	}
+
+	switch {
+	case ctx.Request.Password == "" || ctx.Request.NewPassword == "":
+		showPasswordForm(nil, "missing password")
+		return
+	case ctx.Request.NewPassword != ctx.Request.NewPasswordConfirm:
+		showPasswordForm(nil, "new passwords are mismatch")
+		return
+	case ctx.Request.NewPassword == ctx.Request.Password:
+		showPasswordForm(nil, "new password is same as current one")
+		return
+	}
+
+	if !ctx.ReserveLoginAttempt(directory, username, ctx.ShowPasswordPage) {
+		return
+	}
+
+	conn, reason, err := ctx.API.connectLDAP()
+	if err != nil {
+		ctx.ReleaseLoginAttempt()
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, reason, "failed to connecting LDAP server"))
+		return
+	}
+	defer conn.Close()
+
+	err = ldap.ChangePasswordIn(conn, directory, username, ctx.Request.Password, ctx.Request.NewPassword)
+	if isLoginFailure(err) {
+		ctx.RecordLoginFailure()
+	} else if err != nil {
+		ctx.ReleaseLoginAttempt()
+	} else {
+		ctx.ResetLockout()
+	}
+
+	switch {
+	case stderrors.Is(err, ldap.UnavailableError):
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.TemporarilyUnavailable, "failed to connecting LDAP server"))
+		return
+	case stderrors.Is(err, ldap.PasswordPolicyError):
+		showPasswordForm(err, "new password does not meet the password policy")
+		return
+	case stderrors.Is(err, ldap.InvalidCredentialsError) || stderrors.Is(err, ldap.UserNotFoundError):
+		RandomDelay()
+		showPasswordForm(err, "invalid current password")
+		return
+	case err == ldap.PasswordChangeNotSupportedError:
+		ctx.Report.Denied()
+		ctx.ErrorRedirect(ctx.Request.makeNonRedirectError(err, errors.AccessDenied, "password change is not supported for the user"))
+		return
+	case err != nil:
+		log.Error().
+			Err(err).
+			Str("username", username).
+			Msg("failed to change password")
+
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to change password"))
+		return
+	}
+
+	log.Info().
+		Str("username", username).
+		Msg("password changed")
+
+	ctx.Request.PasswordChangeUser = ""
+	ctx.Request.PasswordChangeDirectory = ""
+
+	id, err := ldap.LoginTestIn(conn, directory, username, ctx.Request.NewPassword)
+	if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, unavailableOr(err, errors.ServerError), "failed to login with the new password"))
+		return
+	}
+
+	ctx.CompletePasswordLogin(conn, id)
+}
+
+func (ctx *AuthzContext) ShowPasswordPage(code int, errorDescription string) {
+	ctx.Report.Continue()
+	ctx.showPage(code, "password.tmpl", ctx.Request.PasswordChangeUser, errorDescription)
+}
@@ -0,0 +1,152 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/testutil"
+)
+
+func setupPasswordChange(t *testing.T, enabled bool) (*testutil.APITestEnvironment, testutil.DummyLDAP) {
+	t.Helper()
+
+	env := testutil.NewAPITestEnvironment(t)
+	if enabled {
+		env.API.Config.LDAP.PasswordChange = "active-directory"
+	}
+
+	users := testutil.DummyLDAP{}
+	for name, user := range testutil.LDAP {
+		users[name] = user
+	}
+	users["expired"] = testutil.DummyUserInfo{Password: "old-password", LoginError: ldap.PasswordExpiredError}
+	users["reset"] = testutil.DummyUserInfo{Password: "old-password", LoginError: ldap.PasswordMustChangeError}
+	users["locked"] = testutil.DummyUserInfo{Password: "password", LoginError: ldap.AccountLockedError}
+	users["disabled"] = testutil.DummyUserInfo{Password: "password", LoginError: ldap.AccountDisabledError}
+	env.API.Connector = users
+
+	return env, users
+}
+
+// postNewPassword posts passwords from the password change page.
+func postNewPassword(t *testing.T, env *testutil.APITestEnvironment, page *httptest.ResponseRecorder, current, newPassword, confirm string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	if !strings.Contains(page.Body.String(), `name="new_password"`) {
+		t.Fatalf("the page has no input for new password: %d: %s", page.Code, page.Body.String())
+	}
+
+	request, err := testutil.FindRequestObjectByHTML(strings.NewReader(page.Body.String()))
+	if err != nil {
+		t.Fatalf("failed to get request object: %s", err)
+	}
+
+	return env.Post("/authz", "", url.Values{
+		"request":              {request},
+		"password":             {current},
+		"new_password":         {newPassword},
+		"new_password_confirm": {confirm},
+	})
+}
+
+func TestPostAuthz_loginFailures(t *testing.T) {
+	env, _ := setupPasswordChange(t, false)
+
+	tests := []struct {
+		Username string
+		Password string
+		Message  string
+	}{
+		{"macrat", "wrong", "Invalid username or password."},
+		{"locked", "password", "Your account is locked out."},
+		{"disabled", "password", "Your account is disabled."},
+		{"expired", "old-password", "Your password has expired."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Username, func(t *testing.T) {
+			resp := postPassword(t, env, tt.Username, tt.Password)
+			if resp.Code != http.StatusForbidden {
+				t.Fatalf("unexpected status code: %d", resp.Code)
+			}
+			if !strings.Contains(resp.Body.String(), tt.Message) {
+				t.Errorf("expected message %#v but not found: %s", tt.Message, resp.Body.String())
+			}
+			if strings.Contains(resp.Body.String(), `name="new_password"`) {
+				t.Errorf("password change page should not be shown if disabled")
+			}
+		})
+	}
+}
+
+func TestPostAuthz_changePassword(t *testing.T) {
+	env, users := setupPasswordChange(t, true)
+
+	resp := postPassword(t, env, "locked", "password")
+	if resp.Code != http.StatusForbidden || strings.Contains(resp.Body.String(), `name="new_password"`) {
+		t.Fatalf("locked user should not be able to change password: %d", resp.Code)
+	}
+
+	page := postPassword(t, env, "expired", "wrong-password")
+	if page.Code != http.StatusForbidden || strings.Contains(page.Body.String(), `name="new_password"`) {
+		t.Fatalf("password change page should not be shown for wrong password: %d", page.Code)
+	}
+
+	page = postPassword(t, env, "expired", "old-password")
+	if page.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d: %s", page.Code, page.Body.String())
+	}
+
+	tests := []struct {
+		Name    string
+		Current string
+		New     string
+		Confirm string
+		Message string
+	}{
+		{"mismatch", "old-password", "new-password", "another-password", "The new passwords do not match."},
+		{"same as current", "old-password", "old-password", "old-password", "The new password must be different from the current password."},
+		{"wrong current", "wrong-password", "new-password", "new-password", "The current password is incorrect."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			resp := postNewPassword(t, env, page, tt.Current, tt.New, tt.Confirm)
+			if resp.Code != http.StatusForbidden {
+				t.Fatalf("unexpected status code: %d", resp.Code)
+			}
+			if !strings.Contains(resp.Body.String(), tt.Message) {
+				t.Errorf("expected message %#v but not found: %s", tt.Message, resp.Body.String())
+			}
+		})
+	}
+	if users["expired"].Password != "old-password" {
+		t.Fatalf("password should not be changed yet")
+	}
+
+	resp = postNewPassword(t, env, page, "old-password", "new-password", "new-password")
+	idToken := parseIDTokenInRedirect(t, env, resp)
+	if idToken.Subject != "expired" || !reflect.DeepEqual(idToken.AuthMethods, []string{"pwd"}) {
+		t.Errorf("unexpected id_token: %#v", idToken)
+	}
+	if users["expired"].Password != "new-password" {
+		t.Errorf("password was not changed")
+	}
+
+	resp = postPassword(t, env, "expired", "new-password")
+	parseIDTokenInRedirect(t, env, resp)
+}
+
+func TestPostAuthz_changePassword_mustChange(t *testing.T) {
+	env, _ := setupPasswordChange(t, true)
+
+	page := postPassword(t, env, "reset", "old-password")
+	resp := postNewPassword(t, env, page, "old-password", "new-password", "new-password")
+	if idToken := parseIDTokenInRedirect(t, env, resp); idToken.Subject != "reset" {
+		t.Errorf("unexpected subject: %s", idToken.Subject)
+	}
+}
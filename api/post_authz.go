@@ -1,12 +1,13 @@
 package api
 
 import (
+	stderrors "errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/macrat/lauth/errors"
-	"github.com/rs/zerolog/log"
+	"github.com/macrat/lauth/ldap"
 )
 
 func (api *LauthAPI) PostAuthz(c *gin.Context) {
@@ -30,38 +31,105 @@ func (api *LauthAPI) PostAuthz(c *gin.Context) {
 		return
 	}
 
+	if ctx.Request.Consent == "deny" {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(nil, errors.AccessDenied, "user denied consent"))
+		return
+	}
+
+	if ctx.Request.PasswordChangeUser != "" {
+		ctx.ChangePassword()
+		return
+	}
+
+	if ctx.Request.MFASubject != "" {
+		switch ctx.Request.MFAMethod {
+		case MFA_METHOD_WEBAUTHN:
+			ctx.VerifyWebAuthn()
+		case MFA_METHOD_WEBAUTHN_REGISTER:
+			ctx.VerifyWebAuthnRegistration()
+		default:
+			ctx.VerifyMFA()
+		}
+		return
+	}
+
 	if proceed := ctx.TrySSO(true); proceed {
 		return
 	}
 
+	if ctx.Request.WebAuthn != "" {
+		ctx.LoginWithPasskey()
+		return
+	}
+
+	if ctx.Request.Upstream != "" {
+		ctx.StartUpstreamLogin()
+		return
+	}
+
 	if ctx.Request.User == "" || ctx.Request.Password == "" {
 		ctx.Report.UserError()
 		showLoginForm(nil, "missing username or password")
 		return
 	}
 
-	conn, err := api.Connector.Connect()
-	if err != nil {
-		log.Error().
-			Err(err).
-			Msg("failed to connecting LDAP server")
+	if !ctx.ReserveLoginAttempt(ctx.Request.Directory, ctx.Request.User, func(code int, description string) {
+		ctx.ShowLoginPage(code, ctx.Request.User, description)
+	}) {
+		return
+	}
 
-		e := ctx.Request.makeRedirectError(err, errors.ServerError, "failed to connecting LDAP server")
+	conn, reason, err := api.connectLDAP()
+	if err != nil {
+		ctx.ReleaseLoginAttempt()
+		e := ctx.Request.makeRedirectError(err, reason, "failed to connecting LDAP server")
 		ctx.ErrorRedirect(e)
 		return
 	}
 	defer conn.Close()
 
-	if err := conn.LoginTest(ctx.Request.User, ctx.Request.Password); err != nil {
+	id, err := ldap.LoginTestIn(conn, ctx.Request.Directory, ctx.Request.User, ctx.Request.Password)
+	if stderrors.Is(err, ldap.UnavailableError) {
+		ctx.ReleaseLoginAttempt()
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.TemporarilyUnavailable, "failed to connecting LDAP server"))
+		return
+	} else if needPasswordChange(err) && api.Config.PasswordChangeEnabled() {
+		ctx.ResetLockout()
+		ctx.StartPasswordChange()
+		return
+	} else if err != nil {
+		if isLoginFailure(err) {
+			ctx.RecordLoginFailure()
+		} else {
+			ctx.ReleaseLoginAttempt()
+		}
+
 		ctx.Report.UserError()
 		RandomDelay()
-		showLoginForm(err, "invalid username or password")
+		showLoginForm(err, loginFailureDescription(err))
+		return
+	}
+
+	ctx.ResetLockout()
+	ctx.CompletePasswordLogin(conn, id)
+}
+
+// CompletePasswordLogin continues the login of the user who passed the password, and sends tokens if the user doesn't need anything else.
+func (ctx *AuthzContext) CompletePasswordLogin(conn ldap.Session, id ldap.Identity) {
+	ctx.Report.Set("username", id.Username)
+
+	if !ctx.CheckAccess(id.Subject) {
+		return
+	}
+
+	if !ctx.StartWebAuthn(conn, id) || !ctx.StartMFA(conn, id) {
 		return
 	}
 
-	if api.Config.Expire.SSO > 0 {
-		api.SetSSOToken(c, ctx.Request.User, ctx.Request.ClientID, true)
+	if ctx.API.Config.ExpireFor(ctx.Request.ClientID).SSO > 0 {
+		ctx.API.SetSSOSession(ctx.Gin, id.Subject, id.Username, ctx.Request.ClientID, PasswordAuthMethods, true)
 	}
+	ctx.RememberConsent(id.Subject)
 
-	ctx.SendTokens(ctx.Request.User, time.Now())
+	ctx.SendTokens(id.Subject, time.Now(), PasswordAuthMethods)
 }
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/ldap"
 	"github.com/macrat/lauth/testutil"
 	"github.com/macrat/lauth/token"
 )
@@ -402,3 +403,163 @@ func TestPostAuthz(t *testing.T) {
 		},
 	})
 }
+
+func TestPostAuthz_SubjectAttribute(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+	env.API.Config.LDAP.IDAttribute = "sAMAccountName"
+	env.API.Config.LDAP.SubjectAttribute = "entryUUID"
+	env.API.Connector = testutil.DummyLDAP{
+		"macrat": testutil.DummyUserInfo{
+			Password: "foobar",
+			Subject:  "6a0e7f8c-1b2d-4e3f-9a8b-7c6d5e4f3a2b",
+			Attributes: map[string][]string{
+				"sAMAccountName": {"macrat"},
+				"displayName":    {"SHIDA Yuuma"},
+			},
+		},
+	}
+
+	request, err := env.API.TokenManager.CreateRequestObject(
+		env.API.Config.Issuer,
+		"::1",
+		token.RequestObjectClaims{
+			ClientID:     "implicit_client_id",
+			RedirectURI:  "http://implicit-client.example.com/callback",
+			ResponseType: "id_token",
+			Scope:        "openid profile",
+		},
+		time.Now().Add(10*time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err)
+	}
+
+	env.RedirectTest(t, "POST", "/authz", []testutil.RedirectTest{
+		{
+			Name: "login with login name",
+			Request: url.Values{
+				"request":  {request},
+				"username": {"macrat"},
+				"password": {"foobar"},
+			},
+			Code:        http.StatusFound,
+			HasLocation: true,
+			CheckParams: func(t *testing.T, query, fragment url.Values) {
+				idToken, err := env.API.TokenManager.ParseIDToken(fragment.Get("id_token"))
+				if err != nil {
+					t.Fatalf("failed to parse id_token: %s", err)
+				}
+				if idToken.Subject != "6a0e7f8c-1b2d-4e3f-9a8b-7c6d5e4f3a2b" {
+					t.Errorf("unexpected subject: %#v", idToken.Subject)
+				}
+				if idToken.ExtraClaims["preferred_username"] != "macrat" {
+					t.Errorf("unexpected preferred_username: %#v", idToken.ExtraClaims["preferred_username"])
+				}
+				if idToken.ExtraClaims["name"] != "SHIDA Yuuma" {
+					t.Errorf("unexpected name: %#v", idToken.ExtraClaims["name"])
+				}
+			},
+		},
+	})
+}
+
+func TestPostAuthz_Directories(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+	env.API.Config.Directories = []config.DirectoryConfig{
+		{Name: "corp", LDAP: config.LDAPConfig{IDAttribute: "sAMAccountName"}},
+		{Name: "acquired", LDAP: config.LDAPConfig{IDAttribute: "sAMAccountName"}},
+	}
+	env.API.Config.DirectoryPicker = true
+	env.API.Connector = &ldap.DirectoryConnector{
+		Directories: []ldap.Directory{
+			{
+				Config: &env.API.Config.Directories[0],
+				Connector: testutil.DummyLDAP{
+					"macrat": testutil.DummyUserInfo{
+						Password:   "foobar",
+						Attributes: map[string][]string{"sAMAccountName": {"macrat"}, "displayName": {"corp macrat"}},
+					},
+				},
+			},
+			{
+				Config: &env.API.Config.Directories[1],
+				Connector: testutil.DummyLDAP{
+					"macrat": testutil.DummyUserInfo{
+						Password:   "hogefuga",
+						Attributes: map[string][]string{"sAMAccountName": {"macrat"}, "displayName": {"acquired macrat"}},
+					},
+				},
+			},
+		},
+	}
+
+	request, err := env.API.TokenManager.CreateRequestObject(
+		env.API.Config.Issuer,
+		"::1",
+		token.RequestObjectClaims{
+			ClientID:     "implicit_client_id",
+			RedirectURI:  "http://implicit-client.example.com/callback",
+			ResponseType: "id_token",
+			Scope:        "openid profile",
+		},
+		time.Now().Add(10*time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err)
+	}
+
+	checkIdentity := func(subject, name string) func(*testing.T, url.Values, url.Values) {
+		return func(t *testing.T, query, fragment url.Values) {
+			idToken, err := env.API.TokenManager.ParseIDToken(fragment.Get("id_token"))
+			if err != nil {
+				t.Fatalf("failed to parse id_token: %s", err)
+			}
+			if idToken.Subject != subject {
+				t.Errorf("unexpected subject: %#v", idToken.Subject)
+			}
+			if idToken.ExtraClaims["name"] != name {
+				t.Errorf("unexpected name: %#v", idToken.ExtraClaims["name"])
+			}
+			if idToken.ExtraClaims["preferred_username"] != "macrat" {
+				t.Errorf("unexpected preferred_username: %#v", idToken.ExtraClaims["preferred_username"])
+			}
+		}
+	}
+
+	env.RedirectTest(t, "POST", "/authz", []testutil.RedirectTest{
+		{
+			Name: "try in order",
+			Request: url.Values{
+				"request":  {request},
+				"username": {"macrat"},
+				"password": {"foobar"},
+			},
+			Code:        http.StatusFound,
+			HasLocation: true,
+			CheckParams: checkIdentity("corp:macrat", "corp macrat"),
+		},
+		{
+			Name: "picked directory",
+			Request: url.Values{
+				"request":   {request},
+				"username":  {"macrat"},
+				"password":  {"hogefuga"},
+				"directory": {"acquired"},
+			},
+			Code:        http.StatusFound,
+			HasLocation: true,
+			CheckParams: checkIdentity("acquired:macrat", "acquired macrat"),
+		},
+		{
+			Name: "wrong directory",
+			Request: url.Values{
+				"request":   {request},
+				"username":  {"macrat"},
+				"password":  {"foobar"},
+				"directory": {"acquired"},
+			},
+			Code:        http.StatusForbidden,
+			HasLocation: false,
+		},
+	})
+}
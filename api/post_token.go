@@ -20,6 +20,7 @@ type PostTokenRequest struct {
 	ClientID     string `form:"client_id"     json:"client_id"     xml:"client_id"`
 	ClientSecret string `form:"client_secret" json:"client_secret" xml:"client_secret"`
 	RedirectURI  string `form:"redirect_uri"  json:"redirect_uri"  xml:"redirect_uri"`
+	Scope        string `form:"scope"         json:"scope"         xml:"scope"`
 }
 
 func (req *PostTokenRequest) Bind(c *gin.Context) *errors.Error {
@@ -161,6 +162,7 @@ func (api *LauthAPI) postTokenWithCode(c *gin.Context, req PostTokenRequest, rep
 	}
 
 	scope := ParseStringSet(code.Scope)
+	expire := api.Config.ExpireFor(code.ClientID)
 
 	accessToken, err := api.TokenManager.CreateAccessToken(
 		api.Config.Issuer,
@@ -168,7 +170,7 @@ func (api *LauthAPI) postTokenWithCode(c *gin.Context, req PostTokenRequest, rep
 		code.ClientID,
 		scope.String(),
 		time.Unix(code.AuthTime, 0),
-		api.Config.Expire.Token.Duration(),
+		expire.Token.Duration(),
 	)
 	if err != nil {
 		return nil, &errors.Error{
@@ -194,7 +196,8 @@ func (api *LauthAPI) postTokenWithCode(c *gin.Context, req PostTokenRequest, rep
 			accessToken,
 			userinfo,
 			time.Unix(code.AuthTime, 0),
-			api.Config.Expire.Token.Duration(),
+			code.AuthMethods,
+			expire.Token.Duration(),
 		)
 		if err != nil {
 			return nil, &errors.Error{
@@ -206,7 +209,7 @@ func (api *LauthAPI) postTokenWithCode(c *gin.Context, req PostTokenRequest, rep
 	}
 
 	refreshToken := ""
-	if api.Config.Expire.Refresh > 0 {
+	if expire.Refresh > 0 {
 		refreshToken, err = api.TokenManager.CreateRefreshToken(
 			api.Config.Issuer,
 			code.Subject,
@@ -214,7 +217,8 @@ func (api *LauthAPI) postTokenWithCode(c *gin.Context, req PostTokenRequest, rep
 			code.Scope,
 			code.Nonce,
 			time.Unix(code.AuthTime, 0),
-			api.Config.Expire.Refresh.Duration(),
+			code.AuthMethods,
+			expire.Refresh.Duration(),
 		)
 		if err != nil {
 			return nil, &errors.Error{
@@ -229,7 +233,7 @@ func (api *LauthAPI) postTokenWithCode(c *gin.Context, req PostTokenRequest, rep
 		TokenType:    "Bearer",
 		AccessToken:  accessToken,
 		IDToken:      idToken,
-		ExpiresIn:    api.Config.Expire.Token.IntSeconds(),
+		ExpiresIn:    expire.Token.IntSeconds(),
 		Scope:        code.Scope,
 		RefreshToken: refreshToken,
 	}, nil
@@ -258,13 +262,42 @@ func (api *LauthAPI) postTokenWithRefreshToken(c *gin.Context, req PostTokenRequ
 		}
 	}
 
+	expire := api.Config.ExpireFor(refreshToken.ClientID)
+	if expire.Refresh <= 0 {
+		return nil, &errors.Error{
+			Reason:      errors.InvalidGrant,
+			Description: "refresh_token is disabled for this client",
+		}
+	}
+
+	scope := ParseStringSet(refreshToken.Scope)
+	if req.Scope != "" {
+		requested := ParseStringSet(req.Scope)
+		if err := requested.Validate("scope", scope.List()); err != nil {
+			return nil, &errors.Error{
+				Err:         err,
+				Reason:      errors.InvalidScope,
+				Description: "scope must be a subset of the original grant",
+			}
+		}
+		scope = requested
+	}
+	scope, err = api.allowedScope(refreshToken.ClientID, scope)
+	if err != nil {
+		return nil, &errors.Error{
+			Err:         err,
+			Reason:      errors.InvalidScope,
+			Description: err.Error(),
+		}
+	}
+
 	accessToken, err := api.TokenManager.CreateAccessToken(
 		api.Config.Issuer,
 		refreshToken.Subject,
 		refreshToken.ClientID,
-		refreshToken.Scope,
+		scope.String(),
 		time.Unix(refreshToken.AuthTime, 0),
-		api.Config.Expire.Token.Duration(),
+		expire.Token.Duration(),
 	)
 	if err != nil {
 		return nil, &errors.Error{
@@ -274,7 +307,6 @@ func (api *LauthAPI) postTokenWithRefreshToken(c *gin.Context, req PostTokenRequ
 		}
 	}
 
-	scope := ParseStringSet(refreshToken.Scope)
 	var idToken string
 	if scope.Has("openid") {
 		userinfo, errMsg := api.userinfo(refreshToken.Subject, scope)
@@ -291,7 +323,8 @@ func (api *LauthAPI) postTokenWithRefreshToken(c *gin.Context, req PostTokenRequ
 			accessToken,
 			userinfo,
 			time.Unix(refreshToken.AuthTime, 0),
-			api.Config.Expire.Token.Duration(),
+			refreshToken.AuthMethods,
+			expire.Token.Duration(),
 		)
 		if err != nil {
 			return nil, &errors.Error{
@@ -306,8 +339,8 @@ func (api *LauthAPI) postTokenWithRefreshToken(c *gin.Context, req PostTokenRequ
 		TokenType:   "Bearer",
 		AccessToken: accessToken,
 		IDToken:     idToken,
-		ExpiresIn:   api.Config.Expire.Token.IntSeconds(),
-		Scope:       refreshToken.Scope,
+		ExpiresIn:   expire.Token.IntSeconds(),
+		Scope:       scope.String(),
 	}, nil
 }
 
@@ -101,6 +101,7 @@ func TestPostToken_Code(t *testing.T) {
 		"openid profile",
 		"something-nonce",
 		time.Now(),
+		nil,
 		env.API.Config.Expire.Code.Duration(),
 	)
 	if err != nil {
@@ -115,6 +116,7 @@ func TestPostToken_Code(t *testing.T) {
 		"profile",
 		"something-nonce",
 		time.Now(),
+		nil,
 		env.API.Config.Expire.Code.Duration(),
 	)
 	if err != nil {
@@ -129,6 +131,7 @@ func TestPostToken_Code(t *testing.T) {
 		"openid profile",
 		"",
 		time.Now(),
+		nil,
 		env.API.Config.Expire.Code.Duration(),
 	)
 	if err != nil {
@@ -397,6 +400,7 @@ func TestPostToken_RefreshToken(t *testing.T) {
 		"openid profile",
 		"something-nonce",
 		time.Now(),
+		nil,
 		env.API.Config.Expire.Refresh.Duration(),
 	)
 	if err != nil {
@@ -410,6 +414,7 @@ func TestPostToken_RefreshToken(t *testing.T) {
 		"profile",
 		"something-nonce",
 		time.Now(),
+		nil,
 		env.API.Config.Expire.Refresh.Duration(),
 	)
 	if err != nil {
@@ -423,6 +428,7 @@ func TestPostToken_RefreshToken(t *testing.T) {
 		"openid profile",
 		"",
 		time.Now(),
+		nil,
 		env.API.Config.Expire.Code.Duration(),
 	)
 	if err != nil {
@@ -582,6 +588,33 @@ func TestPostToken_RefreshToken(t *testing.T) {
 			Code:      http.StatusOK,
 			CheckBody: ResponseValidation(env, "profile", ""),
 		},
+		{
+			Name: "success / narrow scope",
+			Request: url.Values{
+				"grant_type":    {"refresh_token"},
+				"refresh_token": {refreshToken},
+				"client_id":     {"some_client_id"},
+				"client_secret": {"secret for some-client"},
+				"scope":         {"openid"},
+			},
+			Code:      http.StatusOK,
+			CheckBody: ResponseValidation(env, "openid", ""),
+		},
+		{
+			Name: "wider scope than original",
+			Request: url.Values{
+				"grant_type":    {"refresh_token"},
+				"refresh_token": {refreshToken},
+				"client_id":     {"some_client_id"},
+				"client_secret": {"secret for some-client"},
+				"scope":         {"openid email"},
+			},
+			Code: http.StatusBadRequest,
+			Body: map[string]interface{}{
+				"error":             "invalid_scope",
+				"error_description": "scope must be a subset of the original grant",
+			},
+		},
 	})
 }
 
@@ -596,6 +629,7 @@ func TestPostToken_CORS(t *testing.T) {
 		"openid profile",
 		"something-nonce",
 		time.Now(),
+		nil,
 		env.API.Config.Expire.Code.Duration(),
 	)
 	if err != nil {
@@ -639,3 +673,86 @@ func TestPostToken_CORS(t *testing.T) {
 		t.Errorf("unexpected response: %#v", string(resp.Body.Bytes()))
 	}
 }
+
+func TestPostToken_ClientExpire(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+
+	code, err := env.API.TokenManager.CreateCode(
+		env.API.Config.Issuer,
+		"macrat",
+		"admin_client_id",
+		"http://admin-client.example.com/callback",
+		"openid",
+		"",
+		time.Now(),
+		nil,
+		env.API.Config.Expire.Code.Duration(),
+	)
+	if err != nil {
+		t.Fatalf("failed to generate test code: %s", err)
+	}
+
+	refreshToken, err := env.API.TokenManager.CreateRefreshToken(
+		env.API.Config.Issuer,
+		"macrat",
+		"admin_client_id",
+		"openid",
+		"",
+		time.Now(),
+		nil,
+		env.API.Config.Expire.Refresh.Duration(),
+	)
+	if err != nil {
+		t.Fatalf("failed to generate test refresh_token: %s", err)
+	}
+
+	env.JSONTest(t, "POST", "/token", []testutil.JSONTest{
+		{
+			Name: "code",
+			Request: url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {code},
+				"client_id":     {"admin_client_id"},
+				"client_secret": {"secret for some-client"},
+				"redirect_uri":  {"http://admin-client.example.com/callback"},
+			},
+			Code: http.StatusOK,
+			CheckBody: func(t *testing.T, body testutil.RawBody) {
+				var resp api.PostTokenResponse
+				if err := body.Bind(&resp); err != nil {
+					t.Fatalf("failed to unmarshal response body: %s", err)
+				}
+
+				if resp.ExpiresIn != 15*60 {
+					t.Errorf("expires_in is expected 900 but got %d", resp.ExpiresIn)
+				}
+
+				if resp.RefreshToken != "" {
+					t.Errorf("refresh_token is disabled but got %#v", resp.RefreshToken)
+				}
+
+				accessToken, err := env.API.TokenManager.ParseAccessToken(resp.AccessToken)
+				if err != nil {
+					t.Fatalf("failed to parse access token: %s", err)
+				}
+				if accessToken.ExpiresAt-accessToken.IssuedAt != 15*60 {
+					t.Errorf("unexpected access token lifetime: %d", accessToken.ExpiresAt-accessToken.IssuedAt)
+				}
+			},
+		},
+		{
+			Name: "refresh_token",
+			Request: url.Values{
+				"grant_type":    {"refresh_token"},
+				"refresh_token": {refreshToken},
+				"client_id":     {"admin_client_id"},
+				"client_secret": {"secret for some-client"},
+			},
+			Code: http.StatusBadRequest,
+			Body: map[string]interface{}{
+				"error":             "invalid_grant",
+				"error_description": "refresh_token is disabled for this client",
+			},
+		},
+	})
+}
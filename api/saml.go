@@ -0,0 +1,276 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/macrat/lauth/errors"
+	"github.com/macrat/lauth/metrics"
+	"github.com/macrat/lauth/saml"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// SAML_RESPONSE_TYPE is the response_type of AuthzRequest that came from SAML Service Provider.
+	SAML_RESPONSE_TYPE = "saml"
+)
+
+// SAMLRequestUnmarshaller makes AuthzRequest from AuthnRequest of SAML.
+//
+// The ACS URL is used as redirect_uri, RelayState as state, and ID of the AuthnRequest as nonce.
+type SAMLRequestUnmarshaller struct {
+	SAMLRequest string `form:"SAMLRequest"`
+	RelayState  string `form:"RelayState"`
+
+	// Deflated is true when the request came with HTTP-Redirect binding.
+	Deflated bool `form:"-"`
+
+	request AuthzRequest
+}
+
+func (req *SAMLRequestUnmarshaller) GetRequest() *AuthzRequest {
+	return &req.request
+}
+
+func (req *SAMLRequestUnmarshaller) PreProcess(api *LauthAPI) *errors.Error {
+	req.request = AuthzRequest{
+		ResponseType: SAML_RESPONSE_TYPE,
+		State:        req.RelayState,
+	}
+
+	authn, err := saml.DecodeRequest(req.SAMLRequest, req.Deflated)
+	if err != nil {
+		return req.request.makeNonRedirectError(err, errors.InvalidRequest, "failed to decode SAML request")
+	}
+	req.request.Nonce = authn.ID
+
+	clientID, ok := api.Config.Clients.SAMLClient(authn.Issuer)
+	if !ok {
+		return req.request.makeNonRedirectError(nil, errors.InvalidClient, "service provider is not registered")
+	}
+	conf := api.Config.Clients[clientID].SAML
+
+	req.request.ClientID = clientID
+	req.request.Scope = strings.Join(api.Config.SAMLScopes(clientID), " ")
+
+	if authn.AssertionConsumerServiceURL != "" && authn.AssertionConsumerServiceURL != conf.ACSURL {
+		return req.request.makeNonRedirectError(nil, errors.UnauthorizedClient, "AssertionConsumerServiceURL is not registered")
+	}
+	req.request.RedirectURI = conf.ACSURL
+
+	if authn.ProtocolBinding != "" && authn.ProtocolBinding != saml.HTTPPostBinding {
+		return req.request.makeRedirectError(nil, errors.InvalidRequest, "only HTTP-POST binding is supported for response")
+	}
+
+	switch {
+	case authn.ForceAuthn && authn.IsPassive:
+		return req.request.makeRedirectError(nil, errors.InvalidRequest, "can't use both of ForceAuthn and IsPassive in same time")
+	case authn.ForceAuthn:
+		req.request.Prompt = "login"
+	case authn.IsPassive:
+		req.request.Prompt = "none"
+	}
+
+	return nil
+}
+
+// samlStatus converts the reason of error to status codes of SAML response.
+func samlStatus(reason errors.Reason) (status, subStatus string) {
+	switch reason {
+	case errors.LoginRequired, errors.InteractionRequired:
+		return saml.StatusResponder, saml.StatusNoPassive
+	case errors.AccessDenied:
+		return saml.StatusResponder, saml.StatusRequestDenied
+	case errors.ServerError, errors.TemporarilyUnavailable:
+		return saml.StatusResponder, ""
+	default:
+		return saml.StatusRequester, ""
+	}
+}
+
+// sendSAMLPost sends SAMLResponse to the ACS URL with HTTP-POST binding.
+func sendSAMLPost(c *gin.Context, acsURL string, response []byte, relayState string) {
+	c.HTML(http.StatusOK, "saml_post.tmpl", gin.H{
+		"url":         acsURL,
+		"response":    base64.StdEncoding.EncodeToString(response),
+		"relay_state": relayState,
+	})
+}
+
+// sendSAMLError sends the error to the service provider as SAML response.
+func (api *LauthAPI) sendSAMLError(c *gin.Context, req *AuthzRequest, e *errors.Error) {
+	status, subStatus := samlStatus(e.Reason)
+	resp := saml.MakeErrorResponse(
+		api.Config.Issuer.String(),
+		req.RedirectURI,
+		req.Nonce,
+		status,
+		subStatus,
+		e.Description,
+		time.Now(),
+	)
+	sendSAMLPost(c, req.RedirectURI, resp, req.State)
+}
+
+// samlAttributeValues converts a claim value to values of SAML attribute.
+func samlAttributeValues(value interface{}) []string {
+	if obj, ok := value.(map[string]interface{}); ok {
+		bs, _ := json.Marshal(obj)
+		return []string{string(bs)}
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice {
+		return []string{fmt.Sprint(value)}
+	}
+
+	values := make([]string, v.Len())
+	for i := range values {
+		values[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return values
+}
+
+// SendSAMLResponse sends the signed assertion of the user to the service provider.
+func (ctx *AuthzContext) SendSAMLResponse(subject string, authTime time.Time, authMethods []string) {
+	conf := ctx.API.Config.Clients[ctx.Request.ClientID].SAML
+
+	claims, e := ctx.API.userinfo(subject, ParseStringSet(ctx.Request.Scope))
+	if e != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(e.Err, e.Reason, e.Description))
+		return
+	}
+
+	nameID := subject
+	if conf.NameIDClaim != "" {
+		values := samlAttributeValues(claims[conf.NameIDClaim])
+		if claims[conf.NameIDClaim] == nil || len(values) == 0 || values[0] == "" {
+			ctx.ErrorRedirect(ctx.Request.makeRedirectError(nil, errors.ServerError, "user has no claim for NameID"))
+			return
+		}
+		nameID = values[0]
+	}
+
+	var names []string
+	for name := range claims {
+		if name != "sub" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var attributes []saml.Attribute
+	for _, name := range names {
+		attributes = append(attributes, saml.Attribute{
+			Name:   conf.AttributeName(name),
+			Values: samlAttributeValues(claims[name]),
+		})
+	}
+
+	cert, err := ctx.API.TokenManager.Certificate(ctx.API.Config.Issuer.Hostname())
+	if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to generate SAML response"))
+		return
+	}
+
+	resp, err := saml.MakeResponse(saml.Assertion{
+		Issuer:       ctx.API.Config.Issuer.String(),
+		Destination:  ctx.Request.RedirectURI,
+		Audience:     conf.EntityID,
+		InResponseTo: ctx.Request.Nonce,
+		NameID:       nameID,
+		NameIDFormat: conf.NameIDFormat,
+		AuthnInstant: authTime,
+		AuthMethods:  authMethods,
+		IssueInstant: time.Now(),
+		Expire:       ctx.API.Config.ExpireFor(ctx.Request.ClientID).Code.Duration(),
+		Attributes:   attributes,
+	}, ctx.API.TokenManager, cert)
+	if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to generate SAML response"))
+		return
+	}
+
+	ctx.Report.Success()
+	sendSAMLPost(ctx.Gin, ctx.Request.RedirectURI, resp, ctx.Request.State)
+}
+
+// SAMLSSO is the SingleSignOnService of SAML that supports HTTP-Redirect and HTTP-POST bindings.
+//
+// The login page and the consent page post to this endpoint too, so it is passed to PostAuthz if the request has the request object.
+func (api *LauthAPI) SAMLSSO(c *gin.Context) {
+	if c.Request.Method == "POST" && c.PostForm("request") != "" {
+		api.PostAuthz(c)
+		return
+	}
+
+	m := metrics.StartAuthz(c)
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	unmarshaller := &SAMLRequestUnmarshaller{Deflated: c.Request.Method == "GET"}
+	if err := c.ShouldBind(unmarshaller); err != nil || unmarshaller.SAMLRequest == "" {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.InvalidRequest,
+			Description: "SAMLRequest is required",
+		}
+		m.SetError(e)
+		m.Close()
+		errors.SendHTML(c, e)
+		return
+	}
+
+	ctx, e := newAuthzContext(api, c, m, unmarshaller)
+	if e != nil {
+		if e.RedirectURI != nil {
+			api.sendSAMLError(c, unmarshaller.GetRequest(), e)
+		} else {
+			errors.SendHTML(c, e)
+		}
+		return
+	}
+	defer ctx.Close()
+
+	if proceed := ctx.TrySSO(false); proceed {
+		return
+	}
+
+	ctx.ShowLoginPage(http.StatusOK, "", "")
+}
+
+// GetSAMLMetadata serves the metadata of SAML Identity Provider.
+func (api *LauthAPI) GetSAMLMetadata(c *gin.Context) {
+	report := metrics.StartLogging(c)
+	defer report.Close()
+
+	cert, err := api.TokenManager.Certificate(api.Config.Issuer.Hostname())
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to make certificate for SAML metadata")
+
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.ServerError,
+			Description: "failed to get key informations",
+		}
+		report.SetError(e)
+		errors.SendHTML(c, e)
+		return
+	}
+
+	sso := *api.Config.Issuer.URL()
+	sso.Path = api.Config.EndpointPaths().SAMLSSO
+
+	formats := []string{saml.NameIDFormatPersistent, saml.NameIDFormatEmail, saml.NameIDFormatUnspecified}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", saml.MakeMetadata(api.Config.Issuer.String(), sso.String(), cert, formats))
+}
@@ -0,0 +1,224 @@
+package api_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/macrat/lauth/saml"
+	"github.com/macrat/lauth/testutil"
+)
+
+func samlRequestXML(id, acsURL string, passive bool) string {
+	return fmt.Sprintf(
+		`<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="%s" Version="2.0" IssueInstant="2021-07-01T12:00:00Z" AssertionConsumerServiceURL="%s" IsPassive="%v"><saml:Issuer>https://saml-client.example.com</saml:Issuer></samlp:AuthnRequest>`,
+		id,
+		acsURL,
+		passive,
+	)
+}
+
+// makeSAMLRequest makes SAMLRequest parameter for HTTP-Redirect binding.
+func makeSAMLRequest(id, acsURL string, passive bool) string {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write([]byte(samlRequestXML(id, acsURL, passive)))
+	w.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+type samlResponse struct {
+	InResponseTo string `xml:"InResponseTo,attr"`
+	Status       struct {
+		Value      string `xml:"Value,attr"`
+		StatusCode struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"StatusCode"`
+	} `xml:"Status>StatusCode"`
+	NameID       string `xml:"Assertion>Subject>NameID"`
+	Audience     string `xml:"Assertion>Conditions>AudienceRestriction>Audience"`
+	AuthnContext string `xml:"Assertion>AuthnStatement>AuthnContext>AuthnContextClassRef"`
+	Attributes   []struct {
+		Name   string   `xml:"Name,attr"`
+		Values []string `xml:"AttributeValue"`
+	} `xml:"Assertion>AttributeStatement>Attribute"`
+}
+
+var (
+	samlFormPattern     = regexp.MustCompile(`<form method="POST" action="([^"]*)">`)
+	samlResponsePattern = regexp.MustCompile(`name="SAMLResponse" value="([^"]*)"`)
+	relayStatePattern   = regexp.MustCompile(`name="RelayState" value="([^"]*)"`)
+)
+
+// parseSAMLPost takes SAMLResponse and RelayState from the page of HTTP-POST binding.
+func parseSAMLPost(t *testing.T, resp *httptest.ResponseRecorder) (samlResponse, string) {
+	t.Helper()
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d: %s", resp.Code, resp.Body.String())
+	}
+
+	body := resp.Body.String()
+	form := samlFormPattern.FindStringSubmatch(body)
+	if form == nil || html.UnescapeString(form[1]) != "https://saml-client.example.com/acs" {
+		t.Fatalf("response is not posted to ACS URL: %s", body)
+	}
+
+	m := samlResponsePattern.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("SAMLResponse is not found: %s", body)
+	}
+	raw, err := base64.StdEncoding.DecodeString(html.UnescapeString(m[1]))
+	if err != nil {
+		t.Fatalf("failed to decode SAMLResponse: %s", err)
+	}
+
+	var r samlResponse
+	if err := xml.Unmarshal(raw, &r); err != nil {
+		t.Fatalf("failed to parse SAMLResponse: %s", err)
+	}
+
+	relayState := ""
+	if m := relayStatePattern.FindStringSubmatch(body); m != nil {
+		relayState = html.UnescapeString(m[1])
+	}
+
+	return r, relayState
+}
+
+func TestSAMLMetadata(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+
+	resp := env.Get("/saml/metadata", "", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", resp.Code)
+	}
+
+	var metadata struct {
+		EntityID string `xml:"entityID,attr"`
+	}
+	if err := xml.Unmarshal(resp.Body.Bytes(), &metadata); err != nil {
+		t.Fatalf("failed to parse metadata: %s", err)
+	}
+
+	if metadata.EntityID != env.API.Config.Issuer.String() {
+		t.Errorf("unexpected entity ID: %#v", metadata.EntityID)
+	}
+	if !strings.Contains(resp.Body.String(), `Location="`+env.API.Config.Issuer.String()+`/saml/sso"`) {
+		t.Errorf("unexpected SSO location: %s", resp.Body.String())
+	}
+}
+
+func TestSAMLSSO(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+
+	resp := env.Get("/saml/sso", "", url.Values{
+		"SAMLRequest": {makeSAMLRequest("_request1", "https://saml-client.example.com/acs", false)},
+		"RelayState":  {"relay-state"},
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d: %s", resp.Code, resp.Body.String())
+	}
+	request, err := testutil.FindRequestObjectByHTML(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to get request object: %s", err)
+	}
+
+	resp = env.Post("/saml/sso", "", url.Values{
+		"request":  {request},
+		"username": {"macrat"},
+		"password": {"foobar"},
+	})
+	r, relayState := parseSAMLPost(t, resp)
+
+	if r.Status.Value != saml.StatusSuccess {
+		t.Fatalf("unexpected status: %#v", r)
+	}
+	if r.InResponseTo != "_request1" || relayState != "relay-state" {
+		t.Errorf("response is not for the request: %#v %#v", r.InResponseTo, relayState)
+	}
+	if r.NameID != "macrat" || r.Audience != "https://saml-client.example.com" {
+		t.Errorf("unexpected assertion: %#v", r)
+	}
+	if r.AuthnContext != saml.AuthnContextPassword {
+		t.Errorf("unexpected authentication context: %#v", r.AuthnContext)
+	}
+
+	attrs := make(map[string][]string)
+	for _, a := range r.Attributes {
+		attrs[a.Name] = a.Values
+	}
+	if len(attrs["mail"]) != 1 || attrs["mail"][0] != "m@crat.jp" {
+		t.Errorf("email should be renamed to mail: %#v", attrs)
+	}
+	if len(attrs["name"]) != 1 || attrs["name"][0] != "SHIDA Yuuma" {
+		t.Errorf("unexpected name attribute: %#v", attrs)
+	}
+	if _, ok := attrs["phone_number"]; ok {
+		t.Errorf("attributes should be limited by allowed_scopes: %#v", attrs)
+	}
+
+	// login again with the SSO session and HTTP-POST binding.
+	req, _ := http.NewRequest("POST", "/saml/sso", strings.NewReader(url.Values{
+		"SAMLRequest": {base64.StdEncoding.EncodeToString([]byte(samlRequestXML("_request2", "", false)))},
+	}.Encode()))
+	req.RemoteAddr = "[::1]:54321"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range resp.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	r, _ = parseSAMLPost(t, env.DoRequest(req))
+	if r.Status.Value != saml.StatusSuccess || r.InResponseTo != "_request2" || r.NameID != "macrat" {
+		t.Errorf("failed to login with SSO session: %#v", r)
+	}
+}
+
+func TestSAMLSSO_passive(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+
+	resp := env.Get("/saml/sso", "", url.Values{
+		"SAMLRequest": {makeSAMLRequest("_request1", "https://saml-client.example.com/acs", true)},
+	})
+	r, _ := parseSAMLPost(t, resp)
+
+	if r.Status.Value != saml.StatusResponder || r.Status.StatusCode.Value != saml.StatusNoPassive {
+		t.Errorf("unexpected status: %#v", r)
+	}
+	if r.InResponseTo != "_request1" || r.NameID != "" {
+		t.Errorf("unexpected response: %#v", r)
+	}
+}
+
+func TestSAMLSSO_invalidRequest(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+
+	tests := []struct {
+		Name  string
+		Query url.Values
+	}{
+		{"no request", url.Values{}},
+		{"broken request", url.Values{"SAMLRequest": {"hello world"}}},
+		{"unknown ACS URL", url.Values{"SAMLRequest": {makeSAMLRequest("_request1", "https://evil.example.com/acs", false)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			resp := env.Get("/saml/sso", "", tt.Query)
+			if resp.Code != http.StatusBadRequest {
+				t.Errorf("unexpected status code: %d", resp.Code)
+			}
+			if strings.Contains(resp.Body.String(), "SAMLResponse") {
+				t.Errorf("invalid request should not be responded to the service provider")
+			}
+		})
+	}
+}
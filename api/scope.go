@@ -0,0 +1,26 @@
+package api
+
+import (
+	"fmt"
+)
+
+// allowedScope drops scopes that are not defined, and scopes that the client is not allowed to request.
+// The "openid" scope is always allowed, because the client can't get ID token without it.
+//
+// It returns an error if the request includes scopes but none of them are allowed.
+func (api *LauthAPI) allowedScope(clientID string, scope *StringSet) (*StringSet, error) {
+	allowed := StringSet(api.Config.Clients[clientID].AllowedScopes)
+
+	known := []string{"openid"}
+	for name := range api.Config.Scopes {
+		if len(allowed) == 0 || allowed.Has(name) {
+			known = append(known, name)
+		}
+	}
+
+	result := scope.Intersect(known)
+	if len(*scope) > 0 && len(*result) == 0 {
+		return nil, fmt.Errorf("requested scope is not allowed for this client")
+	}
+	return result, nil
+}
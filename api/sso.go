@@ -4,71 +4,70 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/macrat/lauth/token"
+	"github.com/macrat/lauth/session"
 )
 
 const (
-	SSO_TOKEN_COOKIE = "lauth_token"
+	SSO_SESSION_COOKIE = "lauth_session"
 )
 
-func (api *LauthAPI) SetSSOToken(c *gin.Context, subject, client string, authenticated bool) error {
-	authTime := time.Now()
-	expiresAt := time.Now().Add(api.Config.Expire.SSO.Duration())
-	azp := token.AuthorizedParties{client}
-
-	if current, err := api.GetSSOToken(c); err == nil {
-		if !authenticated {
-			authTime = time.Unix(current.AuthTime, 0)
-			expiresAt = time.Unix(current.ExpiresAt, 0)
-		}
-		azp = current.Authorized.Append(client)
-	}
-
-	token, err := api.TokenManager.CreateSSOToken(
-		api.Config.Issuer,
-		subject,
-		azp,
-		authTime,
-		expiresAt,
-	)
-	if err != nil {
-		return err
-	}
-
+func (api *LauthAPI) setSSOCookie(c *gin.Context, sessionID string, maxAge int) {
 	secure := api.Config.Issuer.Scheme == "https"
 	c.SetCookie(
-		SSO_TOKEN_COOKIE,
-		token,
-		int(api.Config.Expire.SSO.IntSeconds()),
+		SSO_SESSION_COOKIE,
+		sessionID,
+		maxAge,
 		"/",
 		api.Config.Issuer.Hostname(),
 		secure,
 		true,
 	)
-
-	return nil
 }
 
-func (api *LauthAPI) GetSSOToken(c *gin.Context) (token.SSOTokenClaims, error) {
-	rawToken, err := c.Cookie(SSO_TOKEN_COOKIE)
-	if err != nil {
-		return token.SSOTokenClaims{}, err
+func (api *LauthAPI) SetSSOSession(c *gin.Context, subject, username, client string, authMethods []string, authenticated bool) error {
+	current, err := api.GetSSOSession(c)
+
+	var sess session.Session
+	if err == nil && !authenticated && current.Subject == subject {
+		sess = current
+	} else {
+		if err == nil {
+			api.Sessions.Revoke(current.ID)
+		}
+
+		sess, err = session.New(subject, time.Now(), time.Now().Add(api.Config.ExpireFor(client).SSO.Duration()))
+		if err != nil {
+			return err
+		}
+		sess.Username = username
+		sess.AuthMethods = authMethods
+		sess.IPAddress = c.ClientIP()
+		sess.UserAgent = c.Request.UserAgent()
 	}
 
-	ssoToken, err := api.TokenManager.ParseSSOToken(rawToken)
-	if err != nil {
-		return token.SSOTokenClaims{}, err
+	sess.Authorize(client)
+
+	if err := api.Sessions.Save(sess); err != nil {
+		return err
 	}
 
-	err = ssoToken.Validate(api.Config.Issuer)
+	api.setSSOCookie(c, sess.ID, int(time.Until(sess.ExpiresAt).Seconds()))
+
+	return nil
+}
+
+func (api *LauthAPI) GetSSOSession(c *gin.Context) (session.Session, error) {
+	id, err := c.Cookie(SSO_SESSION_COOKIE)
 	if err != nil {
-		return token.SSOTokenClaims{}, err
+		return session.Session{}, err
 	}
 
-	return ssoToken, nil
+	return api.Sessions.Get(id)
 }
 
-func (api *LauthAPI) DeleteSSOToken(c *gin.Context) {
-	secure := api.Config.Issuer.Scheme == "https"
-	c.SetCookie(SSO_TOKEN_COOKIE, "", 0, "/", api.Config.Issuer.Hostname(), secure, true)
+func (api *LauthAPI) DeleteSSOSession(c *gin.Context) {
+	if id, err := c.Cookie(SSO_SESSION_COOKIE); err == nil {
+		api.Sessions.Revoke(id)
+	}
+	api.setSSOCookie(c, "", 0)
 }
@@ -58,3 +58,16 @@ func (ss StringSet) Validate(what string, accepts []string) error {
 	}
 	return nil
 }
+
+func (ss StringSet) Intersect(values []string) *StringSet {
+	var result StringSet
+	for _, x := range ss {
+		for _, y := range values {
+			if x == y {
+				result = append(result, x)
+				break
+			}
+		}
+	}
+	return &result
+}
@@ -44,4 +44,14 @@ func TestStringSet(t *testing.T) {
 	} else if err.Error() != "something \"foobar\" is not supported" {
 		t.Errorf("unexpected error causes: %s", err)
 	}
+
+	c := api.ParseStringSet("hello world foobar").Intersect([]string{"world", "foobar", "hogefuga"})
+	if c.String() != "foobar world" {
+		t.Errorf("unexpected intersection: %#v", c.String())
+	}
+
+	c = api.ParseStringSet("hello world").Intersect([]string{"foobar"})
+	if c.String() != "" {
+		t.Errorf("unexpected intersection: %#v", c.String())
+	}
 }
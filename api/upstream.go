@@ -0,0 +1,267 @@
+package api
+
+import (
+	stderrors "errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/macrat/lauth/errors"
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/metrics"
+	"github.com/macrat/lauth/upstream"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	UPSTREAM_STATE_COOKIE = "lauth_upstream"
+)
+
+func (api *LauthAPI) upstreamStore() upstream.Store {
+	return upstream.Store{Store: api.Store}
+}
+
+func (api *LauthAPI) upstreamCallbackURL() string {
+	u := *api.Config.Issuer.URL()
+	u.Path = api.Config.EndpointPaths().UpstreamCallback
+	return u.String()
+}
+
+// StartUpstreamLogin redirects the end-user to the upstream provider that chosen on the login page.
+func (ctx *AuthzContext) StartUpstreamLogin() {
+	ctx.Report.Set("authn_by", "upstream")
+	ctx.Report.Set("upstream", ctx.Request.Upstream)
+
+	provider, ok := ctx.API.Upstreams[ctx.Request.Upstream]
+	if !ok {
+		ctx.Report.UserError()
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(nil, errors.InvalidRequest, "unknown upstream provider"))
+		return
+	}
+
+	request, err := ctx.MakeRequestObject()
+	if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to create login session"))
+		return
+	}
+
+	expire := ctx.API.Config.Expire.Login.Duration()
+	login, state, err := ctx.API.upstreamStore().StartLogin(upstream.Login{
+		Upstream: ctx.Request.Upstream,
+		Request:  request,
+	}, expire)
+	if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to create login session"))
+		return
+	}
+
+	redirect, err := provider.AuthCodeURL(ctx.Gin.Request.Context(), ctx.API.upstreamCallbackURL(), state, login.Nonce)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("upstream", ctx.Request.Upstream).
+			Msg("failed to connecting upstream provider")
+
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.TemporarilyUnavailable, "failed to connecting upstream provider"))
+		return
+	}
+
+	// bind the state to the browser, so that an attacker can't make a victim login as the attacker.
+	ctx.Gin.SetCookie(
+		UPSTREAM_STATE_COOKIE,
+		state,
+		int(expire.Seconds()),
+		ctx.API.Config.EndpointPaths().UpstreamCallback,
+		ctx.API.Config.Issuer.Hostname(),
+		ctx.API.Config.Issuer.Scheme == "https",
+		true,
+	)
+
+	ctx.Report.Continue()
+	ctx.Gin.Redirect(http.StatusFound, redirect)
+}
+
+// GetUpstreamCallback receives the end-user who logged in with the upstream provider, and continues the authorization.
+func (api *LauthAPI) GetUpstreamCallback(c *gin.Context) {
+	m := metrics.StartAuthz(c)
+	m.Set("authn_by", "upstream")
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(UPSTREAM_STATE_COOKIE)
+	c.SetCookie(UPSTREAM_STATE_COOKIE, "", -1, api.Config.EndpointPaths().UpstreamCallback, api.Config.Issuer.Hostname(), api.Config.Issuer.Scheme == "https", true)
+
+	var login upstream.Login
+	var err error
+	if state == "" || state != cookie {
+		err = stderrors.New("state is mismatch with cookie")
+	} else {
+		login, err = api.upstreamStore().FinishLogin(state)
+	}
+	if err != nil {
+		e := &errors.Error{
+			Err:         err,
+			Reason:      errors.InvalidRequest,
+			Description: "login session is invalid or timed out",
+		}
+		m.SetError(e)
+		m.Close()
+		errors.SendHTML(c, e)
+		return
+	}
+	m.Set("upstream", login.Upstream)
+
+	ctx, e := newAuthzContext(api, c, m, &PostAuthzRequestUnmarshaller{Request: login.Request})
+	if e != nil {
+		errors.SendRedirect(c, e)
+		return
+	}
+	defer ctx.Close()
+
+	if ctx.Request.RequestSubject != c.ClientIP() {
+		ctx.ErrorRedirect(ctx.Request.makeNonRedirectError(nil, errors.AccessDenied, "incorrect login session"))
+		return
+	}
+
+	if reason := c.Query("error"); reason != "" {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(nil, errors.AccessDenied, "upstream provider returned error: "+reason))
+		return
+	}
+
+	provider, ok := api.Upstreams[login.Upstream]
+	if !ok {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(nil, errors.ServerError, "unknown upstream provider"))
+		return
+	}
+
+	claims, err := provider.Exchange(c.Request.Context(), api.upstreamCallbackURL(), c.Query("code"), login.Nonce)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("upstream", login.Upstream).
+			Msg("failed to verify login with upstream provider")
+
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.AccessDenied, "failed to verify login with upstream provider"))
+		return
+	}
+
+	id, ok := ctx.upstreamIdentity(provider, claims)
+	if !ok {
+		return
+	}
+
+	ctx.Report.Set("username", id.Username)
+
+	if !ctx.CheckAccess(id.Subject) || !ctx.StartUpstreamMFA(id) {
+		return
+	}
+
+	if api.Config.ExpireFor(ctx.Request.ClientID).SSO > 0 {
+		api.SetSSOSession(c, id.Subject, id.Username, ctx.Request.ClientID, nil, true)
+	}
+	ctx.RememberConsent(id.Subject)
+
+	ctx.SendTokens(id.Subject, time.Now(), nil)
+}
+
+// linkable reports the claim can be used to link the user.
+// The email claim is used only if the upstream says it is verified, because users can set any address on some providers.
+func linkable(claim string, attrs map[string][]string) bool {
+	if claim != "email" {
+		return true
+	}
+	for _, v := range attrs["email_verified"] {
+		if v == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// StartUpstreamMFA shows the page of the second factor to the user who logged in with the upstream provider, if needed.
+//
+// The second factor of the upstream is not trusted, so users and clients that require the second factor have to pass it on Lauth too.
+// It returns true if the user doesn't need the second factor.
+func (ctx *AuthzContext) StartUpstreamMFA(id ldap.Identity) bool {
+	if !ctx.API.Config.MFA.Enabled() && !ctx.API.Config.WebAuthn.Enabled {
+		return true
+	}
+
+	conn, reason, err := ctx.API.connectLDAP()
+	if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, reason, "failed to connecting LDAP server"))
+		return false
+	}
+	defer conn.Close()
+
+	ctx.Request.MFAUpstream = true
+	return ctx.StartWebAuthn(conn, id) && ctx.StartMFA(conn, id)
+}
+
+// upstreamIdentity decides the identity of the user who logged in with the upstream provider.
+//
+// The user is linked to LDAP account if configured. Otherwise, attributes of the user are saved for issuing tokens.
+// It responds error and returns false if failed.
+func (ctx *AuthzContext) upstreamIdentity(provider *upstream.Provider, claims map[string]interface{}) (ldap.Identity, bool) {
+	conf := provider.Config
+	attrs := upstream.Attributes(claims)
+
+	if len(attrs["sub"]) == 0 || attrs["sub"][0] == "" {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(nil, errors.AccessDenied, "upstream provider returned no subject"))
+		return ldap.Identity{}, false
+	}
+
+	if conf.Link.Enabled() {
+		if values := attrs[conf.Link.Claim]; len(values) > 0 && linkable(conf.Link.Claim, attrs) {
+			conn, reason, err := ctx.API.connectLDAP()
+			if err != nil {
+				ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, reason, "failed to connecting LDAP server"))
+				return ldap.Identity{}, false
+			}
+			defer conn.Close()
+
+			id, err := ldap.FindUser(conn, conf.Link.Attribute, values[0])
+			if err == nil {
+				return id, true
+			} else if !stderrors.Is(err, ldap.UserNotFoundError) {
+				ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, unavailableOr(err, errors.ServerError), "failed to search linked user"))
+				return ldap.Identity{}, false
+			}
+		}
+
+		if conf.Link.Required {
+			ctx.Report.Denied()
+			ctx.ErrorRedirect(ctx.Request.makeNonRedirectError(nil, errors.AccessDenied, "user is not linked to any account"))
+			return ldap.Identity{}, false
+		}
+	}
+
+	id := ldap.Identity{
+		Subject:  conf.Subject(attrs["sub"][0]),
+		Username: attrs["sub"][0],
+	}
+	for _, claim := range []string{"preferred_username", "email"} {
+		if vs := attrs[claim]; len(vs) > 0 && vs[0] != "" {
+			id.Username = vs[0]
+			break
+		}
+	}
+
+	// attributes are kept while tokens could be refreshed or the SSO session is alive.
+	expire := ctx.API.Config.ExpireFor(ctx.Request.ClientID)
+	ttl := expire.Token
+	if expire.Refresh > ttl {
+		ttl = expire.Refresh
+	}
+	if expire.SSO > ttl {
+		ttl = expire.SSO
+	}
+	if err := ctx.API.upstreamStore().SaveUser(id.Subject, attrs, ttl.Duration()); err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to save user"))
+		return ldap.Identity{}, false
+	}
+
+	return id, true
+}
@@ -0,0 +1,360 @@
+package api_test
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/api"
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/mfa"
+	"github.com/macrat/lauth/testutil"
+	"github.com/macrat/lauth/token"
+	"github.com/macrat/lauth/upstream"
+)
+
+// fakeUpstream is an upstream OpenID Provider that issues ID token for the code.
+// The code is used as the nonce of the ID token.
+type fakeUpstream struct {
+	Server *httptest.Server
+	Issuer *config.URL
+	Claims token.ExtraClaims
+}
+
+func newFakeUpstream(t *testing.T) *fakeUpstream {
+	t.Helper()
+
+	tokens, err := testutil.MakeTokenManager()
+	if err != nil {
+		t.Fatalf("failed to make token manager: %s", err)
+	}
+
+	f := &fakeUpstream{Claims: token.ExtraClaims{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                f.Issuer.String(),
+			"authorization_endpoint":                f.Issuer.String() + "/authz",
+			"token_endpoint":                        f.Issuer.String() + "/token",
+			"jwks_uri":                              f.Issuer.String() + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		keys, _ := tokens.JWKs(f.Issuer.Hostname())
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idToken, err := tokens.CreateIDToken(f.Issuer, "ext-user", "upstream_client", r.Form.Get("code"), "", "", f.Claims, time.Now(), nil, time.Hour)
+		if err != nil {
+			t.Errorf("failed to create id_token: %s", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "upstream-access-token",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Server.Close)
+
+	f.Issuer = &config.URL{}
+	if err := f.Issuer.Set(f.Server.URL); err != nil {
+		t.Fatalf("failed to parse URL: %s", err)
+	}
+
+	return f
+}
+
+func setupUpstream(t *testing.T, link config.UpstreamLinkConfig) (*testutil.APITestEnvironment, *fakeUpstream) {
+	t.Helper()
+
+	fake := newFakeUpstream(t)
+
+	env := testutil.NewAPITestEnvironment(t)
+	env.API.Config.Upstreams = []config.UpstreamConfig{
+		{
+			Name:          "partner",
+			DisplayName:   "Partner IdP",
+			Issuer:        fake.Issuer,
+			ClientID:      "upstream_client",
+			ClientSecret:  "upstream_secret",
+			RequestScopes: config.DefaultUpstreamRequestScopes,
+			Link:          link,
+		},
+	}
+	env.API.Upstreams = upstream.NewProviders(env.API.Config.Upstreams)
+	env.App.GET(env.API.Config.EndpointPaths().UpstreamCallback, env.API.GetUpstreamCallback)
+	env.App.POST(env.API.Config.EndpointPaths().UpstreamCallback, env.API.PostAuthz)
+
+	return env, fake
+}
+
+// loginWithUpstream goes to the upstream from the login form, and comes back to the callback.
+func loginWithUpstream(t *testing.T, env *testutil.APITestEnvironment, fake *fakeUpstream) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request, err := env.API.TokenManager.CreateRequestObject(
+		env.API.Config.Issuer,
+		"::1",
+		token.RequestObjectClaims{
+			ClientID:     "implicit_client_id",
+			RedirectURI:  "http://implicit-client.example.com/callback",
+			ResponseType: "id_token",
+			Scope:        "openid profile email",
+			Nonce:        "client-nonce",
+		},
+		time.Now().Add(10*time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("failed to make request: %s", err)
+	}
+
+	resp := env.Post("/authz", "", url.Values{"request": {request}, "upstream": {"partner"}})
+	if resp.Code != http.StatusFound {
+		t.Fatalf("unexpected status code: %d: %s", resp.Code, resp.Body.String())
+	}
+
+	location, _ := url.Parse(resp.Header().Get("Location"))
+	if !strings.HasPrefix(location.String(), fake.Issuer.String()+"/authz?") {
+		t.Fatalf("unexpected redirect: %s", location)
+	}
+	query := location.Query()
+	if query.Get("client_id") != "upstream_client" || query.Get("redirect_uri") != env.API.Config.Issuer.String()+"/authz/upstream" {
+		t.Errorf("unexpected authorization request: %s", location)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range resp.Result().Cookies() {
+		if c.Name == "lauth_upstream" {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value != query.Get("state") {
+		t.Fatalf("state cookie is not set: %#v", cookie)
+	}
+
+	r, _ := http.NewRequest("GET", "/authz/upstream?"+url.Values{
+		"state": {query.Get("state")},
+		"code":  {query.Get("nonce")},
+	}.Encode(), nil)
+	r.RemoteAddr = "[::1]:54321"
+	r.AddCookie(cookie)
+	return env.DoRequest(r)
+}
+
+func TestUpstreamLogin(t *testing.T) {
+	env, fake := setupUpstream(t, config.UpstreamLinkConfig{})
+	fake.Claims["name"] = "Contractor"
+	fake.Claims["email"] = "contractor@partner.example.com"
+
+	resp := env.Get("/authz", "", url.Values{
+		"response_type": {"code"},
+		"client_id":     {"some_client_id"},
+		"redirect_uri":  {"http://some-client.example.com/callback"},
+	})
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "Login with Partner IdP") {
+		t.Errorf("login page has no button for upstream: %d", resp.Code)
+	}
+
+	resp = loginWithUpstream(t, env, fake)
+	if resp.Code != http.StatusFound {
+		t.Fatalf("unexpected status code: %d: %s", resp.Code, resp.Body.String())
+	}
+
+	location, _ := url.Parse(resp.Header().Get("Location"))
+	fragment, _ := url.ParseQuery(location.Fragment)
+	if fragment.Get("error") != "" {
+		t.Fatalf("unexpected error: %s", location)
+	}
+
+	idToken, err := env.API.TokenManager.ParseIDToken(fragment.Get("id_token"))
+	if err != nil {
+		t.Fatalf("failed to parse id_token: %s", err)
+	}
+	if idToken.Subject != "partner:ext-user" {
+		t.Errorf("unexpected subject: %#v", idToken.Subject)
+	}
+	if idToken.Nonce != "client-nonce" {
+		t.Errorf("unexpected nonce: %#v", idToken.Nonce)
+	}
+	if idToken.ExtraClaims["name"] != "Contractor" || idToken.ExtraClaims["email"] != "contractor@partner.example.com" {
+		t.Errorf("unexpected claims: %#v", idToken.ExtraClaims)
+	}
+
+	accessToken, err := env.API.TokenManager.CreateAccessToken(env.API.Config.Issuer, "partner:ext-user", "some_client_id", "openid email", time.Now(), time.Hour)
+	if err != nil {
+		t.Fatalf("failed to make access token: %s", err)
+	}
+	resp = env.Get("/userinfo", "Bearer "+accessToken, nil)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"email":"contractor@partner.example.com"`) {
+		t.Errorf("unexpected userinfo: %d %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestUpstreamLogin_link(t *testing.T) {
+	env, fake := setupUpstream(t, config.UpstreamLinkConfig{Claim: "email", Attribute: "mail"})
+	fake.Claims["email"] = "m@crat.jp"
+
+	resp := loginWithUpstream(t, env, fake)
+	location, _ := url.Parse(resp.Header().Get("Location"))
+	fragment, _ := url.ParseQuery(location.Fragment)
+	if idToken, err := env.API.TokenManager.ParseIDToken(fragment.Get("id_token")); err != nil || idToken.Subject != "partner:ext-user" {
+		t.Errorf("user should not be linked by unverified email: %v %s", err, location)
+	}
+
+	fake.Claims["email_verified"] = true
+	resp = loginWithUpstream(t, env, fake)
+	location, _ = url.Parse(resp.Header().Get("Location"))
+	fragment, _ = url.ParseQuery(location.Fragment)
+
+	idToken, err := env.API.TokenManager.ParseIDToken(fragment.Get("id_token"))
+	if err != nil {
+		t.Fatalf("failed to parse id_token: %s: %s", err, location)
+	}
+	if idToken.Subject != "macrat" {
+		t.Errorf("user should be linked to LDAP account: %#v", idToken.Subject)
+	}
+	if idToken.ExtraClaims["name"] != "SHIDA Yuuma" {
+		t.Errorf("claims should be made from LDAP: %#v", idToken.ExtraClaims)
+	}
+
+	fake.Claims["email"] = "unknown@partner.example.com"
+	resp = loginWithUpstream(t, env, fake)
+	location, _ = url.Parse(resp.Header().Get("Location"))
+	fragment, _ = url.ParseQuery(location.Fragment)
+	if idToken, err := env.API.TokenManager.ParseIDToken(fragment.Get("id_token")); err != nil || idToken.Subject != "partner:ext-user" {
+		t.Errorf("unlinked user should login as upstream user: %v %s", err, location)
+	}
+
+	env.API.Config.Upstreams[0].Link.Required = true
+	resp = loginWithUpstream(t, env, fake)
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "user is not linked to any account") {
+		t.Errorf("unlinked user should be denied: %d %s", resp.Code, resp.Header().Get("Location"))
+	}
+}
+
+func TestUpstreamLogin_linkPooled(t *testing.T) {
+	env, fake := setupUpstream(t, config.UpstreamLinkConfig{Claim: "email", Attribute: "mail", Required: true})
+	fake.Claims["email"] = "m@crat.jp"
+	fake.Claims["email_verified"] = true
+
+	pool := ldap.NewPooledConnector(testutil.LDAP, 0, 1, time.Hour)
+	defer pool.Close()
+	env.API.Connector = pool
+
+	resp := loginWithUpstream(t, env, fake)
+	location, _ := url.Parse(resp.Header().Get("Location"))
+	fragment, _ := url.ParseQuery(location.Fragment)
+
+	idToken, err := env.API.TokenManager.ParseIDToken(fragment.Get("id_token"))
+	if err != nil {
+		t.Fatalf("failed to parse id_token: %s: %s", err, location)
+	}
+	if idToken.Subject != "macrat" {
+		t.Errorf("user should be linked to LDAP account via pooled session: %#v", idToken.Subject)
+	}
+}
+
+func TestUpstreamLogin_accessFilter(t *testing.T) {
+	env, fake := setupUpstream(t, config.UpstreamLinkConfig{Claim: "email", Attribute: "mail"})
+	fake.Claims["email"] = "m@crat.jp"
+	fake.Claims["email_verified"] = true
+
+	client := env.API.Config.Clients["implicit_client_id"]
+	client.RequiredGroups = []string{"CN=admin,OU=group,DC=example,DC=local"}
+	env.API.Config.Clients["implicit_client_id"] = client
+
+	resp := loginWithUpstream(t, env, fake)
+	location, _ := url.Parse(resp.Header().Get("Location"))
+	fragment, _ := url.ParseQuery(location.Fragment)
+	if idToken, err := env.API.TokenManager.ParseIDToken(fragment.Get("id_token")); err != nil || idToken.Subject != "macrat" {
+		t.Errorf("linked user should be checked with LDAP: %v %s", err, location)
+	}
+
+	fake.Claims["email"] = "unknown@partner.example.com"
+	fake.Claims["memberOf"] = []interface{}{"CN=admin,OU=group,DC=example,DC=local"}
+	resp = loginWithUpstream(t, env, fake)
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "user is not allowed to use this client") {
+		t.Errorf("unlinked user should be denied even if the upstream claims groups: %d %s", resp.Code, resp.Header().Get("Location"))
+	}
+}
+
+func TestUpstreamLogin_mfa(t *testing.T) {
+	env, fake := setupUpstream(t, config.UpstreamLinkConfig{Claim: "email", Attribute: "mail"})
+	env.API.Config.MFA = config.MFAConfig{TOTPAttribute: "totpSecret", Enroll: true, MaxAttempts: 5}
+
+	client := env.API.Config.Clients["implicit_client_id"]
+	client.RequireMFA = true
+	env.API.Config.Clients["implicit_client_id"] = client
+
+	fake.Claims["email"] = "m@crat.jp"
+	fake.Claims["email_verified"] = true
+
+	page := loginWithUpstream(t, env, fake)
+	m := totpSecretPattern.FindStringSubmatch(page.Body.String())
+	if m == nil {
+		t.Fatalf("linked user should be asked to enroll TOTP: %d: %s", page.Code, page.Header().Get("Location"))
+	}
+	secret, err := mfa.ParseSecret(html.UnescapeString(m[1]))
+	if err != nil {
+		t.Fatalf("failed to parse secret: %s", err)
+	}
+
+	idToken := parseIDTokenInRedirect(t, env, postOTP(t, env, page, mfa.Code(secret, time.Now())))
+	if idToken.Subject != "macrat" || !reflect.DeepEqual(idToken.AuthMethods, []string{"otp"}) {
+		t.Errorf("unexpected id_token: %#v %#v", idToken.Subject, idToken.AuthMethods)
+	}
+
+	// SSO sessions that made by upstream providers without the second factor are not enough.
+	client = env.API.Config.Clients["some_client_id"]
+	client.RequireMFA = true
+	env.API.Config.Clients["some_client_id"] = client
+
+	sessionID := env.MakeSSOSession(t, "macrat", []string{"some_client_id"}, time.Now(), time.Now().Add(time.Hour))
+	req, _ := http.NewRequest("GET", "/authz?"+url.Values{
+		"response_type": {"code"},
+		"client_id":     {"some_client_id"},
+		"redirect_uri":  {"http://some-client.example.com/callback"},
+		"prompt":        {"none"},
+	}.Encode(), nil)
+	req.AddCookie(&http.Cookie{Name: api.SSO_SESSION_COOKIE, Value: sessionID})
+	resp := env.DoRequest(req)
+	if location, _ := url.Parse(resp.Header().Get("Location")); resp.Code != http.StatusFound || location.Query().Get("error") != "login_required" {
+		t.Errorf("SSO session without the second factor should not be used: %d %s", resp.Code, resp.Header().Get("Location"))
+	}
+
+	// the secret in claims of the upstream must not be used, because the upstream can set any value.
+	fake.Claims["email"] = "unknown@partner.example.com"
+	fake.Claims["totpSecret"] = mfaTestSecret
+	page = loginWithUpstream(t, env, fake)
+	if totpSecretPattern.FindStringSubmatch(page.Body.String()) == nil {
+		t.Errorf("unlinked user should be asked to enroll TOTP: %d: %s", page.Code, page.Header().Get("Location"))
+	}
+}
+
+func TestUpstreamLogin_invalidState(t *testing.T) {
+	env, _ := setupUpstream(t, config.UpstreamLinkConfig{})
+
+	r, _ := http.NewRequest("GET", "/authz/upstream?state=foo&code=bar", nil)
+	r.RemoteAddr = "[::1]:54321"
+	r.AddCookie(&http.Cookie{Name: "lauth_upstream", Value: "foo"})
+	if resp := env.DoRequest(r); resp.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status code: %d", resp.Code)
+	}
+
+	r, _ = http.NewRequest("GET", "/authz/upstream?state=foo&code=bar", nil)
+	r.RemoteAddr = "[::1]:54321"
+	if resp := env.DoRequest(r); resp.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status code: %d", resp.Code)
+	}
+}
@@ -7,37 +7,54 @@ import (
 	"github.com/macrat/lauth/config"
 	"github.com/macrat/lauth/errors"
 	"github.com/macrat/lauth/metrics"
-	"github.com/rs/zerolog/log"
 )
 
 func (api *LauthAPI) userinfo(subject string, scope *StringSet) (map[string]interface{}, *errors.Error) {
-	conn, err := api.Connector.Connect()
+	conn, reason, err := api.connectLDAP()
 	if err != nil {
-		log.Error().
-			Err(err).
-			Msg("failed to connecting LDAP server")
-
 		return nil, &errors.Error{
 			Err:         err,
-			Reason:      errors.ServerError,
+			Reason:      reason,
 			Description: "failed to get user info",
 		}
 	}
 	defer conn.Close()
 
-	attrs, err := conn.GetUserAttributes(subject, api.Config.Scopes.AttributesFor(scope.List()))
+	dir, _, ok := api.Config.DirectoryFor(subject)
+	if !ok {
+		return nil, &errors.Error{
+			Reason:      errors.InvalidToken,
+			Description: "user was not found or disabled",
+		}
+	}
+
+	attributes := dir.Scopes.AttributesFor(scope.List())
+
+	// the login name is not the subject if using subject attribute or directories, so tell it as preferred_username.
+	preferredUsername := (dir.LDAP.SubjectAttribute != "" || dir.Name != "") && scope.Has("profile")
+	if preferredUsername {
+		attributes = append(attributes, dir.LDAP.IDAttribute)
+	}
+
+	attrs, err := conn.GetUserAttributes(subject, attributes)
 	if err != nil {
 		return nil, &errors.Error{
 			Err:         err,
-			Reason:      errors.InvalidToken,
+			Reason:      unavailableOr(err, errors.InvalidToken),
 			Description: "user was not found or disabled",
 		}
 	}
 
-	maps := api.Config.Scopes.ClaimMapFor(scope.List())
+	maps := dir.Scopes.ClaimMapFor(scope.List())
 	result := config.MappingClaims(attrs, maps)
 	result["sub"] = subject
 
+	if _, ok := result["preferred_username"]; preferredUsername && !ok {
+		if vs := attrs[dir.LDAP.IDAttribute]; len(vs) > 0 {
+			result["preferred_username"] = vs[0]
+		}
+	}
+
 	return result, nil
 }
 
@@ -0,0 +1,422 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"time"
+
+	"github.com/macrat/lauth/errors"
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/webauthn"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// SecurityKeyAuthMethods is the amr claim for users who logged in with the password and a security key.
+	SecurityKeyAuthMethods = []string{"pwd", "hwk", "user"}
+
+	// PasskeyAuthMethods is the amr claim for users who logged in with a passkey without the password.
+	PasskeyAuthMethods = []string{"hwk", "user"}
+)
+
+func (api *LauthAPI) webauthnUserVerification() string {
+	if uv := api.Config.WebAuthn.UserVerification; uv != "" {
+		return uv
+	}
+	return webauthn.UserVerificationPreferred
+}
+
+func (ctx *AuthzContext) webauthnCeremony(userVerification string) webauthn.Ceremony {
+	return webauthn.Ceremony{
+		RPID:             ctx.API.Config.WebAuthnRPID(),
+		Origin:           ctx.API.Config.WebAuthnOrigin(),
+		Challenge:        ctx.Request.WebAuthnChallenge,
+		UserVerification: userVerification,
+	}
+}
+
+// webauthnOptions makes the options of navigator.credentials for the page, or returns nil if the page doesn't use WebAuthn.
+func (ctx *AuthzContext) webauthnOptions() (interface{}, error) {
+	if ctx.Request.WebAuthnChallenge == "" {
+		return nil, nil
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(ctx.Request.WebAuthnChallenge)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ctx.Request.MFAMethod {
+	case "":
+		// passwordless login; any passkey of any user can be used.
+		return webauthn.RequestOptions{
+			Challenge:        challenge,
+			RPID:             ctx.API.Config.WebAuthnRPID(),
+			UserVerification: webauthn.UserVerificationRequired,
+		}, nil
+
+	case MFA_METHOD_WEBAUTHN:
+		creds, err := ctx.API.Credentials.Credentials(ctx.Request.MFASubject)
+		if err != nil {
+			return nil, err
+		}
+		return webauthn.RequestOptions{
+			Challenge:        challenge,
+			RPID:             ctx.API.Config.WebAuthnRPID(),
+			AllowCredentials: webauthn.Descriptors(creds),
+			UserVerification: ctx.API.webauthnUserVerification(),
+		}, nil
+
+	case MFA_METHOD_WEBAUTHN_REGISTER:
+		creds, err := ctx.API.Credentials.Credentials(ctx.Request.MFASubject)
+		if err != nil {
+			return nil, err
+		}
+
+		params := make([]webauthn.CredentialParameter, len(webauthn.SupportedAlgorithms))
+		for i, alg := range webauthn.SupportedAlgorithms {
+			params[i] = webauthn.CredentialParameter{Type: "public-key", Alg: alg}
+		}
+
+		return webauthn.CreationOptions{
+			RP: webauthn.RelyingParty{
+				ID:   ctx.API.Config.WebAuthnRPID(),
+				Name: ctx.API.Config.Issuer.Hostname(),
+			},
+			User: webauthn.User{
+				ID:          webauthn.UserHandle(ctx.Request.MFASubject),
+				Name:        ctx.Request.MFAUsername,
+				DisplayName: ctx.Request.MFAUsername,
+			},
+			Challenge:          challenge,
+			PubKeyCredParams:   params,
+			ExcludeCredentials: webauthn.Descriptors(creds),
+			AuthenticatorSelection: webauthn.AuthenticatorSelection{
+				ResidentKey:      "preferred",
+				UserVerification: ctx.API.webauthnUserVerification(),
+			},
+			Attestation: "none",
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// webauthnRequired reports the user has to use a security key.
+//
+// Clients that require the second factor also require it if TOTP is not enabled.
+func (ctx *AuthzContext) webauthnRequired(conn ldap.Session, subject string) (bool, error) {
+	if ctx.API.Config.Clients[ctx.Request.ClientID].RequireMFA && !ctx.API.Config.MFA.Enabled() {
+		return true, nil
+	}
+	if filter := ctx.API.Config.WebAuthn.RequiredFilter(); filter != "" {
+		return conn.MatchFilter(subject, filter)
+	}
+	return false, nil
+}
+
+// needWebAuthn reports the user has to pass a security key, and returns the credentials of the user.
+// The credentials are empty if the user has to register.
+func (ctx *AuthzContext) needWebAuthn(conn ldap.Session, subject string) (need bool, creds []webauthn.Credential, err error) {
+	if !ctx.API.Config.WebAuthn.Enabled {
+		return false, nil, nil
+	}
+
+	creds, err = ctx.API.Credentials.Credentials(subject)
+	if err != nil || len(creds) > 0 {
+		return err == nil, creds, err
+	}
+
+	need, err = ctx.webauthnRequired(conn, subject)
+	return need, nil, err
+}
+
+// StartWebAuthn shows the page of the security key to the user who passed the password, if needed.
+//
+// Users who registered a credential are asked it, and users who have to use a security key or chose to register a passkey are asked to register.
+// Users who have to pass TOTP are asked to register after TOTP.
+// It returns true if the user doesn't need the security key now.
+// Otherwise, it responds the page or an error, and returns false.
+func (ctx *AuthzContext) StartWebAuthn(conn ldap.Session, id ldap.Identity) bool {
+	need, creds, err := ctx.needWebAuthn(conn, id.Subject)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to check WebAuthn requirement")
+
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, unavailableOr(err, errors.ServerError), "failed to check two-factor authentication"))
+		return false
+	}
+
+	if len(creds) > 0 {
+		ctx.Request.MFAMethod = MFA_METHOD_WEBAUTHN
+	} else {
+		enroll := ctx.API.Config.WebAuthn.Enabled && ctx.API.Config.WebAuthn.Enroll
+		if !need && !(ctx.Request.RegisterPasskey && enroll) {
+			return true
+		}
+		if !enroll {
+			ctx.Report.Denied()
+			ctx.ErrorRedirect(ctx.Request.makeNonRedirectError(nil, errors.AccessDenied, "security key is required but not registered for the user"))
+			return false
+		}
+
+		// registering a passkey with only the password would be a way to skip TOTP.
+		needTOTP, _, err := ctx.needMFA(conn, id.Subject)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Msg("failed to check MFA requirement")
+
+			ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, unavailableOr(err, errors.ServerError), "failed to check two-factor authentication"))
+			return false
+		}
+		if needTOTP {
+			ctx.Request.RegisterPasskey = true
+			return true
+		}
+
+		ctx.Request.MFAMethod = MFA_METHOD_WEBAUTHN_REGISTER
+	}
+
+	ctx.Request.MFASubject = id.Subject
+	ctx.Request.MFAUsername = id.Username
+	ctx.ShowWebAuthnPage(http.StatusOK, "")
+	return false
+}
+
+// useWebAuthnChallenge consumes the challenge in the request object, and reports it was not used before.
+func (ctx *AuthzContext) useWebAuthnChallenge() (bool, error) {
+	return webauthn.UseChallenge(ctx.API.Store, ctx.Request.WebAuthnChallenge, ctx.API.Config.Expire.Login.Duration())
+}
+
+// failWebAuthn responds the page again for the user who sent an invalid response of the authenticator.
+func (ctx *AuthzContext) failWebAuthn(err error, description string) {
+	ctx.Report.UserError()
+	RandomDelay()
+	ctx.Report.SetError(ctx.Request.makeRedirectError(err, errors.InvalidRequest, description))
+
+	if ctx.Request.MFASubject == "" {
+		ctx.ShowLoginPage(http.StatusForbidden, "", description)
+	} else {
+		ctx.ShowWebAuthnPage(http.StatusForbidden, description)
+	}
+}
+
+// verifyAssertion checks the response of navigator.credentials.get() for the credential, and saves the updated credential.
+// It responds the page or an error, and returns false if failed.
+func (ctx *AuthzContext) verifyAssertion(resp webauthn.AssertionResponse, subject string, cred webauthn.Credential, userVerification string) bool {
+	updated, _, err := ctx.webauthnCeremony(userVerification).VerifyAssertion(resp, cred)
+	if err != nil {
+		ctx.failAssertion(err, subject, cred)
+		return false
+	}
+
+	if ok, err := ctx.useWebAuthnChallenge(); err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to check two-factor authentication"))
+		return false
+	} else if !ok {
+		ctx.failWebAuthn(nil, "response of security key is already used")
+		return false
+	}
+
+	if err := ctx.API.Credentials.Save(subject, updated); err == webauthn.ClonedAuthenticatorError {
+		ctx.failAssertion(err, subject, cred)
+		return false
+	} else if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to update security key"))
+		return false
+	}
+	return true
+}
+
+// failAssertion responds the error of the assertion, and warns if the security key may be cloned.
+func (ctx *AuthzContext) failAssertion(err error, subject string, cred webauthn.Credential) {
+	if err == webauthn.ClonedAuthenticatorError {
+		log.Warn().
+			Str("subject", subject).
+			Str("credential", cred.ID.String()).
+			Msg("signature counter of the security key is not increased; it may be cloned")
+	}
+	ctx.failWebAuthn(err, "invalid response of security key")
+}
+
+// completeWebAuthn makes the SSO session and sends tokens to the user who passed the security key.
+func (ctx *AuthzContext) completeWebAuthn(subject, username string, authMethods []string) {
+	authMethods = ctx.secondFactorAuthMethods(authMethods)
+
+	if ctx.API.Config.ExpireFor(ctx.Request.ClientID).SSO > 0 {
+		ctx.API.SetSSOSession(ctx.Gin, subject, username, ctx.Request.ClientID, authMethods, true)
+	}
+	ctx.RememberConsent(subject)
+
+	ctx.SendTokens(subject, time.Now(), authMethods)
+}
+
+// VerifyWebAuthn checks the security key of the user who passed the password, and sends tokens if correct.
+func (ctx *AuthzContext) VerifyWebAuthn() {
+	ctx.Report.Set("authn_by", "password")
+	ctx.Report.Set("username", ctx.Request.MFAUsername)
+
+	subject := ctx.Request.MFASubject
+
+	var resp webauthn.AssertionResponse
+	if ctx.Request.WebAuthn == "" {
+		ctx.Report.UserError()
+		ctx.ShowWebAuthnPage(http.StatusForbidden, "missing response of security key")
+		return
+	} else if err := json.Unmarshal([]byte(ctx.Request.WebAuthn), &resp); err != nil {
+		ctx.failWebAuthn(err, "invalid response of security key")
+		return
+	}
+
+	owner, cred, err := ctx.API.Credentials.Find(resp.ID)
+	if err == webauthn.CredentialNotFoundError || (err == nil && owner != subject) {
+		ctx.failWebAuthn(webauthn.CredentialMismatchError, "unknown security key")
+		return
+	} else if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to check two-factor authentication"))
+		return
+	}
+
+	if !ctx.verifyAssertion(resp, subject, cred, ctx.API.webauthnUserVerification()) {
+		return
+	}
+
+	ctx.completeWebAuthn(subject, ctx.Request.MFAUsername, SecurityKeyAuthMethods)
+}
+
+// VerifyWebAuthnRegistration registers the security key of the user who passed the password, and sends tokens if succeed.
+func (ctx *AuthzContext) VerifyWebAuthnRegistration() {
+	ctx.Report.Set("authn_by", "password")
+	ctx.Report.Set("username", ctx.Request.MFAUsername)
+
+	subject := ctx.Request.MFASubject
+
+	var resp webauthn.RegistrationResponse
+	if ctx.Request.WebAuthn == "" {
+		ctx.Report.UserError()
+		ctx.ShowWebAuthnPage(http.StatusForbidden, "missing response of security key")
+		return
+	} else if err := json.Unmarshal([]byte(ctx.Request.WebAuthn), &resp); err != nil {
+		ctx.failWebAuthn(err, "invalid response of security key")
+		return
+	}
+
+	cred, _, err := ctx.webauthnCeremony(ctx.API.webauthnUserVerification()).VerifyRegistration(resp)
+	if err != nil {
+		ctx.failWebAuthn(err, "invalid response of security key")
+		return
+	}
+	cred.Username = ctx.Request.MFAUsername
+
+	if _, _, err := ctx.API.Credentials.Find(cred.ID); err == nil {
+		ctx.failWebAuthn(nil, "security key is already registered")
+		return
+	} else if err != webauthn.CredentialNotFoundError {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to register security key"))
+		return
+	}
+
+	if ok, err := ctx.useWebAuthnChallenge(); err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to register security key"))
+		return
+	} else if !ok {
+		ctx.failWebAuthn(nil, "response of security key is already used")
+		return
+	}
+
+	if err := ctx.API.Credentials.Save(subject, cred); err == webauthn.CredentialRegisteredError {
+		ctx.failWebAuthn(nil, "security key is already registered")
+		return
+	} else if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to register security key"))
+		return
+	}
+
+	log.Info().
+		Str("username", ctx.Request.MFAUsername).
+		Str("credential", cred.ID.String()).
+		Msg("security key registered")
+
+	ctx.completeWebAuthn(subject, ctx.Request.MFAUsername, SecurityKeyAuthMethods)
+}
+
+// LoginWithPasskey checks the passkey that sent from the login page, and sends tokens if correct.
+//
+// The passkey has to verify the user like PIN or biometrics, because it is used instead of the password.
+func (ctx *AuthzContext) LoginWithPasskey() {
+	ctx.Report.Set("authn_by", "passkey")
+
+	if !ctx.API.Config.WebAuthn.Passwordless {
+		ctx.Report.UserError()
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(nil, errors.InvalidRequest, "passwordless login is not enabled"))
+		return
+	}
+
+	var resp webauthn.AssertionResponse
+	if err := json.Unmarshal([]byte(ctx.Request.WebAuthn), &resp); err != nil {
+		ctx.failWebAuthn(err, "invalid response of passkey")
+		return
+	}
+
+	subject, cred, err := ctx.API.Credentials.Find(resp.ID)
+	if err == webauthn.CredentialNotFoundError {
+		ctx.failWebAuthn(err, "unknown passkey")
+		return
+	} else if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to check passkey"))
+		return
+	}
+	if len(resp.Response.UserHandle) > 0 && !bytes.Equal(resp.Response.UserHandle, webauthn.UserHandle(subject)) {
+		ctx.failWebAuthn(webauthn.CredentialMismatchError, "unknown passkey")
+		return
+	}
+
+	username := cred.Username
+	if username == "" {
+		username = subject
+	}
+	ctx.Report.Set("username", username)
+
+	if !ctx.verifyAssertion(resp, subject, cred, webauthn.UserVerificationRequired) {
+		return
+	}
+
+	// the user has to still exist in the directory, because the password is not checked.
+	conn, reason, err := ctx.API.connectLDAP()
+	if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, reason, "failed to connecting LDAP server"))
+		return
+	}
+	_, err = conn.GetUserAttributes(subject, nil)
+	conn.Close()
+	if stderrors.Is(err, ldap.UserNotFoundError) {
+		ctx.Report.Denied()
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.AccessDenied, "user of the passkey is not found"))
+		return
+	} else if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, unavailableOr(err, errors.ServerError), "failed to check passkey"))
+		return
+	}
+
+	if !ctx.CheckAccess(subject) {
+		return
+	}
+
+	ctx.completeWebAuthn(subject, username, PasskeyAuthMethods)
+}
+
+func (ctx *AuthzContext) ShowWebAuthnPage(code int, errorDescription string) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		ctx.ErrorRedirect(ctx.Request.makeRedirectError(err, errors.ServerError, "failed to create login session"))
+		return
+	}
+	ctx.Request.WebAuthnChallenge = challenge
+
+	ctx.Report.Continue()
+	ctx.showPage(code, "webauthn.tmpl", ctx.Request.MFAUsername, errorDescription)
+}
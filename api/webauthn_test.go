@@ -0,0 +1,300 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/mfa"
+	"github.com/macrat/lauth/testutil"
+	"github.com/macrat/lauth/webauthn"
+)
+
+func setupWebAuthn(t *testing.T, conf config.WebAuthnConfig) (*testutil.APITestEnvironment, *testutil.SoftAuthenticator) {
+	t.Helper()
+
+	env := setupMFA(t, config.MFAConfig{})
+	conf.Enabled = true
+	env.API.Config.WebAuthn = conf
+
+	auth, err := testutil.NewSoftAuthenticator(env.API.Config.WebAuthnOrigin())
+	if err != nil {
+		t.Fatalf("failed to make authenticator: %s", err)
+	}
+
+	return env, auth
+}
+
+// postWebAuthn posts the response of the authenticator from the page.
+// respond makes the response from the challenge in the page.
+func postWebAuthn(t *testing.T, env *testutil.APITestEnvironment, page *httptest.ResponseRecorder, respond func(challenge string) (string, error)) *httptest.ResponseRecorder {
+	t.Helper()
+
+	if page.Code != http.StatusOK && page.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status code of the page: %d: %s", page.Code, page.Body.String())
+	}
+	if !strings.Contains(page.Body.String(), `name="webauthn"`) {
+		t.Fatalf("the page has no input for WebAuthn: %s", page.Body.String())
+	}
+
+	request, err := testutil.FindRequestObjectByHTML(strings.NewReader(page.Body.String()))
+	if err != nil {
+		t.Fatalf("failed to get request object: %s", err)
+	}
+	claims, err := env.API.TokenManager.ParseRequestObject(request, "")
+	if err != nil {
+		t.Fatalf("failed to parse request object: %s", err)
+	}
+
+	resp, err := respond(claims.WebAuthnChallenge)
+	if err != nil {
+		t.Fatalf("failed to make response of authenticator: %s", err)
+	}
+
+	return env.Post("/authz", "", url.Values{
+		"request":  {request},
+		"webauthn": {resp},
+	})
+}
+
+// registerPasskey registers the authenticator to the user through the login.
+func registerPasskey(t *testing.T, env *testutil.APITestEnvironment, auth *testutil.SoftAuthenticator, username, password string) {
+	t.Helper()
+
+	page := postPasswordWith(t, env, username, password, url.Values{"register_passkey": {"true"}})
+	resp := postWebAuthn(t, env, page, func(challenge string) (string, error) {
+		return auth.Create(env.API.Config.WebAuthnRPID(), challenge, webauthn.UserHandle(username))
+	})
+	if resp.Code != http.StatusFound {
+		t.Fatalf("failed to register: %d: %s", resp.Code, resp.Body.String())
+	}
+}
+
+func postPasswordWith(t *testing.T, env *testutil.APITestEnvironment, username, password string, extra url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	page := env.Get("/authz", "", url.Values{
+		"response_type": {"id_token"},
+		"client_id":     {"implicit_client_id"},
+		"redirect_uri":  {"http://implicit-client.example.com/callback"},
+		"scope":         {"openid"},
+		"nonce":         {"client-nonce"},
+	})
+	request, err := testutil.FindRequestObjectByHTML(strings.NewReader(page.Body.String()))
+	if err != nil {
+		t.Fatalf("failed to get request object: %s: %s", err, page.Body.String())
+	}
+
+	values := url.Values{
+		"request":  {request},
+		"username": {username},
+		"password": {password},
+	}
+	for k, v := range extra {
+		values[k] = v
+	}
+	return env.Post("/authz", "", values)
+}
+
+func TestWebAuthn_secondFactor(t *testing.T) {
+	env, auth := setupWebAuthn(t, config.WebAuthnConfig{Enroll: true})
+	rpID := env.API.Config.WebAuthnRPID()
+
+	idToken := parseIDTokenInRedirect(t, env, postPassword(t, env, "macrat", "foobar"))
+	if !reflect.DeepEqual(idToken.AuthMethods, []string{"pwd"}) {
+		t.Errorf("user without security key should login with only password: %#v", idToken.AuthMethods)
+	}
+
+	page := postPasswordWith(t, env, "macrat", "foobar", url.Values{"register_passkey": {"true"}})
+	if !strings.Contains(page.Body.String(), "Register security key") {
+		t.Fatalf("unexpected page: %d: %s", page.Code, page.Body.String())
+	}
+	resp := postWebAuthn(t, env, page, func(challenge string) (string, error) {
+		return auth.Create(rpID, challenge, webauthn.UserHandle("macrat"))
+	})
+	idToken = parseIDTokenInRedirect(t, env, resp)
+	if !reflect.DeepEqual(idToken.AuthMethods, []string{"pwd", "hwk", "user"}) {
+		t.Errorf("unexpected amr: %#v", idToken.AuthMethods)
+	}
+
+	if creds, err := env.API.Credentials.Credentials("macrat"); err != nil || len(creds) != 1 || creds[0].Username != "macrat" {
+		t.Fatalf("credential should be saved after registered: %#v %v", creds, err)
+	}
+
+	page = postPassword(t, env, "macrat", "foobar")
+	if !strings.Contains(page.Body.String(), "Use security key") {
+		t.Fatalf("registered user should be asked security key: %d: %s", page.Code, page.Body.String())
+	}
+
+	another, _ := testutil.NewSoftAuthenticator(env.API.Config.WebAuthnOrigin())
+	resp = postWebAuthn(t, env, page, func(challenge string) (string, error) {
+		return another.Get(rpID, challenge)
+	})
+	if resp.Code != http.StatusForbidden {
+		t.Fatalf("unknown security key should be rejected: %d", resp.Code)
+	}
+
+	request, _ := testutil.FindRequestObjectByHTML(strings.NewReader(resp.Body.String()))
+	var response string
+	resp = postWebAuthn(t, env, resp, func(challenge string) (string, error) {
+		var err error
+		response, err = auth.Get(rpID, challenge)
+		return response, err
+	})
+	idToken = parseIDTokenInRedirect(t, env, resp)
+	if !reflect.DeepEqual(idToken.AuthMethods, []string{"pwd", "hwk", "user"}) {
+		t.Errorf("unexpected amr: %#v", idToken.AuthMethods)
+	}
+
+	resp = env.Post("/authz", "", url.Values{"request": {request}, "webauthn": {response}})
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("same response should not be used twice: %d", resp.Code)
+	}
+
+	page = postPassword(t, env, "macrat", "foobar")
+	request, _ = testutil.FindRequestObjectByHTML(strings.NewReader(page.Body.String()))
+	resp = env.Post("/authz", "", url.Values{"request": {request}, "webauthn": {response}})
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("response for another challenge should be rejected: %d", resp.Code)
+	}
+}
+
+func TestWebAuthn_required(t *testing.T) {
+	env, auth := setupWebAuthn(t, config.WebAuthnConfig{
+		RequiredGroups: []string{"CN=admin,OU=group,DC=example,DC=local"},
+	})
+
+	resp := postPassword(t, env, "macrat", "foobar")
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "security key is required") {
+		t.Errorf("member of required group without security key should be denied: %d %s", resp.Code, resp.Body.String())
+	}
+
+	idToken := parseIDTokenInRedirect(t, env, postPassword(t, env, "j.smith", "hello"))
+	if idToken.Subject != "j.smith" {
+		t.Errorf("user out of required groups should login with only password: %#v", idToken.Subject)
+	}
+
+	env.API.Config.WebAuthn.Enroll = true
+
+	page := postPassword(t, env, "macrat", "foobar")
+	if !strings.Contains(page.Body.String(), "Register security key") {
+		t.Fatalf("member of required group should be asked to register: %d: %s", page.Code, page.Body.String())
+	}
+	resp = postWebAuthn(t, env, page, func(challenge string) (string, error) {
+		return auth.Create(env.API.Config.WebAuthnRPID(), challenge, webauthn.UserHandle("macrat"))
+	})
+	if resp.Code != http.StatusFound {
+		t.Errorf("failed to register: %d: %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestWebAuthn_afterTOTP(t *testing.T) {
+	env, auth := setupWebAuthn(t, config.WebAuthnConfig{Enroll: true})
+	env.API.Config.MFA = config.MFAConfig{TOTPAttribute: "totpSecret", MaxAttempts: 5}
+	secret, _ := mfa.ParseSecret(mfaTestSecret)
+
+	page := postPasswordWith(t, env, "totp-user", "totp-password", url.Values{"register_passkey": {"true"}})
+	page = postOTP(t, env, page, mfa.Code(secret, time.Now()))
+	if !strings.Contains(page.Body.String(), "Register security key") {
+		t.Fatalf("user should be asked to register after TOTP: %d: %s", page.Code, page.Body.String())
+	}
+
+	resp := postWebAuthn(t, env, page, func(challenge string) (string, error) {
+		return auth.Create(env.API.Config.WebAuthnRPID(), challenge, webauthn.UserHandle("totp-user"))
+	})
+	if resp.Code != http.StatusFound {
+		t.Fatalf("failed to register: %d: %s", resp.Code, resp.Body.String())
+	}
+
+	page = postPassword(t, env, "totp-user", "totp-password")
+	if !strings.Contains(page.Body.String(), "Use security key") {
+		t.Errorf("security key should be asked instead of TOTP: %d: %s", page.Code, page.Body.String())
+	}
+}
+
+func TestWebAuthn_passwordless(t *testing.T) {
+	env, auth := setupWebAuthn(t, config.WebAuthnConfig{Enroll: true, Passwordless: true})
+	rpID := env.API.Config.WebAuthnRPID()
+
+	registerPasskey(t, env, auth, "macrat", "foobar")
+
+	loginPage := func() *httptest.ResponseRecorder {
+		return env.Get("/authz", "", url.Values{
+			"response_type": {"id_token"},
+			"client_id":     {"implicit_client_id"},
+			"redirect_uri":  {"http://implicit-client.example.com/callback"},
+			"scope":         {"openid"},
+			"nonce":         {"client-nonce"},
+		})
+	}
+
+	page := loginPage()
+	if !strings.Contains(page.Body.String(), "Login with a passkey") {
+		t.Fatalf("login page has no button for passkey: %s", page.Body.String())
+	}
+
+	idToken := parseIDTokenInRedirect(t, env, postWebAuthn(t, env, page, func(challenge string) (string, error) {
+		return auth.Get(rpID, challenge)
+	}))
+	if idToken.Subject != "macrat" {
+		t.Errorf("unexpected subject: %#v", idToken.Subject)
+	}
+	if !reflect.DeepEqual(idToken.AuthMethods, []string{"hwk", "user"}) {
+		t.Errorf("unexpected amr: %#v", idToken.AuthMethods)
+	}
+
+	another, _ := testutil.NewSoftAuthenticator(env.API.Config.WebAuthnOrigin())
+	resp := postWebAuthn(t, env, loginPage(), func(challenge string) (string, error) {
+		return another.Get(rpID, challenge)
+	})
+	if resp.Code != http.StatusForbidden || !strings.Contains(resp.Body.String(), "Failed to login with the passkey") {
+		t.Errorf("unknown passkey should be rejected: %d: %s", resp.Code, resp.Body.String())
+	}
+
+	auth.UserVerified = false
+	resp = postWebAuthn(t, env, loginPage(), func(challenge string) (string, error) {
+		return auth.Get(rpID, challenge)
+	})
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("passkey without user verification should be rejected: %d", resp.Code)
+	}
+
+	env.API.Config.WebAuthn.Passwordless = false
+	if page := loginPage(); strings.Contains(page.Body.String(), "Login with a passkey") {
+		t.Errorf("login page should not have button for passkey if disabled")
+	}
+}
+
+func TestAdminCredentials(t *testing.T) {
+	env, auth := setupWebAuthn(t, config.WebAuthnConfig{Enroll: true})
+	registerPasskey(t, env, auth, "macrat", "foobar")
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.SetBasicAuth("admin", "admin password")
+		return env.DoRequest(req)
+	}
+
+	if resp := request("GET", "/admin/credentials"); resp.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status code: %d", resp.Code)
+	}
+
+	resp := request("GET", "/admin/credentials?subject=macrat")
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), webauthn.Base64URL(auth.CredentialID).String()) {
+		t.Errorf("unexpected response: %d: %s", resp.Code, resp.Body.String())
+	}
+
+	if resp := request("DELETE", "/admin/credentials?subject=macrat"); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `"deleted":1`) {
+		t.Errorf("unexpected response: %d: %s", resp.Code, resp.Body.String())
+	}
+
+	idToken := parseIDTokenInRedirect(t, env, postPassword(t, env, "macrat", "foobar"))
+	if !reflect.DeepEqual(idToken.AuthMethods, []string{"pwd"}) {
+		t.Errorf("user should login with only password after credentials deleted: %#v", idToken.AuthMethods)
+	}
+}
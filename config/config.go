@@ -6,11 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path"
 	"reflect"
+	"sort"
 	"strings"
 
+	"github.com/go-ldap/ldap/v3"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -36,9 +39,90 @@ var (
 )
 
 type ClaimConfig struct {
-	Claim     string    `json:"claim"          yaml:"claim"          toml:"claim"`
-	Attribute string    `json:"attribute"      yaml:"attribute"      toml:"attribute"`
-	Type      ClaimType `json:"type,omitempty" yaml:"type,omitempty" toml:"type,omitempty"`
+	Claim     string        `json:"claim"               yaml:"claim"               toml:"claim"`
+	Attribute string        `json:"attribute,omitempty" yaml:"attribute,omitempty" toml:"attribute,omitempty"`
+	Template  string        `json:"template,omitempty"  yaml:"template,omitempty"  toml:"template,omitempty"`
+	Transform []Transform   `json:"transform,omitempty" yaml:"transform,omitempty" toml:"transform,omitempty"`
+	Type      ClaimType     `json:"type,omitempty"      yaml:"type,omitempty"      toml:"type,omitempty"`
+	Claims    []ClaimConfig `json:"claims,omitempty"    yaml:"claims,omitempty"    toml:"claims,omitempty"` // members of object type claim
+}
+
+// Attributes returns attribute names that needed to make this claim.
+func (c ClaimConfig) Attributes() []string {
+	if c.Type == CLAIM_TYPE_OBJECT {
+		var attrs []string
+		for _, member := range c.Claims {
+			attrs = append(attrs, member.Attributes()...)
+		}
+		return attrs
+	}
+	if c.Template != "" {
+		return TemplateAttributes(c.Template)
+	}
+	return []string{c.Attribute}
+}
+
+func (c ClaimConfig) validate(path string) []error {
+	var es []error
+
+	if c.Type == CLAIM_TYPE_OBJECT {
+		if len(c.Claims) == 0 {
+			es = append(es, fmt.Errorf("%s: Claims is required for object type.", path))
+		}
+		for _, member := range c.Claims {
+			es = append(es, member.validate(path+"."+member.Claim)...)
+		}
+		return es
+	}
+
+	if c.Attribute == "" && c.Template == "" {
+		es = append(es, fmt.Errorf("%s: Attribute or Template is required.", path))
+	}
+	for _, t := range c.Transform {
+		if err := t.Validate(); err != nil {
+			es = append(es, fmt.Errorf("%s: Invalid transform: %s.", path, err))
+		}
+	}
+	return es
+}
+
+// Values makes values of this claim from attributes, before converting type.
+//
+// The second return value is false if no attribute for this claim is included in attrs.
+func (c ClaimConfig) Values(attrs map[string][]string) ([]string, bool) {
+	var values []string
+
+	if c.Template != "" {
+		found := false
+		for _, attr := range TemplateAttributes(c.Template) {
+			if _, ok := attrs[attr]; ok {
+				found = true
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		values = []string{ExecuteTemplate(c.Template, attrs)}
+	} else {
+		vs, ok := attrs[c.Attribute]
+		if !ok {
+			return nil, false
+		}
+		values = vs
+	}
+
+	if len(c.Transform) > 0 {
+		transformed := make([]string, len(values))
+		for i, v := range values {
+			for _, t := range c.Transform {
+				v = t.Apply(v)
+			}
+			transformed[i] = v
+		}
+		values = transformed
+	}
+
+	return values, true
 }
 
 type ScopeConfig map[string][]ClaimConfig
@@ -49,6 +133,7 @@ type EndpointConfig struct {
 	Userinfo string `json:"userinfo"      yaml:"userinfo"      toml:"userinfo"      flag:"userinfo-endpoint"`
 	Jwks     string `json:"jwks"          yaml:"jwks"          toml:"jwks"          flag:"jwks-uri"`
 	Logout   string `json:"logout"        yaml:"logout"        toml:"logout"        flag:"logout-endpoint"`
+	SAML     string `json:"saml"          yaml:"saml"          toml:"saml"          flag:"saml-endpoint"`
 }
 
 type ExpireConfig struct {
@@ -57,20 +142,93 @@ type ExpireConfig struct {
 	Token   Duration `json:"token"   yaml:"token"   toml:"token"   flag:"token-expire"`
 	Refresh Duration `json:"refresh" yaml:"refresh" toml:"refresh" flag:"refresh-expire"`
 	SSO     Duration `json:"sso"     yaml:"sso"     toml:"sso"     flag:"sso-expire"`
+	Consent Duration `json:"consent" yaml:"consent" toml:"consent" flag:"consent-expire"`
 }
 
 type ClientConfig struct {
-	Name              string     `json:"name"                yaml:"name"                toml:"name"`
-	IconURL           string     `json:"icon_url"            yaml:"icon_url"            toml:"icon_url"`
-	Secret            string     `json:"secret"              yaml:"secret"              toml:"secret"`
-	RedirectURI       PatternSet `json:"redirect_uri"        yaml:"redirect_uri"        toml:"redirect_uri"`
-	CORSOrigin        PatternSet `json:"cors_origin"         yaml:"cors_origin"         toml:"cors_origin"`
-	AllowImplicitFlow bool       `json:"allow_implicit_flow" yaml:"allow_implicit_flow" toml:"allow_implicit_flow"`
-	RequestKey        string     `json:"request_key"         yaml:"request_key"         toml:"request_key"`
+	Name              string             `json:"name"                yaml:"name"                toml:"name"`
+	IconURL           string             `json:"icon_url"            yaml:"icon_url"            toml:"icon_url"`
+	Secret            string             `json:"secret"              yaml:"secret"              toml:"secret"`
+	RedirectURI       PatternSet         `json:"redirect_uri"        yaml:"redirect_uri"        toml:"redirect_uri"`
+	CORSOrigin        PatternSet         `json:"cors_origin"         yaml:"cors_origin"         toml:"cors_origin"`
+	AllowImplicitFlow bool               `json:"allow_implicit_flow" yaml:"allow_implicit_flow" toml:"allow_implicit_flow"`
+	RequestKey        string             `json:"request_key"         yaml:"request_key"         toml:"request_key"`
+	SkipConsent       bool               `json:"skip_consent"        yaml:"skip_consent"        toml:"skip_consent"`
+	AllowedScopes     []string           `json:"allowed_scopes"      yaml:"allowed_scopes"      toml:"allowed_scopes"`
+	RequiredGroups    []string           `json:"required_groups"     yaml:"required_groups"     toml:"required_groups"`
+	RequiredFilter    string             `json:"required_filter"     yaml:"required_filter"     toml:"required_filter"`
+	Expire            ClientExpireConfig `json:"expire"              yaml:"expire"              toml:"expire"`
+	RequireMFA        bool               `json:"require_mfa"         yaml:"require_mfa"         toml:"require_mfa"`
+	SAML              SAMLConfig         `json:"saml,omitempty"      yaml:"saml,omitempty"      toml:"saml,omitempty"`
+}
+
+// ClientExpireConfig overrides ExpireConfig for each client. Zero value means use the global setting.
+type ClientExpireConfig struct {
+	Code           Duration `json:"code,omitempty"            yaml:"code,omitempty"            toml:"code,omitempty"`
+	Token          Duration `json:"token,omitempty"           yaml:"token,omitempty"           toml:"token,omitempty"`
+	Refresh        Duration `json:"refresh,omitempty"         yaml:"refresh,omitempty"         toml:"refresh,omitempty"`
+	SSO            Duration `json:"sso,omitempty"             yaml:"sso,omitempty"             toml:"sso,omitempty"`
+	DisableRefresh bool     `json:"disable_refresh,omitempty" yaml:"disable_refresh,omitempty" toml:"disable_refresh,omitempty"`
+}
+
+// AccessFilter returns LDAP filter for users who allowed to use the client.
+//
+// The user has to be member of one of RequiredGroups, and has to match to RequiredFilter.
+// It returns empty string if the client is not restricted.
+func (c ClientConfig) AccessFilter() string {
+	var filters []string
+
+	if len(c.RequiredGroups) > 0 {
+		groups := ""
+		for _, g := range c.RequiredGroups {
+			groups += fmt.Sprintf("(memberOf=%s)", ldap.EscapeFilter(g))
+		}
+		filters = append(filters, "(|"+groups+")")
+	}
+
+	if f := strings.TrimSpace(c.RequiredFilter); f != "" {
+		if !strings.HasPrefix(f, "(") {
+			f = "(" + f + ")"
+		}
+		filters = append(filters, f)
+	}
+
+	switch len(filters) {
+	case 0:
+		return ""
+	case 1:
+		return filters[0]
+	default:
+		return "(&" + strings.Join(filters, "") + ")"
+	}
 }
 
 type ClientConfigSet map[string]ClientConfig
 
+// ExpireFor returns expiration settings for the client that applied overrides.
+func (c *Config) ExpireFor(clientID string) ExpireConfig {
+	e := c.Expire
+	client := c.Clients[clientID].Expire
+
+	if client.Code > 0 {
+		e.Code = client.Code
+	}
+	if client.Token > 0 {
+		e.Token = client.Token
+	}
+	if client.Refresh > 0 {
+		e.Refresh = client.Refresh
+	}
+	if client.SSO > 0 {
+		e.SSO = client.SSO
+	}
+	if client.DisableRefresh {
+		e.Refresh = 0
+	}
+
+	return e
+}
+
 type MetricsConfig struct {
 	Path     string `json:"path"               yaml:"path"               toml:"path"               flag:"metrics-path"`
 	Username string `json:"username,omitempty" yaml:"username,omitempty" toml:"username,omitempty" flag:"metrics-username"`
@@ -84,32 +242,281 @@ type TLSConfig struct {
 }
 
 type LDAPConfig struct {
-	Server      *URL   `json:"server"       yaml:"server"       toml:"server"       flag:"ldap"`
-	User        string `json:"user"         yaml:"user"         toml:"user"         flag:"ldap-user"`
-	Password    string `json:"password"     yaml:"password"     toml:"password"     flag:"ldap-password"`
-	BaseDN      string `json:"base_dn"      yaml:"base_dn"      toml:"base_dn"      flag:"ldap-base-dn"`
-	IDAttribute string `json:"id_attribute" yaml:"id_attribute" toml:"id_attribute" flag:"ldap-id-attribute"`
-	DisableTLS  bool   `json:"disable_tls"  yaml:"disable_tls"  toml:"disable_tls"  flag:"ldap-disable-tls"`
+	Server           *URL        `json:"server"            yaml:"server"            toml:"server"            flag:"ldap"`
+	Servers          []string    `json:"servers"           yaml:"servers"           toml:"servers"           flag:"ldap-servers"`
+	SRV              string      `json:"srv"               yaml:"srv"               toml:"srv"               flag:"ldap-srv"`
+	Strategy         string      `json:"strategy"          yaml:"strategy"          toml:"strategy"          flag:"ldap-strategy"`
+	MaxBackoff       Duration    `json:"max_backoff"       yaml:"max_backoff"       toml:"max_backoff"       flag:"ldap-max-backoff"`
+	User             string      `json:"user"              yaml:"user"              toml:"user"              flag:"ldap-user"`
+	Password         string      `json:"password"          yaml:"password"          toml:"password"          flag:"ldap-password"`
+	BaseDN           string      `json:"base_dn"           yaml:"base_dn"           toml:"base_dn"           flag:"ldap-base-dn"`
+	IDAttribute      string      `json:"id_attribute"      yaml:"id_attribute"      toml:"id_attribute"      flag:"ldap-id-attribute"`
+	UserFilter       string      `json:"user_filter"       yaml:"user_filter"       toml:"user_filter"       flag:"ldap-user-filter"`
+	SubjectAttribute string      `json:"subject_attribute" yaml:"subject_attribute" toml:"subject_attribute" flag:"ldap-subject-attribute"`
+	LoginAttributes  []string    `json:"login_attributes"  yaml:"login_attributes"  toml:"login_attributes"  flag:"ldap-login-attributes"`
+	LoginDomains     []string    `json:"login_domains"     yaml:"login_domains"     toml:"login_domains"     flag:"ldap-login-domains"`
+	DisableTLS       bool        `json:"disable_tls"       yaml:"disable_tls"       toml:"disable_tls"       flag:"ldap-disable-tls"`
+	TLSCA            string      `json:"tls_ca"            yaml:"tls_ca"            toml:"tls_ca"            flag:"ldap-tls-ca"`
+	TLSCert          string      `json:"tls_cert"          yaml:"tls_cert"          toml:"tls_cert"          flag:"ldap-tls-cert"`
+	TLSKey           string      `json:"tls_key"           yaml:"tls_key"           toml:"tls_key"           flag:"ldap-tls-key"`
+	TLSServerName    string      `json:"tls_server_name"   yaml:"tls_server_name"   toml:"tls_server_name"   flag:"ldap-tls-server-name"`
+	TLSMinVersion    TLSVersion  `json:"tls_min_version"   yaml:"tls_min_version"   toml:"tls_min_version"   flag:"ldap-tls-min-version"`
+	TLSSkipVerify    bool        `json:"tls_skip_verify"   yaml:"tls_skip_verify"   toml:"tls_skip_verify"   flag:"ldap-tls-skip-verify"`
+	PasswordChange   string      `json:"password_change"   yaml:"password_change"   toml:"password_change"   flag:"ldap-password-change"`
+	Pool             PoolConfig  `json:"pool"              yaml:"pool"              toml:"pool"`
+	Groups           GroupConfig `json:"groups"            yaml:"groups"            toml:"groups"`
+}
+
+// GroupConfig is the settings for the memberOf attribute.
+type GroupConfig struct {
+	Nested  string     `json:"nested"  yaml:"nested"  toml:"nested"  flag:"ldap-group-nested"`
+	Format  string     `json:"format"  yaml:"format"  toml:"format"  flag:"ldap-group-format"`
+	BaseDN  string     `json:"base_dn" yaml:"base_dn" toml:"base_dn" flag:"ldap-group-base-dn"`
+	Include PatternSet `json:"include" yaml:"include" toml:"include" flag:"ldap-group-include"`
+	Exclude PatternSet `json:"exclude" yaml:"exclude" toml:"exclude" flag:"ldap-group-exclude"`
+}
+
+// Enabled reports the memberOf attribute needs to be transformed.
+func (c GroupConfig) Enabled() bool {
+	return (c.Nested != "" && c.Nested != "none") || (c.Format != "" && c.Format != "dn") || len(c.Include) > 0 || len(c.Exclude) > 0
+}
+
+// NameAttribute returns the attribute name of group entry to use as the group name, or empty string if Format is "dn" or "cn".
+func (c GroupConfig) NameAttribute() string {
+	if c.Format == "" || c.Format == "dn" || c.Format == "cn" {
+		return ""
+	}
+	return c.Format
+}
+
+// Allowed reports the group name matches to Include and doesn't match to Exclude.
+func (c GroupConfig) Allowed(name string) bool {
+	if len(c.Include) > 0 && !c.Include.Match(name) {
+		return false
+	}
+	return !c.Exclude.Match(name)
+}
+
+type PoolConfig struct {
+	MinIdle     int      `json:"min_idle"     yaml:"min_idle"     toml:"min_idle"     flag:"ldap-pool-min-idle"`
+	MaxIdle     int      `json:"max_idle"     yaml:"max_idle"     toml:"max_idle"     flag:"ldap-pool-max-idle"`
+	HealthCheck Duration `json:"health_check" yaml:"health_check" toml:"health_check" flag:"ldap-pool-health-check"`
+}
+
+// ServerURLs returns URLs of Server and Servers, without credentials.
+func (c LDAPConfig) ServerURLs() ([]*url.URL, error) {
+	var result []*url.URL
+
+	if c.Server != nil && c.Server.String() != "" {
+		u := *c.Server.URL()
+		u.User = nil
+		result = append(result, &u)
+	}
+
+	for _, s := range c.Servers {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "ldap" && u.Scheme != "ldaps" || u.Host == "" {
+			return nil, fmt.Errorf("LDAP server address must be ldap:// or ldaps:// URL: %s", s)
+		}
+		u.User = nil
+		result = append(result, u)
+	}
+
+	return result, nil
+}
+
+// UserFilterTemplate returns the filter for search user that includes {username} placeholder.
+func (c LDAPConfig) UserFilterTemplate() string {
+	if c.UserFilter != "" {
+		return c.UserFilter
+	}
+	if len(c.LoginAttributes) > 0 {
+		var alts strings.Builder
+		for _, attr := range c.LoginAttributes {
+			fmt.Fprintf(&alts, "(%s={username})", attr)
+		}
+		return fmt.Sprintf("(&(objectClass=person)(|%s))", alts.String())
+	}
+	return fmt.Sprintf("(&(objectClass=person)(%s={username}))", c.IDAttribute)
+}
+
+// UserFilterFor returns the filter for search the user. The username will be escaped.
+func (c LDAPConfig) UserFilterFor(username string) string {
+	return strings.ReplaceAll(c.UserFilterTemplate(), "{username}", ldap.EscapeFilter(username))
+}
+
+// NormalizeUsername trims spaces, and strips LoginDomains like DOMAIN\username or username@domain.
+func (c LDAPConfig) NormalizeUsername(username string) string {
+	username = strings.TrimSpace(username)
+
+	for _, domain := range c.LoginDomains {
+		if prefix := domain + "\\"; len(username) > len(prefix) && strings.EqualFold(username[:len(prefix)], prefix) {
+			return username[len(prefix):]
+		}
+		if suffix := "@" + domain; len(username) > len(suffix) && strings.EqualFold(username[len(username)-len(suffix):], suffix) {
+			return username[:len(username)-len(suffix)]
+		}
+	}
+
+	return username
+}
+
+// SubjectFor makes the subject from value of SubjectAttribute.
+// Binary objectGUID of ActiveDirectory is formatted as UUID.
+func (c LDAPConfig) SubjectFor(value string) string {
+	if strings.EqualFold(c.SubjectAttribute, "objectGUID") {
+		return FormatUUID(value)
+	}
+	return value
+}
+
+// SubjectFilterFor returns the filter for search the user by subject.
+//
+// The filter is the same as UserFilterFor if neither SubjectAttribute nor LoginAttributes is set.
+// Otherwise, it is made from UserFilterTemplate that {username} replaced to "*" and equality match of SubjectAttribute, or IDAttribute if SubjectAttribute is not set.
+func (c LDAPConfig) SubjectFilterFor(subject string) string {
+	attr := c.SubjectAttribute
+	if attr == "" {
+		if len(c.LoginAttributes) == 0 {
+			return c.UserFilterFor(subject)
+		}
+		attr = c.IDAttribute
+	}
+
+	value := ldap.EscapeFilter(subject)
+	if strings.EqualFold(attr, "objectGUID") {
+		if guid, err := ParseGUID(subject); err == nil {
+			var buf strings.Builder
+			for _, b := range guid {
+				fmt.Fprintf(&buf, "\\%02x", b)
+			}
+			value = buf.String()
+		}
+	}
+
+	return fmt.Sprintf("(&%s(%s=%s))", strings.ReplaceAll(c.UserFilterTemplate(), "{username}", "*"), attr, value)
+}
+
+// validate checks the settings. name converts a flag name to the name of option for error messages.
+func (c LDAPConfig) validate(name func(flag string) string) []error {
+	var es []error
+
+	if c.Server.String() == "" && len(c.Servers) == 0 && c.SRV == "" {
+		es = append(es, fmt.Errorf("%s: LDAP Server address is required.", name("ldap")))
+	}
+	if _, err := c.ServerURLs(); len(c.Servers) > 0 && err != nil {
+		es = append(es, fmt.Errorf("%s: Invalid LDAP Servers: %s.", name("ldap-servers"), err))
+	}
+	if c.SRV != "" && !strings.HasPrefix(c.SRV, "_ldap._tcp.") && !strings.HasPrefix(c.SRV, "_ldaps._tcp.") {
+		es = append(es, fmt.Errorf("%s: LDAP SRV must starts with \"_ldap._tcp.\" or \"_ldaps._tcp.\".", name("ldap-srv")))
+	}
+	if c.Strategy != "" && c.Strategy != "failover" && c.Strategy != "round-robin" {
+		es = append(es, fmt.Errorf("%s: LDAP Strategy must be \"failover\" or \"round-robin\".", name("ldap-strategy")))
+	}
+	if c.MaxBackoff < 0 {
+		es = append(es, fmt.Errorf("%s: LDAP Max Backoff can't set less than 0.", name("ldap-max-backoff")))
+	}
+	if c.User == "" {
+		es = append(es, fmt.Errorf("%s: LDAP User is required.", name("ldap-user")))
+	}
+	if c.Password == "" {
+		es = append(es, fmt.Errorf("%s: LDAP Password is required.", name("ldap-password")))
+	}
+	if c.BaseDN == "" {
+		es = append(es, fmt.Errorf("%s: LDAP Base DN is required if using user that non DN style.", name("ldap-base-dn")))
+	}
+	if c.UserFilter != "" && len(c.LoginAttributes) > 0 {
+		es = append(es, fmt.Errorf("%s: Can't use both of LDAP Login Attributes and LDAP User Filter.", name("ldap-login-attributes")))
+	}
+	if c.UserFilter != "" && !strings.Contains(c.UserFilter, "{username}") {
+		es = append(es, fmt.Errorf("%s: LDAP User Filter must include {username}.", name("ldap-user-filter")))
+	} else if _, err := ldap.CompileFilter(c.UserFilterFor("username")); c.UserFilter != "" && err != nil {
+		es = append(es, fmt.Errorf("%s: Invalid LDAP filter: %s.", name("ldap-user-filter"), err))
+	}
+	if c.Pool.MinIdle < 0 {
+		es = append(es, fmt.Errorf("%s: LDAP Pool Min Idle can't set less than 0.", name("ldap-pool-min-idle")))
+	}
+	if c.Pool.MaxIdle < 0 {
+		es = append(es, fmt.Errorf("%s: LDAP Pool Max Idle can't set less than 0.", name("ldap-pool-max-idle")))
+	} else if c.Pool.MinIdle > c.Pool.MaxIdle {
+		es = append(es, fmt.Errorf("%s: LDAP Pool Max Idle must be greater than or equal to LDAP Pool Min Idle.", name("ldap-pool-max-idle")))
+	}
+	switch c.Groups.Nested {
+	case "", "none", "in_chain", "recursive":
+	default:
+		es = append(es, fmt.Errorf("%s: LDAP Group Nested must be \"none\", \"in_chain\", or \"recursive\".", name("ldap-group-nested")))
+	}
+	if c.Groups.Enabled() && c.Groups.Nested == "in_chain" && c.Groups.BaseDN == "" {
+		es = append(es, fmt.Errorf("%s: LDAP Group Base DN is required when use in_chain.", name("ldap-group-base-dn")))
+	}
+	switch c.PasswordChange {
+	case "", "active-directory", "password-modify":
+	default:
+		es = append(es, fmt.Errorf("%s: LDAP Password Change must be \"active-directory\" or \"password-modify\".", name("ldap-password-change")))
+	}
+	if c.TLSCert != "" && c.TLSKey == "" {
+		es = append(es, fmt.Errorf("%s: LDAP TLS Key is required when set LDAP TLS Cert.", name("ldap-tls-key")))
+	} else if c.TLSCert == "" && c.TLSKey != "" {
+		es = append(es, fmt.Errorf("%s: LDAP TLS Cert is required when set LDAP TLS Key.", name("ldap-tls-cert")))
+	}
+
+	return es
+}
+
+// UsersConfig is the settings for users file, that used instead of LDAP server.
+type UsersConfig struct {
+	File string `json:"file,omitempty" yaml:"file,omitempty" toml:"file,omitempty" flag:"users-file"`
+}
+
+type StorageConfig struct {
+	Type StorageType `json:"type"           yaml:"type"           toml:"type"           flag:"storage-type"`
+	File string      `json:"file,omitempty" yaml:"file,omitempty" toml:"file,omitempty" flag:"storage-file"`
+}
+
+type AdminConfig struct {
+	Path     string `json:"path"               yaml:"path"               toml:"path"               flag:"admin-path"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty" toml:"username,omitempty" flag:"admin-username"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty" toml:"password,omitempty" flag:"admin-password"`
+}
+
+func (c AdminConfig) Enabled() bool {
+	return c.Username != "" && c.Password != ""
 }
 
 type TemplateConfig struct {
-	LoginPage  string `json:"login_page,omitempty"  yaml:"login_page,omitempty"  toml:"login_page,omitempty"  flag:"login-page"`
-	LogoutPage string `json:"logout_page,omitempty" yaml:"logout_page,omitempty" toml:"logout_page,omitempty" flag:"logout-page"`
-	ErrorPage  string `json:"error_page,omitempty"  yaml:"error_page,omitempty"  toml:"error_page,omitempty"  flag:"error-page"`
+	LoginPage    string `json:"login_page,omitempty"    yaml:"login_page,omitempty"    toml:"login_page,omitempty"    flag:"login-page"`
+	ConsentPage  string `json:"consent_page,omitempty"  yaml:"consent_page,omitempty"  toml:"consent_page,omitempty"  flag:"consent-page"`
+	LogoutPage   string `json:"logout_page,omitempty"   yaml:"logout_page,omitempty"   toml:"logout_page,omitempty"   flag:"logout-page"`
+	ErrorPage    string `json:"error_page,omitempty"    yaml:"error_page,omitempty"    toml:"error_page,omitempty"    flag:"error-page"`
+	MFAPage      string `json:"mfa_page,omitempty"      yaml:"mfa_page,omitempty"      toml:"mfa_page,omitempty"      flag:"mfa-page"`
+	WebAuthnPage string `json:"webauthn_page,omitempty" yaml:"webauthn_page,omitempty" toml:"webauthn_page,omitempty" flag:"webauthn-page"`
+	PasswordPage string `json:"password_page,omitempty" yaml:"password_page,omitempty" toml:"password_page,omitempty" flag:"password-page"`
 }
 
 type Config struct {
-	Issuer    *URL            `json:"issuer"              yaml:"issuer"              toml:"issuer"             flag:"issuer"`
-	Listen    *TCPAddr        `json:"listen,omitempty"    yaml:"listen,omitempty"    toml:"listen,omitempty"   flag:"listen"`
-	SignKey   string          `json:"sign_key,omitempty"  yaml:"sign_key,omitempty"  toml:"sign_key,omitempty" flag:"sign-key"`
-	TLS       TLSConfig       `json:"tls,omitempty"       yaml:"tls,omitempty"       toml:"tls,omitempty"`
-	LDAP      LDAPConfig      `json:"ldap"                yaml:"ldap"                toml:"ldap"`
-	Expire    ExpireConfig    `json:"expire"              yaml:"expire"              toml:"expire"`
-	Endpoints EndpointConfig  `json:"endpoint"            yaml:"endpoint"            toml:"endpoint"`
-	Scopes    ScopeConfig     `json:"scope,omitempty"     yaml:"scope,omitempty"     toml:"scope,omitempty"`
-	Clients   ClientConfigSet `json:"client,omitempty"    yaml:"client,omitempty"    toml:"client,omitempty"`
-	Metrics   MetricsConfig   `json:"metrics"             yaml:"metrics"             toml:"metrics"`
-	Templates TemplateConfig  `json:"template,omitempty"  yaml:"template,omitempty"  toml:"template,omitempty"`
+	Issuer          *URL              `json:"issuer"                     yaml:"issuer"                     toml:"issuer"                     flag:"issuer"`
+	Listen          *TCPAddr          `json:"listen,omitempty"           yaml:"listen,omitempty"           toml:"listen,omitempty"           flag:"listen"`
+	SignKey         string            `json:"sign_key,omitempty"         yaml:"sign_key,omitempty"         toml:"sign_key,omitempty"         flag:"sign-key"`
+	TLS             TLSConfig         `json:"tls,omitempty"              yaml:"tls,omitempty"              toml:"tls,omitempty"`
+	LDAP            LDAPConfig        `json:"ldap"                       yaml:"ldap"                       toml:"ldap"`
+	Directories     []DirectoryConfig `json:"directory,omitempty"        yaml:"directory,omitempty"        toml:"directory,omitempty"`
+	Users           UsersConfig       `json:"users,omitempty"            yaml:"users,omitempty"            toml:"users,omitempty"`
+	DirectoryPicker bool              `json:"directory_picker,omitempty" yaml:"directory_picker,omitempty" toml:"directory_picker,omitempty" flag:"directory-picker"`
+	Upstreams       []UpstreamConfig  `json:"upstream,omitempty"         yaml:"upstream,omitempty"         toml:"upstream,omitempty"`
+	MFA             MFAConfig         `json:"mfa,omitempty"              yaml:"mfa,omitempty"              toml:"mfa,omitempty"`
+	WebAuthn        WebAuthnConfig    `json:"webauthn,omitempty"         yaml:"webauthn,omitempty"         toml:"webauthn,omitempty"`
+	Lockout         LockoutConfig     `json:"lockout"                    yaml:"lockout"                    toml:"lockout"`
+	Expire          ExpireConfig      `json:"expire"                     yaml:"expire"                     toml:"expire"`
+	Endpoints       EndpointConfig    `json:"endpoint"                   yaml:"endpoint"                   toml:"endpoint"`
+	Scopes          ScopeConfig       `json:"scope,omitempty"            yaml:"scope,omitempty"            toml:"scope,omitempty"`
+	Clients         ClientConfigSet   `json:"client,omitempty"           yaml:"client,omitempty"           toml:"client,omitempty"`
+	Storage         StorageConfig     `json:"storage"                    yaml:"storage"                    toml:"storage"`
+	Metrics         MetricsConfig     `json:"metrics"                    yaml:"metrics"                    toml:"metrics"`
+	Admin           AdminConfig       `json:"admin"                      yaml:"admin"                      toml:"admin"`
+	Templates       TemplateConfig    `json:"template,omitempty"         yaml:"template,omitempty"         toml:"template,omitempty"`
 }
 
 func TakeOptions(prefix string, typ reflect.Type, result map[string]string) {
@@ -170,25 +577,27 @@ func (c *Config) unmarshal(vip *viper.Viper) error {
 		c.Scopes = DefaultScopes
 	}
 
-	if c.LDAP.Server != nil {
-		if c.LDAP.User == "" {
-			c.LDAP.User = c.LDAP.Server.User.Username()
-		}
-		if c.LDAP.Password == "" {
-			c.LDAP.Password, _ = c.LDAP.Server.User.Password()
-		}
-		c.LDAP.Server.User = nil
+	c.LDAP.complete()
+	for i := range c.Directories {
+		c.Directories[i].LDAP.inherit(c.LDAP)
+		c.Directories[i].LDAP.complete()
 	}
 
-	if c.LDAP.BaseDN == "" {
-		c.LDAP.BaseDN, _ = GetDCByDN(c.LDAP.User)
+	for i := range c.Upstreams {
+		if c.Upstreams[i].DisplayName == "" {
+			c.Upstreams[i].DisplayName = c.Upstreams[i].Name
+		}
+		if len(c.Upstreams[i].RequestScopes) == 0 {
+			c.Upstreams[i].RequestScopes = DefaultUpstreamRequestScopes
+		}
 	}
 
 	for id, client := range c.Clients {
 		if client.Name == "" {
 			client.Name = id
-			c.Clients[id] = client
 		}
+		client.SAML.complete()
+		c.Clients[id] = client
 	}
 
 	return nil
@@ -257,17 +666,63 @@ func (c *Config) Validate() error {
 		es = append(es, errors.New("--issuer: Please set https URL for Issuer URL when use TLS."))
 	}
 
-	if c.LDAP.Server.String() == "" {
-		es = append(es, errors.New("--ldap: LDAP Server address is required."))
+	if c.Users.File != "" {
+		if c.LDAP.Server.String() != "" || len(c.LDAP.Servers) > 0 || c.LDAP.SRV != "" || c.HasDirectories() {
+			es = append(es, errors.New("--users-file: Can't use both of Users File and LDAP Server or directories."))
+		}
+	} else if !c.HasDirectories() {
+		es = append(es, c.LDAP.validate(func(flag string) string { return "--" + flag })...)
+	} else if c.LDAP.Server.String() != "" || len(c.LDAP.Servers) > 0 || c.LDAP.SRV != "" {
+		es = append(es, errors.New("--ldap: Can't use both of LDAP Server and directories."))
 	}
-	if c.LDAP.User == "" {
-		es = append(es, errors.New("--ldap-user: LDAP User is required."))
+	seenDirectories := make(map[string]bool)
+	for i, d := range c.Directories {
+		if d.Name == "" {
+			es = append(es, fmt.Errorf("directory.%d.name: Directory Name is required.", i))
+			continue
+		}
+		if strings.Contains(d.Name, DirectorySeparator) {
+			es = append(es, fmt.Errorf("directory.%s.name: Directory Name can't include \"%s\".", d.Name, DirectorySeparator))
+		}
+		if seenDirectories[d.Name] {
+			es = append(es, fmt.Errorf("directory.%s.name: Directory Name is duplicated.", d.Name))
+		}
+		seenDirectories[d.Name] = true
+
+		es = append(es, d.LDAP.validate(directoryOptionNames("directory."+d.Name+".ldap."))...)
+		es = append(es, d.Scopes.validate("directory."+d.Name+".scope.")...)
 	}
-	if c.LDAP.Password == "" {
-		es = append(es, errors.New("--ldap-password: LDAP Password is required."))
+	if c.DirectoryPicker && !c.HasDirectories() {
+		es = append(es, errors.New("--directory-picker: Directories are required to use Directory Picker."))
 	}
-	if c.LDAP.BaseDN == "" {
-		es = append(es, errors.New("--ldap-base-dn: LDAP Base DN is required if using user that non DN style."))
+
+	for i, u := range c.Upstreams {
+		if u.Name == "" {
+			es = append(es, fmt.Errorf("upstream.%d.name: Upstream Name is required.", i))
+			continue
+		}
+		if strings.Contains(u.Name, DirectorySeparator) {
+			es = append(es, fmt.Errorf("upstream.%s.name: Upstream Name can't include \"%s\".", u.Name, DirectorySeparator))
+		}
+		if seenDirectories[u.Name] {
+			es = append(es, fmt.Errorf("upstream.%s.name: Upstream Name is duplicated with other upstream or directory.", u.Name))
+		}
+		seenDirectories[u.Name] = true
+
+		if u.Issuer.String() == "" {
+			es = append(es, fmt.Errorf("upstream.%s.issuer: Upstream Issuer is required.", u.Name))
+		} else if !u.Issuer.URL().IsAbs() {
+			es = append(es, fmt.Errorf("upstream.%s.issuer: Upstream Issuer must be absolute URL.", u.Name))
+		}
+		if u.ClientID == "" {
+			es = append(es, fmt.Errorf("upstream.%s.client_id: Upstream Client ID is required.", u.Name))
+		}
+		if (u.Link.Claim == "") != (u.Link.Attribute == "") {
+			es = append(es, fmt.Errorf("upstream.%s.link: Both of Link Claim and Link Attribute are required to link users.", u.Name))
+		} else if u.Link.Required && !u.Link.Enabled() {
+			es = append(es, fmt.Errorf("upstream.%s.link.required: Link Claim and Link Attribute are required when set Link Required.", u.Name))
+		}
+		es = append(es, u.Scopes.validate("upstream."+u.Name+".scope.")...)
 	}
 
 	if c.Expire.Login <= 0 {
@@ -289,6 +744,52 @@ func (c *Config) Validate() error {
 		es = append(es, errors.New("--metrics-password: Metrics Password is required when set Metrics Username."))
 	}
 
+	if c.Storage.Type == STORAGE_TYPE_FILE && c.Storage.File == "" {
+		es = append(es, errors.New("--storage-file: Storage File is required when use file storage."))
+	}
+
+	if c.Admin.Username != "" && c.Admin.Password == "" {
+		es = append(es, errors.New("--admin-password: Admin Password is required when set Admin Username."))
+	} else if c.Admin.Username == "" && c.Admin.Password != "" {
+		es = append(es, errors.New("--admin-username: Admin Username is required when set Admin Password."))
+	}
+	if c.Admin.Enabled() && c.Admin.Path == "" {
+		es = append(es, errors.New("--admin-path: Admin Path can't set empty."))
+	}
+
+	es = append(es, c.validateMFA()...)
+	es = append(es, c.validateWebAuthn()...)
+	es = append(es, c.validateLockout()...)
+
+	es = append(es, c.Scopes.validate("scope.")...)
+
+	var clientIDs []string
+	for id := range c.Clients {
+		clientIDs = append(clientIDs, id)
+	}
+	sort.Strings(clientIDs)
+	for _, id := range clientIDs {
+		for _, scope := range c.Clients[id].AllowedScopes {
+			if _, ok := c.Scopes[scope]; !ok && scope != "openid" {
+				es = append(es, fmt.Errorf("client.%s.allowed_scopes: Scope \"%s\" is not defined.", id, scope))
+			}
+		}
+		if f := c.Clients[id].AccessFilter(); f != "" {
+			if _, err := ldap.CompileFilter(f); err != nil {
+				es = append(es, fmt.Errorf("client.%s.required_filter: Invalid LDAP filter: %s.", id, err))
+			}
+		}
+		if c.Clients[id].RequireMFA && !c.MFA.Enabled() && !c.WebAuthn.Enabled {
+			es = append(es, fmt.Errorf("client.%s.require_mfa: MFA TOTP Attribute, MFA Enroll, or WebAuthn is required to require MFA.", id))
+		}
+		if saml := c.Clients[id].SAML; saml.Enabled() {
+			es = append(es, saml.validate("client."+id+".saml.")...)
+			if other, _ := c.Clients.SAMLClient(saml.EntityID); other != id {
+				es = append(es, fmt.Errorf("client.%s.saml.entity_id: SAML Entity ID is duplicated with other client.", id))
+			}
+		}
+	}
+
 	if len(es) > 0 {
 		return es
 	}
@@ -302,6 +803,9 @@ type ResolvedEndpointPaths struct {
 	Userinfo            string
 	Jwks                string
 	Logout              string
+	UpstreamCallback    string
+	SAMLMetadata        string
+	SAMLSSO             string
 }
 
 func (c *Config) EndpointPaths() ResolvedEndpointPaths {
@@ -312,6 +816,9 @@ func (c *Config) EndpointPaths() ResolvedEndpointPaths {
 		Userinfo:            path.Join(c.Issuer.Path, c.Endpoints.Userinfo),
 		Jwks:                path.Join(c.Issuer.Path, c.Endpoints.Jwks),
 		Logout:              path.Join(c.Issuer.Path, c.Endpoints.Logout),
+		UpstreamCallback:    path.Join(c.Issuer.Path, c.Endpoints.Authz, "/upstream"),
+		SAMLMetadata:        path.Join(c.Issuer.Path, c.Endpoints.SAML, "/metadata"),
+		SAMLSSO:             path.Join(c.Issuer.Path, c.Endpoints.SAML, "/sso"),
 	}
 }
 
@@ -370,6 +877,7 @@ func (c *Config) OpenIDConfiguration() OpenIDConfiguration {
 			"iat",
 			"typ",
 			"auth_time",
+			"amr",
 			"nonce",
 			"c_hash",
 			"at_hash",
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// DirectorySeparator separates the directory name and the user's subject, like "corp:jsmith".
+const DirectorySeparator = ":"
+
+// DirectoryConfig is a named user directory.
+//
+// The name becomes the prefix of subjects of users in the directory, so identities in different directories never collide.
+type DirectoryConfig struct {
+	Name   string      `json:"name"            yaml:"name"            toml:"name"`
+	LDAP   LDAPConfig  `json:"ldap"            yaml:"ldap"            toml:"ldap"`
+	Scopes ScopeConfig `json:"scope,omitempty" yaml:"scope,omitempty" toml:"scope,omitempty"`
+}
+
+// Subject makes the subject of the user in this directory.
+func (d DirectoryConfig) Subject(subject string) string {
+	if d.Name == "" {
+		return subject
+	}
+	return d.Name + DirectorySeparator + subject
+}
+
+// complete fills values that can be derived from other fields, like credentials in the server URL.
+func (c *LDAPConfig) complete() {
+	if c.Server != nil {
+		if c.User == "" {
+			c.User = c.Server.User.Username()
+		}
+		if c.Password == "" {
+			c.Password, _ = c.Server.User.Password()
+		}
+		c.Server.User = nil
+	}
+
+	if c.BaseDN == "" {
+		c.BaseDN, _ = GetDCByDN(c.User)
+	}
+
+	if c.Groups.BaseDN == "" {
+		c.Groups.BaseDN, _ = GetDCByDN(c.BaseDN)
+	}
+}
+
+// inherit fills options that are not specific to a server from the base config.
+func (c *LDAPConfig) inherit(base LDAPConfig) {
+	if c.IDAttribute == "" {
+		c.IDAttribute = base.IDAttribute
+	}
+	if c.Strategy == "" {
+		c.Strategy = base.Strategy
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = base.MaxBackoff
+	}
+	if c.TLSMinVersion == 0 {
+		c.TLSMinVersion = base.TLSMinVersion
+	}
+	if c.Pool == (PoolConfig{}) {
+		c.Pool = base.Pool
+	}
+}
+
+// HasDirectories reports multiple named directories are configured instead of the single LDAP.
+func (c *Config) HasDirectories() bool {
+	return len(c.Directories) > 0
+}
+
+// PasswordChangeEnabled reports users can change their password in the LDAP server or any of directories.
+func (c *Config) PasswordChangeEnabled() bool {
+	if c.LDAP.PasswordChange != "" {
+		return true
+	}
+	for _, d := range c.Directories {
+		if d.LDAP.PasswordChange != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// DirectoryFor returns the directory that the subject belongs to, and the subject inside of the directory.
+//
+// If the subject belongs to an upstream, it returns a directory that made from the upstream.
+// If no directories configured, it returns an unnamed directory made from LDAP and Scopes.
+// Scopes of the returned directory are merged with the global Scopes.
+func (c *Config) DirectoryFor(subject string) (DirectoryConfig, string, bool) {
+	if u, sub, ok := c.UpstreamFor(subject); ok {
+		return u.directory(), sub, true
+	}
+
+	if !c.HasDirectories() {
+		return DirectoryConfig{LDAP: c.LDAP, Scopes: c.Scopes}, subject, true
+	}
+
+	idx := strings.Index(subject, DirectorySeparator)
+	if idx < 0 {
+		return DirectoryConfig{}, "", false
+	}
+
+	for _, d := range c.Directories {
+		if d.Name == subject[:idx] {
+			scopes := make(ScopeConfig)
+			for name, claims := range c.Scopes {
+				scopes[name] = claims
+			}
+			for name, claims := range d.Scopes {
+				scopes[name] = claims
+			}
+			d.Scopes = scopes
+
+			return d, subject[idx+len(DirectorySeparator):], true
+		}
+	}
+
+	return DirectoryConfig{}, "", false
+}
+
+// NormalizeUsername strips the login domain from the username, using LoginDomains of the directory that the login is routed to.
+//
+// The directory is the picked one if directory is not empty, or the directory that has the domain of the username, same as routing of logins.
+func (c *Config) NormalizeUsername(directory, username string) string {
+	if !c.HasDirectories() {
+		return c.LDAP.NormalizeUsername(username)
+	}
+
+	username = strings.TrimSpace(username)
+	for _, d := range c.Directories {
+		if directory != "" && d.Name != directory {
+			continue
+		}
+		if normalized := d.LDAP.NormalizeUsername(username); normalized != username || directory != "" {
+			return normalized
+		}
+	}
+	return username
+}
+
+// DirectoryNames returns names of directories in the configured order.
+func (c *Config) DirectoryNames() []string {
+	names := make([]string, len(c.Directories))
+	for i, d := range c.Directories {
+		names[i] = d.Name
+	}
+	return names
+}
+
+// directoryOptionNames returns a function to convert flag name of LDAPConfig to the option name in the directory.
+func directoryOptionNames(prefix string) func(string) string {
+	options := map[string]string{}
+	TakeOptions(prefix, reflect.TypeOf(LDAPConfig{}), options)
+
+	names := make(map[string]string)
+	for key, flag := range options {
+		names[flag] = key
+	}
+
+	return func(flag string) string {
+		return names[flag]
+	}
+}
@@ -25,3 +25,15 @@ func GetDCByDN(dn string) (string, error) {
 
 	return strings.Join(result, ","), nil
 }
+
+// GetCNByDN returns value of the first RDN, like "admin" of "CN=admin,OU=group,DC=example,DC=local".
+func GetCNByDN(dn string) (string, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return "", err
+	}
+	if len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return "", fmt.Errorf("empty DN")
+	}
+	return parsed.RDNs[0].Attributes[0].Value, nil
+}
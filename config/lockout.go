@@ -0,0 +1,46 @@
+package config
+
+import (
+	"errors"
+)
+
+// LockoutConfig is the settings to protect users from password guessing.
+//
+// Each username has to wait Backoff after a failed login, that doubles on each failure up to MaxBackoff.
+// Usernames are locked out after MaxAttempts failures, and IP addresses are locked out after IPMaxAttempts failures.
+// Failures are forgotten after Duration since the last failure.
+type LockoutConfig struct {
+	MaxAttempts   int      `json:"max_attempts"    yaml:"max_attempts"    toml:"max_attempts"    flag:"lockout-max-attempts"`
+	IPMaxAttempts int      `json:"ip_max_attempts" yaml:"ip_max_attempts" toml:"ip_max_attempts" flag:"lockout-ip-max-attempts"`
+	Duration      Duration `json:"duration"        yaml:"duration"        toml:"duration"        flag:"lockout-duration"`
+	Backoff       Duration `json:"backoff"         yaml:"backoff"         toml:"backoff"         flag:"lockout-backoff"`
+	MaxBackoff    Duration `json:"max_backoff"     yaml:"max_backoff"     toml:"max_backoff"     flag:"lockout-max-backoff"`
+}
+
+// Enabled reports failed logins are counted for usernames or IP addresses.
+// Usernames are counted for Backoff even if MaxAttempts is 0.
+func (c LockoutConfig) Enabled() bool {
+	return c.MaxAttempts > 0 || c.IPMaxAttempts > 0 || c.Backoff > 0
+}
+
+func (c *Config) validateLockout() []error {
+	var es []error
+
+	if c.Lockout.MaxAttempts < 0 {
+		es = append(es, errors.New("--lockout-max-attempts: Lockout Max Attempts can't set less than 0."))
+	}
+	if c.Lockout.IPMaxAttempts < 0 {
+		es = append(es, errors.New("--lockout-ip-max-attempts: Lockout IP Max Attempts can't set less than 0."))
+	}
+	if c.Lockout.Enabled() && c.Lockout.Duration <= 0 {
+		es = append(es, errors.New("--lockout-duration: Lockout Duration must be greater than 0."))
+	}
+	if c.Lockout.Backoff < 0 {
+		es = append(es, errors.New("--lockout-backoff: Lockout Backoff can't set less than 0."))
+	}
+	if c.Lockout.MaxBackoff < 0 {
+		es = append(es, errors.New("--lockout-max-backoff: Lockout Max Backoff can't set less than 0."))
+	}
+
+	return es
+}
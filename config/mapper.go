@@ -1,8 +1,13 @@
 package config
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type ClaimType string
@@ -12,6 +17,10 @@ const (
 	CLAIM_TYPE_STRING_LIST           = "[]string"
 	CLAIM_TYPE_NUMBER                = "number"
 	CLAIM_TYPE_NUMBER_LIST           = "[]number"
+	CLAIM_TYPE_BOOL                  = "bool"
+	CLAIM_TYPE_UNIX_TIME             = "unix_time"
+	CLAIM_TYPE_UUID                  = "uuid"
+	CLAIM_TYPE_OBJECT                = "object"
 )
 
 func (t ClaimType) String() string {
@@ -22,7 +31,7 @@ func (t *ClaimType) UnmarshalText(text []byte) error {
 	switch ClaimType(string(text)) {
 	case CLAIM_TYPE_STRING, "":
 		*t = CLAIM_TYPE_STRING
-	case CLAIM_TYPE_STRING_LIST, CLAIM_TYPE_NUMBER, CLAIM_TYPE_NUMBER_LIST:
+	case CLAIM_TYPE_STRING_LIST, CLAIM_TYPE_NUMBER, CLAIM_TYPE_NUMBER_LIST, CLAIM_TYPE_BOOL, CLAIM_TYPE_UNIX_TIME, CLAIM_TYPE_UUID, CLAIM_TYPE_OBJECT:
 		*t = ClaimType(string(text))
 	default:
 		return fmt.Errorf("unsupported claim type: %#v", string(text))
@@ -38,6 +47,63 @@ func parseNumberList(values []string) []float64 {
 	return result
 }
 
+// fileTimeEpoch is the difference between 1601-01-01 and 1970-01-01 in seconds.
+const fileTimeEpoch = 11644473600
+
+// parseUnixTime parses GeneralizedTime like "20210102150405Z" or Windows FILETIME like "132537600000000000".
+// It returns false if the value is not a time, or FILETIME means never like 0 or 0x7FFFFFFFFFFFFFFF.
+func parseUnixTime(value string) (int64, bool) {
+	if t, err := time.Parse("20060102150405Z0700", value); err == nil {
+		return t.Unix(), true
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 || n == math.MaxInt64 {
+		return 0, false
+	}
+	return n/10000000 - fileTimeEpoch, true
+}
+
+// FormatUUID formats binary GUID of ActiveDirectory, or normalizes textual UUID like entryUUID of OpenLDAP.
+func FormatUUID(value string) string {
+	if len(value) != 16 {
+		return strings.ToLower(value)
+	}
+
+	b := []byte(value)
+
+	// the first 3 parts of GUID are little endian.
+	var buf [16]byte
+	binary.BigEndian.PutUint32(buf[0:4], binary.LittleEndian.Uint32(b[0:4]))
+	binary.BigEndian.PutUint16(buf[4:6], binary.LittleEndian.Uint16(b[4:6]))
+	binary.BigEndian.PutUint16(buf[6:8], binary.LittleEndian.Uint16(b[6:8]))
+	copy(buf[8:], b[8:])
+
+	h := hex.EncodeToString(buf[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// ParseGUID parses UUID string that formatted by FormatUUID, and returns binary GUID of ActiveDirectory.
+func ParseGUID(uuid string) ([]byte, error) {
+	raw, err := hex.DecodeString(strings.ReplaceAll(uuid, "-", ""))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 16 {
+		return nil, fmt.Errorf("invalid UUID: %#v", uuid)
+	}
+
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint32(b[0:4], binary.BigEndian.Uint32(raw[0:4]))
+	binary.LittleEndian.PutUint16(b[4:6], binary.BigEndian.Uint16(raw[4:6]))
+	binary.LittleEndian.PutUint16(b[6:8], binary.BigEndian.Uint16(raw[6:8]))
+	copy(b[8:], raw[8:])
+	return b, nil
+}
+
+// Convert converts values of attribute as this type.
+//
+// CLAIM_TYPE_OBJECT can't convert by this method, because it needs claims of members. Please use MappingClaims.
 func (t ClaimType) Convert(values []string) interface{} {
 	switch t {
 	case CLAIM_TYPE_STRING:
@@ -59,17 +125,59 @@ func (t ClaimType) Convert(values []string) interface{} {
 	case CLAIM_TYPE_NUMBER_LIST:
 		return parseNumberList(values)
 
+	case CLAIM_TYPE_BOOL:
+		if len(values) == 0 {
+			return false
+		}
+		result, _ := strconv.ParseBool(values[0])
+		return result
+
+	case CLAIM_TYPE_UNIX_TIME:
+		if len(values) == 0 {
+			return nil
+		}
+		if result, ok := parseUnixTime(values[0]); ok {
+			return result
+		}
+		return nil
+
+	case CLAIM_TYPE_UUID:
+		if len(values) == 0 {
+			return ""
+		}
+		return FormatUUID(values[0])
+
 	default:
 		return nil
 	}
 }
 
+// MappingClaims makes claims from attributes.
+//
+// Each claim in maps is made by template, transforms, and type conversion in this order.
+// A claim is omitted if no attribute for the claim is included in attrs.
 func MappingClaims(attrs map[string][]string, maps map[string]ClaimConfig) map[string]interface{} {
 	result := make(map[string]interface{})
 
-	for name, values := range attrs {
-		conf := maps[name]
-		result[conf.Claim] = conf.Type.Convert(values)
+	for _, conf := range maps {
+		if conf.Type == CLAIM_TYPE_OBJECT {
+			members := make(map[string]ClaimConfig)
+			for _, c := range conf.Claims {
+				members[c.Claim] = c
+			}
+			if obj := MappingClaims(attrs, members); len(obj) > 0 {
+				result[conf.Claim] = obj
+			}
+			continue
+		}
+
+		if values, ok := conf.Values(attrs); ok {
+			typ := conf.Type
+			if typ == "" {
+				typ = CLAIM_TYPE_STRING
+			}
+			result[conf.Claim] = typ.Convert(values)
+		}
 	}
 
 	return result
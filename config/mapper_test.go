@@ -21,6 +21,17 @@ func TestClaimType(t *testing.T) {
 		{"[]number", "", []string{"hello", "world"}, []float64{0, 0}},
 		{"number", "", []string{"12.34", "56.78"}, float64(12.34)},
 		{"[]number", "", []string{"12.34", "56.78"}, []float64{12.34, 56.78}},
+		{"bool", "", []string{"TRUE"}, true},
+		{"bool", "", []string{"FALSE"}, false},
+		{"bool", "", nil, false},
+		{"unix_time", "", []string{"20210102030405Z"}, int64(1609556645)},
+		{"unix_time", "", []string{"20210102120405.0+0900"}, int64(1609556645)},
+		{"unix_time", "", []string{"132540302450000000"}, int64(1609556645)},
+		{"unix_time", "", []string{"0"}, nil},
+		{"unix_time", "", []string{"9223372036854775807"}, nil},
+		{"unix_time", "", []string{"hello"}, nil},
+		{"uuid", "", []string{"\x78\x56\x34\x12\x34\x12\x78\x56\x12\x34\x56\x78\x9a\xbc\xde\xf0"}, "12345678-1234-5678-1234-56789abcdef0"},
+		{"uuid", "", []string{"1234ABCD-1234-5678-1234-56789ABCDEF0"}, "1234abcd-1234-5678-1234-56789abcdef0"},
 
 		{
 			Type:       "hoge",
@@ -147,3 +158,43 @@ func TestMappingClaims(t *testing.T) {
 		}
 	}
 }
+
+func TestMappingClaims_Object(t *testing.T) {
+	maps := map[string]config.ClaimConfig{
+		"address": {
+			Claim: "address",
+			Type:  config.CLAIM_TYPE_OBJECT,
+			Claims: []config.ClaimConfig{
+				{Claim: "street_address", Attribute: "streetAddress"},
+				{Claim: "locality", Attribute: "l"},
+				{Claim: "postal_code", Attribute: "postalCode"},
+				{Claim: "country", Attribute: "co"},
+			},
+		},
+	}
+
+	if attrs := maps["address"].Attributes(); !SameStringSet(attrs, []string{"streetAddress", "l", "postalCode", "co"}) {
+		t.Errorf("unexpected attributes: %#v", attrs)
+	}
+
+	result := config.MappingClaims(map[string][]string{
+		"streetAddress": {"1-2-3 Somewhere"},
+		"l":             {"Chiyoda"},
+		"co":            {"Japan"},
+	}, maps)
+	expect := map[string]interface{}{
+		"address": map[string]interface{}{
+			"street_address": "1-2-3 Somewhere",
+			"locality":       "Chiyoda",
+			"country":        "Japan",
+		},
+	}
+	if !reflect.DeepEqual(result, expect) {
+		t.Errorf("unexpected mapping result:\nexpected: %#v\nbut got: %#v", expect, result)
+	}
+
+	result = config.MappingClaims(map[string][]string{"mail": {"macrat@example.com"}}, maps)
+	if len(result) != 0 {
+		t.Errorf("empty object should be omitted but got %#v", result)
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// MFAConfig is the settings of TOTP second factor for password logins.
+//
+// Users who have TOTP secret in the LDAP attribute or enrolled in the storage are always asked a code.
+// Other users are asked to enroll if required by the client or groups.
+type MFAConfig struct {
+	TOTPAttribute  string   `json:"totp_attribute,omitempty"  yaml:"totp_attribute,omitempty"  toml:"totp_attribute,omitempty"  flag:"mfa-totp-attribute"`
+	Enroll         bool     `json:"enroll,omitempty"          yaml:"enroll,omitempty"          toml:"enroll,omitempty"          flag:"mfa-enroll"`
+	RequiredGroups []string `json:"required_groups,omitempty" yaml:"required_groups,omitempty" toml:"required_groups,omitempty" flag:"mfa-required-groups"`
+	MaxAttempts    int      `json:"max_attempts"              yaml:"max_attempts"              toml:"max_attempts"              flag:"mfa-max-attempts"`
+}
+
+// Enabled reports users can use TOTP.
+func (c MFAConfig) Enabled() bool {
+	return c.TOTPAttribute != "" || c.Enroll
+}
+
+// RequiredFilter returns LDAP filter for users who have to use TOTP, or empty string if no group requires it.
+func (c MFAConfig) RequiredFilter() string {
+	if len(c.RequiredGroups) == 0 {
+		return ""
+	}
+
+	groups := ""
+	for _, g := range c.RequiredGroups {
+		groups += fmt.Sprintf("(memberOf=%s)", ldap.EscapeFilter(g))
+	}
+	return "(|" + groups + ")"
+}
+
+func (c *Config) validateMFA() []error {
+	var es []error
+
+	if c.MFA.Enabled() && c.MFA.MaxAttempts <= 0 {
+		es = append(es, errors.New("--mfa-max-attempts: MFA Max Attempts can't set 0 or less."))
+	}
+	if len(c.MFA.RequiredGroups) > 0 && !c.MFA.Enabled() {
+		es = append(es, errors.New("--mfa-required-groups: MFA TOTP Attribute or MFA Enroll is required to require MFA."))
+	}
+
+	return es
+}
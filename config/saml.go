@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/macrat/lauth/saml"
+)
+
+// SAMLConfig is the settings to use the client as a SAML 2.0 Service Provider.
+//
+// Attributes of the assertion are made from the scopes in AllowedScopes of the client, or all scopes if not set.
+type SAMLConfig struct {
+	EntityID       string            `json:"entity_id,omitempty"       yaml:"entity_id,omitempty"       toml:"entity_id,omitempty"`
+	ACSURL         string            `json:"acs_url,omitempty"         yaml:"acs_url,omitempty"         toml:"acs_url,omitempty"`
+	NameIDClaim    string            `json:"name_id_claim,omitempty"   yaml:"name_id_claim,omitempty"   toml:"name_id_claim,omitempty"`
+	NameIDFormat   string            `json:"name_id_format,omitempty"  yaml:"name_id_format,omitempty"  toml:"name_id_format,omitempty"`
+	AttributeNames map[string]string `json:"attribute_names,omitempty" yaml:"attribute_names,omitempty" toml:"attribute_names,omitempty"`
+}
+
+// Enabled reports the client is a SAML Service Provider.
+func (c SAMLConfig) Enabled() bool {
+	return c.EntityID != ""
+}
+
+// complete sets default NameIDFormat. The persistent format is used for subjects.
+func (c *SAMLConfig) complete() {
+	if !c.Enabled() || c.NameIDFormat != "" {
+		return
+	}
+	if c.NameIDClaim == "" {
+		c.NameIDFormat = saml.NameIDFormatPersistent
+	} else {
+		c.NameIDFormat = saml.NameIDFormatUnspecified
+	}
+}
+
+// AttributeName returns the name of SAML attribute for the claim.
+func (c SAMLConfig) AttributeName(claim string) string {
+	if name, ok := c.AttributeNames[claim]; ok {
+		return name
+	}
+	return claim
+}
+
+// SAMLClient returns ID of the client that registered as the SAML Service Provider of entityID.
+func (cs ClientConfigSet) SAMLClient(entityID string) (string, bool) {
+	for id, c := range cs {
+		if c.SAML.Enabled() && c.SAML.EntityID == entityID {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// HasSAMLClients reports any client is a SAML Service Provider.
+func (c *Config) HasSAMLClients() bool {
+	for _, client := range c.Clients {
+		if client.SAML.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// SAMLScopes returns the scopes to make attributes of the assertion for the client.
+func (c *Config) SAMLScopes(clientID string) []string {
+	if allowed := c.Clients[clientID].AllowedScopes; len(allowed) > 0 {
+		return allowed
+	}
+
+	var scopes []string
+	for s := range c.Scopes {
+		scopes = append(scopes, s)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+func (c SAMLConfig) validate(prefix string) []error {
+	var es []error
+
+	if u, err := url.Parse(c.ACSURL); c.ACSURL == "" {
+		es = append(es, fmt.Errorf("%sacs_url: SAML ACS URL is required.", prefix))
+	} else if err != nil || !u.IsAbs() {
+		es = append(es, fmt.Errorf("%sacs_url: SAML ACS URL must be absolute URL.", prefix))
+	}
+
+	return es
+}
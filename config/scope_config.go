@@ -1,5 +1,9 @@
 package config
 
+import (
+	"sort"
+)
+
 func (sc ScopeConfig) ScopeNames() []string {
 	var ss []string
 	for scope := range sc {
@@ -18,13 +22,21 @@ func (sc ScopeConfig) AllClaims() []string {
 	return claims
 }
 
+func (sc ScopeConfig) ClaimsOf(scope string) []string {
+	var claims []string
+	for _, claim := range sc[scope] {
+		claims = append(claims, claim.Claim)
+	}
+	return claims
+}
+
 func (sc ScopeConfig) AttributesFor(scopes []string) []string {
 	var claims []string
 
 	for _, scopeName := range scopes {
 		if scope, ok := sc[scopeName]; ok {
 			for _, x := range scope {
-				claims = append(claims, x.Attribute)
+				claims = append(claims, x.Attributes()...)
 			}
 		}
 	}
@@ -38,10 +50,25 @@ func (sc ScopeConfig) ClaimMapFor(scopes []string) map[string]ClaimConfig {
 	for _, scopeName := range scopes {
 		if scope, ok := sc[scopeName]; ok {
 			for _, x := range scope {
-				claims[x.Attribute] = x
+				claims[x.Claim] = x
 			}
 		}
 	}
 
 	return claims
 }
+
+// validate checks claims of all scopes. prefix is the path to the scope config for error messages like "scope.".
+func (sc ScopeConfig) validate(prefix string) []error {
+	var es []error
+
+	names := sc.ScopeNames()
+	sort.Strings(names)
+	for _, name := range names {
+		for _, claim := range sc[name] {
+			es = append(es, claim.validate(prefix+name+"."+claim.Claim)...)
+		}
+	}
+
+	return es
+}
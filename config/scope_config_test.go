@@ -35,6 +35,16 @@ func TestScopeConfig(t *testing.T) {
 		t.Errorf("AllClaims returns unexpected value: %#v", ss)
 	}
 
+	ss = conf.ClaimsOf("profile")
+	if !SameStringSet(ss, []string{"name", "given_name"}) {
+		t.Errorf("ClaimsOf returns unexpected value: %#v", ss)
+	}
+
+	ss = conf.ClaimsOf("unknown")
+	if len(ss) != 0 {
+		t.Errorf("ClaimsOf returns unexpected value: %#v", ss)
+	}
+
 	ss = conf.AttributesFor([]string{"profile"})
 	if !SameStringSet(ss, []string{"DisplayName", "GivenName"}) {
 		t.Errorf("AttributesFor returns unexpected value: %#v", ss)
@@ -47,9 +57,9 @@ func TestScopeConfig(t *testing.T) {
 
 	maps := conf.ClaimMapFor([]string{"profile", "email"})
 	if !reflect.DeepEqual(maps, map[string]config.ClaimConfig{
-		"DisplayName": {Claim: "name", Attribute: "DisplayName", Type: "string"},
-		"GivenName":   {Claim: "given_name", Attribute: "GivenName", Type: "string"},
-		"mail":        {Claim: "email", Attribute: "mail", Type: "string"},
+		"name":       {Claim: "name", Attribute: "DisplayName", Type: "string"},
+		"given_name": {Claim: "given_name", Attribute: "GivenName", Type: "string"},
+		"email":      {Claim: "email", Attribute: "mail", Type: "string"},
 	}) {
 		t.Errorf("ClaimMapFor returns unexpected value: %#v", maps)
 	}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"fmt"
+)
+
+type StorageType string
+
+const (
+	STORAGE_TYPE_MEMORY StorageType = "memory"
+	STORAGE_TYPE_FILE   StorageType = "file"
+)
+
+func (t StorageType) String() string {
+	return string(t)
+}
+
+func (t *StorageType) UnmarshalText(text []byte) error {
+	switch StorageType(string(text)) {
+	case STORAGE_TYPE_MEMORY, "":
+		*t = STORAGE_TYPE_MEMORY
+	case STORAGE_TYPE_FILE:
+		*t = STORAGE_TYPE_FILE
+	default:
+		return fmt.Errorf("unsupported storage type: %#v", string(text))
+	}
+	return nil
+}
+
+func (t StorageType) MarshalText() ([]byte, error) {
+	return []byte(t), nil
+}
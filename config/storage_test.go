@@ -0,0 +1,34 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/macrat/lauth/config"
+)
+
+func TestStorageType(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output config.StorageType
+		Error  bool
+	}{
+		{"", config.STORAGE_TYPE_MEMORY, false},
+		{"memory", config.STORAGE_TYPE_MEMORY, false},
+		{"file", config.STORAGE_TYPE_FILE, false},
+		{"redis", "", true},
+	}
+
+	for _, tt := range tests {
+		var st config.StorageType
+		err := st.UnmarshalText([]byte(tt.Input))
+		if tt.Error {
+			if err == nil {
+				t.Errorf("%#v: expected error but got nil", tt.Input)
+			}
+		} else if err != nil {
+			t.Errorf("%#v: unexpected error: %s", tt.Input, err)
+		} else if st != tt.Output {
+			t.Errorf("%#v: expected %#v but got %#v", tt.Input, tt.Output, st)
+		}
+	}
+}
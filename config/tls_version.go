@@ -0,0 +1,46 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+type TLSVersion uint16
+
+var tlsVersions = []struct {
+	Name    string
+	Version TLSVersion
+}{
+	{"1.0", tls.VersionTLS10},
+	{"1.1", tls.VersionTLS11},
+	{"1.2", tls.VersionTLS12},
+	{"1.3", tls.VersionTLS13},
+}
+
+func (v TLSVersion) String() string {
+	for _, x := range tlsVersions {
+		if x.Version == v {
+			return x.Name
+		}
+	}
+	return ""
+}
+
+func (v *TLSVersion) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*v = 0
+		return nil
+	}
+
+	for _, x := range tlsVersions {
+		if x.Name == string(text) {
+			*v = x.Version
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported TLS version: %#v", string(text))
+}
+
+func (v TLSVersion) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
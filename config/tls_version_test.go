@@ -0,0 +1,40 @@
+package config_test
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/macrat/lauth/config"
+)
+
+func TestTLSVersion(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Output config.TLSVersion
+		Error  bool
+	}{
+		{"", 0, false},
+		{"1.0", tls.VersionTLS10, false},
+		{"1.1", tls.VersionTLS11, false},
+		{"1.2", tls.VersionTLS12, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"1.4", 0, true},
+		{"TLS1.2", 0, true},
+	}
+
+	for _, tt := range tests {
+		var v config.TLSVersion
+		err := v.UnmarshalText([]byte(tt.Input))
+		if tt.Error {
+			if err == nil {
+				t.Errorf("%#v: expected error but got nil", tt.Input)
+			}
+		} else if err != nil {
+			t.Errorf("%#v: unexpected error: %s", tt.Input, err)
+		} else if v != tt.Output {
+			t.Errorf("%#v: expected %#v but got %#v", tt.Input, tt.Output, v)
+		} else if v.String() != tt.Input {
+			t.Errorf("%#v: unexpected string: %#v", tt.Input, v.String())
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type TransformType string
+
+const (
+	TRANSFORM_LOWERCASE    TransformType = "lowercase"
+	TRANSFORM_UPPERCASE                  = "uppercase"
+	TRANSFORM_DN_TO_CN                   = "dn_to_cn"
+	TRANSFORM_STRIP_PREFIX               = "strip_prefix"
+	TRANSFORM_STRIP_SUFFIX               = "strip_suffix"
+	TRANSFORM_REPLACE                    = "replace"
+)
+
+func (t TransformType) String() string {
+	return string(t)
+}
+
+func (t *TransformType) UnmarshalText(text []byte) error {
+	switch TransformType(string(text)) {
+	case TRANSFORM_LOWERCASE, TRANSFORM_UPPERCASE, TRANSFORM_DN_TO_CN, TRANSFORM_STRIP_PREFIX, TRANSFORM_STRIP_SUFFIX, TRANSFORM_REPLACE:
+		*t = TransformType(string(text))
+	default:
+		return fmt.Errorf("unsupported transform type: %#v", string(text))
+	}
+	return nil
+}
+
+type Regexp struct {
+	*regexp.Regexp
+}
+
+func (r Regexp) MarshalText() ([]byte, error) {
+	if r.Regexp == nil {
+		return []byte{}, nil
+	}
+	return []byte(r.Regexp.String()), nil
+}
+
+func (r *Regexp) UnmarshalText(text []byte) error {
+	re, err := regexp.Compile(string(text))
+	if err != nil {
+		return err
+	}
+	r.Regexp = re
+	return nil
+}
+
+// Transform is a conversion for each value of attribute.
+type Transform struct {
+	Type    TransformType `json:"type"              yaml:"type"              toml:"type"`
+	Value   string        `json:"value,omitempty"   yaml:"value,omitempty"   toml:"value,omitempty"`
+	Pattern Regexp        `json:"pattern,omitempty" yaml:"pattern,omitempty" toml:"pattern,omitempty"`
+	Replace string        `json:"replace,omitempty" yaml:"replace,omitempty" toml:"replace,omitempty"`
+}
+
+func (t Transform) Validate() error {
+	switch t.Type {
+	case TRANSFORM_STRIP_PREFIX, TRANSFORM_STRIP_SUFFIX:
+		if t.Value == "" {
+			return fmt.Errorf("value is required for %s", t.Type)
+		}
+	case TRANSFORM_REPLACE:
+		if t.Pattern.Regexp == nil {
+			return fmt.Errorf("pattern is required for %s", t.Type)
+		}
+	}
+	return nil
+}
+
+func (t Transform) Apply(value string) string {
+	switch t.Type {
+	case TRANSFORM_LOWERCASE:
+		return strings.ToLower(value)
+	case TRANSFORM_UPPERCASE:
+		return strings.ToUpper(value)
+	case TRANSFORM_DN_TO_CN:
+		if cn, err := GetCNByDN(value); err == nil {
+			return cn
+		}
+		return value
+	case TRANSFORM_STRIP_PREFIX:
+		return strings.TrimPrefix(value, t.Value)
+	case TRANSFORM_STRIP_SUFFIX:
+		return strings.TrimSuffix(value, t.Value)
+	case TRANSFORM_REPLACE:
+		if t.Pattern.Regexp == nil {
+			return value
+		}
+		return t.Pattern.ReplaceAllString(value, t.Replace)
+	default:
+		return value
+	}
+}
+
+var templatePlaceholder = regexp.MustCompile(`{([^{}]+)}`)
+
+// TemplateAttributes returns attribute names in the template like "{givenName} {sn}".
+func TemplateAttributes(template string) []string {
+	var attrs []string
+	for _, m := range templatePlaceholder.FindAllStringSubmatch(template, -1) {
+		attrs = append(attrs, m[1])
+	}
+	return attrs
+}
+
+// ExecuteTemplate replaces placeholders in the template with the first value of the attributes.
+// Spaces around the result are trimmed, because an attribute in the template could be empty.
+func ExecuteTemplate(template string, attrs map[string][]string) string {
+	return strings.TrimSpace(templatePlaceholder.ReplaceAllStringFunc(template, func(m string) string {
+		if vs := attrs[m[1:len(m)-1]]; len(vs) > 0 {
+			return vs[0]
+		}
+		return ""
+	}))
+}
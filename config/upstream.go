@@ -0,0 +1,98 @@
+package config
+
+import (
+	"strings"
+)
+
+var (
+	// DefaultUpstreamScopes maps standard claims of upstream providers to the same claims.
+	// Claims of upstream ID tokens are treated as attributes of the user.
+	DefaultUpstreamScopes = ScopeConfig{
+		"profile": []ClaimConfig{
+			{Claim: "name", Attribute: "name", Type: "string"},
+			{Claim: "given_name", Attribute: "given_name", Type: "string"},
+			{Claim: "family_name", Attribute: "family_name", Type: "string"},
+		},
+		"email": []ClaimConfig{
+			{Claim: "email", Attribute: "email", Type: "string"},
+		},
+		"phone": []ClaimConfig{
+			{Claim: "phone_number", Attribute: "phone_number", Type: "string"},
+		},
+		"groups": []ClaimConfig{
+			{Claim: "groups", Attribute: "groups", Type: "[]string"},
+		},
+	}
+
+	// DefaultUpstreamRequestScopes are the scopes that requested to upstream providers in default.
+	DefaultUpstreamRequestScopes = []string{"openid", "profile", "email"}
+)
+
+// UpstreamConfig is an upstream OpenID Provider that users can log in with, instead of the password.
+//
+// Users who logged in with the upstream have subjects that prefixed with the name like "partner:xxxx", unless linked to LDAP account.
+type UpstreamConfig struct {
+	Name          string             `json:"name"                     yaml:"name"                     toml:"name"`
+	DisplayName   string             `json:"display_name,omitempty"   yaml:"display_name,omitempty"   toml:"display_name,omitempty"`
+	Issuer        *URL               `json:"issuer"                   yaml:"issuer"                   toml:"issuer"`
+	ClientID      string             `json:"client_id"                yaml:"client_id"                toml:"client_id"`
+	ClientSecret  string             `json:"client_secret"            yaml:"client_secret"            toml:"client_secret"`
+	RequestScopes []string           `json:"request_scopes,omitempty" yaml:"request_scopes,omitempty" toml:"request_scopes,omitempty"`
+	Scopes        ScopeConfig        `json:"scope,omitempty"          yaml:"scope,omitempty"          toml:"scope,omitempty"`
+	Link          UpstreamLinkConfig `json:"link,omitempty"           yaml:"link,omitempty"           toml:"link,omitempty"`
+}
+
+// UpstreamLinkConfig is the setting to link users of the upstream to LDAP accounts.
+//
+// The user is treated as the LDAP user that Attribute equals to Claim of the upstream ID token.
+type UpstreamLinkConfig struct {
+	Claim     string `json:"claim,omitempty"     yaml:"claim,omitempty"     toml:"claim,omitempty"`
+	Attribute string `json:"attribute,omitempty" yaml:"attribute,omitempty" toml:"attribute,omitempty"`
+
+	// Required denies users who are not found in LDAP.
+	Required bool `json:"required,omitempty" yaml:"required,omitempty" toml:"required,omitempty"`
+}
+
+// Enabled reports linking to LDAP accounts is configured.
+func (c UpstreamLinkConfig) Enabled() bool {
+	return c.Claim != "" && c.Attribute != ""
+}
+
+// Subject makes the subject of the user of this upstream.
+func (u UpstreamConfig) Subject(subject string) string {
+	return u.Name + DirectorySeparator + subject
+}
+
+// directory makes a DirectoryConfig to make claims of the users of this upstream.
+//
+// Claims of the upstream are the attributes of the user, so the ID attribute is preferred_username.
+func (u UpstreamConfig) directory() DirectoryConfig {
+	scopes := make(ScopeConfig)
+	for name, claims := range DefaultUpstreamScopes {
+		scopes[name] = claims
+	}
+	for name, claims := range u.Scopes {
+		scopes[name] = claims
+	}
+
+	return DirectoryConfig{
+		Name:   u.Name,
+		LDAP:   LDAPConfig{IDAttribute: "preferred_username"},
+		Scopes: scopes,
+	}
+}
+
+// UpstreamFor returns the upstream that the subject belongs to, and the subject in the upstream.
+func (c *Config) UpstreamFor(subject string) (UpstreamConfig, string, bool) {
+	idx := strings.Index(subject, DirectorySeparator)
+	if idx < 0 {
+		return UpstreamConfig{}, "", false
+	}
+
+	for _, u := range c.Upstreams {
+		if u.Name == subject[:idx] {
+			return u, subject[idx+len(DirectorySeparator):], true
+		}
+	}
+	return UpstreamConfig{}, "", false
+}
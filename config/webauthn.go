@@ -0,0 +1,81 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// WebAuthnConfig is the settings of security keys and passkeys.
+//
+// Users who registered a credential are always asked it after the password.
+// Other users are asked to register if required by the groups, or if they chose it in the login page.
+type WebAuthnConfig struct {
+	Enabled          bool     `json:"enabled,omitempty"           yaml:"enabled,omitempty"           toml:"enabled,omitempty"           flag:"webauthn"`
+	RPID             string   `json:"rp_id,omitempty"             yaml:"rp_id,omitempty"             toml:"rp_id,omitempty"             flag:"webauthn-rp-id"`
+	Enroll           bool     `json:"enroll,omitempty"            yaml:"enroll,omitempty"            toml:"enroll,omitempty"            flag:"webauthn-enroll"`
+	Passwordless     bool     `json:"passwordless,omitempty"      yaml:"passwordless,omitempty"      toml:"passwordless,omitempty"      flag:"webauthn-passwordless"`
+	RequiredGroups   []string `json:"required_groups,omitempty"   yaml:"required_groups,omitempty"   toml:"required_groups,omitempty"   flag:"webauthn-required-groups"`
+	UserVerification string   `json:"user_verification,omitempty" yaml:"user_verification,omitempty" toml:"user_verification,omitempty" flag:"webauthn-user-verification"`
+}
+
+// RequiredFilter returns LDAP filter for users who have to use a security key, or empty string if no group requires it.
+func (c WebAuthnConfig) RequiredFilter() string {
+	if len(c.RequiredGroups) == 0 {
+		return ""
+	}
+
+	groups := ""
+	for _, g := range c.RequiredGroups {
+		groups += fmt.Sprintf("(memberOf=%s)", ldap.EscapeFilter(g))
+	}
+	return "(|" + groups + ")"
+}
+
+// WebAuthnRPID returns the relying party ID of WebAuthn.
+func (c *Config) WebAuthnRPID() string {
+	if c.WebAuthn.RPID != "" {
+		return c.WebAuthn.RPID
+	}
+	return c.Issuer.Hostname()
+}
+
+// WebAuthnOrigin returns the origin of the login page, that browsers report to authenticators.
+func (c *Config) WebAuthnOrigin() string {
+	return c.Issuer.Scheme + "://" + c.Issuer.Host
+}
+
+func (c *Config) validateWebAuthn() []error {
+	var es []error
+
+	if !c.WebAuthn.Enabled {
+		if c.WebAuthn.Enroll {
+			es = append(es, errors.New("--webauthn-enroll: WebAuthn is required to enroll security keys."))
+		}
+		if c.WebAuthn.Passwordless {
+			es = append(es, errors.New("--webauthn-passwordless: WebAuthn is required to passwordless login."))
+		}
+		if len(c.WebAuthn.RequiredGroups) > 0 {
+			es = append(es, errors.New("--webauthn-required-groups: WebAuthn is required to require security keys."))
+		}
+		return es
+	}
+
+	switch c.WebAuthn.UserVerification {
+	case "", "required", "preferred", "discouraged":
+	default:
+		es = append(es, errors.New("--webauthn-user-verification: WebAuthn User Verification must be \"required\", \"preferred\", or \"discouraged\"."))
+	}
+
+	if c.Issuer.String() != "" {
+		host := c.Issuer.Hostname()
+		rpID := c.WebAuthnRPID()
+		if rpID != host && !strings.HasSuffix(host, "."+rpID) {
+			es = append(es, errors.New("--webauthn-rp-id: WebAuthn RP ID must be the host name of Issuer URL or its parent domain."))
+		}
+	}
+
+	return es
+}
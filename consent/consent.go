@@ -0,0 +1,130 @@
+package consent
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/macrat/lauth/store"
+)
+
+const (
+	CONSENT_BUCKET = "consent"
+)
+
+// Grant is a consent by user that allows a client to access to scopes.
+type Grant struct {
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Covers reports the grant is alive and includes all of scopes.
+func (g Grant) Covers(scopes []string) bool {
+	if !g.ExpiresAt.After(time.Now()) {
+		return false
+	}
+
+	for _, s := range scopes {
+		found := false
+		for _, x := range g.Scopes {
+			if s == x {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Store remembers consents of each user and client.
+type Store struct {
+	Store store.Store
+}
+
+func key(subject, clientID string) string {
+	return subject + "\n" + clientID
+}
+
+func (s Store) Grants(subject, clientID string) ([]Grant, error) {
+	raw, err := s.Store.Get(CONSENT_BUCKET, key(subject, clientID))
+	if err == store.KeyNotFoundError {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var grants []Grant
+	if err := json.Unmarshal(raw, &grants); err != nil {
+		return nil, err
+	}
+
+	alive := grants[:0]
+	for _, g := range grants {
+		if g.ExpiresAt.After(time.Now()) {
+			alive = append(alive, g)
+		}
+	}
+	return alive, nil
+}
+
+// IsGranted reports the user already consented scopes to the client.
+func (s Store) IsGranted(subject, clientID string, scopes []string) (bool, error) {
+	grants, err := s.Grants(subject, clientID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, g := range grants {
+		if g.Covers(scopes) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Grant remembers consent of the user to the client for the duration.
+//
+// Grants that covered by the new grant will be replaced.
+func (s Store) Grant(subject, clientID string, scopes []string, duration time.Duration) error {
+	grants, err := s.Grants(subject, clientID)
+	if err != nil {
+		return err
+	}
+
+	grant := Grant{
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(duration),
+	}
+
+	newGrants := []Grant{grant}
+	for _, g := range grants {
+		if !grant.Covers(g.Scopes) || g.ExpiresAt.After(grant.ExpiresAt) {
+			newGrants = append(newGrants, g)
+		}
+	}
+
+	raw, err := json.Marshal(newGrants)
+	if err != nil {
+		return err
+	}
+
+	ttl := duration
+	for _, g := range newGrants {
+		if d := time.Until(g.ExpiresAt); d > ttl {
+			ttl = d
+		}
+	}
+
+	return s.Store.Set(CONSENT_BUCKET, key(subject, clientID), raw, ttl)
+}
+
+// Revoke forgets all consents of the user to the client.
+func (s Store) Revoke(subject, clientID string) error {
+	err := s.Store.Delete(CONSENT_BUCKET, key(subject, clientID))
+	if err == store.KeyNotFoundError {
+		return nil
+	}
+	return err
+}
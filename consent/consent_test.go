@@ -0,0 +1,72 @@
+package consent_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/consent"
+	"github.com/macrat/lauth/store"
+)
+
+func TestStore(t *testing.T) {
+	s := consent.Store{Store: store.NewMemoryStore()}
+
+	check := func(t *testing.T, subject, clientID string, scopes []string, expect bool) {
+		t.Helper()
+
+		if ok, err := s.IsGranted(subject, clientID, scopes); err != nil {
+			t.Errorf("failed to check grant: %s", err)
+		} else if ok != expect {
+			t.Errorf("%s/%s/%v: expected %v but got %v", subject, clientID, scopes, expect, ok)
+		}
+	}
+
+	check(t, "macrat", "some_client_id", []string{"openid"}, false)
+
+	if err := s.Grant("macrat", "some_client_id", []string{"openid", "profile", "email"}, time.Hour); err != nil {
+		t.Fatalf("failed to grant: %s", err)
+	}
+
+	check(t, "macrat", "some_client_id", []string{"openid"}, true)
+	check(t, "macrat", "some_client_id", []string{"openid", "email"}, true)
+	check(t, "macrat", "some_client_id", []string{"openid", "profile", "email"}, true)
+	check(t, "macrat", "some_client_id", []string{"openid", "phone"}, false)
+	check(t, "macrat", "implicit_client_id", []string{"openid"}, false)
+	check(t, "j.smith", "some_client_id", []string{"openid"}, false)
+
+	if err := s.Grant("macrat", "some_client_id", []string{"openid", "phone"}, time.Hour); err != nil {
+		t.Fatalf("failed to grant: %s", err)
+	}
+
+	check(t, "macrat", "some_client_id", []string{"openid", "phone"}, true)
+	check(t, "macrat", "some_client_id", []string{"openid", "email"}, true)
+	check(t, "macrat", "some_client_id", []string{"email", "phone"}, false)
+
+	if grants, err := s.Grants("macrat", "some_client_id"); err != nil {
+		t.Errorf("failed to get grants: %s", err)
+	} else if len(grants) != 2 {
+		t.Errorf("unexpected grants: %#v", grants)
+	}
+
+	if err := s.Grant("macrat", "some_client_id", []string{"openid"}, time.Hour); err != nil {
+		t.Fatalf("failed to grant: %s", err)
+	}
+	if err := s.Grant("macrat", "some_client_id", []string{"openid", "phone"}, time.Hour); err != nil {
+		t.Fatalf("failed to grant: %s", err)
+	}
+	if grants, err := s.Grants("macrat", "some_client_id"); err != nil {
+		t.Errorf("failed to get grants: %s", err)
+	} else if len(grants) != 2 {
+		t.Errorf("expected covered grants are replaced but got %#v", grants)
+	}
+
+	if err := s.Grant("macrat", "implicit_client_id", []string{"openid"}, -time.Second); err != nil {
+		t.Fatalf("failed to grant: %s", err)
+	}
+	check(t, "macrat", "implicit_client_id", []string{"openid"}, false)
+
+	if err := s.Revoke("macrat", "some_client_id"); err != nil {
+		t.Fatalf("failed to revoke: %s", err)
+	}
+	check(t, "macrat", "some_client_id", []string{"openid"}, false)
+}
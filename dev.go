@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/macrat/lauth/api"
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/secret"
+	"github.com/macrat/lauth/userfile"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DevClientID is the client ID of the client that `lauth dev` registers automatically.
+const DevClientID = "dev"
+
+// devUsers is the users for `lauth dev` when --users option is omitted.
+const devUsers = `
+users:
+  macrat:
+    password: foobar
+    attributes:
+      displayName: SHIDA Yuuma
+      givenName: yuuma
+      sn: shida
+      mail: m@crat.jp
+      memberOf:
+        - CN=users,OU=group,DC=example,DC=local
+        - CN=admin,OU=group,DC=example,DC=local
+  j.smith:
+    password: hello
+    attributes:
+      displayName: John Smith
+      givenName: john
+      sn: smith
+      mail: john@example.com
+      memberOf:
+        - CN=users,OU=group,DC=example,DC=local
+`
+
+type DevConfig struct {
+	Issuer     config.URL
+	ConfigFile string
+	UsersFile  string
+}
+
+var (
+	devConfig = DevConfig{
+		Issuer: config.URL{Scheme: "http", Host: "localhost:8000"},
+	}
+	devCmd = &cobra.Command{
+		Use:   "dev",
+		Short: "Start a development server without LDAP",
+		Long: strings.Join([]string{
+			"Start a development server without LDAP.",
+			"",
+			"The users are read from --users file, or built-in users (macrat:foobar and j.smith:hello) are used.",
+			"A client named \"" + DevClientID + "\" and a test page for it are registered at ISSUER/dev.",
+			"So you can try the authorization code flow, the implicit flow and logout with a browser.",
+			"",
+			"Do not use this for production.",
+		}, "\n"),
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			setupLogging()
+
+			if err := conf.Load(devConfig.ConfigFile, cmd.Root().Flags()); err != nil {
+				return err
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			users, err := LoadDevUsers(devConfig.UsersFile)
+			if err != nil {
+				log.Fatal().Msgf("failed to load users: %s", err)
+			}
+
+			clientSecret, err := SetupDevConfig(conf, &devConfig.Issuer)
+			if err != nil {
+				log.Fatal().Msgf("failed to register client: %s", err)
+			}
+
+			printBanner(conf)
+			fmt.Printf("Test page:     %s\n", DevPageURL(conf))
+			fmt.Printf("Client ID:     %s\n", DevClientID)
+			fmt.Printf("Client secret: %s\n", clientSecret)
+			fmt.Println()
+			fmt.Fprintln(os.Stderr, "WARNING  This is a development server. Do not use for production.")
+			fmt.Fprintln(os.Stderr, "")
+
+			run(conf, loadTokenManager(conf), users, DevRoutes(clientSecret))
+		},
+	}
+)
+
+func init() {
+	cmd.AddCommand(devCmd)
+
+	flags := devCmd.Flags()
+	flags.SortFlags = false
+
+	flags.VarP(&devConfig.Issuer, "issuer", "i", "Issuer URL.")
+	flags.StringVarP(&devConfig.UsersFile, "users", "u", "", "Users file in LDIF, YAML, TOML, JSON or htpasswd. Passwords can be plain text. Use built-in users if omit.")
+	flags.StringVarP(&devConfig.ConfigFile, "config", "c", "", "Load options from the config file, like templates or scopes. LDAP settings are ignored.")
+	flags.BoolVar(&debug, "debug", false, "Enable debug output.")
+}
+
+// LoadDevUsers loads users for the development server.
+// Plain text passwords are hashed, so the file can be written by hand.
+func LoadDevUsers(file string) (ldap.Connector, error) {
+	name := "users.yaml"
+	raw := []byte(devUsers)
+	if file != "" {
+		var err error
+		name = file
+		if raw, err = os.ReadFile(file); err != nil {
+			return nil, err
+		}
+	}
+
+	users, err := userfile.Decode(name, raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := users.HashPasswords(bcrypt.MinCost); err != nil {
+		return nil, err
+	}
+	if err := users.Validate(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SetupDevConfig overrides conf for the development server, and registers the client for the test page.
+// The returned value is the plain secret of the client.
+func SetupDevConfig(conf *config.Config, issuer *config.URL) (string, error) {
+	conf.Issuer = issuer
+	conf.Listen = config.DecideListenAddress(issuer, nil)
+	conf.TLS = config.TLSConfig{}
+	conf.LDAP = config.LDAPConfig{}
+	conf.Directories = nil
+	conf.DirectoryPicker = false
+	conf.Users = config.UsersConfig{}
+
+	s, err := secret.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	var uris config.PatternSet
+	for _, u := range []string{DevPageURL(conf), DevPageURL(conf) + "/callback"} {
+		var p config.Pattern
+		if err := p.UnmarshalText([]byte(u)); err != nil {
+			return "", err
+		}
+		uris = append(uris, p)
+	}
+
+	if conf.Clients == nil {
+		conf.Clients = make(config.ClientConfigSet)
+	}
+	conf.Clients[DevClientID] = config.ClientConfig{
+		Name:              "Development Client",
+		Secret:            string(s.Hash),
+		RedirectURI:       uris,
+		AllowImplicitFlow: true,
+	}
+
+	return string(s.Secret), nil
+}
+
+// DevPageURL returns URL of the test page of the development server.
+func DevPageURL(conf *config.Config) string {
+	u := *conf.Issuer.URL()
+	u.Path = path.Join(u.Path, "/dev")
+	return u.String()
+}
+
+func endpointURL(conf *config.Config, p string) string {
+	u := *conf.Issuer.URL()
+	u.Path = p
+	return u.String()
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DevRoutes makes a setup function for run, that adds the test page of the development server.
+//
+// The test page is a tiny relying party. The code is exchanged in the process, so it doesn't need the network.
+func DevRoutes(clientSecret string) func(*gin.Engine, *api.LauthAPI) {
+	return func(router *gin.Engine, a *api.LauthAPI) {
+		base := path.Join(a.Config.Issuer.Path, "/dev")
+		paths := a.Config.EndpointPaths()
+		callback := DevPageURL(a.Config) + "/callback"
+
+		render := func(c *gin.Context, data gin.H) {
+			state := randomString()
+			nonce := randomString()
+
+			code := url.Values{}
+			code.Set("client_id", DevClientID)
+			code.Set("redirect_uri", callback)
+			code.Set("response_type", "code")
+			code.Set("scope", "openid profile email groups")
+			code.Set("state", state)
+
+			implicit := url.Values{}
+			implicit.Set("client_id", DevClientID)
+			implicit.Set("redirect_uri", callback)
+			implicit.Set("response_type", "id_token token")
+			implicit.Set("scope", "openid profile email groups")
+			implicit.Set("state", state)
+			implicit.Set("nonce", nonce)
+
+			data["code_flow"] = endpointURL(a.Config, paths.Authz) + "?" + code.Encode()
+			data["implicit_flow"] = endpointURL(a.Config, paths.Authz) + "?" + implicit.Encode()
+			data["logout"] = endpointURL(a.Config, paths.Logout)
+			data["userinfo"] = endpointURL(a.Config, paths.Userinfo)
+			data["page"] = DevPageURL(a.Config)
+
+			c.Header("Cache-Control", "no-store")
+			c.Status(http.StatusOK)
+			if err := devPage.Execute(c.Writer, data); err != nil {
+				log.Error().Err(err).Msg("failed to render test page")
+			}
+		}
+
+		router.GET(base, func(c *gin.Context) {
+			render(c, gin.H{})
+		})
+
+		router.GET(base+"/callback", func(c *gin.Context) {
+			if e := c.Query("error"); e != "" {
+				render(c, gin.H{"error": e + ": " + c.Query("error_description")})
+				return
+			}
+
+			code := c.Query("code")
+			if code == "" {
+				// implicit flow. tokens are in the fragment, so the page reads them.
+				render(c, gin.H{})
+				return
+			}
+
+			form := url.Values{}
+			form.Set("grant_type", "authorization_code")
+			form.Set("code", code)
+			form.Set("redirect_uri", callback)
+			form.Set("client_id", DevClientID)
+			form.Set("client_secret", clientSecret)
+
+			req := httptest.NewRequest("POST", paths.Token, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				render(c, gin.H{"error": "failed to exchange code: " + resp.Body.String()})
+				return
+			}
+			render(c, gin.H{"tokens": template.JS(resp.Body.String())})
+		})
+	}
+}
+
+var devPage = template.Must(template.New("dev").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Lauth development client</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; }
+nav a { margin-right: 1em; }
+pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Lauth development client</h1>
+<nav>
+<a href="{{ .code_flow }}">Authorization code flow</a>
+<a href="{{ .implicit_flow }}">Implicit flow</a>
+<a href="#" id="logout" hidden>Logout</a>
+<a href="{{ .page }}">Reset</a>
+</nav>
+{{ with .error }}<p class="error">{{ . }}</p>{{ end }}
+<div id="result" hidden>
+<h2>Token response</h2>
+<pre id="tokens"></pre>
+<h2>ID token claims</h2>
+<pre id="claims"></pre>
+<h2>Userinfo</h2>
+<pre id="userinfo"></pre>
+</div>
+<script>
+(function() {
+	var tokens = {{ if .tokens }}{{ .tokens }}{{ else }}null{{ end }};
+
+	if (!tokens && location.hash.length > 1) {
+		tokens = {};
+		new URLSearchParams(location.hash.slice(1)).forEach(function(v, k) { tokens[k] = v; });
+		history.replaceState(null, '', location.pathname + location.search);
+	}
+
+	var show = function(id, value) {
+		document.getElementById(id).textContent = JSON.stringify(value, null, 2);
+	};
+
+	if (tokens) {
+		document.getElementById('result').hidden = false;
+		show('tokens', tokens);
+
+		if (tokens.id_token) {
+			sessionStorage.setItem('id_token', tokens.id_token);
+			var payload = tokens.id_token.split('.')[1].replace(/-/g, '+').replace(/_/g, '/');
+			show('claims', JSON.parse(decodeURIComponent(escape(atob(payload)))));
+		}
+
+		if (tokens.access_token) {
+			fetch({{ .userinfo }}, {headers: {Authorization: 'Bearer ' + tokens.access_token}})
+				.then(function(r) { return r.json(); })
+				.then(function(info) { show('userinfo', info); });
+		}
+	}
+
+	var idToken = sessionStorage.getItem('id_token');
+	if (idToken) {
+		var logout = document.getElementById('logout');
+		logout.hidden = false;
+		logout.href = {{ .logout }} + '?' + new URLSearchParams({
+			id_token_hint: idToken,
+			post_logout_redirect_uri: {{ .page }},
+		}).toString();
+		logout.addEventListener('click', function() { sessionStorage.removeItem('id_token'); });
+	}
+})();
+</script>
+</body>
+</html>
+`))
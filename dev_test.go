@@ -0,0 +1,104 @@
+package main_test
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/macrat/lauth"
+	"github.com/macrat/lauth/api"
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/session"
+	"github.com/macrat/lauth/store"
+	"github.com/macrat/lauth/testutil"
+)
+
+func TestLoadDevUsers(t *testing.T) {
+	users, err := main.LoadDevUsers("")
+	if err != nil {
+		t.Fatalf("failed to load built-in users: %s", err)
+	}
+
+	s, err := users.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer s.Close()
+
+	if id, err := s.LoginTest("macrat", "foobar"); err != nil || id.Subject != "macrat" {
+		t.Errorf("failed to login: %#v %v", id, err)
+	}
+	if _, err := s.LoginTest("j.smith", "foobar"); err == nil {
+		t.Errorf("succeed to login with incorrect password")
+	}
+
+	if _, err := main.LoadDevUsers("not-exists.ldif"); err == nil {
+		t.Errorf("expected error but got nil")
+	}
+}
+
+func TestDevRoutes(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	conf := testutil.MakeConfig()
+	clientSecret, err := main.SetupDevConfig(conf, &config.URL{Scheme: "http", Host: "localhost:8000", Path: "/auth"})
+	if err != nil {
+		t.Fatalf("failed to setup config: %s", err)
+	}
+	if main.DevPageURL(conf) != "http://localhost:8000/auth/dev" {
+		t.Errorf("unexpected test page URL: %s", main.DevPageURL(conf))
+	}
+
+	users, err := main.LoadDevUsers("")
+	if err != nil {
+		t.Fatalf("failed to load users: %s", err)
+	}
+	tokenManager, err := testutil.MakeTokenManager()
+	if err != nil {
+		t.Fatalf("failed to make token manager: %s", err)
+	}
+	kv := store.NewMemoryStore()
+	a := &api.LauthAPI{
+		Connector:    users,
+		Config:       conf,
+		TokenManager: tokenManager,
+		Store:        kv,
+		Sessions:     session.NewStore(kv),
+	}
+
+	router := gin.New()
+	a.SetRoutes(router)
+	main.DevRoutes(clientSecret)(router, a)
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != 200 {
+			t.Fatalf("%s: unexpected status code: %d", path, w.Code)
+		}
+		return w.Body.String()
+	}
+
+	if body := get("/auth/dev"); !strings.Contains(body, "/auth/authz?client_id=dev") {
+		t.Errorf("test page doesn't include link to authorization endpoint:\n%s", body)
+	}
+
+	callback := main.DevPageURL(conf) + "/callback"
+	code, err := tokenManager.CreateCode(conf.Issuer, "macrat", main.DevClientID, callback, "openid profile", "", time.Now(), nil, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create code: %s", err)
+	}
+
+	body := get("/auth/dev/callback?code=" + url.QueryEscape(code))
+	if !strings.Contains(body, `"access_token"`) || !strings.Contains(body, `"id_token"`) {
+		t.Errorf("code was not exchanged:\n%s", body)
+	}
+
+	body = get("/auth/dev/callback?code=invalid")
+	if !strings.Contains(body, "failed to exchange code") {
+		t.Errorf("expected error but got:\n%s", body)
+	}
+}
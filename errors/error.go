@@ -33,6 +33,8 @@ func (e *Error) StatusCode() int {
 	switch e.Reason {
 	case ServerError:
 		return http.StatusInternalServerError
+	case TemporarilyUnavailable:
+		return http.StatusServiceUnavailable
 	case InvalidToken:
 		return http.StatusForbidden
 	case MethodNotAllowed:
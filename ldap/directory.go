@@ -0,0 +1,224 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/metrics"
+)
+
+var (
+	UnknownDirectoryError = fmt.Errorf("unknown directory")
+)
+
+// Directory is a named user directory.
+type Directory struct {
+	Config    *config.DirectoryConfig
+	Connector Connector
+}
+
+// DirectoryConnector is a Connector that routes users to multiple named directories.
+//
+// Subjects of users are prefixed with name of the directory like "corp:jsmith".
+type DirectoryConnector struct {
+	Directories []Directory
+}
+
+// Connect makes a session for the directories.
+// Connection to each directory is established when it is needed first time.
+func (c *DirectoryConnector) Connect() (Session, error) {
+	return &DirectorySession{
+		connector: c,
+		sessions:  make(map[string]Session),
+	}, nil
+}
+
+// DirectorySession is a Session that has multiple named directories.
+type DirectorySession struct {
+	connector *DirectoryConnector
+	sessions  map[string]Session
+}
+
+func (s *DirectorySession) Close() error {
+	for _, sess := range s.sessions {
+		sess.Close()
+	}
+	s.sessions = make(map[string]Session)
+	return nil
+}
+
+func (s *DirectorySession) open(d Directory) (Session, error) {
+	if sess, ok := s.sessions[d.Config.Name]; ok {
+		return sess, nil
+	}
+
+	sess, err := d.Connector.Connect()
+	if err != nil {
+		return nil, err
+	}
+	if server := ServerOf(sess); server != "" {
+		metrics.LDAPServerUsed(server)
+	}
+
+	s.sessions[d.Config.Name] = sess
+	return sess, nil
+}
+
+// route returns directories to try login.
+//
+// It is the picked directory if directory is not empty, or the directory that has the domain of the username in LoginDomains.
+// Otherwise, all directories in the configured order.
+func (s *DirectorySession) route(directory, username string) ([]Directory, error) {
+	if directory != "" {
+		for _, d := range s.connector.Directories {
+			if d.Config.Name == directory {
+				return []Directory{d}, nil
+			}
+		}
+		return nil, UnknownDirectoryError
+	}
+
+	username = strings.TrimSpace(username)
+	for _, d := range s.connector.Directories {
+		if d.Config.LDAP.NormalizeUsername(username) != username {
+			return []Directory{d}, nil
+		}
+	}
+
+	return s.connector.Directories, nil
+}
+
+func (s *DirectorySession) LoginTest(username, password string) (Identity, error) {
+	return s.LoginTestIn("", username, password)
+}
+
+// LoginTestIn checks the password of the user in the directory.
+// The directory will be decided by the username if directory is empty.
+func (s *DirectorySession) LoginTestIn(directory, username, password string) (Identity, error) {
+	ds, err := s.route(directory, username)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var lastErr error = UserNotFoundError
+	for _, d := range ds {
+		sess, err := s.open(d)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		id, err := sess.LoginTest(username, password)
+		if errors.Is(err, UserNotFoundError) {
+			continue
+		} else if err != nil {
+			return Identity{}, err
+		}
+
+		id.Subject = d.Config.Subject(id.Subject)
+		return id, nil
+	}
+
+	return Identity{}, lastErr
+}
+
+func (s *DirectorySession) ChangePassword(username, oldPassword, newPassword string) error {
+	return s.ChangePasswordIn("", username, oldPassword, newPassword)
+}
+
+// ChangePasswordIn changes password of the user in the directory.
+// The directory will be decided by the username if directory is empty, same as LoginTestIn.
+func (s *DirectorySession) ChangePasswordIn(directory, username, oldPassword, newPassword string) error {
+	ds, err := s.route(directory, username)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error = UserNotFoundError
+	for _, d := range ds {
+		sess, err := s.open(d)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		err = ChangePasswordIn(sess, "", username, oldPassword, newPassword)
+		if !errors.Is(err, UserNotFoundError) {
+			return err
+		}
+	}
+
+	return lastErr
+}
+
+// FindUser searches the user in all directories in the configured order.
+func (s *DirectorySession) FindUser(attribute, value string) (Identity, error) {
+	var lastErr error = UserNotFoundError
+	for _, d := range s.connector.Directories {
+		sess, err := s.open(d)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		id, err := FindUser(sess, attribute, value)
+		if errors.Is(err, UserNotFoundError) {
+			continue
+		} else if err != nil {
+			return Identity{}, err
+		}
+
+		id.Subject = d.Config.Subject(id.Subject)
+		return id, nil
+	}
+
+	return Identity{}, lastErr
+}
+
+func (s *DirectorySession) sessionFor(subject string) (Session, string, error) {
+	idx := strings.Index(subject, config.DirectorySeparator)
+	if idx < 0 {
+		return nil, "", UserNotFoundError
+	}
+
+	for _, d := range s.connector.Directories {
+		if d.Config.Name == subject[:idx] {
+			sess, err := s.open(d)
+			return sess, subject[idx+len(config.DirectorySeparator):], err
+		}
+	}
+
+	return nil, "", UserNotFoundError
+}
+
+func (s *DirectorySession) GetUserAttributes(subject string, attributes []string) (map[string][]string, error) {
+	sess, sub, err := s.sessionFor(subject)
+	if err != nil {
+		return nil, err
+	}
+	return sess.GetUserAttributes(sub, attributes)
+}
+
+func (s *DirectorySession) MatchFilter(subject, filter string) (bool, error) {
+	sess, sub, err := s.sessionFor(subject)
+	if err != nil {
+		return false, err
+	}
+	return sess.MatchFilter(sub, filter)
+}
+
+// DirectoryLoginTester is a Session that can check password in the picked directory.
+type DirectoryLoginTester interface {
+	LoginTestIn(directory, username, password string) (Identity, error)
+}
+
+// LoginTestIn checks the password of the user in the directory if the session supports directories.
+// Otherwise, directory is ignored.
+func LoginTestIn(s Session, directory, username, password string) (Identity, error) {
+	if t, ok := s.(DirectoryLoginTester); ok && directory != "" {
+		return t.LoginTestIn(directory, username, password)
+	}
+	return s.LoginTest(username, password)
+}
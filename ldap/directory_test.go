@@ -0,0 +1,218 @@
+package ldap_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/ldap"
+)
+
+type directoryUsers struct {
+	passwords map[string]string
+	connects  int
+	down      bool
+}
+
+func (d *directoryUsers) Connect() (ldap.Session, error) {
+	d.connects++
+	if d.down {
+		return nil, ldap.UnavailableError
+	}
+	return &directoryUsersSession{d}, nil
+}
+
+type directoryUsersSession struct {
+	users *directoryUsers
+}
+
+func (s *directoryUsersSession) Close() error {
+	return nil
+}
+
+func (s *directoryUsersSession) LoginTest(username, password string) (ldap.Identity, error) {
+	p, ok := s.users.passwords[username]
+	if !ok {
+		return ldap.Identity{}, ldap.UserNotFoundError
+	}
+	if p != password {
+		return ldap.Identity{}, errors.New("invalid credentials")
+	}
+	return ldap.Identity{Subject: username, Username: username}, nil
+}
+
+func (s *directoryUsersSession) ChangePassword(username, oldPassword, newPassword string) error {
+	if _, err := s.LoginTest(username, oldPassword); err != nil {
+		return err
+	}
+	s.users.passwords[username] = newPassword
+	return nil
+}
+
+func (s *directoryUsersSession) GetUserAttributes(subject string, attributes []string) (map[string][]string, error) {
+	if _, ok := s.users.passwords[subject]; !ok {
+		return nil, ldap.UserNotFoundError
+	}
+	return map[string][]string{"uid": {subject}}, nil
+}
+
+func (s *directoryUsersSession) MatchFilter(subject, filter string) (bool, error) {
+	_, ok := s.users.passwords[subject]
+	return ok, nil
+}
+
+func makeDirectories() (*ldap.DirectoryConnector, *directoryUsers, *directoryUsers) {
+	corp := &directoryUsers{passwords: map[string]string{"jsmith": "corp-pass", "alice": "alice-pass"}}
+	acquired := &directoryUsers{passwords: map[string]string{"jsmith": "acquired-pass", "bob": "bob-pass"}}
+
+	return &ldap.DirectoryConnector{
+		Directories: []ldap.Directory{
+			{
+				Config:    &config.DirectoryConfig{Name: "corp", LDAP: config.LDAPConfig{LoginDomains: []string{"CORP"}}},
+				Connector: corp,
+			},
+			{
+				Config:    &config.DirectoryConfig{Name: "acquired", LDAP: config.LDAPConfig{LoginDomains: []string{"acquired.local"}}},
+				Connector: acquired,
+			},
+		},
+	}, corp, acquired
+}
+
+func TestDirectoryConnector_LoginTest(t *testing.T) {
+	c, _, _ := makeDirectories()
+
+	tests := []struct {
+		Name      string
+		Directory string
+		Username  string
+		Password  string
+		Subject   string
+		Error     bool
+	}{
+		{"in order", "", "bob", "bob-pass", "acquired:bob", false},
+		{"first one wins", "", "jsmith", "corp-pass", "corp:jsmith", false},
+		{"no fallthrough on wrong password", "", "jsmith", "acquired-pass", "", true},
+		{"picked", "acquired", "jsmith", "acquired-pass", "acquired:jsmith", false},
+		{"picked but not there", "acquired", "alice", "alice-pass", "", true},
+		{"unknown directory", "unknown", "alice", "alice-pass", "", true},
+		{"not found", "", "eve", "eve-pass", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			s, err := c.Connect()
+			if err != nil {
+				t.Fatalf("failed to connect: %s", err)
+			}
+			defer s.Close()
+
+			id, err := ldap.LoginTestIn(s, tt.Directory, tt.Username, tt.Password)
+			if tt.Error {
+				if err == nil {
+					t.Errorf("expected error but succeed as %#v", id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to login: %s", err)
+			}
+			if id.Subject != tt.Subject || id.Username != tt.Username {
+				t.Errorf("unexpected identity: %#v", id)
+			}
+		})
+	}
+}
+
+func TestDirectoryConnector_RouteByDomain(t *testing.T) {
+	c, corp, acquired := makeDirectories()
+
+	s, _ := c.Connect()
+	defer s.Close()
+
+	// the fake session doesn't strip the domain, so the user is not found if routed.
+	if _, err := s.LoginTest("bob@acquired.local", "bob-pass"); !errors.Is(err, ldap.UserNotFoundError) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if corp.connects != 0 || acquired.connects != 1 {
+		t.Errorf("should connect to only routed directory: corp=%d acquired=%d", corp.connects, acquired.connects)
+	}
+}
+
+func TestDirectoryConnector_Subject(t *testing.T) {
+	c, corp, acquired := makeDirectories()
+
+	s, _ := c.Connect()
+	defer s.Close()
+
+	attrs, err := s.GetUserAttributes("acquired:jsmith", []string{"uid"})
+	if err != nil {
+		t.Fatalf("failed to get attributes: %s", err)
+	}
+	if !reflect.DeepEqual(attrs, map[string][]string{"uid": {"jsmith"}}) {
+		t.Errorf("unexpected attributes: %#v", attrs)
+	}
+
+	if ok, err := s.MatchFilter("acquired:alice", "(uid=*)"); err != nil || ok {
+		t.Errorf("alice should not match in acquired directory: %v %v", ok, err)
+	}
+
+	for _, subject := range []string{"jsmith", "unknown:jsmith"} {
+		if _, err := s.GetUserAttributes(subject, nil); !errors.Is(err, ldap.UserNotFoundError) {
+			t.Errorf("%#v: unexpected error: %v", subject, err)
+		}
+	}
+
+	if corp.connects != 0 || acquired.connects != 1 {
+		t.Errorf("connection should be reused in a session: corp=%d acquired=%d", corp.connects, acquired.connects)
+	}
+}
+
+func TestDirectoryConnector_Unavailable(t *testing.T) {
+	c, corp, _ := makeDirectories()
+	corp.down = true
+
+	s, _ := c.Connect()
+	defer s.Close()
+
+	if id, err := s.LoginTest("bob", "bob-pass"); err != nil || id.Subject != "acquired:bob" {
+		t.Errorf("should skip unavailable directory: %#v %v", id, err)
+	}
+	if _, err := s.LoginTest("alice", "alice-pass"); !errors.Is(err, ldap.UnavailableError) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDirectoryConnector_ChangePassword(t *testing.T) {
+	c, corp, acquired := makeDirectories()
+
+	s, err := c.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer s.Close()
+
+	if err := ldap.ChangePasswordIn(s, "acquired", "jsmith", "acquired-pass", "new-pass"); err != nil {
+		t.Fatalf("failed to change password: %s", err)
+	}
+	if corp.passwords["jsmith"] != "corp-pass" || acquired.passwords["jsmith"] != "new-pass" {
+		t.Errorf("password of unexpected directory was changed: corp=%s acquired=%s", corp.passwords["jsmith"], acquired.passwords["jsmith"])
+	}
+
+	if err := ldap.ChangePasswordIn(s, "", "bob", "bob-pass", "new-pass"); err != nil {
+		t.Fatalf("failed to change password: %s", err)
+	}
+	if acquired.passwords["bob"] != "new-pass" {
+		t.Errorf("password was not changed: %s", acquired.passwords["bob"])
+	}
+
+	if err := ldap.ChangePasswordIn(s, "", "eve", "eve-pass", "new-pass"); !errors.Is(err, ldap.UserNotFoundError) {
+		t.Errorf("expected user not found error but got %v", err)
+	}
+
+	// wrapping hides ChangePassword of the underlying session.
+	if err := ldap.ChangePasswordIn(struct{ ldap.Session }{s}, "", "alice", "alice-pass", "new-pass"); err != ldap.PasswordChangeNotSupportedError {
+		t.Errorf("expected not supported error but got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package ldap
+
+import (
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/macrat/lauth/config"
+)
+
+const (
+	// GroupAttribute is the attribute that transformed by config.GroupConfig.
+	GroupAttribute = "memberOf"
+
+	// MaxGroupDepth is the maximum depth of nested groups to search recursively.
+	MaxGroupDepth = 16
+
+	matchingRuleInChain = "1.2.840.113556.1.4.1941"
+)
+
+// GroupDirectory is a directory to look up groups.
+type GroupDirectory interface {
+	// SearchGroupsInChain searches all groups that the user is member of, including nested groups.
+	SearchGroupsInChain(baseDN, userDN string, attributes []string) ([]*ldap.Entry, error)
+
+	// ReadEntry reads the entry. It returns nil if the entry was not found.
+	ReadEntry(dn string, attributes []string) (*ldap.Entry, error)
+}
+
+// ResolveGroups makes group names for the user as configured.
+//
+// memberOf is the direct groups of the user.
+// Nested groups are searched if conf.Nested is "in_chain" or "recursive".
+func ResolveGroups(dir GroupDirectory, conf config.GroupConfig, userDN string, memberOf []string) ([]string, error) {
+	attr := conf.NameAttribute()
+	attrs := []string{"1.1"}
+	if attr != "" {
+		attrs = []string{attr}
+	}
+
+	var entries []*ldap.Entry
+	var err error
+
+	switch conf.Nested {
+	case "in_chain":
+		entries, err = dir.SearchGroupsInChain(conf.BaseDN, userDN, attrs)
+	case "recursive":
+		entries, err = searchGroupsRecursive(dir, memberOf, attr)
+	default:
+		if attr == "" {
+			for _, dn := range memberOf {
+				entries = append(entries, ldap.NewEntry(dn, nil))
+			}
+		} else {
+			entries, err = readGroups(dir, memberOf, attrs)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name := groupName(conf, entry)
+		if name == "" || seen[name] || !conf.Allowed(name) {
+			continue
+		}
+		seen[name] = true
+		result = append(result, name)
+	}
+	return result, nil
+}
+
+func readGroups(dir GroupDirectory, dns []string, attributes []string) ([]*ldap.Entry, error) {
+	var entries []*ldap.Entry
+	for _, dn := range dns {
+		entry, err := dir.ReadEntry(dn, attributes)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func searchGroupsRecursive(dir GroupDirectory, memberOf []string, attr string) ([]*ldap.Entry, error) {
+	attrs := []string{GroupAttribute}
+	if attr != "" {
+		attrs = append(attrs, attr)
+	}
+
+	var entries []*ldap.Entry
+	visited := make(map[string]bool)
+	queue := memberOf
+
+	for depth := 0; len(queue) > 0 && depth < MaxGroupDepth; depth++ {
+		var next []string
+
+		for _, dn := range queue {
+			key := strings.ToLower(dn)
+			if visited[key] {
+				continue
+			}
+			visited[key] = true
+
+			entry, err := dir.ReadEntry(dn, attrs)
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				continue
+			}
+			entries = append(entries, entry)
+			next = append(next, entry.GetAttributeValues(GroupAttribute)...)
+		}
+
+		queue = next
+	}
+
+	return entries, nil
+}
+
+func groupName(conf config.GroupConfig, entry *ldap.Entry) string {
+	switch conf.Format {
+	case "", "dn":
+		return entry.DN
+	case "cn":
+		cn, err := config.GetCNByDN(entry.DN)
+		if err != nil {
+			return entry.DN
+		}
+		return cn
+	default:
+		return entry.GetAttributeValue(conf.Format)
+	}
+}
+
+func (c *SimpleSession) SearchGroupsInChain(baseDN, userDN string, attributes []string) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, // size limit
+		0, // time limit
+		false,
+		"(member:"+matchingRuleInChain+":="+ldap.EscapeFilter(userDN)+")",
+		attributes,
+		nil,
+	)
+
+	res, err := c.conn.Search(req)
+	if err != nil {
+		return nil, err
+	}
+	return res.Entries, nil
+}
+
+func (c *SimpleSession) ReadEntry(dn string, attributes []string) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		1, // size limit
+		0, // time limit
+		false,
+		"(objectClass=*)",
+		attributes,
+		nil,
+	)
+
+	res, err := c.conn.Search(req)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(res.Entries) == 0 {
+		return nil, nil
+	}
+	return res.Entries[0], nil
+}
@@ -0,0 +1,161 @@
+package ldap_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/ldap"
+)
+
+type fakeDirectory struct {
+	Groups map[string]map[string][]string
+	Reads  int
+}
+
+func (d *fakeDirectory) ReadEntry(dn string, attributes []string) (*goldap.Entry, error) {
+	d.Reads++
+	attrs, ok := d.Groups[strings.ToLower(dn)]
+	if !ok {
+		return nil, nil
+	}
+	return goldap.NewEntry(dn, attrs), nil
+}
+
+func (d *fakeDirectory) SearchGroupsInChain(baseDN, userDN string, attributes []string) ([]*goldap.Entry, error) {
+	var result []*goldap.Entry
+	for _, dn := range []string{"CN=app-users,OU=group,DC=example,DC=local", "CN=staff,OU=group,DC=example,DC=local", "CN=everyone,OU=group,DC=example,DC=local"} {
+		result = append(result, goldap.NewEntry(dn, d.Groups[strings.ToLower(dn)]))
+	}
+	return result, nil
+}
+
+func makeGroupConfig(t *testing.T, nested, format string, include, exclude []string) config.GroupConfig {
+	t.Helper()
+
+	conf := config.GroupConfig{Nested: nested, Format: format}
+	for _, p := range include {
+		var pat config.Pattern
+		if err := pat.UnmarshalText([]byte(p)); err != nil {
+			t.Fatalf("failed to parse pattern: %s", err)
+		}
+		conf.Include = append(conf.Include, pat)
+	}
+	for _, p := range exclude {
+		var pat config.Pattern
+		if err := pat.UnmarshalText([]byte(p)); err != nil {
+			t.Fatalf("failed to parse pattern: %s", err)
+		}
+		conf.Exclude = append(conf.Exclude, pat)
+	}
+	return conf
+}
+
+func TestResolveGroups(t *testing.T) {
+	dir := &fakeDirectory{
+		Groups: map[string]map[string][]string{
+			"cn=app-users,ou=group,dc=example,dc=local": {
+				"memberOf":       {"CN=staff,OU=group,DC=example,DC=local"},
+				"sAMAccountName": {"app-users"},
+			},
+			"cn=staff,ou=group,dc=example,dc=local": {
+				"memberOf":       {"CN=everyone,OU=group,DC=example,DC=local"},
+				"sAMAccountName": {"staff"},
+			},
+			"cn=everyone,ou=group,dc=example,dc=local": {
+				"memberOf":       {"CN=app-users,OU=group,DC=example,DC=local"}, // circular
+				"sAMAccountName": {"everyone"},
+			},
+		},
+	}
+	memberOf := []string{"CN=app-users,OU=group,DC=example,DC=local"}
+
+	tests := []struct {
+		Name    string
+		Nested  string
+		Format  string
+		Include []string
+		Exclude []string
+		Expect  []string
+	}{
+		{
+			Name:   "direct DN",
+			Expect: []string{"CN=app-users,OU=group,DC=example,DC=local"},
+		},
+		{
+			Name:   "direct CN",
+			Format: "cn",
+			Expect: []string{"app-users"},
+		},
+		{
+			Name:   "direct attribute",
+			Format: "sAMAccountName",
+			Expect: []string{"app-users"},
+		},
+		{
+			Name:   "recursive",
+			Nested: "recursive",
+			Format: "cn",
+			Expect: []string{"app-users", "staff", "everyone"},
+		},
+		{
+			Name:   "in chain",
+			Nested: "in_chain",
+			Format: "sAMAccountName",
+			Expect: []string{"app-users", "staff", "everyone"},
+		},
+		{
+			Name:    "include",
+			Nested:  "recursive",
+			Format:  "cn",
+			Include: []string{"app-*", "staff"},
+			Expect:  []string{"app-users", "staff"},
+		},
+		{
+			Name:    "exclude",
+			Nested:  "in_chain",
+			Exclude: []string{"CN=everyone,*"},
+			Expect:  []string{"CN=app-users,OU=group,DC=example,DC=local", "CN=staff,OU=group,DC=example,DC=local"},
+		},
+		{
+			Name:    "include and exclude",
+			Nested:  "recursive",
+			Format:  "cn",
+			Include: []string{"*"},
+			Exclude: []string{"every*"},
+			Expect:  []string{"app-users", "staff"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			conf := makeGroupConfig(t, tt.Nested, tt.Format, tt.Include, tt.Exclude)
+
+			groups, err := ldap.ResolveGroups(dir, conf, "CN=macrat,OU=user,DC=example,DC=local", memberOf)
+			if err != nil {
+				t.Fatalf("failed to resolve groups: %s", err)
+			}
+			if !reflect.DeepEqual(groups, tt.Expect) {
+				t.Errorf("unexpected groups:\nexpected: %#v\n but got: %#v", tt.Expect, groups)
+			}
+		})
+	}
+}
+
+func TestResolveGroups_NoQueryForDirectDN(t *testing.T) {
+	dir := &fakeDirectory{}
+	conf := makeGroupConfig(t, "none", "cn", nil, nil)
+
+	groups, err := ldap.ResolveGroups(dir, conf, "CN=macrat,DC=example,DC=local", []string{"CN=admin,OU=group,DC=example,DC=local", "CN=missing,DC=example,DC=local"})
+	if err != nil {
+		t.Fatalf("failed to resolve groups: %s", err)
+	}
+	if !reflect.DeepEqual(groups, []string{"admin", "missing"}) {
+		t.Errorf("unexpected groups: %#v", groups)
+	}
+	if dir.Reads != 0 {
+		t.Errorf("expected no read but read %d times", dir.Reads)
+	}
+}
@@ -2,8 +2,11 @@ package ldap
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"strings"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/macrat/lauth/config"
@@ -12,54 +15,163 @@ import (
 var (
 	UserNotFoundError       = fmt.Errorf("user was not found")
 	MultipleUsersFoundError = fmt.Errorf("multiple users was found")
+	NotEncryptedError       = fmt.Errorf("connection to LDAP server is not encrypted")
+	ClosedError             = fmt.Errorf("connection to LDAP server is closed")
+	UnavailableError        = fmt.Errorf("no LDAP server is available")
+	SubjectNotFoundError    = fmt.Errorf("user has no subject attribute")
 )
 
 type Connector interface {
 	Connect() (Session, error)
 }
 
+// Identity is the user that authenticated by the directory.
+type Identity struct {
+	// Subject is the stable identifier of the user, that used as "sub" claim.
+	Subject string
+
+	// Username is the login name of the user.
+	Username string
+}
+
 type Session interface {
 	io.Closer
 
-	LoginTest(username, password string) error
-	GetUserAttributes(username string, attributes []string) (map[string][]string, error)
+	// LoginTest checks the password of the user, and returns the identity of the user.
+	LoginTest(username, password string) (Identity, error)
+
+	GetUserAttributes(subject string, attributes []string) (map[string][]string, error)
+
+	// MatchFilter reports the user matches to the LDAP filter.
+	MatchFilter(subject, filter string) (bool, error)
+}
+
+// UserFinder is a Session that can search the user by an attribute, to link identities from other sources.
+type UserFinder interface {
+	FindUser(attribute, value string) (Identity, error)
+}
+
+// FindUser searches the user that has the value in the attribute.
+// It returns UserNotFoundError if the session doesn't support searching.
+func FindUser(s Session, attribute, value string) (Identity, error) {
+	if f, ok := s.(UserFinder); ok {
+		return f.FindUser(attribute, value)
+	}
+	return Identity{}, UserNotFoundError
+}
+
+// ServerNamer is a Session that knows which server it is connected to.
+type ServerNamer interface {
+	Server() string
+}
+
+// ServerOf returns URL of the server that the session is connected to, or empty string if unknown.
+func ServerOf(s Session) string {
+	if n, ok := s.(ServerNamer); ok {
+		return n.Server()
+	}
+	return ""
 }
 
 type SimpleConnector struct {
 	Config *config.LDAPConfig
+
+	// TLS is the configuration for ldaps:// and StartTLS. It is made from Config if nil.
+	TLS *tls.Config
 }
 
-func (c SimpleConnector) Connect() (Session, error) {
-	conn, err := ldap.DialURL(c.Config.Server.String())
+func NewSimpleConnector(conf *config.LDAPConfig) (SimpleConnector, error) {
+	tc, err := TLSConfig(conf)
 	if err != nil {
-		return nil, err
+		return SimpleConnector{}, err
+	}
+
+	return SimpleConnector{
+		Config: conf,
+		TLS:    tc,
+	}, nil
+}
+
+func (c SimpleConnector) Connect() (Session, error) {
+	return c.ConnectTo(c.Config.Server.URL())
+}
+
+// ConnectTo connects to the specified server with the credentials and TLS settings of Config.
+func (c SimpleConnector) ConnectTo(server *url.URL) (Session, error) {
+	tc := c.TLS
+	if tc == nil {
+		var err error
+		tc, err = TLSConfig(c.Config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.Config.TLSServerName == "" && tc.ServerName != server.Hostname() {
+		tc = tc.Clone()
+		tc.ServerName = server.Hostname()
 	}
 
-	err = conn.Bind(c.Config.User, c.Config.Password)
+	conn, err := ldap.DialURL(server.String(), ldap.DialWithTLSConfig(tc))
 	if err != nil {
-		conn.Close()
 		return nil, err
 	}
 
-	if c.Config.Server.Scheme != "ldaps" && !c.Config.DisableTLS {
-		err = conn.StartTLS(&tls.Config{InsecureSkipVerify: true})
+	// encryption must be set up before any bind, so that credentials never be sent in cleartext.
+	if server.Scheme != "ldaps" && !c.Config.DisableTLS {
+		err = conn.StartTLS(tc)
 		if err != nil {
 			conn.Close()
-			return nil, err
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
 		}
 	}
 
-	return &SimpleSession{
-		conn:        conn,
-		IDAttribute: c.Config.IDAttribute,
-		BaseDN:      c.Config.BaseDN,
-	}, nil
+	session := &SimpleSession{
+		conn:       conn,
+		server:     server.String(),
+		Config:     c.Config,
+		RequireTLS: !c.Config.DisableTLS,
+	}
+
+	err = session.bind(c.Config.User, c.Config.Password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return session, nil
 }
 
 type SimpleSession struct {
-	conn        *ldap.Conn
-	IDAttribute string
-	BaseDN      string
+	conn       *ldap.Conn
+	server     string
+	Config     *config.LDAPConfig
+	RequireTLS bool
+}
+
+func (c *SimpleSession) bind(username, password string) error {
+	if _, ok := c.conn.TLSConnectionState(); c.RequireTLS && !ok {
+		return NotEncryptedError
+	}
+	return c.conn.Bind(username, password)
+}
+
+// bindUser binds as the user with the password policy control, and returns BindError if the user can't login.
+func (c *SimpleSession) bindUser(dn, password string) error {
+	if _, ok := c.conn.TLSConnectionState(); c.RequireTLS && !ok {
+		return NotEncryptedError
+	}
+
+	res, err := c.conn.SimpleBind(&ldap.SimpleBindRequest{
+		Username: dn,
+		Password: password,
+		Controls: []ldap.Control{ldap.NewControlBeheraPasswordPolicy()},
+	})
+
+	var controls []ldap.Control
+	if res != nil {
+		controls = res.Controls
+	}
+	return ParseBindError(err, controls)
 }
 
 func (c *SimpleSession) Close() error {
@@ -67,15 +179,57 @@ func (c *SimpleSession) Close() error {
 	return nil
 }
 
-func (c *SimpleSession) searchUser(username string, attributes []string) (*ldap.Entry, error) {
+// Server returns URL of the connected server.
+func (c *SimpleSession) Server() string {
+	return c.server
+}
+
+func (c *SimpleSession) Closed() bool {
+	return c.conn.IsClosing()
+}
+
+// Ping checks the connection is still alive, by reading the root DSE.
+func (c *SimpleSession) Ping() error {
+	if c.conn.IsClosing() {
+		return ClosedError
+	}
+
 	req := ldap.NewSearchRequest(
-		c.BaseDN,
+		"",
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		1, // size limit
+		0, // time limit
+		false,
+		"(objectClass=*)",
+		[]string{"1.1"},
+		nil,
+	)
+	_, err := c.conn.Search(req)
+	return err
+}
+
+func (c *SimpleSession) searchUser(username, filter string, attributes []string) (*ldap.Entry, error) {
+	return c.search(c.Config.UserFilterFor(username), filter, attributes)
+}
+
+func (c *SimpleSession) searchSubject(subject, filter string, attributes []string) (*ldap.Entry, error) {
+	return c.search(c.Config.SubjectFilterFor(subject), filter, attributes)
+}
+
+func (c *SimpleSession) search(query, filter string, attributes []string) (*ldap.Entry, error) {
+	if filter != "" {
+		query = "(&" + query + filter + ")"
+	}
+
+	req := ldap.NewSearchRequest(
+		c.Config.BaseDN,
 		ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
 		2, // size limit
 		0, // time limit
 		false,
-		fmt.Sprintf("(&(objectClass=person)(%s=%s))", c.IDAttribute, username),
+		query,
 		attributes,
 		nil,
 	)
@@ -95,17 +249,134 @@ func (c *SimpleSession) searchUser(username string, attributes []string) (*ldap.
 	return res.Entries[0], nil
 }
 
-func (c *SimpleSession) LoginTest(username, password string) error {
-	user, err := c.searchUser(username, []string{"dn"})
+func (c *SimpleSession) identityAttributes() []string {
+	attrs := []string{"dn"}
+	if c.Config.IDAttribute != "" {
+		attrs = append(attrs, c.Config.IDAttribute)
+	}
+	if c.Config.SubjectAttribute != "" {
+		attrs = append(attrs, c.Config.SubjectAttribute)
+	}
+	return attrs
+}
+
+// identityOf makes Identity from the entry that searched with identityAttributes.
+// username is used if the entry has no IDAttribute.
+func (c *SimpleSession) identityOf(user *ldap.Entry, username string) (Identity, error) {
+	if canonical := user.GetAttributeValue(c.Config.IDAttribute); c.Config.IDAttribute != "" && canonical != "" {
+		username = canonical
+	}
+
+	id := Identity{
+		Subject:  username,
+		Username: username,
+	}
+	if c.Config.SubjectAttribute != "" {
+		value := user.GetAttributeValue(c.Config.SubjectAttribute)
+		if value == "" {
+			return Identity{}, SubjectNotFoundError
+		}
+		id.Subject = c.Config.SubjectFor(value)
+	}
+	return id, nil
+}
+
+// LoginTest checks the password of the user.
+//
+// The username can be any of LoginAttributes, and can include LoginDomains.
+// Username of the returned Identity is the canonical value of IDAttribute in the LDAP server.
+//
+// If the LDAP server rejects the user, the error is BindError that has the reason like PasswordExpiredError.
+func (c *SimpleSession) LoginTest(username, password string) (Identity, error) {
+	username = c.Config.NormalizeUsername(username)
+
+	user, err := c.searchUser(username, "", c.identityAttributes())
+	if err != nil {
+		return Identity{}, err
+	}
+
+	id, err := c.identityOf(user, username)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	err = c.bindUser(user.DN, password)
+
+	if rerr := c.rebind(); rerr != nil && err == nil {
+		err = rerr
+	}
+
+	if err != nil {
+		return Identity{}, err
+	}
+	return id, nil
+}
+
+// rebind binds as the service account again, after bound as a user.
+// The connection will be closed if failed.
+func (c *SimpleSession) rebind() error {
+	err := c.bind(c.Config.User, c.Config.Password)
+	if err != nil {
+		c.conn.Close()
+	}
+	return err
+}
+
+// ChangePassword changes password of the user who knows the current password.
+//
+// How to change is decided by PasswordChange of Config.
+// "active-directory" replaces unicodePwd attribute as the service account, and "password-modify" uses the password modify extended operation (RFC 3062) as the user.
+// Users who can't bind because of expired password can change only in Active Directory.
+func (c *SimpleSession) ChangePassword(username, oldPassword, newPassword string) error {
+	if c.Config.PasswordChange == "" {
+		return PasswordChangeNotSupportedError
+	}
+	if _, ok := c.conn.TLSConnectionState(); c.RequireTLS && !ok {
+		return NotEncryptedError
+	}
+
+	user, err := c.searchUser(c.Config.NormalizeUsername(username), "", []string{"dn"})
 	if err != nil {
 		return err
 	}
 
-	return c.conn.Bind(user.DN, password)
+	if c.Config.PasswordChange == "active-directory" {
+		req := ldap.NewModifyRequest(user.DN, nil)
+		req.Delete("unicodePwd", []string{encodeADPassword(oldPassword)})
+		req.Add("unicodePwd", []string{encodeADPassword(newPassword)})
+		return parseChangeError(c.conn.Modify(req))
+	}
+
+	err = c.bindUser(user.DN, oldPassword)
+	if err == nil || errors.Is(err, PasswordMustChangeError) {
+		_, err = c.conn.PasswordModify(ldap.NewPasswordModifyRequest("", oldPassword, newPassword))
+		err = parseChangeError(err)
+	}
+
+	if rerr := c.rebind(); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// FindUser searches the user that has the value in the attribute, like mail.
+func (c *SimpleSession) FindUser(attribute, value string) (Identity, error) {
+	query := fmt.Sprintf("(&%s(%s=%s))", strings.ReplaceAll(c.Config.UserFilterTemplate(), "{username}", "*"), attribute, ldap.EscapeFilter(value))
+
+	user, err := c.search(query, "", c.identityAttributes())
+	if err != nil {
+		return Identity{}, err
+	}
+
+	id, err := c.identityOf(user, "")
+	if err == nil && id.Subject == "" {
+		err = UserNotFoundError
+	}
+	return id, err
 }
 
-func (c *SimpleSession) GetUserAttributes(username string, attributes []string) (map[string][]string, error) {
-	user, err := c.searchUser(username, attributes)
+func (c *SimpleSession) GetUserAttributes(subject string, attributes []string) (map[string][]string, error) {
+	user, err := c.searchSubject(subject, "", attributes)
 	if err != nil {
 		return nil, err
 	}
@@ -113,8 +384,25 @@ func (c *SimpleSession) GetUserAttributes(username string, attributes []string)
 	result := make(map[string][]string)
 
 	for _, attr := range attributes {
-		result[attr] = user.GetAttributeValues(attr)
+		if strings.EqualFold(attr, GroupAttribute) && c.Config.Groups.Enabled() {
+			result[attr], err = ResolveGroups(c, c.Config.Groups, user.DN, user.GetAttributeValues(attr))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			result[attr] = user.GetAttributeValues(attr)
+		}
 	}
 
 	return result, nil
 }
+
+func (c *SimpleSession) MatchFilter(subject, filter string) (bool, error) {
+	_, err := c.searchSubject(subject, filter, []string{"dn"})
+	if err == UserNotFoundError {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,82 @@
+package ldap_test
+
+import (
+	"net"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/ldap"
+)
+
+// startNoTLSServer starts a LDAP server that doesn't support StartTLS.
+// It sends the first operation tag that received from client.
+func startNoTLSServer(t *testing.T) (*config.URL, <-chan ber.Tag) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ops := make(chan ber.Tag, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1].Tag
+		ops <- op
+
+		if op == goldap.ApplicationExtendedRequest {
+			resp := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			resp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+			result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationExtendedResponse, nil, "Extended Response")
+			result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(goldap.LDAPResultProtocolError), "resultCode"))
+			result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+			result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "StartTLS is not supported", "diagnosticMessage"))
+			resp.AppendChild(result)
+			conn.Write(resp.Bytes())
+
+			ber.ReadPacket(conn)
+		}
+	}()
+
+	u := &config.URL{}
+	if err := u.Set("ldap://" + l.Addr().String()); err != nil {
+		t.Fatalf("failed to parse URL: %s", err)
+	}
+	return u, ops
+}
+
+func TestSimpleConnector_StartTLSBeforeBind(t *testing.T) {
+	server, ops := startNoTLSServer(t)
+
+	connector, err := ldap.NewSimpleConnector(&config.LDAPConfig{
+		Server:   server,
+		User:     "CN=service,DC=example,DC=local",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("failed to make connector: %s", err)
+	}
+
+	if conn, err := connector.Connect(); err == nil {
+		conn.Close()
+		t.Fatalf("expected fail to connect but succeed")
+	}
+
+	if op := <-ops; op != goldap.ApplicationExtendedRequest {
+		t.Errorf("expected StartTLS request at first but got %s", goldap.ApplicationMap[uint8(op)])
+	}
+}
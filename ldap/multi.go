@@ -0,0 +1,222 @@
+package ldap
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DefaultMinBackoff = time.Second
+	SRVCacheDuration  = time.Minute
+)
+
+type serverHealth struct {
+	Failures   int
+	RetryAfter time.Time
+}
+
+// MultiConnector is a Connector that selects one server from multiple LDAP servers.
+//
+// A server that failed to connect is skipped until its backoff time is over.
+// The backoff time starts from MinBackoff and doubles on each failure until MaxBackoff.
+type MultiConnector struct {
+	// Dial connects to the server.
+	Dial func(server *url.URL) (Session, error)
+
+	Servers []*url.URL
+
+	// SRV is a DNS SRV record name like "_ldap._tcp.example.local".
+	// Servers in the record are used after Servers.
+	SRV string
+
+	// Strategy is "failover" or "round-robin". Default is "failover".
+	Strategy string
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// LookupSRV resolves the SRV record. Uses net.LookupSRV if nil.
+	LookupSRV func(name string) ([]*net.SRV, error)
+
+	mu       sync.Mutex
+	next     int
+	health   map[string]*serverHealth
+	srv      []*url.URL
+	srvSince time.Time
+}
+
+// NewMultiConnector makes MultiConnector that connects via SimpleConnector.
+func NewMultiConnector(conf *config.LDAPConfig, dialer SimpleConnector) (*MultiConnector, error) {
+	servers, err := conf.ServerURLs()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultiConnector{
+		Dial:       dialer.ConnectTo,
+		Servers:    servers,
+		SRV:        conf.SRV,
+		Strategy:   conf.Strategy,
+		MaxBackoff: conf.MaxBackoff.Duration(),
+	}, nil
+}
+
+func (c *MultiConnector) lookupSRV() []*url.URL {
+	if c.SRV == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	cache, since := c.srv, c.srvSince
+	c.mu.Unlock()
+
+	if cache != nil && time.Since(since) < SRVCacheDuration {
+		return cache
+	}
+
+	lookup := c.LookupSRV
+	if lookup == nil {
+		lookup = func(name string) ([]*net.SRV, error) {
+			_, addrs, err := net.LookupSRV("", "", name)
+			return addrs, err
+		}
+	}
+
+	addrs, err := lookup(c.SRV)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("srv", c.SRV).
+			Msg("failed to lookup SRV record of LDAP server")
+		return cache
+	}
+
+	scheme := "ldap"
+	if strings.HasPrefix(c.SRV, "_ldaps.") {
+		scheme = "ldaps"
+	}
+
+	servers := make([]*url.URL, len(addrs))
+	for i, addr := range addrs {
+		servers[i] = &url.URL{
+			Scheme: scheme,
+			Host:   net.JoinHostPort(strings.TrimSuffix(addr.Target, "."), strconv.Itoa(int(addr.Port))),
+		}
+	}
+
+	c.mu.Lock()
+	c.srv = servers
+	c.srvSince = time.Now()
+	c.mu.Unlock()
+
+	return servers
+}
+
+// candidates returns servers in the order to try. Servers in backoff are excluded.
+func (c *MultiConnector) candidates() []*url.URL {
+	all := append(append([]*url.URL{}, c.Servers...), c.lookupSRV()...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Strategy == "round-robin" && len(all) > 0 {
+		offset := c.next % len(all)
+		c.next = offset + 1
+		all = append(all[offset:], all[:offset]...)
+	}
+
+	now := time.Now()
+	result := make([]*url.URL, 0, len(all))
+	for _, s := range all {
+		if h, ok := c.health[s.String()]; !ok || !now.Before(h.RetryAfter) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func (c *MultiConnector) markDown(server *url.URL, err error) {
+	c.mu.Lock()
+	if c.health == nil {
+		c.health = make(map[string]*serverHealth)
+	}
+	h, ok := c.health[server.String()]
+	if !ok {
+		h = &serverHealth{}
+		c.health[server.String()] = h
+	}
+
+	backoff := c.MinBackoff
+	if backoff <= 0 {
+		backoff = DefaultMinBackoff
+	}
+	for i := 0; i < h.Failures && (c.MaxBackoff <= 0 || backoff < c.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if c.MaxBackoff > 0 && backoff > c.MaxBackoff {
+		backoff = c.MaxBackoff
+	}
+
+	h.Failures++
+	h.RetryAfter = time.Now().Add(backoff)
+	failures := h.Failures
+	c.mu.Unlock()
+
+	metrics.LDAPServerStatus(server.String(), false)
+	log.Warn().
+		Err(err).
+		Str("ldap_server", server.String()).
+		Int("failures", failures).
+		Dur("backoff", backoff).
+		Msg("failed to connect LDAP server")
+}
+
+func (c *MultiConnector) markUp(server *url.URL) {
+	c.mu.Lock()
+	h, ok := c.health[server.String()]
+	if ok {
+		delete(c.health, server.String())
+	}
+	c.mu.Unlock()
+
+	metrics.LDAPServerStatus(server.String(), true)
+	if ok {
+		log.Info().
+			Str("ldap_server", server.String()).
+			Int("failures", h.Failures).
+			Msg("LDAP server recovered")
+	}
+}
+
+// Connect connects to the first available server.
+//
+// It returns an error that wraps UnavailableError if no server could connect.
+func (c *MultiConnector) Connect() (Session, error) {
+	servers := c.candidates()
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%w: all servers are backing off", UnavailableError)
+	}
+
+	var lastErr error
+	for _, server := range servers {
+		s, err := c.Dial(server)
+		if err != nil {
+			c.markDown(server, err)
+			lastErr = err
+			continue
+		}
+		c.markUp(server)
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", UnavailableError, lastErr)
+}
@@ -0,0 +1,191 @@
+package ldap_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/ldap"
+)
+
+type serverSession struct {
+	fakeSession
+	server string
+}
+
+func (s *serverSession) Server() string {
+	return s.server
+}
+
+type fakeServers struct {
+	mu    sync.Mutex
+	down  map[string]bool
+	dials []string
+}
+
+func (f *fakeServers) Dial(server *url.URL) (ldap.Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.dials = append(f.dials, server.Host)
+	if f.down[server.Host] {
+		return nil, fmt.Errorf("%s is down", server.Host)
+	}
+	return &serverSession{server: server.String()}, nil
+}
+
+func (f *fakeServers) SetDown(host string, down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down[host] = down
+}
+
+func (f *fakeServers) TakeDials() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ds := f.dials
+	f.dials = nil
+	return ds
+}
+
+func makeServers(hosts ...string) []*url.URL {
+	us := make([]*url.URL, len(hosts))
+	for i, h := range hosts {
+		us[i] = &url.URL{Scheme: "ldap", Host: h}
+	}
+	return us
+}
+
+func connectedServer(t *testing.T, c ldap.Connector) string {
+	t.Helper()
+
+	s, err := c.Connect()
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer s.Close()
+	return ldap.ServerOf(s)
+}
+
+func TestMultiConnector_Failover(t *testing.T) {
+	servers := &fakeServers{down: map[string]bool{}}
+	c := &ldap.MultiConnector{
+		Dial:       servers.Dial,
+		Servers:    makeServers("dc01", "dc02"),
+		Strategy:   "failover",
+		MinBackoff: 50 * time.Millisecond,
+	}
+
+	if s := connectedServer(t, c); s != "ldap://dc01" {
+		t.Errorf("expected to use primary server but got %s", s)
+	}
+	if s := connectedServer(t, c); s != "ldap://dc01" {
+		t.Errorf("expected to use primary server but got %s", s)
+	}
+	servers.TakeDials()
+
+	servers.SetDown("dc01", true)
+	if s := connectedServer(t, c); s != "ldap://dc02" {
+		t.Errorf("expected to use secondary server but got %s", s)
+	}
+	if ds := servers.TakeDials(); !reflect.DeepEqual(ds, []string{"dc01", "dc02"}) {
+		t.Errorf("unexpected dials: %v", ds)
+	}
+
+	if s := connectedServer(t, c); s != "ldap://dc02" {
+		t.Errorf("expected to use secondary server but got %s", s)
+	}
+	if ds := servers.TakeDials(); !reflect.DeepEqual(ds, []string{"dc02"}) {
+		t.Errorf("server in backoff should be skipped: %v", ds)
+	}
+
+	servers.SetDown("dc01", false)
+	time.Sleep(60 * time.Millisecond)
+	if s := connectedServer(t, c); s != "ldap://dc01" {
+		t.Errorf("expected to back to primary server but got %s", s)
+	}
+}
+
+func TestMultiConnector_RoundRobin(t *testing.T) {
+	servers := &fakeServers{down: map[string]bool{}}
+	c := &ldap.MultiConnector{
+		Dial:     servers.Dial,
+		Servers:  makeServers("dc01", "dc02", "dc03"),
+		Strategy: "round-robin",
+	}
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, connectedServer(t, c))
+	}
+	expect := []string{"ldap://dc01", "ldap://dc02", "ldap://dc03", "ldap://dc01", "ldap://dc02", "ldap://dc03"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("unexpected servers:\nexpected: %v\n but got: %v", expect, got)
+	}
+
+	servers.SetDown("dc02", true)
+	got = nil
+	for i := 0; i < 3; i++ {
+		got = append(got, connectedServer(t, c))
+	}
+	expect = []string{"ldap://dc01", "ldap://dc03", "ldap://dc03"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("unexpected servers:\nexpected: %v\n but got: %v", expect, got)
+	}
+}
+
+func TestMultiConnector_Unavailable(t *testing.T) {
+	servers := &fakeServers{down: map[string]bool{"dc01": true, "dc02": true}}
+	c := &ldap.MultiConnector{
+		Dial:       servers.Dial,
+		Servers:    makeServers("dc01", "dc02"),
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Connect(); !errors.Is(err, ldap.UnavailableError) {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		}
+	}
+	if ds := servers.TakeDials(); !reflect.DeepEqual(ds, []string{"dc01", "dc02"}) {
+		t.Errorf("servers in backoff should not be dialed: %v", ds)
+	}
+}
+
+func TestMultiConnector_SRV(t *testing.T) {
+	servers := &fakeServers{down: map[string]bool{}}
+	lookups := 0
+	c := &ldap.MultiConnector{
+		Dial: servers.Dial,
+		SRV:  "_ldaps._tcp.example.local",
+		LookupSRV: func(name string) ([]*net.SRV, error) {
+			lookups++
+			if name != "_ldaps._tcp.example.local" {
+				t.Errorf("unexpected SRV name: %s", name)
+			}
+			return []*net.SRV{
+				{Target: "dc01.example.local.", Port: 636},
+				{Target: "dc02.example.local.", Port: 3269},
+			}, nil
+		},
+	}
+
+	if s := connectedServer(t, c); s != "ldaps://dc01.example.local:636" {
+		t.Errorf("unexpected server: %s", s)
+	}
+
+	servers.SetDown("dc01.example.local:636", true)
+	if s := connectedServer(t, c); s != "ldaps://dc02.example.local:3269" {
+		t.Errorf("unexpected server: %s", s)
+	}
+
+	if lookups != 1 {
+		t.Errorf("SRV record should be cached but looked up %d times", lookups)
+	}
+}
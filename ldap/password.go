@@ -0,0 +1,171 @@
+package ldap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	InvalidCredentialsError         = fmt.Errorf("invalid username or password")
+	LogonTimeRestrictedError        = fmt.Errorf("user is not permitted to logon at this time")
+	WorkstationRestrictedError      = fmt.Errorf("user is not permitted to logon at this workstation")
+	PasswordExpiredError            = fmt.Errorf("password has expired")
+	AccountDisabledError            = fmt.Errorf("account is disabled")
+	AccountExpiredError             = fmt.Errorf("account has expired")
+	PasswordMustChangeError         = fmt.Errorf("password must be changed")
+	AccountLockedError              = fmt.Errorf("account is locked out")
+	PasswordPolicyError             = fmt.Errorf("password does not meet the password policy")
+	PasswordChangeNotSupportedError = fmt.Errorf("password change is not supported")
+)
+
+// BindError is the error of binding as the user, with the reason that told by the LDAP server.
+//
+// It can be compared with the reason by errors.Is, like errors.Is(err, PasswordExpiredError).
+type BindError struct {
+	Reason error
+
+	// Err is the original error from the LDAP server, or nil if the bind succeeded but the user has to do something.
+	Err error
+}
+
+func (e *BindError) Error() string {
+	if e.Err == nil {
+		return e.Reason.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Reason, e.Err)
+}
+
+func (e *BindError) Is(target error) bool {
+	return e.Reason == target
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// adBindDataPattern matches the sub-code in diagnostic message of Active Directory, like "80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data 52e, v4563".
+	adBindDataPattern = regexp.MustCompile(`\bdata ([0-9a-fA-F]+)\b`)
+
+	// adErrorCodePattern matches the Win32 error code at the head of diagnostic message of Active Directory, like "0000052D: Constraint violation - ...".
+	adErrorCodePattern = regexp.MustCompile(`^0*([0-9a-fA-F]+):`)
+)
+
+// adBindReasons are the reasons for sub-codes of invalid credentials error of Active Directory.
+var adBindReasons = map[string]error{
+	"525": InvalidCredentialsError,
+	"52e": InvalidCredentialsError,
+	"530": LogonTimeRestrictedError,
+	"531": WorkstationRestrictedError,
+	"532": PasswordExpiredError,
+	"533": AccountDisabledError,
+	"701": AccountExpiredError,
+	"773": PasswordMustChangeError,
+	"775": AccountLockedError,
+}
+
+// ppolicyReason returns the reason in the password policy controls, or nil if nothing.
+func ppolicyReason(controls []ldap.Control) error {
+	for _, c := range controls {
+		switch c := c.(type) {
+		case *ldap.ControlBeheraPasswordPolicy:
+			switch c.Error {
+			case ldap.BeheraPasswordExpired:
+				return PasswordExpiredError
+			case ldap.BeheraAccountLocked:
+				return AccountLockedError
+			case ldap.BeheraChangeAfterReset:
+				return PasswordMustChangeError
+			}
+		case *ldap.ControlVChuPasswordMustChange:
+			if c.MustChange {
+				return PasswordMustChangeError
+			}
+		}
+	}
+	return nil
+}
+
+// ParseBindError converts the result of binding as the user to BindError.
+//
+// The reason is taken from the password policy controls (draft-behera-ldap-password-policy and draft-vchu-ldap-pwd-policy), or from the diagnostic message of Active Directory.
+// It returns BindError even if err is nil, when the controls tell the user has to change the password.
+// Errors that are not about the user, like network errors, are returned as is.
+func ParseBindError(err error, controls []ldap.Control) error {
+	if reason := ppolicyReason(controls); reason != nil {
+		return &BindError{Reason: reason, Err: err}
+	}
+
+	var lerr *ldap.Error
+	if err == nil || !errors.As(err, &lerr) || lerr.ResultCode != ldap.LDAPResultInvalidCredentials {
+		return err
+	}
+
+	if m := adBindDataPattern.FindStringSubmatch(lerr.Err.Error()); m != nil {
+		if reason, ok := adBindReasons[strings.ToLower(m[1])]; ok {
+			return &BindError{Reason: reason, Err: err}
+		}
+	}
+	return &BindError{Reason: InvalidCredentialsError, Err: err}
+}
+
+// parseChangeError converts the error of changing password to BindError.
+func parseChangeError(err error) error {
+	var lerr *ldap.Error
+	if err == nil || !errors.As(err, &lerr) {
+		return err
+	}
+
+	switch lerr.ResultCode {
+	case ldap.LDAPResultInvalidCredentials:
+		return &BindError{Reason: InvalidCredentialsError, Err: err}
+	case ldap.LDAPResultConstraintViolation:
+		// Active Directory reports wrong current password as ERROR_INVALID_PASSWORD (0x56).
+		if m := adErrorCodePattern.FindStringSubmatch(lerr.Err.Error()); m != nil && m[1] == "56" {
+			return &BindError{Reason: InvalidCredentialsError, Err: err}
+		}
+		return &BindError{Reason: PasswordPolicyError, Err: err}
+	}
+	return err
+}
+
+// encodeADPassword encodes the password for unicodePwd attribute of Active Directory.
+func encodeADPassword(password string) string {
+	encoded := utf16.Encode([]rune(`"` + password + `"`))
+	buf := make([]byte, len(encoded)*2)
+	for i, c := range encoded {
+		binary.LittleEndian.PutUint16(buf[i*2:], c)
+	}
+	return string(buf)
+}
+
+// PasswordChanger is a Session that can change password of users.
+type PasswordChanger interface {
+	// ChangePassword changes password of the user who knows the current password.
+	ChangePassword(username, oldPassword, newPassword string) error
+}
+
+// DirectoryPasswordChanger is a Session that can change password in the picked directory.
+type DirectoryPasswordChanger interface {
+	ChangePasswordIn(directory, username, oldPassword, newPassword string) error
+}
+
+// ChangePasswordIn changes password of the user in the directory if the session supports directories.
+// Otherwise, directory is ignored.
+//
+// It returns PasswordChangeNotSupportedError if the session can't change password.
+func ChangePasswordIn(s Session, directory, username, oldPassword, newPassword string) error {
+	if c, ok := s.(DirectoryPasswordChanger); ok && directory != "" {
+		return c.ChangePasswordIn(directory, username, oldPassword, newPassword)
+	}
+	if c, ok := s.(PasswordChanger); ok {
+		return c.ChangePassword(username, oldPassword, newPassword)
+	}
+	return PasswordChangeNotSupportedError
+}
@@ -0,0 +1,91 @@
+package ldap_test
+
+import (
+	"errors"
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/macrat/lauth/ldap"
+)
+
+func adError(data string) error {
+	return goldap.NewError(goldap.LDAPResultInvalidCredentials, errors.New("80090308: LdapErr: DSID-0C09044E, comment: AcceptSecurityContext error, data "+data+", v4563"))
+}
+
+func TestParseBindError(t *testing.T) {
+	network := goldap.NewError(goldap.ErrorNetwork, errors.New("connection closed"))
+
+	tests := []struct {
+		Name     string
+		Err      error
+		Controls []goldap.Control
+		Reason   error
+	}{
+		{"success", nil, nil, nil},
+		{"network error", network, nil, network},
+		{"invalid credentials", goldap.NewError(goldap.LDAPResultInvalidCredentials, errors.New("")), nil, ldap.InvalidCredentialsError},
+		{"AD wrong password", adError("52e"), nil, ldap.InvalidCredentialsError},
+		{"AD logon hours", adError("530"), nil, ldap.LogonTimeRestrictedError},
+		{"AD workstation", adError("531"), nil, ldap.WorkstationRestrictedError},
+		{"AD password expired", adError("532"), nil, ldap.PasswordExpiredError},
+		{"AD disabled", adError("533"), nil, ldap.AccountDisabledError},
+		{"AD account expired", adError("701"), nil, ldap.AccountExpiredError},
+		{"AD must change", adError("773"), nil, ldap.PasswordMustChangeError},
+		{"AD locked", adError("775"), nil, ldap.AccountLockedError},
+		{"AD unknown code", adError("999"), nil, ldap.InvalidCredentialsError},
+		{
+			"ppolicy expired",
+			goldap.NewError(goldap.LDAPResultInvalidCredentials, errors.New("")),
+			[]goldap.Control{&goldap.ControlBeheraPasswordPolicy{Expire: -1, Grace: -1, Error: goldap.BeheraPasswordExpired}},
+			ldap.PasswordExpiredError,
+		},
+		{
+			"ppolicy locked",
+			goldap.NewError(goldap.LDAPResultInvalidCredentials, errors.New("")),
+			[]goldap.Control{&goldap.ControlBeheraPasswordPolicy{Expire: -1, Grace: -1, Error: goldap.BeheraAccountLocked}},
+			ldap.AccountLockedError,
+		},
+		{
+			"ppolicy change after reset",
+			nil,
+			[]goldap.Control{&goldap.ControlBeheraPasswordPolicy{Expire: -1, Grace: -1, Error: goldap.BeheraChangeAfterReset}},
+			ldap.PasswordMustChangeError,
+		},
+		{
+			"ppolicy grace login",
+			nil,
+			[]goldap.Control{&goldap.ControlBeheraPasswordPolicy{Expire: -1, Grace: 2, Error: -1}},
+			nil,
+		},
+		{
+			"vchu must change",
+			nil,
+			[]goldap.Control{&goldap.ControlVChuPasswordMustChange{MustChange: true}},
+			ldap.PasswordMustChangeError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := ldap.ParseBindError(tt.Err, tt.Controls)
+
+			if tt.Reason == nil || tt.Reason == network {
+				if err != tt.Reason {
+					t.Errorf("expected %v but got %v", tt.Reason, err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.Reason) {
+				t.Errorf("expected %v but got %v", tt.Reason, err)
+			}
+			var berr *ldap.BindError
+			if !errors.As(err, &berr) {
+				t.Fatalf("expected BindError but got %T", err)
+			}
+			if tt.Err != nil && !errors.Is(err, tt.Err) {
+				t.Errorf("original error is lost: %v", err)
+			}
+		})
+	}
+}
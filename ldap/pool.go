@@ -0,0 +1,257 @@
+package ldap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/macrat/lauth/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// HealthChecker is a Session that can check the connection is still usable.
+type HealthChecker interface {
+	// Ping checks the connection with the server.
+	Ping() error
+
+	// Closed reports the connection was closed, for example by network error.
+	Closed() bool
+}
+
+type idleSession struct {
+	Session Session
+	Since   time.Time
+}
+
+// PooledConnector is a Connector that reuses sessions of underlying Connector.
+type PooledConnector struct {
+	Connector           Connector
+	MinIdle             int
+	MaxIdle             int
+	HealthCheckInterval time.Duration
+
+	mu     sync.Mutex
+	idle   []idleSession
+	active int
+	closed bool
+	stop   chan struct{}
+}
+
+// NewPooledConnector makes PooledConnector and starts background health checking.
+func NewPooledConnector(connector Connector, minIdle, maxIdle int, healthCheckInterval time.Duration) *PooledConnector {
+	p := &PooledConnector{
+		Connector:           connector,
+		MinIdle:             minIdle,
+		MaxIdle:             maxIdle,
+		HealthCheckInterval: healthCheckInterval,
+		stop:                make(chan struct{}),
+	}
+	go p.maintain()
+	return p
+}
+
+func isHealthy(s Session) bool {
+	if hc, ok := s.(HealthChecker); ok {
+		return !hc.Closed() && hc.Ping() == nil
+	}
+	return true
+}
+
+func isClosed(s Session) bool {
+	if hc, ok := s.(HealthChecker); ok {
+		return hc.Closed()
+	}
+	return false
+}
+
+func (p *PooledConnector) reportStatus() {
+	metrics.LDAPPoolStatus(len(p.idle), p.active)
+}
+
+func (p *PooledConnector) Connect() (Session, error) {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		s := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.active++
+		p.reportStatus()
+		p.mu.Unlock()
+
+		if p.HealthCheckInterval > 0 && time.Since(s.Since) >= p.HealthCheckInterval && !isHealthy(s.Session) {
+			s.Session.Close()
+			metrics.LDAPPoolDiscarded("unhealthy")
+			p.mu.Lock()
+			p.active--
+			p.reportStatus()
+			p.mu.Unlock()
+			continue
+		}
+
+		metrics.LDAPPoolAcquired("reuse")
+		return &pooledSession{Session: s.Session, pool: p}, nil
+	}
+
+	s, err := p.Connector.Connect()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.active++
+	p.reportStatus()
+	p.mu.Unlock()
+
+	metrics.LDAPPoolAcquired("new")
+	return &pooledSession{Session: s, pool: p}, nil
+}
+
+func (p *PooledConnector) release(s Session) {
+	p.mu.Lock()
+	p.active--
+
+	if isClosed(s) {
+		p.reportStatus()
+		p.mu.Unlock()
+		s.Close()
+		metrics.LDAPPoolDiscarded("broken")
+		return
+	}
+
+	if p.closed || len(p.idle) >= p.MaxIdle {
+		p.reportStatus()
+		p.mu.Unlock()
+		s.Close()
+		metrics.LDAPPoolDiscarded("overflow")
+		return
+	}
+
+	p.idle = append(p.idle, idleSession{Session: s, Since: time.Now()})
+	p.reportStatus()
+	p.mu.Unlock()
+}
+
+// Idle returns the number of idle sessions in the pool.
+func (p *PooledConnector) Idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// check closes unhealthy idle sessions, and connects new sessions until MinIdle.
+func (p *PooledConnector) check() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	var alive []idleSession
+	for _, s := range idle {
+		if isHealthy(s.Session) {
+			s.Since = time.Now()
+			alive = append(alive, s)
+		} else {
+			s.Session.Close()
+			metrics.LDAPPoolDiscarded("unhealthy")
+		}
+	}
+
+	for len(alive) < p.MinIdle {
+		s, err := p.Connector.Connect()
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Msg("failed to connect LDAP server for connection pool")
+			break
+		}
+		alive = append(alive, idleSession{Session: s, Since: time.Now()})
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		for _, s := range alive {
+			s.Session.Close()
+		}
+		return
+	}
+	p.idle = append(p.idle, alive...)
+	for len(p.idle) > p.MaxIdle {
+		p.idle[0].Session.Close()
+		p.idle = p.idle[1:]
+		metrics.LDAPPoolDiscarded("overflow")
+	}
+	p.reportStatus()
+	p.mu.Unlock()
+}
+
+func (p *PooledConnector) maintain() {
+	interval := p.HealthCheckInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	p.check()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.check()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Close stops health checking and closes all idle sessions.
+func (p *PooledConnector) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.stop)
+
+	for _, s := range p.idle {
+		s.Session.Close()
+	}
+	p.idle = nil
+	p.reportStatus()
+
+	return nil
+}
+
+type pooledSession struct {
+	Session
+	pool *PooledConnector
+	once sync.Once
+}
+
+// Close returns the session to the pool.
+func (s *pooledSession) Close() error {
+	s.once.Do(func() {
+		s.pool.release(s.Session)
+	})
+	return nil
+}
+
+// ChangePassword changes password of the user via the underlying session.
+func (s *pooledSession) ChangePassword(username, oldPassword, newPassword string) error {
+	return ChangePasswordIn(s.Session, "", username, oldPassword, newPassword)
+}
+
+// FindUser searches the user via the underlying session.
+func (s *pooledSession) FindUser(attribute, value string) (Identity, error) {
+	return FindUser(s.Session, attribute, value)
+}
+
+// Server returns URL of the server that the underlying session is connected to.
+func (s *pooledSession) Server() string {
+	return ServerOf(s.Session)
+}
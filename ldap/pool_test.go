@@ -0,0 +1,184 @@
+package ldap_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/ldap"
+)
+
+type fakeSession struct {
+	mu      sync.Mutex
+	id      int
+	closed  bool
+	broken  bool
+	healthy bool
+}
+
+func (s *fakeSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeSession) LoginTest(username, password string) (ldap.Identity, error) {
+	return ldap.Identity{Subject: username, Username: username}, nil
+}
+
+func (s *fakeSession) GetUserAttributes(username string, attributes []string) (map[string][]string, error) {
+	return nil, nil
+}
+
+func (s *fakeSession) MatchFilter(username, filter string) (bool, error) {
+	return true, nil
+}
+
+func (s *fakeSession) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.healthy {
+		return errors.New("unhealthy")
+	}
+	return nil
+}
+
+func (s *fakeSession) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.broken
+}
+
+type fakeConnector struct {
+	mu       sync.Mutex
+	sessions []*fakeSession
+}
+
+func (c *fakeConnector) Connect() (ldap.Session, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := &fakeSession{id: len(c.sessions), healthy: true}
+	c.sessions = append(c.sessions, s)
+	return s, nil
+}
+
+func (c *fakeConnector) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sessions)
+}
+
+func TestPooledConnector(t *testing.T) {
+	t.Run("reuse", func(t *testing.T) {
+		base := &fakeConnector{}
+		pool := ldap.NewPooledConnector(base, 0, 1, time.Hour)
+		defer pool.Close()
+
+		for i := 0; i < 3; i++ {
+			s, err := pool.Connect()
+			if err != nil {
+				t.Fatalf("failed to connect: %s", err)
+			}
+			s.Close()
+		}
+
+		if n := base.Count(); n != 1 {
+			t.Errorf("expected 1 connection but made %d connections", n)
+		}
+	})
+
+	t.Run("max idle", func(t *testing.T) {
+		base := &fakeConnector{}
+		pool := ldap.NewPooledConnector(base, 0, 1, time.Hour)
+		defer pool.Close()
+
+		a, _ := pool.Connect()
+		b, _ := pool.Connect()
+		a.Close()
+		b.Close()
+
+		if n := base.Count(); n != 2 {
+			t.Fatalf("expected 2 connections but made %d connections", n)
+		}
+		if n := pool.Idle(); n != 1 {
+			t.Errorf("expected 1 idle connection but got %d", n)
+		}
+		if !base.sessions[1].closed {
+			t.Errorf("overflowed session was not closed")
+		}
+	})
+
+	t.Run("broken", func(t *testing.T) {
+		base := &fakeConnector{}
+		pool := ldap.NewPooledConnector(base, 0, 2, time.Hour)
+		defer pool.Close()
+
+		s, _ := pool.Connect()
+		base.sessions[0].mu.Lock()
+		base.sessions[0].broken = true
+		base.sessions[0].mu.Unlock()
+		s.Close()
+
+		if n := pool.Idle(); n != 0 {
+			t.Errorf("broken session was returned to pool")
+		}
+		if !base.sessions[0].closed {
+			t.Errorf("broken session was not closed")
+		}
+	})
+
+	t.Run("unhealthy", func(t *testing.T) {
+		base := &fakeConnector{}
+		pool := ldap.NewPooledConnector(base, 0, 2, 10*time.Millisecond)
+		defer pool.Close()
+
+		s, _ := pool.Connect()
+		s.Close()
+
+		base.sessions[0].mu.Lock()
+		base.sessions[0].healthy = false
+		base.sessions[0].mu.Unlock()
+
+		time.Sleep(30 * time.Millisecond)
+
+		s, err := pool.Connect()
+		if err != nil {
+			t.Fatalf("failed to connect: %s", err)
+		}
+		defer s.Close()
+
+		if n := base.Count(); n != 2 {
+			t.Errorf("expected reconnect but made %d connections", n)
+		}
+		if !base.sessions[0].closed {
+			t.Errorf("unhealthy session was not closed")
+		}
+	})
+
+	t.Run("min idle", func(t *testing.T) {
+		base := &fakeConnector{}
+		pool := ldap.NewPooledConnector(base, 2, 4, 10*time.Millisecond)
+
+		deadline := time.Now().Add(time.Second)
+		for pool.Idle() < 2 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if n := pool.Idle(); n != 2 {
+			t.Errorf("expected 2 idle connections but got %d", n)
+		}
+
+		pool.Close()
+
+		if n := pool.Idle(); n != 0 {
+			t.Errorf("expected no idle connection after close but got %d", n)
+		}
+		for _, s := range base.sessions {
+			if !s.closed {
+				t.Errorf("session %d was not closed", s.id)
+			}
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/macrat/lauth/config"
+)
+
+// TLSConfig makes TLS configuration to connect to the LDAP server.
+//
+// The server certificate is verified with the CA file or system CA, unless TLSSkipVerify is set.
+func TLSConfig(conf *config.LDAPConfig) (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName:         conf.TLSServerName,
+		MinVersion:         uint16(conf.TLSMinVersion),
+		InsecureSkipVerify: conf.TLSSkipVerify,
+	}
+
+	if tc.ServerName == "" && conf.Server != nil {
+		tc.ServerName = conf.Server.Hostname()
+	}
+
+	if tc.MinVersion == 0 {
+		tc.MinVersion = tls.VersionTLS12
+	}
+
+	if conf.TLSCA != "" {
+		raw, err := os.ReadFile(conf.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("no certificate found in CA file: %s", conf.TLSCA)
+		}
+		tc.RootCAs = pool
+	}
+
+	if conf.TLSCert != "" || conf.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(conf.TLSCert, conf.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return tc, nil
+}
@@ -0,0 +1,131 @@
+package ldap_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/ldap"
+)
+
+func writeCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldap.example.com"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %s", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600); err != nil {
+		t.Fatalf("failed to write key: %s", err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir)
+
+	server := &config.URL{}
+	if err := server.Set("ldaps://ldap.example.com"); err != nil {
+		t.Fatalf("failed to parse URL: %s", err)
+	}
+
+	t.Run("default", func(t *testing.T) {
+		tc, err := ldap.TLSConfig(&config.LDAPConfig{Server: server})
+		if err != nil {
+			t.Fatalf("failed to make TLS config: %s", err)
+		}
+
+		if tc.InsecureSkipVerify {
+			t.Errorf("verification must be enabled in default")
+		}
+		if tc.ServerName != "ldap.example.com" {
+			t.Errorf("unexpected server name: %s", tc.ServerName)
+		}
+		if tc.MinVersion != tls.VersionTLS12 {
+			t.Errorf("unexpected min version: %x", tc.MinVersion)
+		}
+		if tc.RootCAs != nil {
+			t.Errorf("expected system CA but set custom CA")
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		tc, err := ldap.TLSConfig(&config.LDAPConfig{
+			Server:        server,
+			TLSCA:         certFile,
+			TLSCert:       certFile,
+			TLSKey:        keyFile,
+			TLSServerName: "dc01.example.local",
+			TLSMinVersion: tls.VersionTLS13,
+		})
+		if err != nil {
+			t.Fatalf("failed to make TLS config: %s", err)
+		}
+
+		if tc.ServerName != "dc01.example.local" {
+			t.Errorf("unexpected server name: %s", tc.ServerName)
+		}
+		if tc.MinVersion != tls.VersionTLS13 {
+			t.Errorf("unexpected min version: %x", tc.MinVersion)
+		}
+		if tc.RootCAs == nil {
+			t.Errorf("custom CA was not loaded")
+		}
+		if len(tc.Certificates) != 1 {
+			t.Errorf("client certificate was not loaded")
+		}
+	})
+
+	t.Run("invalid CA", func(t *testing.T) {
+		if _, err := ldap.TLSConfig(&config.LDAPConfig{Server: server, TLSCA: keyFile}); err == nil {
+			t.Errorf("expected error but got nil")
+		}
+
+		if _, err := ldap.TLSConfig(&config.LDAPConfig{Server: server, TLSCA: filepath.Join(dir, "not-found.pem")}); err == nil {
+			t.Errorf("expected error but got nil")
+		}
+	})
+
+	t.Run("invalid client certificate", func(t *testing.T) {
+		if _, err := ldap.TLSConfig(&config.LDAPConfig{Server: server, TLSCert: certFile, TLSKey: certFile}); err == nil {
+			t.Errorf("expected error but got nil")
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package lockout
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/macrat/lauth/store"
+)
+
+// Kind is the kind of keys that failed logins are counted for.
+type Kind string
+
+const (
+	Username Kind = "username"
+	IP       Kind = "ip"
+)
+
+// Kinds is all kinds of keys, in the order to check.
+var Kinds = []Kind{Username, IP}
+
+func (k Kind) bucket() string {
+	return "lockout_" + string(k)
+}
+
+// Policy is the rule to delay and lock out logins after failures.
+type Policy struct {
+	// MaxAttempts is the number of failures until locked out. 0 means never locked out.
+	MaxAttempts int
+
+	// Duration is the time to lock out, and to remember failures since the last failure.
+	Duration time.Duration
+
+	// Backoff is the time to wait after the first failure. It doubles on each failure up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Enabled reports the policy counts failures.
+func (p Policy) Enabled() bool {
+	return p.MaxAttempts > 0 || p.Backoff > 0
+}
+
+// Wait returns the time that have to wait after the failures.
+func (p Policy) Wait(failures int) time.Duration {
+	if p.MaxAttempts > 0 && failures >= p.MaxAttempts {
+		return p.Duration
+	}
+	if p.Backoff <= 0 || failures <= 0 {
+		return 0
+	}
+
+	wait := p.Backoff
+	for i := 1; i < failures; i++ {
+		if p.MaxBackoff > 0 && wait >= p.MaxBackoff {
+			break
+		}
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
+
+// Record is the failures of a username or an IP address.
+type Record struct {
+	Kind        Kind      `json:"kind"`
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	RetryAt     time.Time `json:"retry_at"`
+	Locked      bool      `json:"locked"`
+}
+
+// Store remembers failed logins of each username and IP address.
+type Store struct {
+	Store store.Store
+}
+
+// Get returns the record of the key, or an empty record if there is no failure recently.
+func (s Store) Get(kind Kind, key string) (Record, error) {
+	r := Record{Kind: kind, Key: key}
+
+	raw, err := s.Store.Get(kind.bucket(), key)
+	if err == store.KeyNotFoundError {
+		return r, nil
+	} else if err != nil {
+		return r, err
+	}
+
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// Check returns the time that the key has to wait until next try, or 0 if it can try now.
+func (s Store) Check(kind Kind, key string) (time.Duration, error) {
+	r, err := s.Get(kind, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if wait := time.Until(r.RetryAt); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// errWaiting aborts the update of Reserve, because the key has to wait.
+var errWaiting = errors.New("have to wait")
+
+// update applies fn to the record of the key atomically, and returns the new record.
+func (s Store) update(kind Kind, key string, policy Policy, fn func(r *Record) error) (Record, error) {
+	var r Record
+
+	err := s.Store.Update(kind.bucket(), key, func(raw []byte) ([]byte, time.Duration, error) {
+		r = Record{Kind: kind, Key: key}
+		if raw != nil {
+			if err := json.Unmarshal(raw, &r); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		if err := fn(&r); err != nil {
+			return nil, 0, err
+		}
+
+		raw, err := json.Marshal(r)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		ttl := policy.Duration
+		if wait := time.Until(r.RetryAt); wait > ttl {
+			ttl = wait
+		}
+		return raw, ttl, nil
+	})
+	return r, err
+}
+
+func (r *Record) addFailure(policy Policy) {
+	r.Failures++
+	r.LastFailure = time.Now()
+	r.RetryAt = r.LastFailure.Add(policy.Wait(r.Failures))
+	r.Locked = policy.MaxAttempts > 0 && r.Failures >= policy.MaxAttempts
+}
+
+// AddFailure counts up the failures of the key, and returns the new record.
+// The failures are forgotten after the policy's Duration since the last failure.
+//
+// The count is updated atomically, so parallel failures are counted correctly.
+func (s Store) AddFailure(kind Kind, key string, policy Policy) (Record, error) {
+	return s.update(kind, key, policy, func(r *Record) error {
+		r.addFailure(policy)
+		return nil
+	})
+}
+
+// Reserve counts up the failures of the key in advance of an attempt, if the key doesn't have to wait.
+// It returns the new record and 0, or the current record and the time to wait.
+//
+// The attempt should be counted before trying the password, because parallel attempts can't see failures of each other until they finish.
+// Call Release if the attempt turned out not a failure, or Reset if succeeded.
+func (s Store) Reserve(kind Kind, key string, policy Policy) (Record, time.Duration, error) {
+	var wait time.Duration
+
+	r, err := s.update(kind, key, policy, func(r *Record) error {
+		if wait = time.Until(r.RetryAt); wait > 0 {
+			return errWaiting
+		}
+		r.addFailure(policy)
+		return nil
+	})
+	if err == errWaiting {
+		return r, wait, nil
+	}
+	return r, 0, err
+}
+
+// Release takes back a failure that counted by Reserve.
+func (s Store) Release(kind Kind, key string, policy Policy) error {
+	_, err := s.update(kind, key, policy, func(r *Record) error {
+		if r.Failures > 0 {
+			r.Failures--
+		}
+		r.RetryAt = r.LastFailure.Add(policy.Wait(r.Failures))
+		r.Locked = policy.MaxAttempts > 0 && r.Failures >= policy.MaxAttempts
+		return nil
+	})
+	return err
+}
+
+// Reset forgets failures of the key, and reports the key had any failures.
+func (s Store) Reset(kind Kind, key string) (bool, error) {
+	err := s.Store.Delete(kind.bucket(), key)
+	if err == store.KeyNotFoundError {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns records of all keys that failed recently, sorted by key.
+func (s Store) List(kind Kind) ([]Record, error) {
+	keys, err := s.Store.Keys(kind.bucket())
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	var rs []Record
+	for _, key := range keys {
+		r, err := s.Get(kind, key)
+		if err != nil {
+			return nil, err
+		}
+		if r.Failures > 0 {
+			rs = append(rs, r)
+		}
+	}
+	return rs, nil
+}
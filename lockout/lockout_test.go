@@ -0,0 +1,165 @@
+package lockout_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/lockout"
+	"github.com/macrat/lauth/store"
+)
+
+func TestPolicy_Wait(t *testing.T) {
+	policy := lockout.Policy{
+		MaxAttempts: 5,
+		Duration:    15 * time.Minute,
+		Backoff:     time.Second,
+		MaxBackoff:  3 * time.Second,
+	}
+
+	tests := []struct {
+		Failures int
+		Wait     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 3 * time.Second},
+		{4, 3 * time.Second},
+		{5, 15 * time.Minute},
+		{6, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if wait := policy.Wait(tt.Failures); wait != tt.Wait {
+			t.Errorf("%d failures: expected %s but got %s", tt.Failures, tt.Wait, wait)
+		}
+	}
+
+	if wait := (lockout.Policy{MaxAttempts: 3, Duration: time.Minute}).Wait(2); wait != 0 {
+		t.Errorf("without backoff: expected 0 but got %s", wait)
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := lockout.Store{Store: store.NewMemoryStore()}
+	policy := lockout.Policy{MaxAttempts: 2, Duration: time.Hour}
+
+	if wait, err := s.Check(lockout.Username, "macrat"); err != nil || wait != 0 {
+		t.Fatalf("unexpected result of first check: %s: %s", wait, err)
+	}
+
+	if r, err := s.AddFailure(lockout.Username, "macrat", policy); err != nil {
+		t.Fatalf("failed to add failure: %s", err)
+	} else if r.Failures != 1 || r.Locked {
+		t.Errorf("unexpected record: %#v", r)
+	}
+	if wait, err := s.Check(lockout.Username, "macrat"); err != nil || wait != 0 {
+		t.Errorf("should not be locked yet: %s: %s", wait, err)
+	}
+
+	if r, err := s.AddFailure(lockout.Username, "macrat", policy); err != nil {
+		t.Fatalf("failed to add failure: %s", err)
+	} else if r.Failures != 2 || !r.Locked {
+		t.Errorf("unexpected record: %#v", r)
+	}
+	if wait, err := s.Check(lockout.Username, "macrat"); err != nil || wait <= 59*time.Minute {
+		t.Errorf("should be locked: %s: %s", wait, err)
+	}
+	if wait, err := s.Check(lockout.IP, "macrat"); err != nil || wait != 0 {
+		t.Errorf("other kind should not be locked: %s: %s", wait, err)
+	}
+
+	if _, err := s.AddFailure(lockout.Username, "j.smith", policy); err != nil {
+		t.Fatalf("failed to add failure: %s", err)
+	}
+	if rs, err := s.List(lockout.Username); err != nil {
+		t.Fatalf("failed to list records: %s", err)
+	} else if len(rs) != 2 || rs[0].Key != "j.smith" || rs[1].Key != "macrat" {
+		t.Errorf("unexpected records: %#v", rs)
+	}
+
+	if ok, err := s.Reset(lockout.Username, "macrat"); err != nil || !ok {
+		t.Errorf("failed to reset: %v: %s", ok, err)
+	}
+	if ok, err := s.Reset(lockout.Username, "macrat"); err != nil || ok {
+		t.Errorf("unexpected result of second reset: %v: %s", ok, err)
+	}
+	if wait, err := s.Check(lockout.Username, "macrat"); err != nil || wait != 0 {
+		t.Errorf("should be unlocked: %s: %s", wait, err)
+	}
+}
+
+func TestStore_AddFailure_parallel(t *testing.T) {
+	s := lockout.Store{Store: store.NewMemoryStore()}
+	policy := lockout.Policy{MaxAttempts: 100, Duration: time.Hour}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.AddFailure(lockout.Username, "macrat", policy); err != nil {
+				t.Errorf("failed to add failure: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if r, err := s.Get(lockout.Username, "macrat"); err != nil || r.Failures != 50 {
+		t.Errorf("expected 50 failures but got %d: %v", r.Failures, err)
+	}
+}
+
+func TestStore_Reserve(t *testing.T) {
+	s := lockout.Store{Store: store.NewMemoryStore()}
+	policy := lockout.Policy{MaxAttempts: 2, Duration: time.Hour}
+
+	for i := 1; i <= 2; i++ {
+		if r, wait, err := s.Reserve(lockout.Username, "macrat", policy); err != nil || wait != 0 || r.Failures != i {
+			t.Fatalf("%d: unexpected result: %#v %s %v", i, r, wait, err)
+		}
+	}
+
+	if r, wait, err := s.Reserve(lockout.Username, "macrat", policy); err != nil || wait <= 59*time.Minute || r.Failures != 2 {
+		t.Errorf("should be refused: %#v %s %v", r, wait, err)
+	}
+
+	if err := s.Release(lockout.Username, "macrat", policy); err != nil {
+		t.Fatalf("failed to release: %s", err)
+	}
+	if r, err := s.Get(lockout.Username, "macrat"); err != nil || r.Failures != 1 || r.Locked {
+		t.Errorf("unexpected record after release: %#v %v", r, err)
+	}
+	if wait, err := s.Check(lockout.Username, "macrat"); err != nil || wait != 0 {
+		t.Errorf("should not be locked after release: %s: %s", wait, err)
+	}
+}
+
+func TestStore_Reserve_parallel(t *testing.T) {
+	s := lockout.Store{Store: store.NewMemoryStore()}
+	policy := lockout.Policy{MaxAttempts: 5, Duration: time.Hour}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, wait, err := s.Reserve(lockout.Username, "macrat", policy)
+			if err != nil {
+				t.Errorf("failed to reserve: %s", err)
+			} else if wait == 0 {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reserved != 5 {
+		t.Errorf("expected 5 reserved attempts but got %d", reserved)
+	}
+}
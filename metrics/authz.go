@@ -7,7 +7,7 @@ import (
 var (
 	Authz = NewEndpointMetrics(
 		"authz",
-		[]string{"method", "response_type", "client_id", "username", "scope", "prompt", "authn_by"},
+		[]string{"method", "response_type", "client_id", "username", "scope", "prompt", "authn_by", "upstream"},
 		[]string{"method", "response_type", "authn_by"},
 	)
 )
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	LDAPPoolIdle = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Subsystem: "ldap_pool",
+		Name:      "idle_connections",
+		Help:      "The number of idle connections in the LDAP connection pool.",
+	})
+	LDAPPoolActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Subsystem: "ldap_pool",
+		Name:      "active_connections",
+		Help:      "The number of connections that taken from the LDAP connection pool.",
+	})
+	LDAPPoolAcquire = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Subsystem: "ldap_pool",
+			Name:      "acquire_count",
+			Help:      "The count of take connection from the LDAP connection pool.",
+		},
+		[]string{"source"},
+	)
+	LDAPPoolDiscard = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Subsystem: "ldap_pool",
+			Name:      "discard_count",
+			Help:      "The count of connections that closed by the LDAP connection pool.",
+		},
+		[]string{"reason"},
+	)
+	LDAPServerUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: NAMESPACE,
+			Subsystem: "ldap_server",
+			Name:      "up",
+			Help:      "1 if the last connection to the LDAP server was succeed, otherwise 0.",
+		},
+		[]string{"server"},
+	)
+	LDAPServerRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Subsystem: "ldap_server",
+			Name:      "requests_count",
+			Help:      "The count of requests that served by each LDAP server.",
+		},
+		[]string{"server"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(LDAPPoolIdle)
+	prometheus.MustRegister(LDAPPoolActive)
+	prometheus.MustRegister(LDAPPoolAcquire)
+	prometheus.MustRegister(LDAPPoolDiscard)
+	prometheus.MustRegister(LDAPServerUp)
+	prometheus.MustRegister(LDAPServerRequests)
+}
+
+// LDAPPoolStatus records the number of idle and active connections.
+func LDAPPoolStatus(idle, active int) {
+	LDAPPoolIdle.Set(float64(idle))
+	LDAPPoolActive.Set(float64(active))
+}
+
+// LDAPPoolAcquired counts taken connection. The source is "new" or "reuse".
+func LDAPPoolAcquired(source string) {
+	LDAPPoolAcquire.WithLabelValues(source).Inc()
+}
+
+// LDAPPoolDiscarded counts closed connection. The reason is "unhealthy", "broken", or "overflow".
+func LDAPPoolDiscarded(reason string) {
+	LDAPPoolDiscard.WithLabelValues(reason).Inc()
+}
+
+// LDAPServerStatus records the server is reachable or not.
+func LDAPServerStatus(server string, up bool) {
+	if up {
+		LDAPServerUp.WithLabelValues(server).Set(1)
+	} else {
+		LDAPServerUp.WithLabelValues(server).Set(0)
+	}
+}
+
+// LDAPServerUsed counts a request that served by the server.
+func LDAPServerUsed(server string) {
+	LDAPServerRequests.WithLabelValues(server).Inc()
+}
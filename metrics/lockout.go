@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	LockoutLocked = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Subsystem: "lockout",
+			Name:      "count",
+			Help:      "The count of usernames and IP addresses that locked out by failed logins.",
+		},
+		[]string{"kind"},
+	)
+	LockoutRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: NAMESPACE,
+			Subsystem: "lockout",
+			Name:      "rejected_count",
+			Help:      "The count of login attempts that rejected because of lockout or backoff.",
+		},
+		[]string{"kind"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(LockoutLocked)
+	prometheus.MustRegister(LockoutRejected)
+}
+
+// LockedOut counts a lockout. The kind is "username" or "ip".
+func LockedOut(kind string) {
+	LockoutLocked.WithLabelValues(kind).Inc()
+}
+
+// LoginRejected counts a login attempt that rejected by lockout. The kind is "username" or "ip".
+func LoginRejected(kind string) {
+	LockoutRejected.WithLabelValues(kind).Inc()
+}
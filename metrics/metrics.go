@@ -114,6 +114,11 @@ func (c *Context) UserError() {
 	c.Labels["status"] = "user_error"
 }
 
+// Denied is valid user but not allowed to access.
+func (c *Context) Denied() {
+	c.Labels["status"] = "denied"
+}
+
 // ClientError is client side error except UserError.
 func (c *Context) ClientError() {
 	c.Labels["status"] = "client_error"
@@ -153,7 +158,7 @@ func (c *Context) writeLog(e *zerolog.Event) *zerolog.Event {
 
 func (c *Context) Close() error {
 	if c.Labels["status"] == "" && c.Labels["error"] != "" {
-		if c.Labels["error"] == "server_error" {
+		if c.Labels["error"] == "server_error" || c.Labels["error"] == "temporarily_unavailable" {
 			c.ServerError()
 		} else {
 			c.ClientError()
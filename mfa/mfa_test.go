@@ -0,0 +1,188 @@
+package mfa_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/mfa"
+	"github.com/macrat/lauth/store"
+)
+
+// rfc6238Secret is the secret of test vectors in RFC 6238, "12345678901234567890" in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	secret, err := mfa.ParseSecret(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("failed to parse secret: %s", err)
+	}
+
+	tests := []struct {
+		Time int64
+		Code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		if code := mfa.Code(secret, time.Unix(tt.Time, 0)); code != tt.Code {
+			t.Errorf("unexpected code at %d: expected %s but got %s", tt.Time, tt.Code, code)
+		}
+	}
+}
+
+func TestParseSecret(t *testing.T) {
+	tests := []struct {
+		Input string
+		Error bool
+	}{
+		{rfc6238Secret, false},
+		{"gezd gnbv gy3t qojq gezd gnbv gy3t qojq", false},
+		{"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ====", false},
+		{"otpauth://totp/Lauth:macrat?secret=" + rfc6238Secret + "&issuer=Lauth", false},
+		{"", true},
+		{"not base32!", true},
+		{"otpauth://totp/Lauth:macrat", true},
+	}
+
+	for _, tt := range tests {
+		secret, err := mfa.ParseSecret(tt.Input)
+		if tt.Error {
+			if err == nil {
+				t.Errorf("%#v: expected error but succeed", tt.Input)
+			}
+		} else if err != nil {
+			t.Errorf("%#v: failed to parse: %s", tt.Input, err)
+		} else if string(secret) != "12345678901234567890" {
+			t.Errorf("%#v: unexpected secret: %#v", tt.Input, string(secret))
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, _ := mfa.ParseSecret(rfc6238Secret)
+	now := time.Unix(1234567890, 0)
+
+	tests := []struct {
+		Name string
+		Code string
+		OK   bool
+	}{
+		{"current", mfa.Code(secret, now), true},
+		{"with spaces", "005 924", true},
+		{"previous step", mfa.Code(secret, now.Add(-mfa.TOTP_PERIOD)), true},
+		{"next step", mfa.Code(secret, now.Add(mfa.TOTP_PERIOD)), true},
+		{"too old", mfa.Code(secret, now.Add(-2*mfa.TOTP_PERIOD)), false},
+		{"wrong", "123456", false},
+		{"short", "05924", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			step, ok := mfa.Verify(secret, tt.Code, now)
+			if ok != tt.OK {
+				t.Fatalf("expected %v but got %v", tt.OK, ok)
+			}
+			if ok && (step < mfa.Step(now)-mfa.TOTP_SKEW || mfa.Step(now)+mfa.TOTP_SKEW < step) {
+				t.Errorf("unexpected step: %d", step)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := mfa.URI("Lauth", "macrat", rfc6238Secret)
+	if uri != "otpauth://totp/Lauth:macrat?issuer=Lauth&secret="+rfc6238Secret {
+		t.Errorf("unexpected URI: %s", uri)
+	}
+
+	secret, err := mfa.ParseSecret(uri)
+	if err != nil || string(secret) != "12345678901234567890" {
+		t.Errorf("failed to parse URI: %#v %s", string(secret), err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := mfa.Store{Store: store.NewMemoryStore()}
+
+	if secret, err := s.Secret("macrat"); err != nil || secret != "" {
+		t.Errorf("unexpected secret before enroll: %#v %s", secret, err)
+	}
+	if err := s.SaveSecret("macrat", rfc6238Secret); err != nil {
+		t.Fatalf("failed to save secret: %s", err)
+	}
+	if secret, err := s.Secret("macrat"); err != nil || secret != rfc6238Secret {
+		t.Errorf("unexpected secret after enroll: %#v %s", secret, err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if n, err := s.AddFailure("macrat", time.Minute); err != nil || n != i {
+			t.Errorf("unexpected failure count: %d %s", n, err)
+		}
+	}
+	if n, err := s.Failures("j.smith"); err != nil || n != 0 {
+		t.Errorf("failures should be counted for each user: %d %s", n, err)
+	}
+	if err := s.ResetFailures("macrat"); err != nil {
+		t.Fatalf("failed to reset failures: %s", err)
+	}
+	if n, err := s.Failures("macrat"); err != nil || n != 0 {
+		t.Errorf("failures should be reset: %d %s", n, err)
+	}
+
+	if ok, err := s.UseStep("macrat", 100); err != nil || !ok {
+		t.Errorf("failed to use step: %v %s", ok, err)
+	}
+	if ok, err := s.UseStep("macrat", 100); err != nil || ok {
+		t.Errorf("same step should not be used twice: %v %s", ok, err)
+	}
+	if ok, err := s.UseStep("macrat", 99); err != nil || ok {
+		t.Errorf("older step should not be used: %v %s", ok, err)
+	}
+	if ok, err := s.UseStep("macrat", 101); err != nil || !ok {
+		t.Errorf("failed to use newer step: %v %s", ok, err)
+	}
+}
+
+func TestStore_parallel(t *testing.T) {
+	s := mfa.Store{Store: store.NewMemoryStore()}
+
+	var wg sync.WaitGroup
+	var attempts, used int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if ok, err := s.AddAttempt("macrat", 5, time.Minute); err != nil {
+				t.Errorf("failed to add attempt: %s", err)
+			} else if ok {
+				atomic.AddInt32(&attempts, 1)
+			}
+
+			if ok, err := s.UseStep("macrat", 100); err != nil {
+				t.Errorf("failed to use step: %s", err)
+			} else if ok {
+				atomic.AddInt32(&used, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if attempts != 5 {
+		t.Errorf("expected 5 attempts but got %d", attempts)
+	}
+	if n, err := s.Failures("macrat"); err != nil || n != 5 {
+		t.Errorf("expected 5 failures but got %d: %v", n, err)
+	}
+	if used != 1 {
+		t.Errorf("same step should be used only once but used %d times", used)
+	}
+}
@@ -0,0 +1,130 @@
+package mfa
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/macrat/lauth/store"
+)
+
+const (
+	SECRET_BUCKET  = "mfa_secret"
+	FAILURE_BUCKET = "mfa_failure"
+	USED_BUCKET    = "mfa_used"
+)
+
+// Store remembers secrets that enrolled by users, and failures of code guesses.
+type Store struct {
+	Store store.Store
+}
+
+// Secret returns the enrolled secret of the user, or empty string if not enrolled.
+func (s Store) Secret(subject string) (string, error) {
+	raw, err := s.Store.Get(SECRET_BUCKET, subject)
+	if err == store.KeyNotFoundError {
+		return "", nil
+	}
+	return string(raw), err
+}
+
+// SaveSecret enrolls the secret for the user.
+func (s Store) SaveSecret(subject, secret string) error {
+	return s.Store.Set(SECRET_BUCKET, subject, []byte(secret), 0)
+}
+
+func (s Store) getInt(bucket, key string) (int64, error) {
+	raw, err := s.Store.Get(bucket, key)
+	if err == store.KeyNotFoundError {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(raw), 10, 64)
+}
+
+// Failures returns count of wrong codes that the user tried recently.
+func (s Store) Failures(subject string) (int, error) {
+	n, err := s.getInt(FAILURE_BUCKET, subject)
+	return int(n), err
+}
+
+var (
+	errTooManyFailures = errors.New("too many failures")
+	errStepUsed        = errors.New("step is already used")
+)
+
+// updateInt replaces the integer value of the key with the result of fn atomically.
+func (s Store) updateInt(bucket, key string, fn func(n int64) (int64, time.Duration, error)) error {
+	return s.Store.Update(bucket, key, func(raw []byte) ([]byte, time.Duration, error) {
+		var n int64
+		if raw != nil {
+			var err error
+			if n, err = strconv.ParseInt(string(raw), 10, 64); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		n, ttl, err := fn(n)
+		if err != nil {
+			return nil, 0, err
+		}
+		return []byte(strconv.FormatInt(n, 10)), ttl, nil
+	})
+}
+
+// AddFailure counts up the failures of the user, and returns the new count.
+// The count is forgotten after ttl since the last failure.
+func (s Store) AddFailure(subject string, ttl time.Duration) (int, error) {
+	var count int64
+	err := s.updateInt(FAILURE_BUCKET, subject, func(n int64) (int64, time.Duration, error) {
+		count = n + 1
+		return count, ttl, nil
+	})
+	return int(count), err
+}
+
+// AddAttempt counts up the failures of the user in advance of verifying a code, and reports the user can try the code.
+// It reports false without counting if the user already failed maxAttempts times.
+//
+// The attempt should be counted before verifying, so parallel attempts can't exceed the limit.
+// Call ResetFailures if the code was correct.
+func (s Store) AddAttempt(subject string, maxAttempts int, ttl time.Duration) (bool, error) {
+	err := s.updateInt(FAILURE_BUCKET, subject, func(n int64) (int64, time.Duration, error) {
+		if n >= int64(maxAttempts) {
+			return 0, 0, errTooManyFailures
+		}
+		return n + 1, ttl, nil
+	})
+	if err == errTooManyFailures {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ResetFailures forgets failures of the user.
+func (s Store) ResetFailures(subject string) error {
+	err := s.Store.Delete(FAILURE_BUCKET, subject)
+	if err == store.KeyNotFoundError {
+		return nil
+	}
+	return err
+}
+
+// UseStep records the time step of the code that the user used, and reports the step is not used yet.
+// A code can't be used twice, because codes before the last used one are rejected.
+// The step is compared and recorded atomically, so parallel requests can't use the same code.
+func (s Store) UseStep(subject string, step int64) (bool, error) {
+	ttl := time.Duration(TOTP_SKEW*2+1) * TOTP_PERIOD
+
+	err := s.updateInt(USED_BUCKET, subject, func(last int64) (int64, time.Duration, error) {
+		if step <= last {
+			return 0, 0, errStepUsed
+		}
+		return step, ttl, nil
+	})
+	if err == errStepUsed {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,114 @@
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	TOTP_DIGITS = 6
+	TOTP_PERIOD = 30 * time.Second
+
+	// TOTP_SKEW is the number of steps before and after the current time that accepted, for the clock difference of devices.
+	TOTP_SKEW = 1
+)
+
+var (
+	InvalidSecretError = errors.New("invalid TOTP secret")
+
+	encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// GenerateSecret makes a new random secret that encoded in base32.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// ParseSecret decodes the secret that encoded in base32 or as a otpauth:// URI.
+//
+// Spaces, lower cases, and paddings in base32 are accepted because the secret may be typed by people.
+func ParseSecret(secret string) ([]byte, error) {
+	if strings.HasPrefix(secret, "otpauth://") {
+		u, err := url.Parse(secret)
+		if err != nil {
+			return nil, InvalidSecretError
+		}
+		secret = u.Query().Get("secret")
+	}
+
+	secret = strings.ToUpper(strings.Join(strings.Fields(secret), ""))
+	secret = strings.TrimRight(secret, "=")
+
+	b, err := encoding.DecodeString(secret)
+	if err != nil || len(b) == 0 {
+		return nil, InvalidSecretError
+	}
+	return b, nil
+}
+
+// Step returns the time step of RFC 6238.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(TOTP_PERIOD/time.Second)
+}
+
+func code(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%1000000)
+}
+
+// Code returns the TOTP code at the time.
+func Code(secret []byte, t time.Time) string {
+	return code(secret, Step(t))
+}
+
+// Verify checks the code, and returns the time step of the code if it is valid.
+func Verify(secret []byte, input string, t time.Time) (step int64, ok bool) {
+	input = strings.Join(strings.Fields(input), "")
+	if len(input) != TOTP_DIGITS {
+		return 0, false
+	}
+
+	current := Step(t)
+	for s := current - TOTP_SKEW; s <= current+TOTP_SKEW; s++ {
+		if subtle.ConstantTimeCompare([]byte(code(secret, s)), []byte(input)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// URI makes otpauth:// URI for registering the secret to authenticator apps.
+func URI(issuer, account, secret string) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + account,
+		RawQuery: url.Values{
+			"secret": {secret},
+			"issuer": {issuer},
+		}.Encode(),
+	}
+	return u.String()
+}
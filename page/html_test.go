@@ -3,8 +3,10 @@ package page_test
 import (
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 
+	"github.com/macrat/lauth/config"
 	"github.com/macrat/lauth/testutil"
 )
 
@@ -71,3 +73,33 @@ func TestLoginForm_value_passing(t *testing.T) {
 		t.Errorf("password is missing in form")
 	}
 }
+
+func TestLoginForm_directoryPicker(t *testing.T) {
+	env := testutil.NewAPITestEnvironment(t)
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"some_client_id"},
+		"redirect_uri":  {"http://some-client.example.com/callback"},
+		"scope":         {"openid"},
+	}
+
+	resp := env.Get("/authz", "", params)
+	if strings.Contains(resp.Body.String(), `name="directory"`) {
+		t.Errorf("directory picker should not be shown in default")
+	}
+
+	env.API.Config.Directories = []config.DirectoryConfig{{Name: "corp"}, {Name: "acquired"}}
+	env.API.Config.DirectoryPicker = true
+
+	resp = env.Get("/authz", "", params)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("failed to render login page (status code = %d)", resp.Code)
+	}
+	body := resp.Body.String()
+	for _, opt := range []string{`<option value="corp">corp</option>`, `<option value="acquired">acquired</option>`} {
+		if !strings.Contains(body, opt) {
+			t.Errorf("directory picker has no option %s", opt)
+		}
+	}
+}
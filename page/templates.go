@@ -34,6 +34,17 @@ func Load(conf config.TemplateConfig) (*template.Template, error) {
 		}
 	}
 
+	if conf.ConsentPage != "" {
+		raw, err := os.ReadFile(conf.ConsentPage)
+		if err != nil {
+			return nil, err
+		}
+		_, err = t.Lookup("consent.tmpl").Parse(string(raw))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if conf.LogoutPage != "" {
 		raw, err := os.ReadFile(conf.LogoutPage)
 		if err != nil {
@@ -56,5 +67,38 @@ func Load(conf config.TemplateConfig) (*template.Template, error) {
 		}
 	}
 
+	if conf.MFAPage != "" {
+		raw, err := os.ReadFile(conf.MFAPage)
+		if err != nil {
+			return nil, err
+		}
+		_, err = t.Lookup("mfa.tmpl").Parse(string(raw))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.WebAuthnPage != "" {
+		raw, err := os.ReadFile(conf.WebAuthnPage)
+		if err != nil {
+			return nil, err
+		}
+		_, err = t.Lookup("webauthn.tmpl").Parse(string(raw))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.PasswordPage != "" {
+		raw, err := os.ReadFile(conf.PasswordPage)
+		if err != nil {
+			return nil, err
+		}
+		_, err = t.Lookup("password.tmpl").Parse(string(raw))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return t, nil
 }
@@ -51,6 +51,10 @@ func TestLoad(t *testing.T) {
 		t.Errorf("expected normal builtin login page but got test page")
 	}
 
+	if Render(t, tmpl, "consent.tmpl") == "[[this is test consent page]]" {
+		t.Errorf("expected normal builtin consent page but got test page")
+	}
+
 	if Render(t, tmpl, "logout.tmpl") == "[[this is test logged out page]]" {
 		t.Errorf("expected normal builtin logout page but got test page")
 	}
@@ -59,17 +63,41 @@ func TestLoad(t *testing.T) {
 		t.Errorf("expected normal builtin error page but got test page")
 	}
 
+	if Render(t, tmpl, "mfa.tmpl") == "[[this is test mfa page]]" {
+		t.Errorf("expected normal builtin mfa page but got test page")
+	}
+
+	if Render(t, tmpl, "webauthn.tmpl") == "[[this is test webauthn page]]" {
+		t.Errorf("expected normal builtin webauthn page but got test page")
+	}
+
+	if Render(t, tmpl, "password.tmpl") == "[[this is test password page]]" {
+		t.Errorf("expected normal builtin password page but got test page")
+	}
+
 	loginPage := MakeTestFile(t, "[[this is test login page]]")
 	defer os.Remove(loginPage)
+	consentPage := MakeTestFile(t, "[[this is test consent page]]")
+	defer os.Remove(consentPage)
 	logoutPage := MakeTestFile(t, "[[this is test logged out page]]")
 	defer os.Remove(logoutPage)
 	errorPage := MakeTestFile(t, "[[this is test error page]]")
 	defer os.Remove(errorPage)
+	mfaPage := MakeTestFile(t, "[[this is test mfa page]]")
+	defer os.Remove(mfaPage)
+	webauthnPage := MakeTestFile(t, "[[this is test webauthn page]]")
+	defer os.Remove(webauthnPage)
+	passwordPage := MakeTestFile(t, "[[this is test password page]]")
+	defer os.Remove(passwordPage)
 
 	tmpl, err = page.Load(config.TemplateConfig{
-		LoginPage:  loginPage,
-		LogoutPage: logoutPage,
-		ErrorPage:  errorPage,
+		LoginPage:    loginPage,
+		ConsentPage:  consentPage,
+		LogoutPage:   logoutPage,
+		ErrorPage:    errorPage,
+		MFAPage:      mfaPage,
+		WebAuthnPage: webauthnPage,
+		PasswordPage: passwordPage,
 	})
 	if err != nil {
 		t.Fatalf("failed to load templates: %s", err)
@@ -79,6 +107,10 @@ func TestLoad(t *testing.T) {
 		t.Errorf("expected test login page but got normal builtin page")
 	}
 
+	if Render(t, tmpl, "consent.tmpl") != "[[this is test consent page]]" {
+		t.Errorf("expected test consent page but got normal builtin page")
+	}
+
 	if Render(t, tmpl, "logout.tmpl") != "[[this is test logged out page]]" {
 		t.Errorf("expected test logout page but got normal builtin page")
 	}
@@ -86,4 +118,16 @@ func TestLoad(t *testing.T) {
 	if Render(t, tmpl, "error.tmpl") != "[[this is test error page]]" {
 		t.Errorf("expected test error page but got normal builtin page")
 	}
+
+	if Render(t, tmpl, "mfa.tmpl") != "[[this is test mfa page]]" {
+		t.Errorf("expected test mfa page but got normal builtin page")
+	}
+
+	if Render(t, tmpl, "webauthn.tmpl") != "[[this is test webauthn page]]" {
+		t.Errorf("expected test webauthn page but got normal builtin page")
+	}
+
+	if Render(t, tmpl, "password.tmpl") != "[[this is test password page]]" {
+		t.Errorf("expected test password page but got normal builtin page")
+	}
 }
@@ -0,0 +1,36 @@
+package saml
+
+import (
+	"encoding/base64"
+)
+
+// MakeMetadata makes the metadata of the Identity Provider.
+//
+// ssoURL is the location of the SingleSignOnService that supports both of HTTP-Redirect and HTTP-POST bindings.
+func MakeMetadata(entityID, ssoURL string, cert []byte, nameIDFormats []string) []byte {
+	descriptor := newElement(
+		"md:IDPSSODescriptor",
+		newElement(
+			"md:KeyDescriptor",
+			newElement(
+				"ds:KeyInfo",
+				newElement("ds:X509Data", newElement("ds:X509Certificate").text(base64.StdEncoding.EncodeToString(cert))),
+			).ns("ds", SignatureNamespace),
+		).attr("use", "signing"),
+	).
+		attr("WantAuthnRequestsSigned", "false").
+		attr("protocolSupportEnumeration", ProtocolNamespace)
+
+	for _, f := range nameIDFormats {
+		descriptor.Children = append(descriptor.Children, newElement("md:NameIDFormat").text(f))
+	}
+	for _, binding := range []string{HTTPRedirectBinding, HTTPPostBinding} {
+		descriptor.Children = append(descriptor.Children, newElement("md:SingleSignOnService").attr("Binding", binding).attr("Location", ssoURL))
+	}
+
+	entity := newElement("md:EntityDescriptor", descriptor).
+		ns("md", MetadataNamespace).
+		attr("entityID", entityID)
+
+	return append([]byte(xmlHeader), entity.Bytes()...)
+}
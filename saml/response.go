@@ -0,0 +1,232 @@
+package saml
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>`
+
+	algorithmExcC14N    = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algorithmEnveloped  = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algorithmRSASHA256  = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algorithmSHA256     = "http://www.w3.org/2001/04/xmlenc#sha256"
+	bearerConfirmation  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	basicAttrNameFormat = "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"
+
+	// clockSkew is the allowance of clock difference between IdP and SP.
+	clockSkew = 30 * time.Second
+)
+
+// Signer makes RSA-SHA256 signature, like token.Manager.
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+}
+
+type Attribute struct {
+	Name   string
+	Values []string
+}
+
+// Assertion is the information to make a response for the service provider.
+type Assertion struct {
+	Issuer       string
+	Destination  string
+	Audience     string
+	InResponseTo string
+	NameID       string
+	NameIDFormat string
+	SessionIndex string
+	AuthnInstant time.Time
+	AuthMethods  []string
+	IssueInstant time.Time
+	Expire       time.Duration
+	Attributes   []Attribute
+}
+
+func newID() string {
+	// ID has to be NCName, so it can't start with digits.
+	return "_" + uuid.New().String()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// AuthnContextClass returns the authentication context class for the amr claim of the session.
+//
+// Security keys and passkeys are told as X509, because they are public key authentication with hardware.
+// Users who logged in without any method that Lauth knows, like via upstream providers, are told as unspecified.
+func AuthnContextClass(authMethods []string) string {
+	has := func(method string) bool {
+		for _, m := range authMethods {
+			if m == method {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has("hwk"):
+		return AuthnContextX509
+	case has("otp"):
+		return AuthnContextTimeSync
+	case has("pwd"):
+		return AuthnContextPassword
+	default:
+		return AuthnContextUnspecified
+	}
+}
+
+func (a Assertion) element() *element {
+	notOnOrAfter := formatTime(a.IssueInstant.Add(a.Expire))
+
+	assertion := newElement(
+		"saml:Assertion",
+		newElement("saml:Issuer").text(a.Issuer),
+		newElement(
+			"saml:Subject",
+			newElement("saml:NameID").attr("Format", a.NameIDFormat).text(a.NameID),
+			newElement(
+				"saml:SubjectConfirmation",
+				newElement("saml:SubjectConfirmationData").
+					attr("InResponseTo", a.InResponseTo).
+					attr("NotOnOrAfter", notOnOrAfter).
+					attr("Recipient", a.Destination),
+			).attr("Method", bearerConfirmation),
+		),
+		newElement(
+			"saml:Conditions",
+			newElement("saml:AudienceRestriction", newElement("saml:Audience").text(a.Audience)),
+		).
+			attr("NotBefore", formatTime(a.IssueInstant.Add(-clockSkew))).
+			attr("NotOnOrAfter", notOnOrAfter),
+		newElement(
+			"saml:AuthnStatement",
+			newElement(
+				"saml:AuthnContext",
+				newElement("saml:AuthnContextClassRef").text(AuthnContextClass(a.AuthMethods)),
+			),
+		).
+			attr("AuthnInstant", formatTime(a.AuthnInstant)).
+			attr("SessionIndex", a.SessionIndex),
+	).
+		ns("saml", AssertionNamespace).
+		attr("ID", newID()).
+		attr("IssueInstant", formatTime(a.IssueInstant)).
+		attr("Version", "2.0")
+
+	if len(a.Attributes) > 0 {
+		statement := newElement("saml:AttributeStatement")
+		for _, attr := range a.Attributes {
+			e := newElement("saml:Attribute").attr("Name", attr.Name).attr("NameFormat", basicAttrNameFormat)
+			for _, v := range attr.Values {
+				e.Children = append(e.Children, newElement("saml:AttributeValue").text(v))
+			}
+			statement.Children = append(statement.Children, e)
+		}
+		assertion.Children = append(assertion.Children, statement)
+	}
+
+	return assertion
+}
+
+// sign inserts enveloped signature into e, right after the Issuer element.
+func sign(e *element, signer Signer, cert []byte) error {
+	digest := sha256.Sum256(e.Bytes())
+
+	signedInfo := newElement(
+		"ds:SignedInfo",
+		newElement("ds:CanonicalizationMethod").attr("Algorithm", algorithmExcC14N),
+		newElement("ds:SignatureMethod").attr("Algorithm", algorithmRSASHA256),
+		newElement(
+			"ds:Reference",
+			newElement(
+				"ds:Transforms",
+				newElement("ds:Transform").attr("Algorithm", algorithmEnveloped),
+				newElement("ds:Transform").attr("Algorithm", algorithmExcC14N),
+			),
+			newElement("ds:DigestMethod").attr("Algorithm", algorithmSHA256),
+			newElement("ds:DigestValue").text(base64.StdEncoding.EncodeToString(digest[:])),
+		).attr("URI", "#"+e.Attributes["ID"]),
+	).ns("ds", SignatureNamespace)
+
+	value, err := signer.Sign(signedInfo.Bytes())
+	if err != nil {
+		return err
+	}
+
+	signature := newElement(
+		"ds:Signature",
+		signedInfo,
+		newElement("ds:SignatureValue").text(base64.StdEncoding.EncodeToString(value)),
+		newElement(
+			"ds:KeyInfo",
+			newElement("ds:X509Data", newElement("ds:X509Certificate").text(base64.StdEncoding.EncodeToString(cert))),
+		),
+	).ns("ds", SignatureNamespace)
+
+	children := append([]*element{e.Children[0], signature}, e.Children[1:]...)
+	e.Children = children
+
+	return nil
+}
+
+func response(issuer, destination, inResponseTo string, issueInstant time.Time, status ...string) *element {
+	code := newElement("samlp:StatusCode").attr("Value", status[0])
+	if len(status) > 1 {
+		code.Children = append(code.Children, newElement("samlp:StatusCode").attr("Value", status[1]))
+	}
+
+	return newElement(
+		"samlp:Response",
+		newElement("saml:Issuer").text(issuer),
+		newElement("samlp:Status", code),
+	).
+		ns("samlp", ProtocolNamespace).
+		ns("saml", AssertionNamespace).
+		attr("Destination", destination).
+		attr("ID", newID()).
+		attr("InResponseTo", inResponseTo).
+		attr("IssueInstant", formatTime(issueInstant)).
+		attr("Version", "2.0")
+}
+
+// MakeResponse makes a successful response that includes the assertion signed by signer.
+//
+// cert is DER encoded certificate of the signer, that embedded in the signature.
+func MakeResponse(a Assertion, signer Signer, cert []byte) ([]byte, error) {
+	assertion := a.element()
+	if err := sign(assertion, signer, cert); err != nil {
+		return nil, err
+	}
+
+	resp := response(a.Issuer, a.Destination, a.InResponseTo, a.IssueInstant, StatusSuccess)
+	resp.Children = append(resp.Children, assertion)
+
+	return append([]byte(xmlHeader), resp.Bytes()...), nil
+}
+
+// MakeErrorResponse makes a response that tells the authentication is failed.
+//
+// status is the top level status code like StatusRequester, and subStatus is the second level status like StatusNoPassive.
+// subStatus can be empty.
+func MakeErrorResponse(issuer, destination, inResponseTo, status, subStatus, message string, issueInstant time.Time) []byte {
+	codes := []string{status}
+	if subStatus != "" {
+		codes = append(codes, subStatus)
+	}
+
+	resp := response(issuer, destination, inResponseTo, issueInstant, codes...)
+	if message != "" {
+		st := resp.Children[1]
+		st.Children = append(st.Children, newElement("samlp:StatusMessage").text(message))
+	}
+
+	return append([]byte(xmlHeader), resp.Bytes()...)
+}
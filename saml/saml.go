@@ -0,0 +1,98 @@
+// Package saml is a minimal SAML 2.0 Identity Provider, that parses authentication requests and makes signed responses.
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	ProtocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	AssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+	MetadataNamespace  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	SignatureNamespace = "http://www.w3.org/2000/09/xmldsig#"
+
+	HTTPRedirectBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	HTTPPostBinding     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	NameIDFormatPersistent  = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	NameIDFormatEmail       = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	NameIDFormatUnspecified = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+
+	AuthnContextPassword    = "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport"
+	AuthnContextTimeSync    = "urn:oasis:names:tc:SAML:2.0:ac:classes:TimeSyncToken"
+	AuthnContextX509        = "urn:oasis:names:tc:SAML:2.0:ac:classes:X509"
+	AuthnContextUnspecified = "urn:oasis:names:tc:SAML:2.0:ac:classes:unspecified"
+
+	StatusSuccess       = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	StatusRequester     = "urn:oasis:names:tc:SAML:2.0:status:Requester"
+	StatusResponder     = "urn:oasis:names:tc:SAML:2.0:status:Responder"
+	StatusAuthnFailed   = "urn:oasis:names:tc:SAML:2.0:status:AuthnFailed"
+	StatusNoPassive     = "urn:oasis:names:tc:SAML:2.0:status:NoPassive"
+	StatusRequestDenied = "urn:oasis:names:tc:SAML:2.0:status:RequestDenied"
+
+	// maxRequestSize is the limit of decoded AuthnRequest, to avoid decompression bomb.
+	maxRequestSize = 64 * 1024
+)
+
+var (
+	InvalidRequestError     = errors.New("invalid SAML request")
+	UnsupportedVersionError = errors.New("unsupported SAML version")
+	MissingIssuerError      = errors.New("issuer of SAML request is required")
+)
+
+// AuthnRequest is a SAML 2.0 authentication request from a service provider.
+//
+// The signature of the request is not verified. The response is sent only to registered ACS URL, so it is not necessary.
+type AuthnRequest struct {
+	XMLName                     xml.Name  `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string    `xml:"ID,attr"`
+	Version                     string    `xml:"Version,attr"`
+	IssueInstant                time.Time `xml:"IssueInstant,attr"`
+	Destination                 string    `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string    `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string    `xml:"ProtocolBinding,attr"`
+	ForceAuthn                  bool      `xml:"ForceAuthn,attr"`
+	IsPassive                   bool      `xml:"IsPassive,attr"`
+	Issuer                      string    `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                struct {
+		Format string `xml:"Format,attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+}
+
+// DecodeRequest decodes SAMLRequest parameter.
+//
+// Set deflated true for HTTP-Redirect binding, and false for HTTP-POST binding.
+func DecodeRequest(raw string, deflated bool) (AuthnRequest, error) {
+	bs, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return AuthnRequest{}, InvalidRequestError
+	}
+
+	var r io.Reader = bytes.NewReader(bs)
+	if deflated {
+		r = flate.NewReader(r)
+	}
+	bs, err = io.ReadAll(io.LimitReader(r, maxRequestSize))
+	if err != nil {
+		return AuthnRequest{}, InvalidRequestError
+	}
+
+	var req AuthnRequest
+	if err := xml.Unmarshal(bs, &req); err != nil || req.ID == "" {
+		return AuthnRequest{}, InvalidRequestError
+	}
+	if req.Version != "2.0" {
+		return AuthnRequest{}, UnsupportedVersionError
+	}
+	if req.Issuer == "" {
+		return AuthnRequest{}, MissingIssuerError
+	}
+
+	return req, nil
+}
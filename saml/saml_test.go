@@ -0,0 +1,247 @@
+package saml_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/saml"
+	"github.com/macrat/lauth/testutil"
+)
+
+const testRequest = `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_request_id" Version="2.0" IssueInstant="2021-07-01T12:00:00Z" AssertionConsumerServiceURL="https://sp.example.com/acs" IsPassive="true"><saml:Issuer>https://sp.example.com</saml:Issuer><samlp:NameIDPolicy Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"/></samlp:AuthnRequest>`
+
+func deflate(s string) string {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write([]byte(s))
+	w.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Raw      string
+		Deflated bool
+		Error    error
+	}{
+		{"redirect binding", deflate(testRequest), true, nil},
+		{"post binding", base64.StdEncoding.EncodeToString([]byte(testRequest)), false, nil},
+		{"not base64", "hello world", false, saml.InvalidRequestError},
+		{"not deflated", base64.StdEncoding.EncodeToString([]byte(testRequest)), true, saml.InvalidRequestError},
+		{"not AuthnRequest", base64.StdEncoding.EncodeToString([]byte(`<Response ID="a" Version="2.0"/>`)), false, saml.InvalidRequestError},
+		{"old version", base64.StdEncoding.EncodeToString([]byte(strings.Replace(testRequest, `Version="2.0"`, `Version="1.1"`, 1))), false, saml.UnsupportedVersionError},
+		{"no issuer", base64.StdEncoding.EncodeToString([]byte(strings.Replace(testRequest, `https://sp.example.com</saml:Issuer>`, `</saml:Issuer>`, 1))), false, saml.MissingIssuerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			req, err := saml.DecodeRequest(tt.Raw, tt.Deflated)
+			if err != tt.Error {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+
+			if req.ID != "_request_id" || req.Issuer != "https://sp.example.com" || req.AssertionConsumerServiceURL != "https://sp.example.com/acs" {
+				t.Errorf("unexpected request: %#v", req)
+			}
+			if !req.IsPassive || req.ForceAuthn {
+				t.Errorf("unexpected flags: %#v", req)
+			}
+			if req.NameIDPolicy.Format != saml.NameIDFormatEmail {
+				t.Errorf("unexpected name id policy: %#v", req.NameIDPolicy)
+			}
+		})
+	}
+}
+
+type testResponse struct {
+	InResponseTo string `xml:"InResponseTo,attr"`
+	Destination  string `xml:"Destination,attr"`
+	Status       struct {
+		StatusCode struct {
+			Value      string `xml:"Value,attr"`
+			StatusCode struct {
+				Value string `xml:"Value,attr"`
+			} `xml:"StatusCode"`
+		} `xml:"StatusCode"`
+		StatusMessage string `xml:"StatusMessage"`
+	} `xml:"Status"`
+	Assertion struct {
+		ID        string `xml:"ID,attr"`
+		Issuer    string `xml:"Issuer"`
+		Signature struct {
+			DigestValue    string `xml:"SignedInfo>Reference>DigestValue"`
+			SignatureValue string `xml:"SignatureValue"`
+		} `xml:"Signature"`
+		NameID struct {
+			Format string `xml:"Format,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"Subject>NameID"`
+		Audience     string `xml:"Conditions>AudienceRestriction>Audience"`
+		AuthnContext string `xml:"AuthnStatement>AuthnContext>AuthnContextClassRef"`
+		Attributes   []struct {
+			Name   string   `xml:"Name,attr"`
+			Values []string `xml:"AttributeValue"`
+		} `xml:"AttributeStatement>Attribute"`
+	} `xml:"Assertion"`
+}
+
+// verifySignature checks the signature of the assertion in raw response.
+//
+// The response is made in the canonical form, so the signed parts can be taken as is.
+func verifySignature(t *testing.T, raw string, public *rsa.PublicKey, resp testResponse) {
+	t.Helper()
+
+	assertion := raw[strings.Index(raw, "<saml:Assertion ") : strings.Index(raw, "</saml:Assertion>")+len("</saml:Assertion>")]
+	signature := assertion[strings.Index(assertion, "<ds:Signature ") : strings.Index(assertion, "</ds:Signature>")+len("</ds:Signature>")]
+
+	digest := sha256.Sum256([]byte(strings.Replace(assertion, signature, "", 1)))
+	if base64.StdEncoding.EncodeToString(digest[:]) != resp.Assertion.Signature.DigestValue {
+		t.Errorf("digest of the assertion is mismatch")
+	}
+
+	signedInfo := signature[strings.Index(signature, "<ds:SignedInfo ") : strings.Index(signature, "</ds:SignedInfo>")+len("</ds:SignedInfo>")]
+	hash := sha256.Sum256([]byte(signedInfo))
+	value, _ := base64.StdEncoding.DecodeString(resp.Assertion.Signature.SignatureValue)
+	if err := rsa.VerifyPKCS1v15(public, crypto.SHA256, hash[:], value); err != nil {
+		t.Errorf("failed to verify signature: %s", err)
+	}
+}
+
+func TestMakeResponse(t *testing.T) {
+	tokenManager, err := testutil.MakeTokenManager()
+	if err != nil {
+		t.Fatalf("failed to generate TokenManager: %s", err)
+	}
+	cert, err := tokenManager.Certificate("lauth.example.com")
+	if err != nil {
+		t.Fatalf("failed to make certificate: %s", err)
+	}
+
+	raw, err := saml.MakeResponse(saml.Assertion{
+		Issuer:       "https://lauth.example.com",
+		Destination:  "https://sp.example.com/acs",
+		Audience:     "https://sp.example.com",
+		InResponseTo: "_request_id",
+		NameID:       "macrat",
+		NameIDFormat: saml.NameIDFormatPersistent,
+		AuthnInstant: time.Now(),
+		AuthMethods:  []string{"pwd", "otp"},
+		IssueInstant: time.Now(),
+		Expire:       5 * time.Minute,
+		Attributes: []saml.Attribute{
+			{Name: "email", Values: []string{"m@crat.jp"}},
+			{Name: "groups", Values: []string{"admin", "<users & staff>"}},
+		},
+	}, tokenManager, cert)
+	if err != nil {
+		t.Fatalf("failed to make response: %s", err)
+	}
+
+	var resp testResponse
+	if err := xml.Unmarshal(raw, &resp); err != nil {
+		t.Fatalf("failed to parse response: %s", err)
+	}
+
+	if resp.InResponseTo != "_request_id" || resp.Destination != "https://sp.example.com/acs" {
+		t.Errorf("unexpected response: %#v", resp)
+	}
+	if resp.Status.StatusCode.Value != saml.StatusSuccess {
+		t.Errorf("unexpected status: %#v", resp.Status)
+	}
+	if resp.Assertion.Issuer != "https://lauth.example.com" || resp.Assertion.Audience != "https://sp.example.com" {
+		t.Errorf("unexpected assertion: %#v", resp.Assertion)
+	}
+	if resp.Assertion.NameID.Value != "macrat" || resp.Assertion.NameID.Format != saml.NameIDFormatPersistent {
+		t.Errorf("unexpected name id: %#v", resp.Assertion.NameID)
+	}
+	if resp.Assertion.AuthnContext != saml.AuthnContextTimeSync {
+		t.Errorf("unexpected authentication context: %#v", resp.Assertion.AuthnContext)
+	}
+	if len(resp.Assertion.Attributes) != 2 || resp.Assertion.Attributes[1].Name != "groups" || resp.Assertion.Attributes[1].Values[1] != "<users & staff>" {
+		t.Errorf("unexpected attributes: %#v", resp.Assertion.Attributes)
+	}
+
+	verifySignature(t, string(raw), tokenManager.PublicKey(), resp)
+}
+
+func TestAuthnContextClass(t *testing.T) {
+	tests := []struct {
+		AuthMethods []string
+		Class       string
+	}{
+		{[]string{"pwd"}, saml.AuthnContextPassword},
+		{[]string{"pwd", "otp"}, saml.AuthnContextTimeSync},
+		{[]string{"pwd", "hwk", "user"}, saml.AuthnContextX509},
+		{[]string{"hwk", "user"}, saml.AuthnContextX509},
+		{nil, saml.AuthnContextUnspecified},
+	}
+
+	for _, tt := range tests {
+		if class := saml.AuthnContextClass(tt.AuthMethods); class != tt.Class {
+			t.Errorf("%#v: expected %s but got %s", tt.AuthMethods, tt.Class, class)
+		}
+	}
+}
+
+func TestMakeErrorResponse(t *testing.T) {
+	raw := saml.MakeErrorResponse("https://lauth.example.com", "https://sp.example.com/acs", "_request_id", saml.StatusResponder, saml.StatusNoPassive, "login is required", time.Now())
+
+	var resp testResponse
+	if err := xml.Unmarshal(raw, &resp); err != nil {
+		t.Fatalf("failed to parse response: %s", err)
+	}
+
+	if resp.Status.StatusCode.Value != saml.StatusResponder || resp.Status.StatusCode.StatusCode.Value != saml.StatusNoPassive {
+		t.Errorf("unexpected status: %#v", resp.Status)
+	}
+	if resp.Status.StatusMessage != "login is required" {
+		t.Errorf("unexpected status message: %#v", resp.Status.StatusMessage)
+	}
+	if resp.Assertion.ID != "" {
+		t.Errorf("error response should not include assertion")
+	}
+}
+
+func TestMakeMetadata(t *testing.T) {
+	raw := saml.MakeMetadata("https://lauth.example.com", "https://lauth.example.com/saml/sso", []byte("cert"), []string{saml.NameIDFormatPersistent})
+
+	var metadata struct {
+		EntityID string `xml:"entityID,attr"`
+		IDP      struct {
+			Certificate string   `xml:"KeyDescriptor>KeyInfo>X509Data>X509Certificate"`
+			Formats     []string `xml:"NameIDFormat"`
+			Services    []struct {
+				Binding  string `xml:"Binding,attr"`
+				Location string `xml:"Location,attr"`
+			} `xml:"SingleSignOnService"`
+		} `xml:"IDPSSODescriptor"`
+	}
+	if err := xml.Unmarshal(raw, &metadata); err != nil {
+		t.Fatalf("failed to parse metadata: %s", err)
+	}
+
+	if metadata.EntityID != "https://lauth.example.com" {
+		t.Errorf("unexpected entity ID: %#v", metadata.EntityID)
+	}
+	if metadata.IDP.Certificate != base64.StdEncoding.EncodeToString([]byte("cert")) {
+		t.Errorf("unexpected certificate: %#v", metadata.IDP.Certificate)
+	}
+	if len(metadata.IDP.Formats) != 1 || metadata.IDP.Formats[0] != saml.NameIDFormatPersistent {
+		t.Errorf("unexpected name id formats: %#v", metadata.IDP.Formats)
+	}
+	if len(metadata.IDP.Services) != 2 || metadata.IDP.Services[0].Binding != saml.HTTPRedirectBinding || metadata.IDP.Services[1].Binding != saml.HTTPPostBinding {
+		t.Errorf("unexpected services: %#v", metadata.IDP.Services)
+	}
+}
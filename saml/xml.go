@@ -0,0 +1,103 @@
+package saml
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// element is a XML element that can be written in the Exclusive XML Canonicalization form.
+//
+// Namespaces have to be declared on the element where it is used first, so that the canonical form of a sub tree is the same as the written bytes.
+type element struct {
+	Name       string
+	Namespaces map[string]string
+	Attributes map[string]string
+	Children   []*element
+	Text       string
+}
+
+func newElement(name string, children ...*element) *element {
+	return &element{
+		Name:       name,
+		Attributes: make(map[string]string),
+		Children:   children,
+	}
+}
+
+func (e *element) ns(prefix, uri string) *element {
+	if e.Namespaces == nil {
+		e.Namespaces = make(map[string]string)
+	}
+	e.Namespaces[prefix] = uri
+	return e
+}
+
+// attr sets an attribute. Empty value means to omit the attribute.
+func (e *element) attr(name, value string) *element {
+	if value != "" {
+		e.Attributes[name] = value
+	}
+	return e
+}
+
+func (e *element) text(s string) *element {
+	e.Text = s
+	return e
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	textEscaper = strings.NewReplacer(
+		"&", "&amp;",
+		"<", "&lt;",
+		">", "&gt;",
+		"\r", "&#xD;",
+	)
+	attrEscaper = strings.NewReplacer(
+		"&", "&amp;",
+		"<", "&lt;",
+		"\"", "&quot;",
+		"\t", "&#x9;",
+		"\n", "&#xA;",
+		"\r", "&#xD;",
+	)
+)
+
+func (e *element) writeTo(buf *bytes.Buffer) {
+	buf.WriteString("<" + e.Name)
+	for _, prefix := range sortedKeys(e.Namespaces) {
+		buf.WriteString(" xmlns")
+		if prefix != "" {
+			buf.WriteString(":" + prefix)
+		}
+		buf.WriteString("=\"" + attrEscaper.Replace(e.Namespaces[prefix]) + "\"")
+	}
+	// all attributes don't have namespace, so sorting by name is the same as the canonical order.
+	for _, name := range sortedKeys(e.Attributes) {
+		buf.WriteString(" " + name + "=\"" + attrEscaper.Replace(e.Attributes[name]) + "\"")
+	}
+	buf.WriteString(">")
+
+	buf.WriteString(textEscaper.Replace(e.Text))
+	for _, c := range e.Children {
+		c.writeTo(buf)
+	}
+
+	buf.WriteString("</" + e.Name + ">")
+}
+
+// Bytes returns the canonical form of the element.
+func (e *element) Bytes() []byte {
+	var buf bytes.Buffer
+	e.writeTo(&buf)
+	return buf.Bytes()
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/macrat/lauth/store"
+)
+
+const (
+	SESSION_BUCKET = "session"
+)
+
+// KVStore is a Store that saves sessions into the storage subsystem.
+type KVStore struct {
+	Store store.Store
+}
+
+func (s KVStore) Close() error {
+	return nil
+}
+
+func (s KVStore) Save(session Session) error {
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Set(SESSION_BUCKET, session.ID, raw, time.Until(session.ExpiresAt))
+}
+
+func (s KVStore) Get(id string) (Session, error) {
+	raw, err := s.Store.Get(SESSION_BUCKET, id)
+	if err == store.KeyNotFoundError {
+		return Session{}, SessionNotFoundError
+	} else if err != nil {
+		return Session{}, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(raw, &session); err != nil {
+		return Session{}, err
+	}
+	if session.Expired() {
+		return Session{}, SessionNotFoundError
+	}
+	return session, nil
+}
+
+func (s KVStore) List() ([]Session, error) {
+	keys, err := s.Store.Keys(SESSION_BUCKET)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := make([]Session, 0, len(keys))
+	for _, k := range keys {
+		session, err := s.Get(k)
+		if err == SessionNotFoundError {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ss = append(ss, session)
+	}
+	sortSessions(ss)
+
+	return ss, nil
+}
+
+func (s KVStore) Revoke(id string) error {
+	err := s.Store.Delete(SESSION_BUCKET, id)
+	if err == store.KeyNotFoundError {
+		return SessionNotFoundError
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/macrat/lauth/store"
+)
+
+var (
+	SessionNotFoundError = errors.New("session was not found")
+)
+
+type Session struct {
+	ID          string    `json:"id"`
+	Subject     string    `json:"sub"`
+	Username    string    `json:"username,omitempty"`
+	AuthTime    time.Time `json:"auth_time"`
+	AuthMethods []string  `json:"amr,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	IPAddress   string    `json:"ip_address"`
+	UserAgent   string    `json:"user_agent"`
+	Authorized  []string  `json:"authorized_clients"`
+}
+
+// DisplayName returns the login name of the user, or the subject if unknown.
+func (s Session) DisplayName() string {
+	if s.Username != "" {
+		return s.Username
+	}
+	return s.Subject
+}
+
+func (s Session) Expired() bool {
+	return !s.ExpiresAt.After(time.Now())
+}
+
+func (s Session) IsAuthorized(clientID string) bool {
+	for _, c := range s.Authorized {
+		if c == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Session) Authorize(clientID string) {
+	if !s.IsAuthorized(clientID) {
+		s.Authorized = append(s.Authorized, clientID)
+	}
+}
+
+type Store interface {
+	io.Closer
+
+	Save(session Session) error
+	Get(id string) (Session, error)
+	List() ([]Session, error)
+	Revoke(id string) error
+}
+
+func NewID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func New(subject string, authTime, expiresAt time.Time) (Session, error) {
+	id, err := NewID()
+	if err != nil {
+		return Session{}, err
+	}
+
+	return Session{
+		ID:        id,
+		Subject:   subject,
+		AuthTime:  authTime,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func NewStore(kv store.Store) Store {
+	return KVStore{Store: kv}
+}
+
+// ListBySubject returns sessions of the subject that sorted by authentication time.
+func ListBySubject(sessions Store, subject string) ([]Session, error) {
+	all, err := sessions.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var ss []Session
+	for _, s := range all {
+		if s.Subject == subject {
+			ss = append(ss, s)
+		}
+	}
+	return ss, nil
+}
+
+// RevokeSubject revokes all sessions of the subject, and returns count of revoked sessions.
+func RevokeSubject(sessions Store, subject string) (int, error) {
+	ss, err := ListBySubject(sessions, subject)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, s := range ss {
+		if err := sessions.Revoke(s.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(ss), nil
+}
+
+func sortSessions(ss []Session) {
+	sort.Slice(ss, func(i, j int) bool {
+		return ss[i].AuthTime.Before(ss[j].AuthTime)
+	})
+}
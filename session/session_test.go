@@ -0,0 +1,95 @@
+package session_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/session"
+	"github.com/macrat/lauth/store"
+)
+
+func StoreTest(t *testing.T, sessions session.Store) {
+	t.Helper()
+
+	a, err := session.New("macrat", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create session: %s", err)
+	}
+	a.IPAddress = "127.0.0.1"
+	a.UserAgent = "test-agent"
+	a.Authorize("some_client_id")
+
+	b, _ := session.New("j.smith", time.Now(), time.Now().Add(time.Hour))
+	c, _ := session.New("macrat", time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	expired, _ := session.New("macrat", time.Now().Add(-time.Hour), time.Now().Add(-time.Minute))
+
+	for _, s := range []session.Session{a, b, c, expired} {
+		if err := sessions.Save(s); err != nil {
+			t.Fatalf("failed to save session: %s", err)
+		}
+	}
+
+	if s, err := sessions.Get(a.ID); err != nil {
+		t.Errorf("failed to get session: %s", err)
+	} else if s.Subject != "macrat" || s.IPAddress != "127.0.0.1" || s.UserAgent != "test-agent" {
+		t.Errorf("unexpected session: %#v", s)
+	} else if !s.IsAuthorized("some_client_id") || s.IsAuthorized("implicit_client_id") {
+		t.Errorf("unexpected authorized clients: %#v", s.Authorized)
+	}
+
+	if _, err := sessions.Get(expired.ID); err != session.SessionNotFoundError {
+		t.Errorf("expected expired session is not found but got %v", err)
+	}
+
+	if _, err := sessions.Get("no-such-session"); err != session.SessionNotFoundError {
+		t.Errorf("expected unknown session is not found but got %v", err)
+	}
+
+	if ss, err := sessions.List(); err != nil {
+		t.Errorf("failed to list sessions: %s", err)
+	} else if len(ss) != 3 || ss[0].ID != a.ID || ss[1].ID != c.ID || ss[2].ID != b.ID {
+		t.Errorf("unexpected sessions list: %#v", ss)
+	}
+
+	if ss, err := session.ListBySubject(sessions, "macrat"); err != nil {
+		t.Errorf("failed to list sessions: %s", err)
+	} else if len(ss) != 2 || ss[0].ID != a.ID || ss[1].ID != c.ID {
+		t.Errorf("unexpected sessions list: %#v", ss)
+	}
+
+	if err := sessions.Revoke(b.ID); err != nil {
+		t.Errorf("failed to revoke session: %s", err)
+	}
+	if _, err := sessions.Get(b.ID); err != session.SessionNotFoundError {
+		t.Errorf("expected revoked session is not found but got %v", err)
+	}
+	if err := sessions.Revoke(b.ID); err != session.SessionNotFoundError {
+		t.Errorf("expected revoke twice is failed but got %v", err)
+	}
+
+	if n, err := session.RevokeSubject(sessions, "macrat"); err != nil {
+		t.Errorf("failed to revoke sessions: %s", err)
+	} else if n != 2 {
+		t.Errorf("unexpected number of revoked sessions: %d", n)
+	}
+	if ss, err := sessions.List(); err != nil {
+		t.Errorf("failed to list sessions: %s", err)
+	} else if len(ss) != 0 {
+		t.Errorf("expected all sessions are revoked but got %#v", ss)
+	}
+}
+
+func TestKVStore_memory(t *testing.T) {
+	StoreTest(t, session.NewStore(store.NewMemoryStore()))
+}
+
+func TestKVStore_file(t *testing.T) {
+	kv, err := store.OpenFileStore(filepath.Join(t.TempDir(), "lauth.db"))
+	if err != nil {
+		t.Fatalf("failed to open storage: %s", err)
+	}
+	defer kv.Close()
+
+	StoreTest(t, session.NewStore(kv))
+}
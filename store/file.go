@@ -0,0 +1,197 @@
+package store
+
+import (
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// FileStore is a Store that persists values into a single bbolt database file.
+//
+// Each value is stored with 8 bytes header that expiration time in unix nano seconds, or 0 if never expires.
+type FileStore struct {
+	db     *bolt.DB
+	purger *purger
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+	return OpenFileStoreWithPurgeInterval(path, DefaultPurgeInterval)
+}
+
+// OpenFileStoreWithPurgeInterval opens FileStore that deletes expired values every interval.
+func OpenFileStoreWithPurgeInterval(path string, interval time.Duration) (*FileStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FileStore{db: db}
+	f.purger = startPurger(interval, f.Purge)
+	return f, nil
+}
+
+// Close stops purging expired values, and closes the database file.
+func (f *FileStore) Close() error {
+	f.purger.Stop()
+	return f.db.Close()
+}
+
+func encodeEntry(value []byte, expiresAt time.Time) []byte {
+	buf := make([]byte, 8+len(value))
+	if !expiresAt.IsZero() {
+		binary.BigEndian.PutUint64(buf, uint64(expiresAt.UnixNano()))
+	}
+	copy(buf[8:], value)
+	return buf
+}
+
+func decodeEntry(raw []byte) (value []byte, expiresAt time.Time) {
+	if len(raw) < 8 {
+		return nil, time.Unix(0, 1)
+	}
+	if n := binary.BigEndian.Uint64(raw); n != 0 {
+		expiresAt = time.Unix(0, int64(n))
+	}
+	return raw[8:], expiresAt
+}
+
+// Get reads the value in a read-only transaction. Expired values are deleted by Purge instead of here, so reads don't block each other.
+func (f *FileStore) Get(bucket, key string) ([]byte, error) {
+	var result []byte
+
+	err := f.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return KeyNotFoundError
+		}
+
+		raw := b.Get([]byte(key))
+		if raw == nil {
+			return KeyNotFoundError
+		}
+
+		value, expiresAt := decodeEntry(raw)
+		if isExpired(expiresAt) {
+			return KeyNotFoundError
+		}
+
+		result = append([]byte{}, value...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (f *FileStore) Set(bucket, key string, value []byte, ttl time.Duration) error {
+	return f.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), encodeEntry(value, expiresAt(ttl)))
+	})
+}
+
+func (f *FileStore) Update(bucket, key string, fn func(value []byte) ([]byte, time.Duration, error)) error {
+	return f.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		var current []byte
+		if raw := b.Get([]byte(key)); raw != nil {
+			if value, expiresAt := decodeEntry(raw); !isExpired(expiresAt) {
+				current = append([]byte{}, value...)
+			}
+		}
+
+		value, ttl, err := fn(current)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), encodeEntry(value, expiresAt(ttl)))
+	})
+}
+
+func (f *FileStore) Delete(bucket, key string) error {
+	return f.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return KeyNotFoundError
+		}
+
+		raw := b.Get([]byte(key))
+		if raw == nil {
+			return KeyNotFoundError
+		}
+		if err := b.Delete([]byte(key)); err != nil {
+			return err
+		}
+
+		if _, expiresAt := decodeEntry(raw); isExpired(expiresAt) {
+			return KeyNotFoundError
+		}
+		return nil
+	})
+}
+
+func (f *FileStore) Keys(bucket string) ([]string, error) {
+	keys := []string{}
+
+	err := f.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			if _, expiresAt := decodeEntry(v); !isExpired(expiresAt) {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Purge deletes all expired values, and returns the count of deleted values.
+func (f *FileStore) Purge() (int, error) {
+	n := 0
+
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			var expired [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				if _, expiresAt := decodeEntry(v); isExpired(expiresAt) {
+					expired = append(expired, append([]byte{}, k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			n += len(expired)
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	Value     []byte
+	ExpiresAt time.Time
+}
+
+type MemoryStore struct {
+	sync.Mutex
+
+	buckets map[string]map[string]memoryEntry
+	purger  *purger
+}
+
+func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreWithPurgeInterval(DefaultPurgeInterval)
+}
+
+// NewMemoryStoreWithPurgeInterval makes MemoryStore that deletes expired values every interval.
+func NewMemoryStoreWithPurgeInterval(interval time.Duration) *MemoryStore {
+	m := &MemoryStore{
+		buckets: make(map[string]map[string]memoryEntry),
+	}
+	m.purger = startPurger(interval, m.Purge)
+	return m
+}
+
+// Close stops purging expired values.
+func (m *MemoryStore) Close() error {
+	m.purger.Stop()
+	return nil
+}
+
+func (m *MemoryStore) Get(bucket, key string) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	e, ok := m.buckets[bucket][key]
+	if !ok {
+		return nil, KeyNotFoundError
+	}
+	if isExpired(e.ExpiresAt) {
+		delete(m.buckets[bucket], key)
+		return nil, KeyNotFoundError
+	}
+	return append([]byte{}, e.Value...), nil
+}
+
+func (m *MemoryStore) Set(bucket, key string, value []byte, ttl time.Duration) error {
+	m.Lock()
+	defer m.Unlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		b = make(map[string]memoryEntry)
+		m.buckets[bucket] = b
+	}
+
+	b[key] = memoryEntry{
+		Value:     append([]byte{}, value...),
+		ExpiresAt: expiresAt(ttl),
+	}
+	return nil
+}
+
+func (m *MemoryStore) Update(bucket, key string, fn func(value []byte) ([]byte, time.Duration, error)) error {
+	m.Lock()
+	defer m.Unlock()
+
+	b, ok := m.buckets[bucket]
+	if !ok {
+		b = make(map[string]memoryEntry)
+		m.buckets[bucket] = b
+	}
+
+	var current []byte
+	if e, ok := b[key]; ok && !isExpired(e.ExpiresAt) {
+		current = append([]byte{}, e.Value...)
+	}
+
+	value, ttl, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	b[key] = memoryEntry{
+		Value:     append([]byte{}, value...),
+		ExpiresAt: expiresAt(ttl),
+	}
+	return nil
+}
+
+func (m *MemoryStore) Delete(bucket, key string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	e, ok := m.buckets[bucket][key]
+	if !ok {
+		return KeyNotFoundError
+	}
+	delete(m.buckets[bucket], key)
+
+	if isExpired(e.ExpiresAt) {
+		return KeyNotFoundError
+	}
+	return nil
+}
+
+func (m *MemoryStore) Keys(bucket string) ([]string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	keys := []string{}
+	for k, e := range m.buckets[bucket] {
+		if isExpired(e.ExpiresAt) {
+			delete(m.buckets[bucket], k)
+		} else {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Purge deletes all expired values, and returns the count of deleted values.
+func (m *MemoryStore) Purge() (int, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	n := 0
+	for _, b := range m.buckets {
+		for k, e := range b {
+			if isExpired(e.ExpiresAt) {
+				delete(b, k)
+				n++
+			}
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/macrat/lauth/config"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	KeyNotFoundError = errors.New("key was not found")
+)
+
+// DefaultPurgeInterval is the interval to delete expired values in background.
+const DefaultPurgeInterval = 5 * time.Minute
+
+// Store is a key-value storage that each value has expiration.
+//
+// Keys are grouped by bucket, so each feature can use it without conflict with other features.
+type Store interface {
+	io.Closer
+
+	// Get returns value of the key, or KeyNotFoundError if the key is not exists or expired.
+	Get(bucket, key string) ([]byte, error)
+
+	// Set stores value with time to live. If ttl is 0 or less, the value never expires.
+	Set(bucket, key string, value []byte, ttl time.Duration) error
+
+	// Delete removes the key, or returns KeyNotFoundError if the key is not exists or expired.
+	Delete(bucket, key string) error
+
+	// Keys returns all alive keys in the bucket.
+	Keys(bucket string) ([]string, error)
+
+	// Update replaces value of the key with the result of fn atomically.
+	//
+	// fn receives the current value, or nil if the key is not exists or expired, and returns new value and time to live.
+	// Nothing is changed if fn returns error, and the error is returned as is.
+	// fn can't use the Store, because the Store is locked while calling it.
+	Update(bucket, key string, fn func(value []byte) ([]byte, time.Duration, error)) error
+}
+
+func Open(conf config.StorageConfig) (Store, error) {
+	switch conf.Type {
+	case config.STORAGE_TYPE_MEMORY, "":
+		return NewMemoryStore(), nil
+	case config.STORAGE_TYPE_FILE:
+		return OpenFileStore(conf.File)
+	default:
+		return nil, fmt.Errorf("unsupported storage type: %#v", conf.Type)
+	}
+}
+
+func expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func isExpired(expiresAt time.Time) bool {
+	return !expiresAt.IsZero() && !expiresAt.After(time.Now())
+}
+
+// purger deletes expired values in background, because values that never read again are never deleted by Get.
+type purger struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func startPurger(interval time.Duration, purge func() (int, error)) *purger {
+	p := &purger{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := purge(); err != nil {
+					log.Error().
+						Err(err).
+						Msg("failed to purge expired values")
+				}
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+// Stop stops the purger, and waits until the running purge finishes.
+func (p *purger) Stop() {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+	<-p.done
+}
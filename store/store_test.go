@@ -0,0 +1,272 @@
+package store_test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/store"
+)
+
+func StoreTest(t *testing.T, s store.Store) {
+	t.Helper()
+
+	if _, err := s.Get("bucket", "no-such-key"); err != store.KeyNotFoundError {
+		t.Errorf("expected KeyNotFoundError but got %v", err)
+	}
+	if keys, err := s.Keys("no-such-bucket"); err != nil {
+		t.Errorf("failed to get keys of empty bucket: %s", err)
+	} else if len(keys) != 0 {
+		t.Errorf("expected no keys but got %#v", keys)
+	}
+
+	if err := s.Set("bucket", "forever", []byte("hello"), 0); err != nil {
+		t.Fatalf("failed to set value: %s", err)
+	}
+	if err := s.Set("bucket", "alive", []byte("world"), time.Hour); err != nil {
+		t.Fatalf("failed to set value: %s", err)
+	}
+	if err := s.Set("bucket", "expired", []byte("old"), time.Millisecond); err != nil {
+		t.Fatalf("failed to set value: %s", err)
+	}
+	if err := s.Set("another", "alive", []byte("another"), time.Hour); err != nil {
+		t.Fatalf("failed to set value: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if v, err := s.Get("bucket", "forever"); err != nil {
+		t.Errorf("failed to get value: %s", err)
+	} else if string(v) != "hello" {
+		t.Errorf("unexpected value: %#v", string(v))
+	}
+
+	if v, err := s.Get("bucket", "alive"); err != nil {
+		t.Errorf("failed to get value: %s", err)
+	} else if string(v) != "world" {
+		t.Errorf("unexpected value: %#v", string(v))
+	}
+
+	if v, err := s.Get("another", "alive"); err != nil {
+		t.Errorf("failed to get value: %s", err)
+	} else if string(v) != "another" {
+		t.Errorf("unexpected value: %#v", string(v))
+	}
+
+	if _, err := s.Get("bucket", "expired"); err != store.KeyNotFoundError {
+		t.Errorf("expected KeyNotFoundError but got %v", err)
+	}
+
+	if keys, err := s.Keys("bucket"); err != nil {
+		t.Errorf("failed to get keys: %s", err)
+	} else if !reflect.DeepEqual(keys, []string{"alive", "forever"}) {
+		t.Errorf("unexpected keys: %#v", keys)
+	}
+
+	if err := s.Set("bucket", "alive", []byte("overwrite"), time.Hour); err != nil {
+		t.Fatalf("failed to overwrite value: %s", err)
+	}
+	if v, err := s.Get("bucket", "alive"); err != nil {
+		t.Errorf("failed to get value: %s", err)
+	} else if string(v) != "overwrite" {
+		t.Errorf("unexpected value: %#v", string(v))
+	}
+
+	if err := s.Delete("bucket", "alive"); err != nil {
+		t.Errorf("failed to delete value: %s", err)
+	}
+	if err := s.Delete("bucket", "alive"); err != store.KeyNotFoundError {
+		t.Errorf("expected KeyNotFoundError but got %v", err)
+	}
+	if _, err := s.Get("bucket", "alive"); err != store.KeyNotFoundError {
+		t.Errorf("expected KeyNotFoundError but got %v", err)
+	}
+}
+
+// UpdateTest checks Store.Update is atomic, by counting up in parallel.
+func UpdateTest(t *testing.T, s store.Store) {
+	t.Helper()
+
+	increment := func(value []byte) ([]byte, time.Duration, error) {
+		n, _ := strconv.Atoi(string(value))
+		return []byte(strconv.Itoa(n + 1)), time.Hour, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Update("counter", "key", increment); err != nil {
+				t.Errorf("failed to update: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, err := s.Get("counter", "key"); err != nil || string(v) != "50" {
+		t.Errorf("unexpected value after parallel updates: %#v %v", string(v), err)
+	}
+
+	abort := errors.New("abort")
+	err := s.Update("counter", "key", func(value []byte) ([]byte, time.Duration, error) {
+		return []byte("changed"), time.Hour, abort
+	})
+	if err != abort {
+		t.Errorf("expected the error of fn but got %v", err)
+	}
+	if v, _ := s.Get("counter", "key"); string(v) != "50" {
+		t.Errorf("value should not be changed if fn failed: %#v", string(v))
+	}
+
+	if err := s.Set("counter", "expired", []byte("old"), time.Millisecond); err != nil {
+		t.Fatalf("failed to set value: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	err = s.Update("counter", "expired", func(value []byte) ([]byte, time.Duration, error) {
+		if value != nil {
+			t.Errorf("expired value should not be passed: %#v", string(value))
+		}
+		return []byte("new"), time.Hour, nil
+	})
+	if err != nil {
+		t.Errorf("failed to update: %s", err)
+	}
+}
+
+// purger is a Store that can delete expired values.
+type purger interface {
+	store.Store
+
+	Purge() (int, error)
+}
+
+func PurgeTest(t *testing.T, s purger) {
+	t.Helper()
+
+	if err := s.Set("purge", "expired", []byte("old"), time.Millisecond); err != nil {
+		t.Fatalf("failed to set value: %s", err)
+	}
+	if err := s.Set("purge", "alive", []byte("new"), time.Hour); err != nil {
+		t.Fatalf("failed to set value: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if n, err := s.Purge(); err != nil || n != 1 {
+		t.Errorf("expected to purge 1 value but purged %d: %v", n, err)
+	}
+	if n, err := s.Purge(); err != nil || n != 0 {
+		t.Errorf("expected nothing to purge but purged %d: %v", n, err)
+	}
+	if v, err := s.Get("purge", "alive"); err != nil || string(v) != "new" {
+		t.Errorf("alive value should not be purged: %#v %v", string(v), err)
+	}
+}
+
+func TestMemoryStore_purge(t *testing.T) {
+	PurgeTest(t, store.NewMemoryStore())
+
+	s := store.NewMemoryStoreWithPurgeInterval(10 * time.Millisecond)
+	if err := s.Set("purge", "expired", []byte("old"), time.Millisecond); err != nil {
+		t.Fatalf("failed to set value: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if n, err := s.Purge(); err != nil || n != 0 {
+		t.Errorf("expired value should be purged in background but %d values left: %v", n, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close store: %s", err)
+	}
+	if err := s.Set("purge", "expired", []byte("old"), time.Millisecond); err != nil {
+		t.Fatalf("failed to set value: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if n, err := s.Purge(); err != nil || n != 1 {
+		t.Errorf("purge should be stopped after close but %d values left: %v", n, err)
+	}
+}
+
+func TestFileStore_purge(t *testing.T) {
+	s, err := store.OpenFileStore(filepath.Join(t.TempDir(), "lauth.db"))
+	if err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+	PurgeTest(t, s)
+	s.Close()
+
+	s, err = store.OpenFileStoreWithPurgeInterval(filepath.Join(t.TempDir(), "lauth.db"), 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+	defer s.Close()
+
+	if err := s.Set("purge", "expired", []byte("old"), time.Millisecond); err != nil {
+		t.Fatalf("failed to set value: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if n, err := s.Purge(); err != nil || n != 0 {
+		t.Errorf("expired value should be purged in background but %d values left: %v", n, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	StoreTest(t, store.NewMemoryStore())
+	UpdateTest(t, store.NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lauth.db")
+
+	s, err := store.OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+	StoreTest(t, s)
+	UpdateTest(t, s)
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close store: %s", err)
+	}
+
+	s, err = store.OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %s", err)
+	}
+	defer s.Close()
+
+	if v, err := s.Get("bucket", "forever"); err != nil {
+		t.Errorf("failed to get value after reopen: %s", err)
+	} else if string(v) != "hello" {
+		t.Errorf("unexpected value after reopen: %#v", string(v))
+	}
+}
+
+func TestOpen(t *testing.T) {
+	s, err := store.Open(config.StorageConfig{Type: config.STORAGE_TYPE_MEMORY})
+	if err != nil {
+		t.Errorf("failed to open memory store: %s", err)
+	} else if _, ok := s.(*store.MemoryStore); !ok {
+		t.Errorf("unexpected store type: %T", s)
+	}
+
+	s, err = store.Open(config.StorageConfig{Type: config.STORAGE_TYPE_FILE, File: filepath.Join(t.TempDir(), "lauth.db")})
+	if err != nil {
+		t.Errorf("failed to open file store: %s", err)
+	} else if _, ok := s.(*store.FileStore); !ok {
+		t.Errorf("unexpected store type: %T", s)
+	} else {
+		s.Close()
+	}
+
+	if _, err := store.Open(config.StorageConfig{Type: "unknown"}); err == nil {
+		t.Errorf("expected error but got nil")
+	}
+}
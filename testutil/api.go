@@ -20,6 +20,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/macrat/lauth/api"
 	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/session"
+	"github.com/macrat/lauth/store"
+	"github.com/macrat/lauth/webauthn"
 	"github.com/rs/zerolog"
 )
 
@@ -89,12 +92,18 @@ func NewAPITestEnvironment(t *testing.T) *APITestEnvironment {
 		t.Fatalf("failed to make jwt certs: %s", err)
 	}
 
+	kv := store.NewMemoryStore()
+
 	api := &api.LauthAPI{
 		Connector:    LDAP,
 		Config:       MakeConfig(),
 		TokenManager: tokenManager,
+		Store:        kv,
+		Sessions:     session.NewStore(kv),
+		Credentials:  webauthn.NewStore(kv),
 	}
 	api.SetRoutes(router)
+	api.SetAdminRoutes(router)
 	api.SetErrorRoutes(router)
 
 	return &APITestEnvironment{
@@ -283,10 +292,26 @@ func (env *APITestEnvironment) Start(t *testing.T) (stop func()) {
 	go func() {
 		err := env.Run(ctx)
 		if err != nil {
-			t.Fatalf("failed on test server: %s", err)
+			t.Errorf("failed on test server: %s", err)
 		}
 	}()
 	time.Sleep(100 * time.Millisecond)
 
 	return stop
 }
+
+func (env *APITestEnvironment) MakeSSOSession(t *testing.T, subject string, authorized []string, authTime, expiresAt time.Time) string {
+	t.Helper()
+
+	sess, err := session.New(subject, authTime, expiresAt)
+	if err != nil {
+		t.Fatalf("failed to create SSO session: %s", err)
+	}
+	sess.Authorized = authorized
+
+	if err := env.API.Sessions.Save(sess); err != nil {
+		t.Fatalf("failed to save SSO session: %s", err)
+	}
+
+	return sess.ID
+}
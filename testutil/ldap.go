@@ -2,7 +2,10 @@ package testutil
 
 import (
 	"fmt"
+	"strings"
 
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
 	"github.com/macrat/lauth/ldap"
 )
 
@@ -16,6 +19,7 @@ var (
 				"sn":              {"shida"},
 				"mail":            {"m@crat.jp"},
 				"telephoneNumber": {"000-1234-5678"},
+				"memberOf":        {"CN=admin,OU=group,DC=example,DC=local"},
 			},
 		},
 		"j.smith": DummyUserInfo{
@@ -33,8 +37,15 @@ var (
 type DummyUserInfo struct {
 	Password   string
 	Attributes map[string][]string
+
+	// Subject is the stable identifier of the user. The username is used if empty.
+	Subject string
+
+	// LoginError is returned by LoginTest when the password is correct, like ldap.PasswordExpiredError.
+	LoginError error
 }
 
+// DummyLDAP is a dummy directory that keyed by username.
 type DummyLDAP map[string]DummyUserInfo
 
 func (c DummyLDAP) Connect() (ldap.Session, error) {
@@ -45,17 +56,66 @@ func (c DummyLDAP) Close() error {
 	return nil
 }
 
-func (c DummyLDAP) LoginTest(username, password string) error {
-	if user, ok := c[username]; !ok {
-		return ldap.UserNotFoundError
+func (c DummyLDAP) LoginTest(username, password string) (ldap.Identity, error) {
+	user, ok := c[username]
+	if !ok {
+		return ldap.Identity{}, ldap.UserNotFoundError
 	} else if user.Password != password {
-		return fmt.Errorf("incorrect password")
+		return ldap.Identity{}, &ldap.BindError{Reason: ldap.InvalidCredentialsError}
+	} else if user.LoginError != nil {
+		return ldap.Identity{}, &ldap.BindError{Reason: user.LoginError}
 	}
-	return nil
+
+	id := ldap.Identity{Subject: user.Subject, Username: username}
+	if id.Subject == "" {
+		id.Subject = username
+	}
+	return id, nil
 }
 
-func (c DummyLDAP) GetUserAttributes(username string, attributes []string) (map[string][]string, error) {
+// ChangePassword changes password of the user, and clears LoginError.
+func (c DummyLDAP) ChangePassword(username, oldPassword, newPassword string) error {
 	user, ok := c[username]
+	if !ok {
+		return ldap.UserNotFoundError
+	} else if user.Password != oldPassword {
+		return &ldap.BindError{Reason: ldap.InvalidCredentialsError}
+	} else if newPassword == oldPassword {
+		return &ldap.BindError{Reason: ldap.PasswordPolicyError}
+	}
+
+	user.Password = newPassword
+	user.LoginError = nil
+	c[username] = user
+	return nil
+}
+
+func (c DummyLDAP) findBySubject(subject string) (DummyUserInfo, bool) {
+	for username, user := range c {
+		if user.Subject == subject || (user.Subject == "" && username == subject) {
+			return user, true
+		}
+	}
+	return DummyUserInfo{}, false
+}
+
+func (c DummyLDAP) FindUser(attribute, value string) (ldap.Identity, error) {
+	for username, user := range c {
+		for _, v := range user.Attributes[attribute] {
+			if v == value {
+				id := ldap.Identity{Subject: user.Subject, Username: username}
+				if id.Subject == "" {
+					id.Subject = username
+				}
+				return id, nil
+			}
+		}
+	}
+	return ldap.Identity{}, ldap.UserNotFoundError
+}
+
+func (c DummyLDAP) GetUserAttributes(subject string, attributes []string) (map[string][]string, error) {
+	user, ok := c.findBySubject(subject)
 	if !ok {
 		return nil, ldap.UserNotFoundError
 	}
@@ -68,3 +128,53 @@ func (c DummyLDAP) GetUserAttributes(username string, attributes []string) (map[
 	}
 	return result, nil
 }
+
+func (c DummyLDAP) MatchFilter(subject, filter string) (bool, error) {
+	user, ok := c.findBySubject(subject)
+	if !ok {
+		return false, nil
+	}
+
+	packet, err := goldap.CompileFilter(filter)
+	if err != nil {
+		return false, err
+	}
+
+	return matchFilter(user.Attributes, packet)
+}
+
+// matchFilter evaluates a compiled LDAP filter that consists of and, or, not, equality match, and present.
+func matchFilter(attrs map[string][]string, packet *ber.Packet) (bool, error) {
+	switch packet.Tag {
+	case goldap.FilterAnd, goldap.FilterOr:
+		for _, child := range packet.Children {
+			ok, err := matchFilter(attrs, child)
+			if err != nil {
+				return false, err
+			}
+			if packet.Tag == goldap.FilterOr && ok {
+				return true, nil
+			}
+			if packet.Tag == goldap.FilterAnd && !ok {
+				return false, nil
+			}
+		}
+		return packet.Tag == goldap.FilterAnd, nil
+	case goldap.FilterNot:
+		ok, err := matchFilter(attrs, packet.Children[0])
+		return !ok, err
+	case goldap.FilterPresent:
+		return len(attrs[string(packet.Data.Bytes())]) > 0, nil
+	case goldap.FilterEqualityMatch:
+		name := ber.DecodeString(packet.Children[0].Data.Bytes())
+		value := ber.DecodeString(packet.Children[1].Data.Bytes())
+		for _, v := range attrs[name] {
+			if strings.EqualFold(v, value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("unsupported filter: %s", goldap.FilterMap[uint64(packet.Tag)])
+	}
+}
@@ -9,10 +9,10 @@ import (
 )
 
 func TestDummyLDAP(t *testing.T) {
-	if err := testutil.LDAP.LoginTest("macrat", "foobar"); err != nil {
+	if _, err := testutil.LDAP.LoginTest("macrat", "foobar"); err != nil {
 		t.Errorf("expected success to login but failed: %s", err)
 	}
-	if err := testutil.LDAP.LoginTest("macrat", "hello"); err == nil {
+	if _, err := testutil.LDAP.LoginTest("macrat", "hello"); err == nil {
 		t.Errorf("expected fail to login but succeed")
 	}
 
@@ -27,4 +27,24 @@ func TestDummyLDAP(t *testing.T) {
 	} else if err != ldap.UserNotFoundError {
 		t.Errorf("unexpected error: %s", err)
 	}
+
+	tests := []struct {
+		Username string
+		Filter   string
+		Expect   bool
+	}{
+		{"macrat", "(memberOf=CN=admin,OU=group,DC=example,DC=local)", true},
+		{"j.smith", "(memberOf=CN=admin,OU=group,DC=example,DC=local)", false},
+		{"j.smith", "(!(memberOf=CN=admin,OU=group,DC=example,DC=local))", true},
+		{"macrat", "(&(mail=*)(sn=SHIDA))", true},
+		{"macrat", "(|(sn=smith)(givenName=jhon))", false},
+		{"noone", "(mail=*)", false},
+	}
+	for _, tt := range tests {
+		if ok, err := testutil.LDAP.MatchFilter(tt.Username, tt.Filter); err != nil {
+			t.Errorf("%s %s: failed to match filter: %s", tt.Username, tt.Filter, err)
+		} else if ok != tt.Expect {
+			t.Errorf("%s %s: expected %v but got %v", tt.Username, tt.Filter, tt.Expect, ok)
+		}
+	}
 }
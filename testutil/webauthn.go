@@ -0,0 +1,172 @@
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/macrat/lauth/webauthn"
+)
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 1<<8:
+		return []byte{major<<5 | 24, byte(n)}
+	case n < 1<<16:
+		b := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		return b
+	default:
+		b := []byte{major<<5 | 26, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		return b
+	}
+}
+
+func cborInt(v int64) []byte {
+	if v < 0 {
+		return cborHead(1, uint64(-1-v))
+	}
+	return cborHead(0, uint64(v))
+}
+
+func cborBytes(b []byte) []byte {
+	return append(cborHead(2, uint64(len(b))), b...)
+}
+
+func cborText(s string) []byte {
+	return append(cborHead(3, uint64(len(s))), s...)
+}
+
+// cborMap encodes a map from encoded keys and values like key1, value1, key2, value2, ...
+func cborMap(items ...[]byte) []byte {
+	b := cborHead(5, uint64(len(items)/2))
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+// SoftAuthenticator is a WebAuthn authenticator in software for testing.
+//
+// It makes responses of navigator.credentials.create() and navigator.credentials.get() in JSON, like the login page sends.
+type SoftAuthenticator struct {
+	Origin       string
+	UserVerified bool
+
+	Key          *ecdsa.PrivateKey
+	CredentialID []byte
+	UserHandle   []byte
+	SignCount    uint32
+}
+
+func NewSoftAuthenticator(origin string) (*SoftAuthenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &SoftAuthenticator{
+		Origin:       origin,
+		UserVerified: true,
+		Key:          key,
+		CredentialID: id,
+	}, nil
+}
+
+func (a *SoftAuthenticator) clientData(typ, challenge string) []byte {
+	raw, _ := json.Marshal(webauthn.ClientData{
+		Type:      typ,
+		Challenge: challenge,
+		Origin:    a.Origin,
+	})
+	return raw
+}
+
+func (a *SoftAuthenticator) authData(rpID string, attested bool) []byte {
+	hash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, hash[:]...)
+
+	flags := byte(webauthn.FlagUserPresent)
+	if a.UserVerified {
+		flags |= webauthn.FlagUserVerified
+	}
+	if attested {
+		flags |= webauthn.FlagAttestedCredentialData
+	}
+	data = append(data, flags)
+
+	a.SignCount++
+	var counter [4]byte
+	binary.BigEndian.PutUint32(counter[:], a.SignCount)
+	data = append(data, counter[:]...)
+
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = append(data, byte(len(a.CredentialID)>>8), byte(len(a.CredentialID)))
+		data = append(data, a.CredentialID...)
+
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		a.Key.X.FillBytes(x)
+		a.Key.Y.FillBytes(y)
+		data = append(data, cborMap(
+			cborInt(1), cborInt(2),
+			cborInt(3), cborInt(webauthn.AlgES256),
+			cborInt(-1), cborInt(1),
+			cborInt(-2), cborBytes(x),
+			cborInt(-3), cborBytes(y),
+		)...)
+	}
+
+	return data
+}
+
+// Create registers this authenticator for the user, and returns the response in JSON.
+func (a *SoftAuthenticator) Create(rpID, challenge string, userHandle []byte) (string, error) {
+	a.UserHandle = userHandle
+
+	var resp webauthn.RegistrationResponse
+	resp.ID = a.CredentialID
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = a.clientData("webauthn.create", challenge)
+	resp.Response.AttestationObject = cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(a.authData(rpID, true)),
+	)
+
+	raw, err := json.Marshal(resp)
+	return string(raw), err
+}
+
+// Get signs the challenge, and returns the response in JSON.
+func (a *SoftAuthenticator) Get(rpID, challenge string) (string, error) {
+	var resp webauthn.AssertionResponse
+	resp.ID = a.CredentialID
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = a.clientData("webauthn.get", challenge)
+	resp.Response.AuthenticatorData = a.authData(rpID, false)
+	resp.Response.UserHandle = a.UserHandle
+
+	hash := sha256.Sum256(resp.Response.ClientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, resp.Response.AuthenticatorData...), hash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.Key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	resp.Response.Signature = sig
+
+	raw, err := json.Marshal(resp)
+	return string(raw), err
+}
@@ -0,0 +1,36 @@
+package token
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+)
+
+// Certificate makes a self-signed certificate of the signing key.
+//
+// The certificate is always the same for the same key and hostname, so it can be registered to SAML service providers.
+func (m Manager) Certificate(hostname string) ([]byte, error) {
+	id := m.KeyID()
+
+	template := &x509.Certificate{
+		Issuer:       pkix.Name{CommonName: hostname},
+		Subject:      pkix.Name{CommonName: hostname},
+		SerialNumber: new(big.Int).SetBytes(id[:]),
+		NotBefore:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	return x509.CreateCertificate(rand.Reader, template, template, m.public, m.private)
+}
+
+// Sign makes RSASSA-PKCS1-v1_5 signature with SHA-256 of data.
+func (m Manager) Sign(data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, m.private, crypto.SHA256, hash[:])
+}
@@ -0,0 +1,59 @@
+package token_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"testing"
+
+	"github.com/macrat/lauth/testutil"
+)
+
+func TestManager_Certificate(t *testing.T) {
+	tokenManager, err := testutil.MakeTokenManager()
+	if err != nil {
+		t.Fatalf("failed to generate TokenManager: %s", err)
+	}
+
+	raw, err := tokenManager.Certificate("lauth.example.com")
+	if err != nil {
+		t.Fatalf("failed to make certificate: %s", err)
+	}
+
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %s", err)
+	}
+	if cert.Subject.CommonName != "lauth.example.com" {
+		t.Errorf("unexpected subject: %s", cert.Subject)
+	}
+	if pub, ok := cert.PublicKey.(*rsa.PublicKey); !ok || !pub.Equal(tokenManager.PublicKey()) {
+		t.Errorf("certificate has unexpected public key")
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Errorf("certificate is not self-signed: %s", err)
+	}
+
+	if again, err := tokenManager.Certificate("lauth.example.com"); err != nil || !bytes.Equal(raw, again) {
+		t.Errorf("certificate should be the same for the same key: %v", err)
+	}
+}
+
+func TestManager_Sign(t *testing.T) {
+	tokenManager, err := testutil.MakeTokenManager()
+	if err != nil {
+		t.Fatalf("failed to generate TokenManager: %s", err)
+	}
+
+	sign, err := tokenManager.Sign([]byte("hello world"))
+	if err != nil {
+		t.Fatalf("failed to sign: %s", err)
+	}
+
+	hash := sha256.Sum256([]byte("hello world"))
+	if err := rsa.VerifyPKCS1v15(tokenManager.PublicKey(), crypto.SHA256, hash[:], sign); err != nil {
+		t.Errorf("failed to verify signature: %s", err)
+	}
+}
@@ -33,7 +33,7 @@ func (claims CodeClaims) Validate(issuer *config.URL) error {
 	return nil
 }
 
-func (m Manager) CreateCode(issuer *config.URL, subject, clientID, redirectURI, scope, nonce string, authTime time.Time, expiresIn time.Duration) (string, error) {
+func (m Manager) CreateCode(issuer *config.URL, subject, clientID, redirectURI, scope, nonce string, authTime time.Time, authMethods []string, expiresIn time.Duration) (string, error) {
 	plain, err := json.Marshal(CodeClaims{
 		OIDCClaims: OIDCClaims{
 			StandardClaims: jwt.StandardClaims{
@@ -43,8 +43,9 @@ func (m Manager) CreateCode(issuer *config.URL, subject, clientID, redirectURI,
 				ExpiresAt: time.Now().Add(expiresIn).Unix(),
 				IssuedAt:  time.Now().Unix(),
 			},
-			Type:     "CODE",
-			AuthTime: authTime.Unix(),
+			Type:        "CODE",
+			AuthTime:    authTime.Unix(),
+			AuthMethods: authMethods,
 		},
 		ClientID:    clientID,
 		RedirectURI: redirectURI,
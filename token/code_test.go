@@ -17,7 +17,7 @@ func TestCodeToken(t *testing.T) {
 
 	issuer := &config.URL{Scheme: "http", Host: "localhost:8000"}
 
-	code, err := tokenManager.CreateCode(issuer, "someone", "something", "http://something", "openid profile", "", time.Now(), 10*time.Minute)
+	code, err := tokenManager.CreateCode(issuer, "someone", "something", "http://something", "openid profile", "", time.Now(), []string{"pwd", "otp"}, 10*time.Minute)
 	if err != nil {
 		t.Fatalf("failed to generate code: %s", err)
 	}
@@ -31,6 +31,10 @@ func TestCodeToken(t *testing.T) {
 		t.Errorf("failed to validate code: %s", err)
 	}
 
+	if len(claims.AuthMethods) != 2 || claims.AuthMethods[0] != "pwd" || claims.AuthMethods[1] != "otp" {
+		t.Errorf("unexpected amr: %#v", claims.AuthMethods)
+	}
+
 	if err = claims.Validate(&config.URL{Host: "another-issuer"}); err == nil {
 		t.Errorf("must be failed if issuer is incorrect but success")
 	} else if err != token.UnexpectedIssuerError {
@@ -36,6 +36,10 @@ func (claims IDTokenClaims) MarshalJSON() ([]byte, error) {
 	c["typ"] = claims.Type
 	c["auth_time"] = claims.AuthTime
 
+	if len(claims.AuthMethods) > 0 {
+		c["amr"] = claims.AuthMethods
+	}
+
 	if claims.Nonce != "" {
 		c["nonce"] = claims.Nonce
 	}
@@ -67,7 +71,7 @@ func (claims *IDTokenClaims) UnmarshalJSON(data []byte) error {
 
 	for k := range c {
 		switch k {
-		case "exp", "iat", "iss", "sub", "aud", "typ", "auth_time", "nbt", "jti", "nonce", "c_hash", "at_hash":
+		case "exp", "iat", "iss", "sub", "aud", "typ", "auth_time", "amr", "nbt", "jti", "nonce", "c_hash", "at_hash":
 			delete(c, k)
 		}
 	}
@@ -88,7 +92,7 @@ func (claims IDTokenClaims) Validate(issuer *config.URL, audience string) error
 	return nil
 }
 
-func (m Manager) CreateIDToken(issuer *config.URL, subject, audience, nonce, code, accessToken string, extraClaims ExtraClaims, authTime time.Time, expiresIn time.Duration) (string, error) {
+func (m Manager) CreateIDToken(issuer *config.URL, subject, audience, nonce, code, accessToken string, extraClaims ExtraClaims, authTime time.Time, authMethods []string, expiresIn time.Duration) (string, error) {
 	codeHash := ""
 	if code != "" {
 		codeHash = TokenHash(code)
@@ -108,8 +112,9 @@ func (m Manager) CreateIDToken(issuer *config.URL, subject, audience, nonce, cod
 				ExpiresAt: time.Now().Add(expiresIn).Unix(),
 				IssuedAt:  time.Now().Unix(),
 			},
-			Type:     "ID_TOKEN",
-			AuthTime: authTime.Unix(),
+			Type:        "ID_TOKEN",
+			AuthTime:    authTime.Unix(),
+			AuthMethods: authMethods,
 		},
 		Nonce:           nonce,
 		CodeHash:        codeHash,
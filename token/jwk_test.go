@@ -62,7 +62,7 @@ func TestTokenManager_JWKs(t *testing.T) {
 		t.Errorf("public key that got by certificate is not equals original key\noriginal key: %#v\ncert key: %#v", manager.PublicKey(), cert.PublicKey)
 	}
 
-	idToken, err := manager.CreateIDToken(&config.URL{Scheme: "https", Host: "localhost"}, "someone", "something", "", "code", "token", nil, time.Now(), 10*time.Minute)
+	idToken, err := manager.CreateIDToken(&config.URL{Scheme: "https", Host: "localhost"}, "someone", "something", "", "code", "token", nil, time.Now(), nil, 10*time.Minute)
 	if err != nil {
 		t.Fatalf("failed to generate id_token: %s", err)
 	}
@@ -8,8 +8,9 @@ import (
 type OIDCClaims struct {
 	jwt.StandardClaims
 
-	Type     string `json:"typ"`
-	AuthTime int64  `json:"auth_time,omitempty"`
+	Type        string   `json:"typ"`
+	AuthTime    int64    `json:"auth_time,omitempty"`
+	AuthMethods []string `json:"amr,omitempty"`
 }
 
 func (claims OIDCClaims) Validate(issuer *config.URL, audience string) error {
@@ -31,7 +31,7 @@ func (claims RefreshTokenClaims) Validate(issuer *config.URL) error {
 	return nil
 }
 
-func (m Manager) CreateRefreshToken(issuer *config.URL, subject, clientID, scope, nonce string, authTime time.Time, expiresIn time.Duration) (string, error) {
+func (m Manager) CreateRefreshToken(issuer *config.URL, subject, clientID, scope, nonce string, authTime time.Time, authMethods []string, expiresIn time.Duration) (string, error) {
 	return m.create(RefreshTokenClaims{
 		OIDCClaims: OIDCClaims{
 			StandardClaims: jwt.StandardClaims{
@@ -41,8 +41,9 @@ func (m Manager) CreateRefreshToken(issuer *config.URL, subject, clientID, scope
 				ExpiresAt: time.Now().Add(expiresIn).Unix(),
 				IssuedAt:  time.Now().Unix(),
 			},
-			Type:     "REFRESH_TOKEN",
-			AuthTime: authTime.Unix(),
+			Type:        "REFRESH_TOKEN",
+			AuthTime:    authTime.Unix(),
+			AuthMethods: authMethods,
 		},
 		ClientID: clientID,
 		Scope:    scope,
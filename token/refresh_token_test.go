@@ -17,7 +17,7 @@ func TestRefreshToken(t *testing.T) {
 
 	issuer := &config.URL{Scheme: "http", Host: "localhost:8000"}
 
-	refreshToken, err := tokenManager.CreateRefreshToken(issuer, "someone", "something", "email profile", "this-is-nonce", time.Now(), 10*time.Minute)
+	refreshToken, err := tokenManager.CreateRefreshToken(issuer, "someone", "something", "email profile", "this-is-nonce", time.Now(), nil, 10*time.Minute)
 	if err != nil {
 		t.Fatalf("failed to generate token: %s", err)
 	}
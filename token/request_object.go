@@ -19,6 +19,29 @@ type RequestObjectClaims struct {
 	MaxAge       int64  `json:"max_age,omitempty"`
 	Prompt       string `json:"prompt,omitempty"`
 	LoginHint    string `json:"login_hint,omitempty"`
+
+	// MFASubject and MFAUsername are the user who passed the password but not the second factor yet.
+	MFASubject  string `json:"mfa_sub,omitempty"`
+	MFAUsername string `json:"mfa_username,omitempty"`
+
+	// MFASecret is the TOTP secret that the user is enrolling.
+	MFASecret string `json:"mfa_secret,omitempty"`
+
+	// MFAMethod is the kind of the second factor; "totp", "webauthn", or "webauthn_register".
+	MFAMethod string `json:"mfa_method,omitempty"`
+
+	// WebAuthnChallenge is the challenge for the security key or the passkey.
+	WebAuthnChallenge string `json:"webauthn_challenge,omitempty"`
+
+	// MFAUpstream means the user logged in with an upstream provider instead of the password.
+	MFAUpstream bool `json:"mfa_upstream,omitempty"`
+
+	// RegisterPasskey means the user is going to register a passkey after TOTP.
+	RegisterPasskey bool `json:"register_passkey,omitempty"`
+
+	// PasswordChangeUser is the user who passed the password but has to change it, and PasswordChangeDirectory is the directory of the user.
+	PasswordChangeUser      string `json:"password_change_user,omitempty"`
+	PasswordChangeDirectory string `json:"password_change_directory,omitempty"`
 }
 
 func (claims RequestObjectClaims) Validate(issuer string, audience *config.URL) error {
@@ -0,0 +1,64 @@
+package upstream
+
+import (
+	"strings"
+
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/ldap"
+)
+
+// Session is a ldap.Session that answers about users of upstream providers from Store, and about others from the base session.
+type Session struct {
+	ldap.Session
+
+	Config *config.Config
+	Store  Store
+}
+
+func (s Session) LoginTestIn(directory, username, password string) (ldap.Identity, error) {
+	return ldap.LoginTestIn(s.Session, directory, username, password)
+}
+
+func (s Session) ChangePassword(username, oldPassword, newPassword string) error {
+	return ldap.ChangePasswordIn(s.Session, "", username, oldPassword, newPassword)
+}
+
+func (s Session) ChangePasswordIn(directory, username, oldPassword, newPassword string) error {
+	return ldap.ChangePasswordIn(s.Session, directory, username, oldPassword, newPassword)
+}
+
+func (s Session) FindUser(attribute, value string) (ldap.Identity, error) {
+	return ldap.FindUser(s.Session, attribute, value)
+}
+
+func (s Session) GetUserAttributes(subject string, attributes []string) (map[string][]string, error) {
+	if _, _, ok := s.Config.UpstreamFor(subject); !ok {
+		return s.Session.GetUserAttributes(subject, attributes)
+	}
+
+	attrs, err := s.Store.User(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]string)
+	for _, name := range attributes {
+		for k, vs := range attrs {
+			if strings.EqualFold(k, name) {
+				result[name] = vs
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// MatchFilter checks the filter against LDAP for LDAP users.
+// Users of upstream providers never match, because their attributes are claims that the upstream made, not of the directory.
+// So clients that have required_groups or required_filter deny users who are not linked to LDAP accounts.
+func (s Session) MatchFilter(subject, filter string) (bool, error) {
+	if _, _, ok := s.Config.UpstreamFor(subject); !ok {
+		return s.Session.MatchFilter(subject, filter)
+	}
+	return false, nil
+}
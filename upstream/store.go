@@ -0,0 +1,100 @@
+package upstream
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/store"
+)
+
+const (
+	LOGIN_BUCKET = "upstream_login"
+	USER_BUCKET  = "upstream_user"
+)
+
+// Login is a login with the upstream provider that in progress.
+type Login struct {
+	Upstream string `json:"upstream"`
+
+	// Request is the request object of the authorization request, to continue the authorization after login.
+	Request string `json:"request"`
+
+	Nonce string `json:"nonce"`
+}
+
+// Store remembers logins in progress, and attributes of users who logged in with upstream providers.
+type Store struct {
+	Store store.Store
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// StartLogin saves the login, and returns the state to find it.
+// The nonce of the login is generated if empty.
+func (s Store) StartLogin(login Login, ttl time.Duration) (Login, string, error) {
+	state, err := randomString()
+	if err != nil {
+		return Login{}, "", err
+	}
+
+	if login.Nonce == "" {
+		if login.Nonce, err = randomString(); err != nil {
+			return Login{}, "", err
+		}
+	}
+
+	raw, err := json.Marshal(login)
+	if err != nil {
+		return Login{}, "", err
+	}
+
+	return login, state, s.Store.Set(LOGIN_BUCKET, state, raw, ttl)
+}
+
+// FinishLogin returns the login of the state, and forgets it so that the state can't be used twice.
+// It returns store.KeyNotFoundError if the state is unknown, expired, or already used by a parallel request.
+func (s Store) FinishLogin(state string) (Login, error) {
+	raw, err := s.Store.Get(LOGIN_BUCKET, state)
+	if err != nil {
+		return Login{}, err
+	}
+	if err := s.Store.Delete(LOGIN_BUCKET, state); err != nil {
+		return Login{}, err
+	}
+
+	var login Login
+	err = json.Unmarshal(raw, &login)
+	return login, err
+}
+
+// SaveUser remembers attributes of the user for issuing tokens and responding userinfo.
+func (s Store) SaveUser(subject string, attributes map[string][]string, ttl time.Duration) error {
+	raw, err := json.Marshal(attributes)
+	if err != nil {
+		return err
+	}
+	return s.Store.Set(USER_BUCKET, subject, raw, ttl)
+}
+
+// User returns attributes of the user, or ldap.UserNotFoundError if the user is unknown or expired.
+func (s Store) User(subject string) (map[string][]string, error) {
+	raw, err := s.Store.Get(USER_BUCKET, subject)
+	if err == store.KeyNotFoundError {
+		return nil, ldap.UserNotFoundError
+	} else if err != nil {
+		return nil, err
+	}
+
+	var attrs map[string][]string
+	err = json.Unmarshal(raw, &attrs)
+	return attrs, err
+}
@@ -0,0 +1,156 @@
+// Package upstream is a relying party of upstream OpenID Providers, to let users log in with other identity providers.
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/macrat/lauth/config"
+	"golang.org/x/oauth2"
+)
+
+var (
+	NonceMismatchError = errors.New("nonce of the ID token is mismatch")
+	NoIDTokenError     = errors.New("upstream provider didn't return ID token")
+)
+
+// Provider is a client of an upstream OpenID Provider.
+//
+// The discovery document of the provider is fetched when it is needed first time.
+type Provider struct {
+	Config *config.UpstreamConfig
+
+	// HTTPClient is used to access to the provider. http.DefaultClient is used if nil.
+	HTTPClient *http.Client
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// Providers is a set of Provider keyed by name.
+type Providers map[string]*Provider
+
+// NewProviders makes Providers from configs.
+func NewProviders(confs []config.UpstreamConfig) Providers {
+	ps := make(Providers)
+	for i := range confs {
+		ps[confs[i].Name] = &Provider{Config: &confs[i]}
+	}
+	return ps
+}
+
+func (p *Provider) context(ctx context.Context) context.Context {
+	if p.HTTPClient != nil {
+		return oidc.ClientContext(ctx, p.HTTPClient)
+	}
+	return ctx
+}
+
+func (p *Provider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider != nil {
+		return p.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(p.context(ctx), p.Config.Issuer.String())
+	if err != nil {
+		return nil, err
+	}
+	p.provider = provider
+	return provider, nil
+}
+
+func (p *Provider) oauth2Config(provider *oidc.Provider, redirectURI string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.Config.ClientID,
+		ClientSecret: p.Config.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURI,
+		Scopes:       p.Config.RequestScopes,
+	}
+}
+
+// AuthCodeURL makes URL of the upstream provider to redirect the end-user.
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURI, state, nonce string) (string, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return p.oauth2Config(provider, redirectURI).AuthCodeURL(state, oidc.Nonce(nonce)), nil
+}
+
+// Exchange exchanges the code to ID token, and returns claims of the verified ID token.
+func (p *Provider) Exchange(ctx context.Context, redirectURI, code, nonce string) (map[string]interface{}, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = p.context(ctx)
+
+	tok, err := p.oauth2Config(provider, redirectURI).Exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return nil, NoIDTokenError
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.Config.ClientID}).Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != nonce {
+		return nil, NonceMismatchError
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Attributes converts claims of ID token to attributes, so that claims can be mapped by ScopeConfig like LDAP attributes.
+//
+// Arrays become multiple values, and objects are encoded as JSON.
+func Attributes(claims map[string]interface{}) map[string][]string {
+	attrs := make(map[string][]string)
+	for name, value := range claims {
+		if vs, ok := value.([]interface{}); ok {
+			for _, v := range vs {
+				attrs[name] = append(attrs[name], stringify(v))
+			}
+		} else if value != nil {
+			attrs[name] = []string{stringify(value)}
+		}
+	}
+	return attrs
+}
+
+func stringify(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+}
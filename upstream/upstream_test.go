@@ -0,0 +1,121 @@
+package upstream_test
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/config"
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/store"
+	"github.com/macrat/lauth/testutil"
+	"github.com/macrat/lauth/upstream"
+)
+
+func TestAttributes(t *testing.T) {
+	attrs := upstream.Attributes(map[string]interface{}{
+		"sub":            "1234",
+		"email_verified": true,
+		"age":            float64(20),
+		"groups":         []interface{}{"admin", "staff"},
+		"address":        map[string]interface{}{"country": "JP"},
+		"nothing":        nil,
+	})
+
+	expect := map[string][]string{
+		"sub":            {"1234"},
+		"email_verified": {"true"},
+		"age":            {"20"},
+		"groups":         {"admin", "staff"},
+		"address":        {`{"country":"JP"}`},
+	}
+	if !reflect.DeepEqual(attrs, expect) {
+		t.Errorf("unexpected attributes: %#v", attrs)
+	}
+}
+
+func TestStore_Login(t *testing.T) {
+	s := upstream.Store{Store: store.NewMemoryStore()}
+
+	login, state, err := s.StartLogin(upstream.Login{Upstream: "partner", Request: "request-object"}, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to start login: %s", err)
+	}
+	if login.Nonce == "" || state == "" || login.Nonce == state {
+		t.Errorf("nonce and state should be generated: %#v %#v", login.Nonce, state)
+	}
+
+	if got, err := s.FinishLogin(state); err != nil || got != login {
+		t.Errorf("failed to finish login: %#v %v", got, err)
+	}
+	if _, err := s.FinishLogin(state); err != store.KeyNotFoundError {
+		t.Errorf("state should not be used twice: %v", err)
+	}
+}
+
+func TestStore_FinishLogin_parallel(t *testing.T) {
+	s := upstream.Store{Store: store.NewMemoryStore()}
+
+	_, state, err := s.StartLogin(upstream.Login{Upstream: "partner", Request: "request-object"}, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to start login: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	var finished int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.FinishLogin(state); err == nil {
+				atomic.AddInt32(&finished, 1)
+			} else if err != store.KeyNotFoundError {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if finished != 1 {
+		t.Errorf("state should be used only once but used %d times", finished)
+	}
+}
+
+func TestSession(t *testing.T) {
+	conf := &config.Config{Upstreams: []config.UpstreamConfig{{Name: "partner"}}}
+	s := upstream.Session{
+		Session: testutil.LDAP,
+		Config:  conf,
+		Store:   upstream.Store{Store: store.NewMemoryStore()},
+	}
+
+	if err := s.Store.SaveUser("partner:1234", map[string][]string{"email": {"a@partner.example.com"}, "groups": {"staff"}}, time.Minute); err != nil {
+		t.Fatalf("failed to save user: %s", err)
+	}
+
+	attrs, err := s.GetUserAttributes("partner:1234", []string{"EMAIL", "name"})
+	if err != nil || !reflect.DeepEqual(attrs, map[string][]string{"EMAIL": {"a@partner.example.com"}}) {
+		t.Errorf("unexpected attributes: %#v %v", attrs, err)
+	}
+	if ok, err := s.MatchFilter("partner:1234", "(groups=staff)"); err != nil || ok {
+		t.Errorf("claims of upstream should not be used as LDAP attributes: %v %v", ok, err)
+	}
+
+	if _, err := s.GetUserAttributes("partner:5678", []string{"email"}); !errors.Is(err, ldap.UserNotFoundError) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if ok, err := s.MatchFilter("partner:5678", "(groups=staff)"); err != nil || ok {
+		t.Errorf("unknown user should not match: %v %v", ok, err)
+	}
+
+	attrs, err = s.GetUserAttributes("macrat", []string{"mail"})
+	if err != nil || !reflect.DeepEqual(attrs, map[string][]string{"mail": {"m@crat.jp"}}) {
+		t.Errorf("LDAP user should be answered by base session: %#v %v", attrs, err)
+	}
+	if id, err := s.FindUser("mail", "m@crat.jp"); err != nil || id.Subject != "macrat" {
+		t.Errorf("failed to find user: %#v %v", id, err)
+	}
+}
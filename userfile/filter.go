@@ -0,0 +1,91 @@
+package userfile
+
+import (
+	"fmt"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// MatchFilter reports the user matches to the LDAP filter.
+//
+// Values are compared case-insensitively. Extensible match is not supported.
+func MatchFilter(user User, filter string) (bool, error) {
+	packet, err := goldap.CompileFilter(filter)
+	if err != nil {
+		return false, err
+	}
+	return matchFilter(user, packet)
+}
+
+func matchFilter(user User, packet *ber.Packet) (bool, error) {
+	switch packet.Tag {
+	case goldap.FilterAnd, goldap.FilterOr:
+		for _, child := range packet.Children {
+			ok, err := matchFilter(user, child)
+			if err != nil {
+				return false, err
+			}
+			if packet.Tag == goldap.FilterOr && ok {
+				return true, nil
+			}
+			if packet.Tag == goldap.FilterAnd && !ok {
+				return false, nil
+			}
+		}
+		return packet.Tag == goldap.FilterAnd, nil
+	case goldap.FilterNot:
+		ok, err := matchFilter(user, packet.Children[0])
+		return !ok, err
+	case goldap.FilterPresent:
+		vs, _ := user.attribute(ber.DecodeString(packet.Data.Bytes()))
+		return len(vs) > 0, nil
+	case goldap.FilterEqualityMatch, goldap.FilterApproxMatch, goldap.FilterGreaterOrEqual, goldap.FilterLessOrEqual:
+		vs, _ := user.attribute(ber.DecodeString(packet.Children[0].Data.Bytes()))
+		value := strings.ToLower(ber.DecodeString(packet.Children[1].Data.Bytes()))
+		for _, v := range vs {
+			v = strings.ToLower(v)
+			if packet.Tag == goldap.FilterGreaterOrEqual && v >= value || packet.Tag == goldap.FilterLessOrEqual && v <= value || v == value {
+				return true, nil
+			}
+		}
+		return false, nil
+	case goldap.FilterSubstrings:
+		vs, _ := user.attribute(ber.DecodeString(packet.Children[0].Data.Bytes()))
+		for _, v := range vs {
+			if matchSubstrings(strings.ToLower(v), packet.Children[1].Children) {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("unsupported filter: %s", goldap.FilterMap[uint64(packet.Tag)])
+	}
+}
+
+func matchSubstrings(value string, parts []*ber.Packet) bool {
+	for _, part := range parts {
+		s := strings.ToLower(ber.DecodeString(part.Data.Bytes()))
+
+		switch part.Tag {
+		case goldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(value, s) {
+				return false
+			}
+			value = value[len(s):]
+		case goldap.FilterSubstringsFinal:
+			if !strings.HasSuffix(value, s) {
+				return false
+			}
+			value = value[:len(value)-len(s)]
+		default:
+			idx := strings.Index(value, s)
+			if idx < 0 {
+				return false
+			}
+			value = value[idx+len(s):]
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package userfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// usernameAttributes are attributes to use as username of LDIF entry, in priority order.
+var usernameAttributes = []string{"sAMAccountName", "uid", "cn"}
+
+type ldifEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+func (e ldifEntry) get(name string) []string {
+	vs, _ := User{Attributes: e.Attributes}.attribute(name)
+	return vs
+}
+
+// parseLDIF reads entries of LDIF.
+//
+// Entries that have userPassword are users, and entries that have member or uniqueMember are groups.
+// memberOf of users is made from the groups.
+func parseLDIF(raw []byte) (Users, error) {
+	entries, err := readLDIF(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(Users)
+	usernames := make(map[string]string) // lower DN -> username
+
+	for _, e := range entries {
+		passwords := e.get("userPassword")
+		if len(passwords) == 0 {
+			continue
+		}
+
+		username := ""
+		for _, attr := range usernameAttributes {
+			if vs := e.get(attr); len(vs) > 0 {
+				username = vs[0]
+				break
+			}
+		}
+		if username == "" {
+			return nil, fmt.Errorf("%s: no username attribute", e.DN)
+		}
+		if _, ok := users[username]; ok {
+			return nil, fmt.Errorf("%s: username %s is duplicated", e.DN, username)
+		}
+
+		attrs := make(map[string][]string)
+		for k, vs := range e.Attributes {
+			if !strings.EqualFold(k, "userPassword") {
+				attrs[k] = vs
+			}
+		}
+
+		users[username] = User{
+			Password:   strings.TrimPrefix(passwords[0], "{CRYPT}"),
+			Attributes: attrs,
+		}
+		usernames[strings.ToLower(e.DN)] = username
+	}
+
+	for _, e := range entries {
+		for _, member := range append(e.get("member"), e.get("uniqueMember")...) {
+			username, ok := usernames[strings.ToLower(member)]
+			if !ok {
+				continue
+			}
+			user := users[username]
+			user.Attributes["memberOf"] = append(user.Attributes["memberOf"], e.DN)
+			users[username] = user
+		}
+	}
+
+	return users, nil
+}
+
+func readLDIF(raw []byte) ([]ldifEntry, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, " ") && len(lines) > 0 && lines[len(lines)-1] != "" {
+			lines[len(lines)-1] += line[1:]
+		} else {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	lines = append(lines, "")
+
+	var entries []ldifEntry
+	var current *ldifEntry
+
+	for i, line := range lines {
+		if line == "" {
+			if current != nil {
+				entries = append(entries, *current)
+				current = nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		idx := strings.Index(line, ":")
+		if idx <= 0 {
+			return nil, fmt.Errorf("line %d: invalid format", i+1)
+		}
+		name := line[:idx]
+		value := line[idx+1:]
+
+		switch {
+		case strings.HasPrefix(value, ":"):
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			value = string(decoded)
+		case strings.HasPrefix(value, "<"):
+			return nil, fmt.Errorf("line %d: URL value is not supported", i+1)
+		default:
+			value = strings.TrimLeft(value, " ")
+		}
+
+		if current == nil {
+			if strings.EqualFold(name, "version") {
+				continue
+			}
+			if !strings.EqualFold(name, "dn") {
+				return nil, fmt.Errorf("line %d: entry must start with dn", i+1)
+			}
+			current = &ldifEntry{DN: value, Attributes: make(map[string][]string)}
+			continue
+		}
+
+		if strings.EqualFold(name, "changetype") {
+			if !strings.EqualFold(value, "add") {
+				return nil, fmt.Errorf("line %d: only add is supported as changetype", i+1)
+			}
+			continue
+		}
+		current.Attributes[name] = append(current.Attributes[name], value)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,365 @@
+// Package userfile is a user directory that reads users from a file, for sites that don't have an LDAP server.
+package userfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/macrat/lauth/ldap"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pelletier/go-toml"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	IncorrectPasswordError = fmt.Errorf("incorrect password")
+)
+
+// DefaultCheckInterval is the minimum interval to check the file was changed.
+const DefaultCheckInterval = time.Second
+
+// dummyHash is used to take the same time as the existing user when the user was not found.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// User is a user in the file.
+type User struct {
+	// Password is the bcrypt hash of the password.
+	Password string `mapstructure:"password"`
+
+	// Subject is the stable identifier of the user. The username is used if empty.
+	Subject string `mapstructure:"subject"`
+
+	// Attributes are the same as LDAP attributes, like displayName, mail or memberOf.
+	Attributes map[string][]string `mapstructure:"attributes"`
+}
+
+// Users is a set of users keyed by username.
+type Users map[string]User
+
+// Parse parses and validates users file. The format is decided by extension of the name; see Decode.
+func Parse(name string, raw []byte) (Users, error) {
+	users, err := Decode(name, raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := users.Validate(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Decode parses users file without validation. The format is decided by extension of the name.
+//
+// ".toml", ".yaml", ".yml" and ".json" are a map of User under "users" key.
+// ".ldif" is LDIF that entries with userPassword are users.
+// Otherwise, it is htpasswd style file.
+func Decode(name string, raw []byte) (Users, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".toml", ".yaml", ".yml", ".json":
+		return parseStructured(filepath.Ext(name), raw)
+	case ".ldif":
+		return parseLDIF(raw)
+	default:
+		return parseHtpasswd(raw)
+	}
+}
+
+// Validate checks that all passwords are bcrypt hash, and subjects are unique.
+func (u Users) Validate() error {
+	subjects := make(map[string]string)
+	for username, user := range u {
+		if username == "" {
+			return fmt.Errorf("username can't be empty")
+		}
+		if _, err := bcrypt.Cost([]byte(user.Password)); err != nil {
+			return fmt.Errorf("%s: password must be a bcrypt hash: %w", username, err)
+		}
+
+		subject := user.subject(username)
+		if other, ok := subjects[subject]; ok {
+			return fmt.Errorf("%s: subject is the same as %s", username, other)
+		}
+		subjects[subject] = username
+	}
+	return nil
+}
+
+// HashPasswords replaces plain text passwords with bcrypt hash.
+func (u Users) HashPasswords(cost int) error {
+	for username, user := range u {
+		if _, err := bcrypt.Cost([]byte(user.Password)); err == nil {
+			continue
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), cost)
+		if err != nil {
+			return fmt.Errorf("%s: %w", username, err)
+		}
+		user.Password = string(hash)
+		u[username] = user
+	}
+	return nil
+}
+
+func parseStructured(ext string, raw []byte) (Users, error) {
+	var data map[string]interface{}
+
+	switch strings.ToLower(ext) {
+	case ".toml":
+		tree, err := toml.LoadBytes(raw)
+		if err != nil {
+			return nil, err
+		}
+		data = tree.ToMap()
+	case ".json":
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return nil, err
+		}
+	default:
+		if err := yaml.Unmarshal(raw, &data); err != nil {
+			return nil, err
+		}
+	}
+
+	var file struct {
+		Users Users `mapstructure:"users"`
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		ErrorUnused:      true,
+		Result:           &file,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.Decode(data); err != nil {
+		return nil, err
+	}
+
+	return file.Users, nil
+}
+
+func parseHtpasswd(raw []byte) (Users, error) {
+	users := make(Users)
+
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		xs := strings.SplitN(line, ":", 2)
+		if len(xs) != 2 {
+			return nil, fmt.Errorf("line %d: invalid format", lineNum)
+		}
+		users[xs[0]] = User{Password: xs[1]}
+	}
+
+	return users, scanner.Err()
+}
+
+func (u User) subject(username string) string {
+	if u.Subject != "" {
+		return u.Subject
+	}
+	return username
+}
+
+// attribute returns values of the attribute. The name is case-insensitive like LDAP.
+func (u User) attribute(name string) ([]string, bool) {
+	if vs, ok := u.Attributes[name]; ok {
+		return vs, true
+	}
+	for k, vs := range u.Attributes {
+		if strings.EqualFold(k, name) {
+			return vs, true
+		}
+	}
+	return nil, false
+}
+
+// Connector is a ldap.Connector that reads users from a file.
+//
+// The file is loaded again when it was changed.
+type Connector struct {
+	Path string
+
+	// CheckInterval is the minimum interval to check the file was changed. DefaultCheckInterval is used if 0.
+	CheckInterval time.Duration
+
+	mu        sync.Mutex
+	users     Users
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+// New makes a Connector and loads the file.
+func New(path string) (*Connector, error) {
+	c := &Connector{Path: path}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Connector) load() error {
+	stat, err := os.Stat(c.Path)
+	if err != nil {
+		return err
+	}
+
+	raw, err := os.ReadFile(c.Path)
+	if err != nil {
+		return err
+	}
+
+	users, err := Parse(c.Path, raw)
+	if err != nil {
+		return err
+	}
+
+	c.users = users
+	c.modTime = stat.ModTime()
+	c.size = stat.Size()
+	return nil
+}
+
+// reload loads the file again if it was changed.
+// The previous users are kept if failed to load, so that a broken file doesn't lock out everyone.
+func (c *Connector) reload() {
+	interval := c.CheckInterval
+	if interval == 0 {
+		interval = DefaultCheckInterval
+	}
+	if time.Since(c.checkedAt) < interval {
+		return
+	}
+	c.checkedAt = time.Now()
+
+	stat, err := os.Stat(c.Path)
+	if err != nil {
+		log.Error().Err(err).Str("path", c.Path).Msg("failed to check users file")
+		return
+	}
+	if stat.ModTime().Equal(c.modTime) && stat.Size() == c.size {
+		return
+	}
+
+	if err := c.load(); err != nil {
+		log.Error().Err(err).Str("path", c.Path).Msg("failed to reload users file")
+		return
+	}
+	log.Info().Str("path", c.Path).Int("users", len(c.users)).Msg("reloaded users file")
+}
+
+func (c *Connector) Connect() (ldap.Session, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reload()
+	return c.users.Session(), nil
+}
+
+// Connect makes a Session of the users, so Users can be used as a static ldap.Connector.
+func (u Users) Connect() (ldap.Session, error) {
+	return u.Session(), nil
+}
+
+// Session makes a Session of the users.
+func (u Users) Session() Session {
+	return Session{users: u}
+}
+
+// Session is a snapshot of users file.
+type Session struct {
+	users Users
+}
+
+func (s Session) Close() error {
+	return nil
+}
+
+func (s Session) LoginTest(username, password string) (ldap.Identity, error) {
+	username = strings.TrimSpace(username)
+
+	user, ok := s.users[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return ldap.Identity{}, ldap.UserNotFoundError
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return ldap.Identity{}, IncorrectPasswordError
+	}
+
+	return ldap.Identity{
+		Subject:  user.subject(username),
+		Username: username,
+	}, nil
+}
+
+func (s Session) findBySubject(subject string) (User, bool) {
+	for username, user := range s.users {
+		if user.subject(username) == subject {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// FindUser searches the user that has the value in the attribute. Values are compared case-insensitively.
+func (s Session) FindUser(attribute, value string) (ldap.Identity, error) {
+	var found []ldap.Identity
+	for username, user := range s.users {
+		vs, _ := user.attribute(attribute)
+		for _, v := range vs {
+			if strings.EqualFold(v, value) {
+				found = append(found, ldap.Identity{Subject: user.subject(username), Username: username})
+				break
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return ldap.Identity{}, ldap.UserNotFoundError
+	case 1:
+		return found[0], nil
+	default:
+		return ldap.Identity{}, ldap.MultipleUsersFoundError
+	}
+}
+
+func (s Session) GetUserAttributes(subject string, attributes []string) (map[string][]string, error) {
+	user, ok := s.findBySubject(subject)
+	if !ok {
+		return nil, ldap.UserNotFoundError
+	}
+
+	result := make(map[string][]string)
+	for _, attr := range attributes {
+		if vs, ok := user.attribute(attr); ok {
+			result[attr] = vs
+		}
+	}
+	return result, nil
+}
+
+func (s Session) MatchFilter(subject, filter string) (bool, error) {
+	user, ok := s.findBySubject(subject)
+	if !ok {
+		return false, ldap.UserNotFoundError
+	}
+	return MatchFilter(user, filter)
+}
@@ -0,0 +1,320 @@
+package userfile_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/ldap"
+	"github.com/macrat/lauth/userfile"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func hash(t *testing.T, password string) string {
+	t.Helper()
+
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %s", err)
+	}
+	return string(h)
+}
+
+func TestParse(t *testing.T) {
+	h := hash(t, "foobar")
+
+	tests := []struct {
+		Name string
+		Raw  string
+	}{
+		{"users.toml", `
+[users.macrat]
+password = "` + h + `"
+subject = "1001"
+
+[users.macrat.attributes]
+displayName = "SHIDA Yuuma"
+memberOf = ["CN=admin,OU=group,DC=example,DC=local", "CN=staff,OU=group,DC=example,DC=local"]
+`},
+		{"users.yaml", `
+users:
+  macrat:
+    password: "` + h + `"
+    subject: "1001"
+    attributes:
+      displayName: SHIDA Yuuma
+      memberOf:
+        - CN=admin,OU=group,DC=example,DC=local
+        - CN=staff,OU=group,DC=example,DC=local
+`},
+		{"users.json", `{"users": {"macrat": {
+			"password": "` + h + `",
+			"subject": "1001",
+			"attributes": {
+				"displayName": "SHIDA Yuuma",
+				"memberOf": ["CN=admin,OU=group,DC=example,DC=local", "CN=staff,OU=group,DC=example,DC=local"]
+			}
+		}}}`},
+	}
+
+	expect := userfile.Users{
+		"macrat": userfile.User{
+			Password: h,
+			Subject:  "1001",
+			Attributes: map[string][]string{
+				"displayName": {"SHIDA Yuuma"},
+				"memberOf":    {"CN=admin,OU=group,DC=example,DC=local", "CN=staff,OU=group,DC=example,DC=local"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			users, err := userfile.Parse(tt.Name, []byte(tt.Raw))
+			if err != nil {
+				t.Fatalf("failed to parse: %s", err)
+			}
+			if !reflect.DeepEqual(users, expect) {
+				t.Errorf("unexpected users:\nexpected: %#v\n but got: %#v", expect, users)
+			}
+		})
+	}
+}
+
+func TestParse_htpasswd(t *testing.T) {
+	h1 := hash(t, "foobar")
+	h2 := strings.Replace(hash(t, "hello"), "$2a$", "$2y$", 1)
+
+	users, err := userfile.Parse(".htpasswd", []byte("# comment\nmacrat:"+h1+"\n\nj.smith:"+h2+"\n"))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+
+	expect := userfile.Users{
+		"macrat":  userfile.User{Password: h1},
+		"j.smith": userfile.User{Password: h2},
+	}
+	if !reflect.DeepEqual(users, expect) {
+		t.Errorf("unexpected users: %#v", users)
+	}
+
+	if _, err := (userfile.Users{"j.smith": users["j.smith"]}).Session().LoginTest("j.smith", "hello"); err != nil {
+		t.Errorf("failed to login with $2y$ hash: %s", err)
+	}
+}
+
+func TestParse_invalid(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Raw   string
+		Error string
+	}{
+		{"users.htpasswd", "macrat:{SHA}AAAA", "password must be a bcrypt hash"},
+		{"users.htpasswd", "macrat", "line 1: invalid format"},
+		{"users.toml", "[users.macrat]\npassword = \"plain\"", "password must be a bcrypt hash"},
+		{"users.toml", "[users.macrat]\npasswd = \"plain\"", "invalid keys"},
+		{"users.yaml", "users:\n  a:\n    password: \"" + hash(t, "a") + "\"\n    subject: x\n  b:\n    password: \"" + hash(t, "b") + "\"\n    subject: x\n", "subject is the same as"},
+	}
+
+	for _, tt := range tests {
+		if _, err := userfile.Parse(tt.Name, []byte(tt.Raw)); err == nil || !strings.Contains(err.Error(), tt.Error) {
+			t.Errorf("%#v: expected error %#v but got %v", tt.Raw, tt.Error, err)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	s := userfile.Users{
+		"macrat": userfile.User{
+			Password: hash(t, "foobar"),
+			Subject:  "1001",
+			Attributes: map[string][]string{
+				"displayName": {"SHIDA Yuuma"},
+				"mail":        {"m@crat.jp"},
+				"memberOf":    {"CN=admin,OU=group,DC=example,DC=local"},
+			},
+		},
+		"j.smith": userfile.User{
+			Password: hash(t, "hello"),
+		},
+	}.Session()
+
+	if id, err := s.LoginTest(" macrat ", "foobar"); err != nil || id != (ldap.Identity{Subject: "1001", Username: "macrat"}) {
+		t.Errorf("failed to login: %#v %v", id, err)
+	}
+	if id, err := s.LoginTest("j.smith", "hello"); err != nil || id.Subject != "j.smith" {
+		t.Errorf("failed to login: %#v %v", id, err)
+	}
+	if _, err := s.LoginTest("macrat", "hello"); !errors.Is(err, userfile.IncorrectPasswordError) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := s.LoginTest("nobody", "foobar"); !errors.Is(err, ldap.UserNotFoundError) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	attrs, err := s.GetUserAttributes("1001", []string{"displayname", "mail", "sn"})
+	if err != nil {
+		t.Fatalf("failed to get attributes: %s", err)
+	}
+	expect := map[string][]string{"displayname": {"SHIDA Yuuma"}, "mail": {"m@crat.jp"}}
+	if !reflect.DeepEqual(attrs, expect) {
+		t.Errorf("unexpected attributes: %#v", attrs)
+	}
+	if _, err := s.GetUserAttributes("macrat", nil); !errors.Is(err, ldap.UserNotFoundError) {
+		t.Errorf("user should be searched by subject: %v", err)
+	}
+
+	filters := map[string]bool{
+		"(memberOf=cn=admin,ou=group,dc=example,dc=local)":                    true,
+		"(|(memberOf=CN=staff,OU=group,DC=example,DC=local)(mail=m@crat.jp))": true,
+		"(&(mail=*)(!(displayName=SHIDA*)))":                                  false,
+		"(mail=*@crat.jp)":                                                    true,
+		"(mail=m*@*.jp)":                                                      true,
+		"(mail=*@example.com)":                                                false,
+		"(sn=*)":                                                              false,
+	}
+	for filter, expect := range filters {
+		if ok, err := s.MatchFilter("1001", filter); err != nil || ok != expect {
+			t.Errorf("%s: expected %v but got %v (%v)", filter, expect, ok, err)
+		}
+	}
+}
+
+func TestConnector_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.toml")
+	write := func(username string) {
+		raw := "[users.\"" + username + "\"]\npassword = \"" + hash(t, "foobar") + "\"\n"
+		if err := os.WriteFile(path, []byte(raw), 0600); err != nil {
+			t.Fatalf("failed to write users file: %s", err)
+		}
+	}
+
+	write("macrat")
+	c, err := userfile.New(path)
+	if err != nil {
+		t.Fatalf("failed to load users file: %s", err)
+	}
+	c.CheckInterval = time.Millisecond
+
+	login := func(username string) error {
+		s, err := c.Connect()
+		if err != nil {
+			t.Fatalf("failed to connect: %s", err)
+		}
+		defer s.Close()
+		_, err = s.LoginTest(username, "foobar")
+		return err
+	}
+
+	if err := login("macrat"); err != nil {
+		t.Errorf("failed to login: %s", err)
+	}
+
+	write("j.smith")
+	time.Sleep(10 * time.Millisecond)
+	if err := login("j.smith"); err != nil {
+		t.Errorf("failed to login after reload: %s", err)
+	}
+	if err := login("macrat"); !errors.Is(err, ldap.UserNotFoundError) {
+		t.Errorf("removed user could login: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("[broken"), 0600); err != nil {
+		t.Fatalf("failed to write users file: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := login("j.smith"); err != nil {
+		t.Errorf("previous users should be kept if the file is broken: %s", err)
+	}
+}
+
+func TestDecode_ldif(t *testing.T) {
+	raw := `version: 1
+
+dn: CN=macrat,OU=user,DC=example,DC=local
+objectClass: person
+sAMAccountName: macrat
+displayName: SHIDA Yuuma
+description: a long
+  description
+userPassword: {CRYPT}` + hash(t, "foobar") + `
+
+# j.smith has base64 value.
+dn: uid=j.smith,OU=user,DC=example,DC=local
+uid: j.smith
+displayName:: Sm9obiBTbWl0aA==
+userPassword: hello
+
+dn: CN=admin,OU=group,DC=example,DC=local
+changetype: add
+objectClass: groupOfNames
+member: cn=macrat,ou=user,dc=example,dc=local
+member: uid=j.smith,OU=user,DC=example,DC=local
+
+dn: OU=user,DC=example,DC=local
+objectClass: organizationalUnit
+`
+
+	users, err := userfile.Decode("initial.ldif", []byte(raw))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("unexpected users: %#v", users)
+	}
+
+	macrat := users["macrat"].Attributes
+	if !reflect.DeepEqual(macrat["description"], []string{"a long description"}) {
+		t.Errorf("unexpected description: %#v", macrat["description"])
+	}
+	if _, ok := macrat["userPassword"]; ok {
+		t.Errorf("userPassword should not be included in attributes")
+	}
+	if !reflect.DeepEqual(macrat["memberOf"], []string{"CN=admin,OU=group,DC=example,DC=local"}) {
+		t.Errorf("unexpected memberOf: %#v", macrat["memberOf"])
+	}
+	if !reflect.DeepEqual(users["j.smith"].Attributes["displayName"], []string{"John Smith"}) {
+		t.Errorf("unexpected displayName: %#v", users["j.smith"].Attributes["displayName"])
+	}
+
+	if err := users.Validate(); err == nil {
+		t.Errorf("plain text password should be invalid before hashing")
+	}
+	if err := users.HashPasswords(bcrypt.MinCost); err != nil {
+		t.Fatalf("failed to hash passwords: %s", err)
+	}
+	if err := users.Validate(); err != nil {
+		t.Fatalf("failed to validate: %s", err)
+	}
+
+	s := users.Session()
+	for username, password := range map[string]string{"macrat": "foobar", "j.smith": "hello"} {
+		if _, err := s.LoginTest(username, password); err != nil {
+			t.Errorf("%s: failed to login: %s", username, err)
+		}
+	}
+}
+
+func TestDecode_ldifInvalid(t *testing.T) {
+	tests := []struct {
+		Raw   string
+		Error string
+	}{
+		{"cn: foo", "line 1: entry must start with dn"},
+		{"dn: cn=foo\nfoo", "line 2: invalid format"},
+		{"dn: cn=foo\ncn:< file:///etc/passwd", "line 2: URL value is not supported"},
+		{"dn: cn=foo\nchangetype: delete", "line 2: only add is supported"},
+		{"dn: cn=foo\nuserPassword: x", "cn=foo: no username attribute"},
+		{"dn: cn=a,dc=x\nuid: foo\nuserPassword: x\n\ndn: cn=b,dc=x\nuid: foo\nuserPassword: x", "username foo is duplicated"},
+	}
+
+	for _, tt := range tests {
+		if _, err := userfile.Decode("users.ldif", []byte(tt.Raw)); err == nil || !strings.Contains(err.Error(), tt.Error) {
+			t.Errorf("%#v: expected error %#v but got %v", tt.Raw, tt.Error, err)
+		}
+	}
+}
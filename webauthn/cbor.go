@@ -0,0 +1,125 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	InvalidCBORError = errors.New("invalid CBOR")
+)
+
+// decodeCBOR decodes the first CBOR item in data, and returns the item and the rest of data.
+//
+// It supports only definite length items that used in WebAuthn.
+// Integers are decoded as int64, byte strings as []byte, text strings as string, arrays as []interface{}, and maps as map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if len(data) == 0 || depth > 16 {
+		return nil, nil, InvalidCBORError
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	if major == 7 {
+		return decodeCBORSimple(info, data)
+	}
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24 && len(data) >= 1:
+		arg, data = uint64(data[0]), data[1:]
+	case info == 25 && len(data) >= 2:
+		arg, data = uint64(binary.BigEndian.Uint16(data)), data[2:]
+	case info == 26 && len(data) >= 4:
+		arg, data = uint64(binary.BigEndian.Uint32(data)), data[4:]
+	case info == 27 && len(data) >= 8:
+		arg, data = binary.BigEndian.Uint64(data), data[8:]
+	default:
+		return nil, nil, InvalidCBORError
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, InvalidCBORError
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, InvalidCBORError
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, InvalidCBORError
+		}
+		if major == 2 {
+			return append([]byte{}, data[:arg]...), data[arg:], nil
+		}
+		return string(data[:arg]), data[arg:], nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, nil, InvalidCBORError
+		}
+		items := make([]interface{}, arg)
+		for i := range items {
+			var err error
+			if items[i], data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, InvalidCBORError
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			var err error
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, InvalidCBORError
+			}
+			if value, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			m[key] = value
+		}
+		return m, data, nil
+	case 6:
+		// ignore tags, and returns the tagged item.
+		return decodeCBORItem(data, depth+1)
+	}
+
+	return nil, nil, InvalidCBORError
+}
+
+func decodeCBORSimple(info byte, data []byte) (interface{}, []byte, error) {
+	switch {
+	case info == 20:
+		return false, data, nil
+	case info == 21:
+		return true, data, nil
+	case info == 22 || info == 23:
+		return nil, data, nil
+	case info == 26 && len(data) >= 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+	}
+	return nil, nil, InvalidCBORError
+}
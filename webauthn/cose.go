@@ -0,0 +1,119 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers that supported.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var (
+	UnsupportedKeyError   = errors.New("unsupported public key")
+	InvalidSignatureError = errors.New("invalid signature")
+
+	// SupportedAlgorithms is the list of algorithms for pubKeyCredParams, in order of preference.
+	SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+)
+
+// PublicKey is a credential public key in COSE_Key format.
+type PublicKey struct {
+	Algorithm int
+	Key       crypto.PublicKey
+}
+
+func coseInt(m map[interface{}]interface{}, key int64) (int64, bool) {
+	v, ok := m[key].(int64)
+	return v, ok
+}
+
+func coseBytes(m map[interface{}]interface{}, key int64) ([]byte, bool) {
+	v, ok := m[key].([]byte)
+	return v, ok && len(v) > 0
+}
+
+// ParsePublicKey decodes COSE_Key that encoded in CBOR.
+func ParsePublicKey(raw []byte) (PublicKey, error) {
+	item, _, err := decodeCBOR(raw)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return PublicKey{}, UnsupportedKeyError
+	}
+
+	kty, _ := coseInt(m, 1)
+	alg, _ := coseInt(m, 3)
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := coseInt(m, -1)
+		x, okX := coseBytes(m, -2)
+		y, okY := coseBytes(m, -3)
+		if crv != 1 || !okX || !okY {
+			return PublicKey{}, UnsupportedKeyError
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return PublicKey{}, UnsupportedKeyError
+		}
+		return PublicKey{Algorithm: AlgES256, Key: key}, nil
+
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := coseInt(m, -1)
+		x, ok := coseBytes(m, -2)
+		if crv != 6 || !ok || len(x) != ed25519.PublicKeySize {
+			return PublicKey{}, UnsupportedKeyError
+		}
+		return PublicKey{Algorithm: AlgEdDSA, Key: ed25519.PublicKey(x)}, nil
+
+	case kty == 3 && alg == AlgRS256:
+		n, okN := coseBytes(m, -1)
+		e, okE := coseBytes(m, -2)
+		if !okN || !okE || len(e) > 4 {
+			return PublicKey{}, UnsupportedKeyError
+		}
+		return PublicKey{Algorithm: AlgRS256, Key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	}
+
+	return PublicKey{}, UnsupportedKeyError
+}
+
+// Verify checks the signature of data.
+func (k PublicKey) Verify(data, signature []byte) error {
+	switch key := k.Key.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(data)
+		if ecdsa.VerifyASN1(key, hash[:], signature) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, data, signature) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(data)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil {
+			return nil
+		}
+	}
+	return InvalidSignatureError
+}
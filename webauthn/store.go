@@ -0,0 +1,155 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/macrat/lauth/store"
+)
+
+const (
+	CREDENTIAL_BUCKET    = "webauthn_credential"
+	CREDENTIAL_ID_BUCKET = "webauthn_credential_id"
+	CHALLENGE_BUCKET     = "webauthn_challenge"
+)
+
+var (
+	CredentialNotFoundError   = errors.New("credential was not found")
+	ChallengeUsedError        = errors.New("challenge was already used")
+	CredentialRegisteredError = errors.New("credential is already registered by another user")
+)
+
+// CredentialStore keeps credentials of each user.
+type CredentialStore interface {
+	// Credentials returns all credentials of the user.
+	Credentials(subject string) ([]Credential, error)
+
+	// Save adds the credential to the user, or updates it if already registered.
+	// It returns CredentialRegisteredError if another user has the credential, or ClonedAuthenticatorError if the signature counter is not increased.
+	Save(subject string, cred Credential) error
+
+	// Find returns the user and the credential of the ID, or CredentialNotFoundError.
+	Find(id []byte) (string, Credential, error)
+
+	// DeleteAll removes all credentials of the user, and returns count of removed credentials.
+	DeleteAll(subject string) (int, error)
+}
+
+// NewStore makes CredentialStore on the key-value storage.
+func NewStore(kv store.Store) CredentialStore {
+	return KVStore{Store: kv}
+}
+
+// KVStore is a CredentialStore on the key-value storage.
+type KVStore struct {
+	Store store.Store
+}
+
+func (s KVStore) Credentials(subject string) ([]Credential, error) {
+	raw, err := s.Store.Get(CREDENTIAL_BUCKET, subject)
+	if err == store.KeyNotFoundError {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var creds []Credential
+	err = json.Unmarshal(raw, &creds)
+	return creds, err
+}
+
+// Save claims the credential ID for the user, and adds or updates the credential atomically.
+//
+// It returns CredentialRegisteredError if another user owns the ID,
+// or ClonedAuthenticatorError if the signature counter is not increased from the saved one, like a parallel assertion already used the counter.
+func (s KVStore) Save(subject string, cred Credential) error {
+	err := s.Store.Update(CREDENTIAL_ID_BUCKET, cred.ID.String(), func(owner []byte) ([]byte, time.Duration, error) {
+		if owner != nil && string(owner) != subject {
+			return nil, 0, CredentialRegisteredError
+		}
+		return []byte(subject), 0, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Update(CREDENTIAL_BUCKET, subject, func(raw []byte) ([]byte, time.Duration, error) {
+		var creds []Credential
+		if raw != nil {
+			if err := json.Unmarshal(raw, &creds); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		found := false
+		for i, c := range creds {
+			if bytes.Equal(c.ID, cred.ID) {
+				if (cred.SignCount != 0 || c.SignCount != 0) && cred.SignCount <= c.SignCount {
+					return nil, 0, ClonedAuthenticatorError
+				}
+				creds[i] = cred
+				found = true
+			}
+		}
+		if !found {
+			creds = append(creds, cred)
+		}
+
+		raw, err := json.Marshal(creds)
+		return raw, 0, err
+	})
+}
+
+func (s KVStore) Find(id []byte) (string, Credential, error) {
+	subject, err := s.Store.Get(CREDENTIAL_ID_BUCKET, Base64URL(id).String())
+	if err == store.KeyNotFoundError {
+		return "", Credential{}, CredentialNotFoundError
+	} else if err != nil {
+		return "", Credential{}, err
+	}
+
+	creds, err := s.Credentials(string(subject))
+	if err != nil {
+		return "", Credential{}, err
+	}
+	for _, c := range creds {
+		if bytes.Equal(c.ID, id) {
+			return string(subject), c, nil
+		}
+	}
+	return "", Credential{}, CredentialNotFoundError
+}
+
+func (s KVStore) DeleteAll(subject string) (int, error) {
+	creds, err := s.Credentials(subject)
+	if err != nil || len(creds) == 0 {
+		return 0, err
+	}
+
+	for _, c := range creds {
+		if err := s.Store.Delete(CREDENTIAL_ID_BUCKET, c.ID.String()); err != nil && err != store.KeyNotFoundError {
+			return 0, err
+		}
+	}
+	if err := s.Store.Delete(CREDENTIAL_BUCKET, subject); err != nil {
+		return 0, err
+	}
+	return len(creds), nil
+}
+
+// UseChallenge records the challenge was used, and reports it was not used before.
+// A response of authenticator can't be used twice, because the challenge is rejected after used.
+func UseChallenge(kv store.Store, challenge string, ttl time.Duration) (bool, error) {
+	err := kv.Update(CHALLENGE_BUCKET, challenge, func(value []byte) ([]byte, time.Duration, error) {
+		if value != nil {
+			return nil, 0, ChallengeUsedError
+		}
+		return []byte{1}, ttl, nil
+	})
+	if err == ChallengeUsedError {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,352 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Flags of authenticator data.
+const (
+	FlagUserPresent            = 0x01
+	FlagUserVerified           = 0x04
+	FlagAttestedCredentialData = 0x40
+)
+
+// Values of userVerification option.
+const (
+	UserVerificationRequired    = "required"
+	UserVerificationPreferred   = "preferred"
+	UserVerificationDiscouraged = "discouraged"
+)
+
+var (
+	InvalidResponseError       = errors.New("invalid response of authenticator")
+	ChallengeMismatchError     = errors.New("challenge is mismatch")
+	OriginMismatchError        = errors.New("origin is mismatch")
+	RPIDMismatchError          = errors.New("relying party ID is mismatch")
+	UserNotPresentError        = errors.New("user is not present")
+	UserNotVerifiedError       = errors.New("user is not verified")
+	CredentialMismatchError    = errors.New("credential is mismatch")
+	ClonedAuthenticatorError   = errors.New("signature counter is not increased; the authenticator may be cloned")
+	MissingCredentialDataError = errors.New("authenticator data has no credential")
+)
+
+// Base64URL is binary data that encoded in base64url without padding in JSON.
+type Base64URL []byte
+
+func (b Base64URL) String() string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s *string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == nil {
+		*b = nil
+		return nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*s, "="))
+	if err != nil {
+		return err
+	}
+	*b = raw
+	return nil
+}
+
+// NewChallenge makes a random challenge that encoded in base64url.
+func NewChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// UserHandle returns the user handle for the subject.
+// It is a hash of the subject, so that the authenticator can't know the subject.
+func UserHandle(subject string) []byte {
+	h := sha256.Sum256([]byte(subject))
+	return h[:]
+}
+
+// Credential is a public key credential that registered by a user.
+type Credential struct {
+	ID         Base64URL `json:"id"`
+	PublicKey  Base64URL `json:"public_key,omitempty"`
+	SignCount  uint32    `json:"sign_count"`
+	Username   string    `json:"username,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+}
+
+type CredentialDescriptor struct {
+	Type string    `json:"type"`
+	ID   Base64URL `json:"id"`
+}
+
+// Descriptors makes a list of credential descriptors for allowCredentials or excludeCredentials.
+func Descriptors(creds []Credential) []CredentialDescriptor {
+	ds := make([]CredentialDescriptor, len(creds))
+	for i, c := range creds {
+		ds[i] = CredentialDescriptor{Type: "public-key", ID: c.ID}
+	}
+	return ds
+}
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type User struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey,omitempty"`
+	UserVerification string `json:"userVerification,omitempty"`
+}
+
+// CreationOptions is the publicKey option of navigator.credentials.create().
+type CreationOptions struct {
+	RP                     RelyingParty           `json:"rp"`
+	User                   User                   `json:"user"`
+	Challenge              Base64URL              `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is the publicKey option of navigator.credentials.get().
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification,omitempty"`
+}
+
+// RegistrationResponse is the result of navigator.credentials.create() that encoded in JSON.
+type RegistrationResponse struct {
+	ID       Base64URL `json:"id"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AttestationObject Base64URL `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the result of navigator.credentials.get() that encoded in JSON.
+type AssertionResponse struct {
+	ID       Base64URL `json:"id"`
+	Type     string    `json:"type"`
+	Response struct {
+		ClientDataJSON    Base64URL `json:"clientDataJSON"`
+		AuthenticatorData Base64URL `json:"authenticatorData"`
+		Signature         Base64URL `json:"signature"`
+		UserHandle        Base64URL `json:"userHandle"`
+	} `json:"response"`
+}
+
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin,omitempty"`
+}
+
+type AuthenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// ParseAuthenticatorData decodes authenticator data.
+func ParseAuthenticatorData(raw []byte) (AuthenticatorData, error) {
+	if len(raw) < 37 {
+		return AuthenticatorData{}, InvalidResponseError
+	}
+
+	d := AuthenticatorData{
+		RPIDHash:  raw[:32],
+		Flags:     raw[32],
+		SignCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	if d.Flags&FlagAttestedCredentialData != 0 {
+		rest := raw[37:]
+		if len(rest) < 18 {
+			return AuthenticatorData{}, InvalidResponseError
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return AuthenticatorData{}, InvalidResponseError
+		}
+		d.CredentialID, rest = rest[:idLen], rest[idLen:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return AuthenticatorData{}, InvalidResponseError
+		}
+		d.PublicKey = rest[:len(rest)-len(after)]
+	}
+
+	return d, nil
+}
+
+// Ceremony is the expectation for a response of authenticator.
+type Ceremony struct {
+	RPID             string
+	Origin           string
+	Challenge        string
+	UserVerification string
+}
+
+func (c Ceremony) verifyClientData(raw []byte, typ string) error {
+	var data ClientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return InvalidResponseError
+	}
+
+	if data.Type != typ {
+		return InvalidResponseError
+	}
+	if c.Challenge == "" || subtle.ConstantTimeCompare([]byte(data.Challenge), []byte(c.Challenge)) != 1 {
+		return ChallengeMismatchError
+	}
+	if data.Origin != c.Origin || data.CrossOrigin {
+		return OriginMismatchError
+	}
+	return nil
+}
+
+func (c Ceremony) verifyAuthenticatorData(d AuthenticatorData) (verified bool, err error) {
+	hash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(d.RPIDHash, hash[:]) {
+		return false, RPIDMismatchError
+	}
+	if d.Flags&FlagUserPresent == 0 {
+		return false, UserNotPresentError
+	}
+
+	verified = d.Flags&FlagUserVerified != 0
+	if c.UserVerification == UserVerificationRequired && !verified {
+		return false, UserNotVerifiedError
+	}
+	return verified, nil
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create(), and returns the new credential.
+//
+// Attestation statements are not verified, so any authenticator can be registered.
+// It also reports the user was verified by the authenticator.
+func (c Ceremony) VerifyRegistration(resp RegistrationResponse) (Credential, bool, error) {
+	if resp.Type != "public-key" {
+		return Credential{}, false, InvalidResponseError
+	}
+	if err := c.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create"); err != nil {
+		return Credential{}, false, err
+	}
+
+	item, _, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, false, InvalidResponseError
+	}
+	obj, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return Credential{}, false, InvalidResponseError
+	}
+	rawAuthData, ok := obj["authData"].([]byte)
+	if !ok {
+		return Credential{}, false, InvalidResponseError
+	}
+
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, false, err
+	}
+	verified, err := c.verifyAuthenticatorData(authData)
+	if err != nil {
+		return Credential{}, false, err
+	}
+	if len(authData.CredentialID) == 0 {
+		return Credential{}, false, MissingCredentialDataError
+	}
+	if _, err := ParsePublicKey(authData.PublicKey); err != nil {
+		return Credential{}, false, err
+	}
+
+	return Credential{
+		ID:        authData.CredentialID,
+		PublicKey: authData.PublicKey,
+		SignCount: authData.SignCount,
+		CreatedAt: time.Now(),
+	}, verified, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get() with the credential.
+//
+// It returns the credential that updated the signature counter, and reports the user was verified by the authenticator.
+func (c Ceremony) VerifyAssertion(resp AssertionResponse, cred Credential) (Credential, bool, error) {
+	if resp.Type != "public-key" {
+		return Credential{}, false, InvalidResponseError
+	}
+	if !bytes.Equal(resp.ID, cred.ID) {
+		return Credential{}, false, CredentialMismatchError
+	}
+	if err := c.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get"); err != nil {
+		return Credential{}, false, err
+	}
+
+	authData, err := ParseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return Credential{}, false, err
+	}
+	verified, err := c.verifyAuthenticatorData(authData)
+	if err != nil {
+		return Credential{}, false, err
+	}
+
+	key, err := ParsePublicKey(cred.PublicKey)
+	if err != nil {
+		return Credential{}, false, err
+	}
+	hash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), hash[:]...)
+	if err := key.Verify(signed, resp.Response.Signature); err != nil {
+		return Credential{}, false, err
+	}
+
+	if (authData.SignCount != 0 || cred.SignCount != 0) && authData.SignCount <= cred.SignCount {
+		return Credential{}, false, ClonedAuthenticatorError
+	}
+
+	cred.SignCount = authData.SignCount
+	cred.LastUsedAt = time.Now()
+	return cred, verified, nil
+}
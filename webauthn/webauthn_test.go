@@ -0,0 +1,341 @@
+package webauthn_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/macrat/lauth/store"
+	"github.com/macrat/lauth/testutil"
+	"github.com/macrat/lauth/webauthn"
+)
+
+const (
+	testRPID   = "auth.example.com"
+	testOrigin = "https://auth.example.com"
+)
+
+func register(t *testing.T, auth *testutil.SoftAuthenticator, challenge string, ceremony webauthn.Ceremony) (webauthn.Credential, error) {
+	t.Helper()
+
+	raw, err := auth.Create(testRPID, challenge, webauthn.UserHandle("macrat"))
+	if err != nil {
+		t.Fatalf("failed to create credential: %s", err)
+	}
+
+	var resp webauthn.RegistrationResponse
+	if err := json.Unmarshal([]byte(raw), &resp); err != nil {
+		t.Fatalf("failed to parse registration response: %s", err)
+	}
+
+	cred, _, err := ceremony.VerifyRegistration(resp)
+	return cred, err
+}
+
+func assert(t *testing.T, auth *testutil.SoftAuthenticator, challenge string) webauthn.AssertionResponse {
+	t.Helper()
+
+	raw, err := auth.Get(testRPID, challenge)
+	if err != nil {
+		t.Fatalf("failed to get assertion: %s", err)
+	}
+
+	var resp webauthn.AssertionResponse
+	if err := json.Unmarshal([]byte(raw), &resp); err != nil {
+		t.Fatalf("failed to parse assertion response: %s", err)
+	}
+	return resp
+}
+
+func newCeremony(t *testing.T) webauthn.Ceremony {
+	t.Helper()
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatalf("failed to make challenge: %s", err)
+	}
+
+	return webauthn.Ceremony{
+		RPID:             testRPID,
+		Origin:           testOrigin,
+		Challenge:        challenge,
+		UserVerification: webauthn.UserVerificationPreferred,
+	}
+}
+
+func TestCeremony_VerifyRegistration(t *testing.T) {
+	auth, err := testutil.NewSoftAuthenticator(testOrigin)
+	if err != nil {
+		t.Fatalf("failed to make authenticator: %s", err)
+	}
+
+	ceremony := newCeremony(t)
+	cred, err := register(t, auth, ceremony.Challenge, ceremony)
+	if err != nil {
+		t.Fatalf("failed to verify registration: %s", err)
+	}
+	if string(cred.ID) != string(auth.CredentialID) {
+		t.Errorf("unexpected credential ID: %s", cred.ID)
+	}
+	if cred.SignCount != 1 {
+		t.Errorf("unexpected sign count: %d", cred.SignCount)
+	}
+	if key, err := webauthn.ParsePublicKey(cred.PublicKey); err != nil {
+		t.Errorf("failed to parse public key: %s", err)
+	} else if key.Algorithm != webauthn.AlgES256 {
+		t.Errorf("unexpected algorithm: %d", key.Algorithm)
+	}
+
+	tests := []struct {
+		Name   string
+		Modify func(*webauthn.Ceremony, *testutil.SoftAuthenticator)
+		Error  error
+	}{
+		{
+			"wrong challenge",
+			func(c *webauthn.Ceremony, a *testutil.SoftAuthenticator) {
+				c.Challenge = "another-challenge"
+			},
+			webauthn.ChallengeMismatchError,
+		},
+		{
+			"wrong origin",
+			func(c *webauthn.Ceremony, a *testutil.SoftAuthenticator) {
+				a.Origin = "https://evil.example.com"
+			},
+			webauthn.OriginMismatchError,
+		},
+		{
+			"wrong RP ID",
+			func(c *webauthn.Ceremony, a *testutil.SoftAuthenticator) {
+				c.RPID = "example.com"
+			},
+			webauthn.RPIDMismatchError,
+		},
+		{
+			"user not verified",
+			func(c *webauthn.Ceremony, a *testutil.SoftAuthenticator) {
+				c.UserVerification = webauthn.UserVerificationRequired
+				a.UserVerified = false
+			},
+			webauthn.UserNotVerifiedError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			auth, err := testutil.NewSoftAuthenticator(testOrigin)
+			if err != nil {
+				t.Fatalf("failed to make authenticator: %s", err)
+			}
+
+			ceremony := newCeremony(t)
+			challenge := ceremony.Challenge
+			tt.Modify(&ceremony, auth)
+
+			if _, err := register(t, auth, challenge, ceremony); err != tt.Error {
+				t.Errorf("expected %s but got %v", tt.Error, err)
+			}
+		})
+	}
+}
+
+func TestCeremony_VerifyAssertion(t *testing.T) {
+	auth, err := testutil.NewSoftAuthenticator(testOrigin)
+	if err != nil {
+		t.Fatalf("failed to make authenticator: %s", err)
+	}
+
+	registration := newCeremony(t)
+	cred, err := register(t, auth, registration.Challenge, registration)
+	if err != nil {
+		t.Fatalf("failed to verify registration: %s", err)
+	}
+
+	ceremony := newCeremony(t)
+	resp := assert(t, auth, ceremony.Challenge)
+
+	updated, verified, err := ceremony.VerifyAssertion(resp, cred)
+	if err != nil {
+		t.Fatalf("failed to verify assertion: %s", err)
+	}
+	if !verified {
+		t.Errorf("user should be verified")
+	}
+	if updated.SignCount != 2 {
+		t.Errorf("unexpected sign count: %d", updated.SignCount)
+	}
+	if updated.LastUsedAt.IsZero() {
+		t.Errorf("last used time is not updated")
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		if _, _, err := ceremony.VerifyAssertion(resp, updated); err != webauthn.ClonedAuthenticatorError {
+			t.Errorf("expected cloned authenticator error but got %v", err)
+		}
+	})
+
+	t.Run("wrong challenge", func(t *testing.T) {
+		resp := assert(t, auth, "another-challenge")
+		if _, _, err := ceremony.VerifyAssertion(resp, updated); err != webauthn.ChallengeMismatchError {
+			t.Errorf("expected challenge mismatch error but got %v", err)
+		}
+	})
+
+	t.Run("broken signature", func(t *testing.T) {
+		resp := assert(t, auth, ceremony.Challenge)
+		resp.Response.Signature[len(resp.Response.Signature)-1] ^= 0xff
+		if _, _, err := ceremony.VerifyAssertion(resp, updated); err != webauthn.InvalidSignatureError {
+			t.Errorf("expected invalid signature error but got %v", err)
+		}
+	})
+
+	t.Run("another authenticator", func(t *testing.T) {
+		another, err := testutil.NewSoftAuthenticator(testOrigin)
+		if err != nil {
+			t.Fatalf("failed to make authenticator: %s", err)
+		}
+		another.CredentialID = auth.CredentialID
+		another.SignCount = 100
+
+		resp := assert(t, another, ceremony.Challenge)
+		if _, _, err := ceremony.VerifyAssertion(resp, updated); err != webauthn.InvalidSignatureError {
+			t.Errorf("expected invalid signature error but got %v", err)
+		}
+	})
+}
+
+func TestKVStore(t *testing.T) {
+	s := webauthn.NewStore(store.NewMemoryStore())
+
+	first := webauthn.Credential{ID: []byte("first"), PublicKey: []byte("key1"), CreatedAt: time.Now()}
+	second := webauthn.Credential{ID: []byte("second"), PublicKey: []byte("key2"), CreatedAt: time.Now()}
+
+	if creds, err := s.Credentials("macrat"); err != nil || len(creds) != 0 {
+		t.Fatalf("unexpected credentials: %#v %v", creds, err)
+	}
+
+	if err := s.Save("macrat", first); err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+	if err := s.Save("macrat", second); err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+
+	first.SignCount = 10
+	if err := s.Save("macrat", first); err != nil {
+		t.Fatalf("failed to update: %s", err)
+	}
+
+	if creds, err := s.Credentials("macrat"); err != nil || len(creds) != 2 || creds[0].SignCount != 10 {
+		t.Errorf("unexpected credentials: %#v %v", creds, err)
+	}
+
+	if err := s.Save("j.smith", first); err != webauthn.CredentialRegisteredError {
+		t.Errorf("expected error when register the same credential by another user but got %v", err)
+	}
+
+	first.SignCount = 9
+	if err := s.Save("macrat", first); err != webauthn.ClonedAuthenticatorError {
+		t.Errorf("expected error when decrease the signature counter but got %v", err)
+	}
+
+	if subject, cred, err := s.Find([]byte("second")); err != nil || subject != "macrat" || string(cred.PublicKey) != "key2" {
+		t.Errorf("unexpected result: %s %#v %v", subject, cred, err)
+	}
+	if _, _, err := s.Find([]byte("unknown")); err != webauthn.CredentialNotFoundError {
+		t.Errorf("expected not found error but got %v", err)
+	}
+
+	if n, err := s.DeleteAll("macrat"); err != nil || n != 2 {
+		t.Errorf("unexpected result of delete: %d %v", n, err)
+	}
+	if _, _, err := s.Find([]byte("first")); err != webauthn.CredentialNotFoundError {
+		t.Errorf("expected not found error but got %v", err)
+	}
+	if n, err := s.DeleteAll("macrat"); err != nil || n != 0 {
+		t.Errorf("unexpected result of delete: %d %v", n, err)
+	}
+}
+
+func TestUseChallenge(t *testing.T) {
+	kv := store.NewMemoryStore()
+
+	if ok, err := webauthn.UseChallenge(kv, "challenge", time.Minute); err != nil || !ok {
+		t.Errorf("failed to use challenge at first: %v %v", ok, err)
+	}
+	if ok, err := webauthn.UseChallenge(kv, "challenge", time.Minute); err != nil || ok {
+		t.Errorf("challenge should not be used twice: %v %v", ok, err)
+	}
+	if ok, err := webauthn.UseChallenge(kv, "another", time.Minute); err != nil || !ok {
+		t.Errorf("failed to use another challenge: %v %v", ok, err)
+	}
+}
+
+func TestUseChallenge_parallel(t *testing.T) {
+	kv := store.NewMemoryStore()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	used := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ok, err := webauthn.UseChallenge(kv, "challenge", time.Minute)
+			if err != nil {
+				t.Errorf("failed to use challenge: %s", err)
+			}
+			if ok {
+				mu.Lock()
+				used++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if used != 1 {
+		t.Errorf("challenge should be used only once but used %d times", used)
+	}
+}
+
+func TestKVStore_parallel(t *testing.T) {
+	s := webauthn.NewStore(store.NewMemoryStore())
+
+	var wg sync.WaitGroup
+	var registered, updated int32
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			cred := webauthn.Credential{ID: []byte("shared"), PublicKey: []byte("key"), CreatedAt: time.Now()}
+			if err := s.Save(fmt.Sprintf("user%d", i), cred); err == nil {
+				atomic.AddInt32(&registered, 1)
+			} else if err != webauthn.CredentialRegisteredError {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			cred := webauthn.Credential{ID: []byte("counter"), PublicKey: []byte("key"), SignCount: 1, CreatedAt: time.Now()}
+			if err := s.Save("macrat", cred); err == nil {
+				atomic.AddInt32(&updated, 1)
+			} else if err != webauthn.ClonedAuthenticatorError {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if registered != 1 {
+		t.Errorf("the credential should be registered only once but registered %d times", registered)
+	}
+	if updated != 1 {
+		t.Errorf("the same signature counter should be saved only once but saved %d times", updated)
+	}
+}